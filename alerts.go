@@ -0,0 +1,611 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// unitlessColumns are archive columns alert rules may use that have no unit conversion
+var unitlessColumns = map[string]bool{
+	"outHumidity":            true,
+	"inHumidity":             true,
+	"windDir":                true,
+	"lightning_strike_count": true,
+}
+
+// alertRule is a validated AlertRuleConfig with thresholds in archive source units
+type alertRule struct {
+	AlertRuleConfig
+	quantity  Quantity // quantity of the compared value (a delta for rate rules)
+	threshold float64
+	clear     float64
+	window    time.Duration
+	hold      time.Duration
+	columns   []string // archive columns needed to evaluate the rule
+}
+
+// compileAlertRules validates the configured rules, falling back to rules built from
+// the legacy extreme_heat/extreme_cold/wind_speed/wind_gust thresholds.
+func compileAlertRules(cfg AlertsConfig) ([]*alertRule, error) {
+	configs := cfg.Rules
+	if len(configs) == 0 {
+		configs = legacyAlertRules(cfg)
+	}
+
+	seen := make(map[string]bool)
+	rules := make([]*alertRule, 0, len(configs))
+	for i, rc := range configs {
+		if rc.ID == "" {
+			return nil, fmt.Errorf("alert rule %d: id is required", i+1)
+		}
+		if seen[rc.ID] {
+			return nil, fmt.Errorf("alert rule %q: duplicate id", rc.ID)
+		}
+		seen[rc.ID] = true
+
+		rule, err := compileAlertRule(rc)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %q: %w", rc.ID, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func compileAlertRule(rc AlertRuleConfig) (*alertRule, error) {
+	rule := &alertRule{AlertRuleConfig: rc}
+	if rule.Name == "" {
+		rule.Name = rule.ID
+	}
+
+	if d, ok := derivedFields[rc.Field]; ok {
+		rule.quantity = d.Quantity
+		rule.columns = d.Inputs
+	} else if q, ok := archiveQuantities[rc.Field]; ok {
+		rule.quantity = q
+		rule.columns = []string{rc.Field}
+	} else if unitlessColumns[rc.Field] {
+		rule.quantity = qNone
+		rule.columns = []string{rc.Field}
+	} else {
+		return nil, fmt.Errorf("unknown field %q", rc.Field)
+	}
+
+	switch rc.Op {
+	case ">", ">=", "<", "<=":
+	default:
+		return nil, fmt.Errorf("op %q (use >, >=, < or <=)", rc.Op)
+	}
+
+	switch rc.Severity {
+	case "":
+		rule.Severity = "warning"
+	case "info", "warning", "critical":
+	default:
+		return nil, fmt.Errorf("severity %q (use info, warning or critical)", rc.Severity)
+	}
+
+	switch rc.Type {
+	case "", "threshold":
+		rule.Type = "threshold"
+	case "rate":
+		if rc.Window == "" {
+			return nil, errors.New("type rate requires a window")
+		}
+		// A change in temperature is a temperature difference, not an absolute value
+		if rule.quantity == qTemp {
+			rule.quantity = qTempDelta
+		}
+	default:
+		return nil, fmt.Errorf("type %q (use threshold or rate)", rc.Type)
+	}
+
+	var err error
+	if rc.Window != "" {
+		if rule.window, err = parseAlertDuration(rc.Window); err != nil {
+			return nil, fmt.Errorf("window: %w", err)
+		}
+	}
+	if rc.For != "" {
+		if rule.hold, err = parseAlertDuration(rc.For); err != nil {
+			return nil, fmt.Errorf("for: %w", err)
+		}
+	}
+
+	rule.threshold = displayToSource(rule.quantity, rc.Value)
+	rule.clear = rule.threshold
+	if rc.Clear != nil {
+		rule.clear = displayToSource(rule.quantity, *rc.Clear)
+		// The clear level must sit on the "safe" side of the threshold
+		if (rule.isUpper() && rule.clear > rule.threshold) || (!rule.isUpper() && rule.clear < rule.threshold) {
+			return nil, fmt.Errorf("clear %v is on the wrong side of value %v", *rc.Clear, rc.Value)
+		}
+	}
+	return rule, nil
+}
+
+// parseAlertDuration accepts compact spans (10m, 3h, 1d) or any Go duration
+func parseAlertDuration(v string) (time.Duration, error) {
+	if d, ok := parseSpan(v); ok {
+		return d, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration (e.g. 10m, 3h)", v)
+	}
+	return d, nil
+}
+
+// legacyAlertRules turns the fixed thresholds into rules so existing configs keep working
+func legacyAlertRules(cfg AlertsConfig) []AlertRuleConfig {
+	var rules []AlertRuleConfig
+	if cfg.ExtremeHeat != 0 {
+		rules = append(rules, AlertRuleConfig{ID: "extreme-heat", Name: "Extreme Heat", Field: "heatindex", Op: ">=", Value: cfg.ExtremeHeat})
+	}
+	if cfg.ExtremeCold != 0 {
+		rules = append(rules, AlertRuleConfig{ID: "extreme-cold", Name: "Extreme Cold", Field: "windchill", Op: "<=", Value: cfg.ExtremeCold})
+	}
+	if cfg.WindSpeed != 0 {
+		rules = append(rules, AlertRuleConfig{ID: "strong-wind", Name: "Strong Wind", Field: "windSpeed", Op: ">=", Value: cfg.WindSpeed})
+	}
+	if cfg.WindGust != 0 {
+		rules = append(rules, AlertRuleConfig{ID: "strong-gust", Name: "Strong Gusts", Field: "windGust", Op: ">=", Value: cfg.WindGust})
+	}
+	return rules
+}
+
+// isUpper reports whether the rule fires on high values
+func (r *alertRule) isUpper() bool {
+	return r.Op == ">" || r.Op == ">="
+}
+
+// breached applies the rule's operator against level
+func (r *alertRule) breached(v, level float64) bool {
+	switch r.Op {
+	case ">":
+		return v > level
+	case ">=":
+		return v >= level
+	case "<":
+		return v < level
+	default:
+		return v <= level
+	}
+}
+
+// valueFrom extracts the rule's field from a record (source units)
+func (r *alertRule) valueFrom(values map[string]float64) (float64, bool) {
+	if d, ok := derivedFields[r.Field]; ok {
+		return d.Compute(values)
+	}
+	v, ok := values[r.Field]
+	return v, ok
+}
+
+// message describes an alert event in display units, e.g.
+// "Strong Gusts: windGust 31 mph >= 25 mph" or "Strong Gusts cleared: windGust 18 mph"
+func (r *alertRule) message(event string, value float64) string {
+	u := displayUnits
+	prec := u.Precision(r.quantity)
+	label := u.Label(r.quantity)
+	if label != "" {
+		label = " " + label
+	}
+	field := r.Field
+	if r.Type == "rate" {
+		field += " change over " + r.Window
+	}
+	if event == "cleared" {
+		return fmt.Sprintf("%s cleared: %s %.*f%s", r.Name, field, prec, u.Convert(r.quantity, value), label)
+	}
+	return fmt.Sprintf("%s: %s %.*f%s %s %.*f%s", r.Name, field,
+		prec, u.Convert(r.quantity, value), label, r.Op, prec, u.Convert(r.quantity, r.threshold), label)
+}
+
+// -------------------- engine --------------------
+
+// alertStateFile is the persisted form of the engine's state
+type alertStateFile struct {
+	LastEpoch int64                   `json:"lastEpoch"`
+	NextID    int64                   `json:"nextId"`
+	Active    map[string]*ActiveAlert `json:"active"`
+	History   []AlertEvent            `json:"history"`
+}
+
+// AlertEngine evaluates rules against each new archive record and tracks which
+// alerts are pending/active. State and history survive restarts via a JSON file.
+type AlertEngine struct {
+	archive Archive
+	rules   []*alertRule
+	path    string
+	limit   int
+
+	mu    sync.Mutex
+	state alertStateFile
+	// listeners receive every fired/cleared event in display units. Evaluate calls
+	// them after unlocking, in order, on the poller goroutine.
+	listeners []func(AlertEvent)
+	// notify holds the events of the current evaluation until they are delivered
+	notify []AlertEvent
+}
+
+var alertEngine *AlertEngine
+
+// NewAlertEngine builds the engine and restores persisted state, dropping entries for
+// rules that no longer exist.
+func NewAlertEngine(archive Archive, cfg AlertsConfig) (*AlertEngine, error) {
+	rules, err := compileAlertRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+	e := &AlertEngine{
+		archive: archive,
+		rules:   rules,
+		path:    cfg.StateFile,
+		limit:   cfg.HistoryLimit,
+		state:   alertStateFile{Active: make(map[string]*ActiveAlert)},
+	}
+
+	data, err := os.ReadFile(e.path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &e.state); err != nil {
+			log.Printf("[Alerts] ignoring unreadable state file %s: %v", e.path, err)
+			e.state = alertStateFile{Active: make(map[string]*ActiveAlert)}
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("reading alert state: %w", err)
+	}
+	if e.state.Active == nil {
+		e.state.Active = make(map[string]*ActiveAlert)
+	}
+	for id := range e.state.Active {
+		if e.rule(id) == nil {
+			delete(e.state.Active, id)
+		}
+	}
+
+	log.Printf("[Alerts] %d rules loaded; %d alerts active", len(rules), len(e.state.Active))
+	return e, nil
+}
+
+// Subscribe registers fn to receive every fired/cleared event
+func (e *AlertEngine) Subscribe(fn func(AlertEvent)) {
+	e.mu.Lock()
+	e.listeners = append(e.listeners, fn)
+	e.mu.Unlock()
+}
+
+func (e *AlertEngine) rule(id string) *alertRule {
+	for _, r := range e.rules {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// Evaluate runs every rule against the archive record at epoch. Records that were
+// already evaluated (e.g. before a restart) are skipped. The archive is read without
+// holding the engine lock, so a slow database doesn't block the alert endpoints.
+func (e *AlertEngine) Evaluate(epoch int64) {
+	e.mu.Lock()
+	skip := epoch <= e.state.LastEpoch || len(e.rules) == 0
+	e.mu.Unlock()
+	if skip {
+		return
+	}
+
+	current, err := e.loadRecord(epoch, epoch)
+	if err != nil {
+		log.Printf("[Alerts] loading record %d: %v", epoch, err)
+		return
+	}
+	// Rule values, with past records for rate rules loaded once per distinct window
+	values := make(map[*alertRule]float64, len(e.rules))
+	past := make(map[time.Duration]map[string]float64)
+	for _, rule := range e.rules {
+		value, ok := rule.valueFrom(current)
+		if !ok {
+			continue
+		}
+		if rule.Type == "rate" {
+			prev, loaded := past[rule.window]
+			if !loaded {
+				prev = e.loadPast(epoch, rule.window)
+				past[rule.window] = prev
+			}
+			before, ok := rule.valueFrom(prev)
+			if !ok {
+				continue
+			}
+			value -= before
+		}
+		values[rule] = value
+	}
+
+	e.mu.Lock()
+	if epoch <= e.state.LastEpoch {
+		e.mu.Unlock()
+		return
+	}
+	for _, rule := range e.rules {
+		if value, ok := values[rule]; ok {
+			e.step(rule, epoch, value)
+		}
+	}
+	e.state.LastEpoch = epoch
+	if err := e.save(); err != nil {
+		log.Printf("[Alerts] saving state: %v", err)
+	}
+	events, listeners := e.notify, e.listeners
+	e.notify = nil
+	e.mu.Unlock()
+
+	for _, ev := range events {
+		for _, fn := range listeners {
+			fn(ev)
+		}
+	}
+}
+
+// step advances one rule's state machine: none -> pending -> active -> cleared
+func (e *AlertEngine) step(rule *alertRule, epoch int64, value float64) {
+	st := e.state.Active[rule.ID]
+
+	if st == nil || st.Status == "pending" {
+		if !rule.breached(value, rule.threshold) {
+			delete(e.state.Active, rule.ID)
+			return
+		}
+		if st == nil {
+			st = &ActiveAlert{
+				RuleID:   rule.ID,
+				Name:     rule.Name,
+				Severity: rule.Severity,
+				Field:    rule.Field,
+				Status:   "pending",
+				Since:    epoch,
+				Peak:     value,
+			}
+			e.state.Active[rule.ID] = st
+		}
+		st.Value = value
+		st.Updated = epoch
+		st.Threshold = rule.threshold
+		st.trackPeak(rule, value)
+		if time.Duration(epoch-st.Since)*time.Second >= rule.hold {
+			st.Status = "active"
+			st.Since = epoch
+			st.Message = rule.message("fired", value)
+			e.record(rule, "fired", epoch, value, 0)
+			log.Printf("[Alerts] fired %s", st.Message)
+		}
+		return
+	}
+
+	// Active: stay active until the value is back past the clear level
+	st.Value = value
+	st.Updated = epoch
+	st.trackPeak(rule, value)
+	if rule.breached(value, rule.clear) {
+		return
+	}
+	delete(e.state.Active, rule.ID)
+	e.record(rule, "cleared", epoch, value, epoch-st.Since)
+	log.Printf("[Alerts] cleared %s (active %s)", rule.Name, time.Duration(epoch-st.Since)*time.Second)
+}
+
+func (a *ActiveAlert) trackPeak(rule *alertRule, value float64) {
+	if (rule.isUpper() && value > a.Peak) || (!rule.isUpper() && value < a.Peak) {
+		a.Peak = value
+	}
+}
+
+// record appends a history entry, trimming the oldest beyond the limit, and queues
+// the event for the listeners
+func (e *AlertEngine) record(rule *alertRule, event string, epoch int64, value float64, duration int64) {
+	e.state.NextID++
+	ev := AlertEvent{
+		ID:        e.state.NextID,
+		RuleID:    rule.ID,
+		Name:      rule.Name,
+		Severity:  rule.Severity,
+		Field:     rule.Field,
+		Event:     event,
+		Value:     value,
+		Threshold: rule.threshold,
+		Timestamp: epoch,
+		Duration:  duration,
+		Message:   rule.message(event, value),
+	}
+	e.state.History = append(e.state.History, ev)
+	if over := len(e.state.History) - e.limit; over > 0 {
+		e.state.History = append([]AlertEvent(nil), e.state.History[over:]...)
+	}
+
+	ev.Value = displayUnits.Convert(rule.quantity, value)
+	ev.Threshold = displayUnits.Convert(rule.quantity, rule.threshold)
+	ev.Unit = displayUnits.Label(rule.quantity)
+	e.notify = append(e.notify, ev)
+}
+
+// columns returns the union of archive columns used by all rules
+func (e *AlertEngine) columns() []string {
+	set := make(map[string]bool)
+	var cols []string
+	for _, r := range e.rules {
+		for _, c := range r.columns {
+			if !set[c] {
+				set[c] = true
+				cols = append(cols, c)
+			}
+		}
+	}
+	return cols
+}
+
+// loadRecord reads the rule columns of the newest archive row with from <= dateTime
+// <= to; NULL columns are absent. Column names come from validated rules only.
+func (e *AlertEngine) loadRecord(from, to int64) (map[string]float64, error) {
+	cols := e.columns()
+	_, vals, err := e.archive.Latest(context.Background(), "alerts", cols, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]float64, len(cols))
+	for i, c := range cols {
+		if vals[i].Valid {
+			values[c] = vals[i].Float64
+		}
+	}
+	return values, nil
+}
+
+// loadPast returns the newest record at least window before epoch, provided it is not
+// more than twice the window old (otherwise the rate would be meaningless).
+func (e *AlertEngine) loadPast(epoch int64, window time.Duration) map[string]float64 {
+	w := int64(window / time.Second)
+	values, err := e.loadRecord(epoch-2*w, epoch-w)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("[Alerts] loading record %s before %d: %v", window, epoch, err)
+		}
+		return nil
+	}
+	return values
+}
+
+// save writes the state atomically (temp file + rename)
+func (e *AlertEngine) save() error {
+	data, err := json.MarshalIndent(e.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return err
+	}
+	tmp := e.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.path)
+}
+
+// Active returns copies of the pending and active alerts, most severe first
+func (e *AlertEngine) Active() []ActiveAlert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]ActiveAlert, 0, len(e.state.Active))
+	for _, a := range e.state.Active {
+		out = append(out, *a)
+	}
+	rank := map[string]int{"critical": 0, "warning": 1, "info": 2}
+	sort.Slice(out, func(i, j int) bool {
+		if rank[out[i].Severity] != rank[out[j].Severity] {
+			return rank[out[i].Severity] < rank[out[j].Severity]
+		}
+		return out[i].Since < out[j].Since
+	})
+	return out
+}
+
+// History returns up to limit events, newest first, optionally for one rule
+func (e *AlertEngine) History(ruleID string, limit int) []AlertEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]AlertEvent, 0, limit)
+	for i := len(e.state.History) - 1; i >= 0 && len(out) < limit; i-- {
+		ev := e.state.History[i]
+		if ruleID == "" || ev.RuleID == ruleID {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// quantityOf returns the quantity of a rule's values, or qNone for rules that were
+// removed from the config since the event was recorded
+func (e *AlertEngine) quantityOf(ruleID string) Quantity {
+	if r := e.rule(ruleID); r != nil {
+		return r.quantity
+	}
+	return qNone
+}
+
+// -------------------- /api/alerts --------------------
+
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	units, apiErr := unitsFromRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	if alertEngine == nil {
+		_ = json.NewEncoder(w).Encode([]ActiveAlert{})
+		return
+	}
+
+	alerts := alertEngine.Active()
+	for i := range alerts {
+		a := &alerts[i]
+		q := alertEngine.quantityOf(a.RuleID)
+		a.Value = units.Convert(q, a.Value)
+		a.Peak = units.Convert(q, a.Peak)
+		a.Threshold = units.Convert(q, a.Threshold)
+		a.Unit = units.Label(q)
+	}
+	if err := json.NewEncoder(w).Encode(alerts); err != nil {
+		http.Error(w, "JSON error", http.StatusInternalServerError)
+	}
+}
+
+// -------------------- /api/alerts/history --------------------
+
+func handleAlertHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	units, apiErr := unitsFromRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			writeAPIError(w, badParam("limit", "invalid_limit", "limit must be an integer between 1 and 1000"))
+			return
+		}
+		limit = n
+	}
+	if alertEngine == nil {
+		_ = json.NewEncoder(w).Encode([]AlertEvent{})
+		return
+	}
+
+	events := alertEngine.History(r.URL.Query().Get("rule"), limit)
+	for i := range events {
+		ev := &events[i]
+		q := alertEngine.quantityOf(ev.RuleID)
+		ev.Value = units.Convert(q, ev.Value)
+		ev.Threshold = units.Convert(q, ev.Threshold)
+		ev.Unit = units.Label(q)
+	}
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		http.Error(w, "JSON error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API key scopes
+const (
+	scopeReadSeries  = "read:series"  // series endpoints, statistics, records, celestial, live stream
+	scopeReadAlerts  = "read:alerts"  // /api/alerts
+	scopeReadExport  = "read:export"  // CSV exports
+	scopeAdminNOAA   = "admin:noaa"   // NOAA report generation
+	scopeAdminNotify = "admin:notify" // test notifications
+)
+
+var apiKeyScopes = []string{scopeReadSeries, scopeReadAlerts, scopeReadExport, scopeAdminNOAA, scopeAdminNotify}
+
+const apiKeyPrefix = "wdk_"
+
+// routeScope returns the scope an API key needs for an /api path. ok is false for
+// routes keys may never use; an empty scope means any valid key.
+func routeScope(path string) (scope string, ok bool) {
+	switch {
+	case path == "/api/keys" || strings.HasPrefix(path, "/api/keys/"):
+		return "", false
+	case path == "/api/me" || path == "/api/ping":
+		return "", true
+	case strings.HasPrefix(path, "/api/csv/"):
+		return scopeReadExport, true
+	case strings.HasPrefix(path, "/api/noaa/"):
+		return scopeAdminNOAA, true
+	case strings.HasPrefix(path, "/api/notify/"):
+		return scopeAdminNotify, true
+	case path == "/api/alerts" || strings.HasPrefix(path, "/api/alerts/"):
+		return scopeReadAlerts, true
+	}
+	return scopeReadSeries, true
+}
+
+// apiKeyRecord is a stored key. Only a SHA-256 hash of the token is kept.
+type apiKeyRecord struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Hash          string   `json:"hash"`
+	Scopes        []string `json:"scopes"`
+	RatePerMinute float64  `json:"ratePerMinute"`
+	Burst         int      `json:"burst"`
+	QuotaPerDay   int      `json:"quotaPerDay"`
+	CreatedAt     int64    `json:"createdAt"`
+	CreatedBy     string   `json:"createdBy,omitempty"`
+	ExpiresAt     int64    `json:"expiresAt,omitempty"`
+	RevokedAt     int64    `json:"revokedAt,omitempty"`
+	LastUsedAt    int64    `json:"lastUsedAt,omitempty"`
+	// Usage for the quota, per station-local day
+	UsageDay  string `json:"usageDay,omitempty"`
+	UsedToday int    `json:"usedToday,omitempty"`
+}
+
+func (k *apiKeyRecord) info() APIKey {
+	used := k.UsedToday
+	if k.UsageDay != station.Now().Format("2006-01-02") {
+		used = 0
+	}
+	return APIKey{
+		ID:            k.ID,
+		Name:          k.Name,
+		Scopes:        append([]string(nil), k.Scopes...),
+		RatePerMinute: k.RatePerMinute,
+		Burst:         k.Burst,
+		QuotaPerDay:   k.QuotaPerDay,
+		UsedToday:     used,
+		CreatedAt:     k.CreatedAt,
+		CreatedBy:     k.CreatedBy,
+		ExpiresAt:     k.ExpiresAt,
+		LastUsedAt:    k.LastUsedAt,
+		RevokedAt:     k.RevokedAt,
+	}
+}
+
+// tokenBucket is a key's rate limiter: Burst tokens, refilled at RatePerMinute
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// APIKeyStore holds the API keys and enforces their rate limits and quotas. Keys are
+// persisted to a JSON file; usage counters are flushed periodically.
+type APIKeyStore struct {
+	path     string
+	defaults APIKeysConfig
+
+	mu      sync.Mutex
+	keys    map[string]*apiKeyRecord
+	buckets map[string]*tokenBucket
+	dirty   bool
+}
+
+var apiKeys *APIKeyStore
+
+func NewAPIKeyStore(cfg APIKeysConfig) (*APIKeyStore, error) {
+	s := &APIKeyStore{
+		path:     cfg.File,
+		defaults: cfg,
+		keys:     make(map[string]*apiKeyRecord),
+		buckets:  make(map[string]*tokenBucket),
+	}
+	data, err := os.ReadFile(s.path)
+	switch {
+	case err == nil:
+		var file struct {
+			Keys []*apiKeyRecord `json:"keys"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", s.path, err)
+		}
+		for _, k := range file.Keys {
+			s.keys[k.ID] = k
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("reading API keys: %w", err)
+	}
+
+	active := 0
+	for _, k := range s.keys {
+		if k.RevokedAt == 0 {
+			active++
+		}
+	}
+	log.Printf("[APIKeys] %d active keys loaded", active)
+	return s, nil
+}
+
+// save writes the keys atomically (temp file + rename); callers hold s.mu
+func (s *APIKeyStore) save() error {
+	file := struct {
+		Keys []*apiKeyRecord `json:"keys"`
+	}{Keys: make([]*apiKeyRecord, 0, len(s.keys))}
+	for _, k := range s.keys {
+		file.Keys = append(file.Keys, k)
+	}
+	sort.Slice(file.Keys, func(i, j int) bool { return file.Keys[i].CreatedAt < file.Keys[j].CreatedAt })
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// StartFlushing saves usage counters every interval until stop is closed
+func (s *APIKeyStore) StartFlushing(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.mu.Lock()
+				if s.dirty {
+					if err := s.save(); err != nil {
+						log.Printf("[APIKeys] saving usage: %v", err)
+					}
+				}
+				s.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// apiKeyRequest is the body of POST /api/keys
+type apiKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	RatePerMinute float64  `json:"ratePerMinute"` // 0 = configured default
+	Burst         int      `json:"burst"`         // 0 = configured default
+	QuotaPerDay   *int     `json:"quotaPerDay"`   // omitted = configured default, 0 = unlimited
+	ExpiresInDays int      `json:"expiresInDays"` // 0 = never
+}
+
+// Create adds a key and returns it with its token, which is not stored
+func (s *APIKeyStore) Create(req apiKeyRequest, createdBy string) (APIKey, *apiError) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		return APIKey{}, badParam("name", "invalid_name", "name is required (at most 64 characters)")
+	}
+	if len(req.Scopes) == 0 {
+		return APIKey{}, badParam("scopes", "invalid_scopes", "at least one scope is required (%s)", strings.Join(apiKeyScopes, ", "))
+	}
+	var scopes []string
+	for _, sc := range req.Scopes {
+		sc = strings.ToLower(strings.TrimSpace(sc))
+		if !containsString(apiKeyScopes, sc) {
+			return APIKey{}, badParam("scopes", "invalid_scopes", "unknown scope %q (use %s)", sc, strings.Join(apiKeyScopes, ", "))
+		}
+		if !containsString(scopes, sc) {
+			scopes = append(scopes, sc)
+		}
+	}
+	if req.RatePerMinute < 0 || req.Burst < 0 || req.ExpiresInDays < 0 || (req.QuotaPerDay != nil && *req.QuotaPerDay < 0) {
+		return APIKey{}, badParam("", "invalid_limits", "limits must not be negative")
+	}
+
+	k := &apiKeyRecord{
+		Name:          req.Name,
+		Scopes:        scopes,
+		RatePerMinute: req.RatePerMinute,
+		Burst:         req.Burst,
+		QuotaPerDay:   s.defaults.QuotaPerDay,
+		CreatedAt:     time.Now().Unix(),
+		CreatedBy:     createdBy,
+	}
+	if k.RatePerMinute == 0 {
+		k.RatePerMinute = s.defaults.RatePerMinute
+	}
+	if k.Burst == 0 {
+		k.Burst = s.defaults.Burst
+	}
+	if req.QuotaPerDay != nil {
+		k.QuotaPerDay = *req.QuotaPerDay
+	}
+	if req.ExpiresInDays > 0 {
+		k.ExpiresAt = time.Now().AddDate(0, 0, req.ExpiresInDays).Unix()
+	}
+
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return APIKey{}, &apiError{Status: http.StatusInternalServerError, Code: "internal", Message: "cannot generate key"}
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, &apiError{Status: http.StatusInternalServerError, Code: "internal", Message: "cannot generate key"}
+	}
+	k.ID = hex.EncodeToString(id)
+	token := apiKeyPrefix + k.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	k.Hash = hashAPIToken(token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[k.ID] = k
+	if err := s.save(); err != nil {
+		delete(s.keys, k.ID)
+		log.Printf("[APIKeys] saving: %v", err)
+		return APIKey{}, &apiError{Status: http.StatusInternalServerError, Code: "internal", Message: "cannot save key"}
+	}
+	log.Printf("[APIKeys] %s created key %s (%s) with scopes %s", createdBy, k.ID, k.Name, strings.Join(scopes, ","))
+
+	info := k.info()
+	info.Token = token
+	return info, nil
+}
+
+// Revoke disables a key; revoked keys stay listed
+func (s *APIKeyStore) Revoke(id, revokedBy string) (APIKey, *apiError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, &apiError{Status: http.StatusNotFound, Code: "unknown_key", Message: fmt.Sprintf("no API key with id %q", id), Param: "id"}
+	}
+	if k.RevokedAt == 0 {
+		k.RevokedAt = time.Now().Unix()
+		if err := s.save(); err != nil {
+			k.RevokedAt = 0
+			log.Printf("[APIKeys] saving: %v", err)
+			return APIKey{}, &apiError{Status: http.StatusInternalServerError, Code: "internal", Message: "cannot save key"}
+		}
+		delete(s.buckets, id)
+		log.Printf("[APIKeys] %s revoked key %s (%s)", revokedBy, k.ID, k.Name)
+	}
+	return k.info(), nil
+}
+
+// List returns every key, newest first
+func (s *APIKeyStore) List() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		out = append(out, k.info())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt > out[j].CreatedAt })
+	return out
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup returns the usable key for token
+func (s *APIKeyStore) lookup(token string) (*apiKeyRecord, error) {
+	rest, ok := strings.CutPrefix(token, apiKeyPrefix)
+	if !ok {
+		return nil, errors.New("malformed key")
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil, errors.New("malformed key")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, found := s.keys[id]
+	if !found || !hmac.Equal([]byte(k.Hash), []byte(hashAPIToken(token))) {
+		return nil, errors.New("unknown key")
+	}
+	if k.RevokedAt != 0 {
+		return nil, fmt.Errorf("key %s is revoked", id)
+	}
+	if k.ExpiresAt != 0 && time.Now().Unix() >= k.ExpiresAt {
+		return nil, fmt.Errorf("key %s has expired", id)
+	}
+	return k, nil
+}
+
+// admit applies the key's token bucket and daily quota to one request, setting the
+// X-RateLimit-* and X-Quota-* headers
+func (s *APIKeyStore) admit(w http.ResponseWriter, k *apiKeyRecord) *apiError {
+	now := station.Now()
+	today := now.Format("2006-01-02")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[k.ID]
+	if !ok {
+		b = &tokenBucket{tokens: float64(k.Burst), last: now}
+		s.buckets[k.ID] = b
+	}
+	perSecond := k.RatePerMinute / 60
+	b.tokens = math.Min(float64(k.Burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if k.UsageDay != today {
+		k.UsageDay, k.UsedToday = today, 0
+	}
+
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(k.Burst))
+	if k.QuotaPerDay > 0 {
+		h.Set("X-Quota-Limit", strconv.Itoa(k.QuotaPerDay))
+	}
+	if b.tokens < 1 {
+		wait := math.Ceil((1 - b.tokens) / perSecond)
+		h.Set("X-RateLimit-Remaining", "0")
+		h.Set("Retry-After", strconv.Itoa(int(wait)))
+		return &apiError{Status: http.StatusTooManyRequests, Code: "rate_limited",
+			Message: fmt.Sprintf("rate limit of %g requests per minute exceeded", k.RatePerMinute)}
+	}
+	if k.QuotaPerDay > 0 && k.UsedToday >= k.QuotaPerDay {
+		h.Set("X-Quota-Remaining", "0")
+		h.Set("Retry-After", strconv.Itoa(int(math.Ceil(station.NextDay(now).Sub(now).Seconds()))))
+		return &apiError{Status: http.StatusTooManyRequests, Code: "quota_exceeded",
+			Message: fmt.Sprintf("daily quota of %d requests used up", k.QuotaPerDay)}
+	}
+
+	b.tokens--
+	k.UsedToday++
+	k.LastUsedAt = now.Unix()
+	s.dirty = true
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(int(b.tokens)))
+	if k.QuotaPerDay > 0 {
+		h.Set("X-Quota-Remaining", strconv.Itoa(k.QuotaPerDay-k.UsedToday))
+	}
+	return nil
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// authorize authenticates an /api request carrying a bearer token and checks its
+// scope and limits. On failure it writes the error response and returns false.
+func (s *APIKeyStore) authorize(w http.ResponseWriter, r *http.Request, token string) (*Principal, bool) {
+	k, err := s.lookup(token)
+	if err != nil {
+		log.Printf("[APIKeys] rejected key from %s: %v", clientIP(r), err)
+		apiKeyRequests.Add(1, "unknown", "invalid")
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeAPIError(w, &apiError{Status: http.StatusUnauthorized, Code: "invalid_token", Message: "invalid, expired or revoked API key"})
+		return nil, false
+	}
+
+	scope, allowed := routeScope(r.URL.Path)
+	if !allowed || (scope != "" && !containsString(k.Scopes, scope)) {
+		apiKeyRequests.Add(1, k.ID, "forbidden")
+		msg := "API keys cannot be used for this endpoint"
+		if allowed {
+			msg = fmt.Sprintf("this endpoint requires the %s scope", scope)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+		}
+		writeAPIError(w, &apiError{Status: http.StatusForbidden, Code: "insufficient_scope", Message: msg})
+		return nil, false
+	}
+
+	if apiErr := s.admit(w, k); apiErr != nil {
+		apiKeyRequests.Add(1, k.ID, apiErr.Code)
+		writeAPIError(w, apiErr)
+		return nil, false
+	}
+	apiKeyRequests.Add(1, k.ID, "ok")
+	return &Principal{User: k.Name, Role: roleViewer, Method: authAPIKey, KeyID: k.ID, Scopes: append([]string(nil), k.Scopes...)}, true
+}
+
+// -------------------- /api/keys --------------------
+
+// handleAPIKeys manages API keys (admin only, and never with an API key):
+// GET lists keys, POST creates one, DELETE ?id= revokes one
+func handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	p := currentPrincipal(r)
+	who := p.User
+	if who == "" {
+		who = p.Method
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(apiKeys.List())
+
+	case http.MethodPost:
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			writeAPIError(w, &apiError{Status: http.StatusUnsupportedMediaType, Code: "invalid_body", Message: "expected a JSON body"})
+			return
+		}
+		var req apiKeyRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			writeAPIError(w, &apiError{Status: http.StatusBadRequest, Code: "invalid_body", Message: "expected JSON {\"name\", \"scopes\", ...}"})
+			return
+		}
+		key, apiErr := apiKeys.Create(req, who)
+		if apiErr != nil {
+			writeAPIError(w, apiErr)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(key)
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			writeAPIError(w, badParam("id", "missing_id", "id is required"))
+			return
+		}
+		key, apiErr := apiKeys.Revoke(id, who)
+		if apiErr != nil {
+			writeAPIError(w, apiErr)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(key)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
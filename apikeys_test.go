@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestAPIKeys installs an empty key store with the given defaults
+func newTestAPIKeys(t *testing.T, cfg APIKeysConfig) *APIKeyStore {
+	t.Helper()
+	cfg.File = filepath.Join(t.TempDir(), "api_keys.json")
+	s, err := NewAPIKeyStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := apiKeys
+	apiKeys = s
+	t.Cleanup(func() { apiKeys = saved })
+	return s
+}
+
+func createTestKey(t *testing.T, s *APIKeyStore, req apiKeyRequest) string {
+	t.Helper()
+	key, apiErr := s.Create(req, "test")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	return key.Token
+}
+
+// apiKeyServer routes a few endpoints the way main does, behind the auth middleware
+func apiKeyServer(t *testing.T) http.Handler {
+	t.Helper()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/weather", ok)
+	mux.HandleFunc("/api/csv/range", ok)
+	mux.HandleFunc("/api/alerts", ok)
+	mux.HandleFunc("/api/me", ok)
+	mux.HandleFunc("/api/noaa/monthly", requireAdmin(ok))
+	mux.HandleFunc("/api/notify/test", requireAdmin(ok))
+	mux.HandleFunc("/api/keys", requireAdmin(ok))
+	return newTestAuthenticator(t).Middleware(mux)
+}
+
+// callWithKey requests path with token and returns the response and its error code
+func callWithKey(h http.Handler, path, token string) (*httptest.ResponseRecorder, string) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	return w, body.Error.Code
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	s := newTestAPIKeys(t, APIKeysConfig{RatePerMinute: 600, Burst: 100})
+	h := apiKeyServer(t)
+	series := createTestKey(t, s, apiKeyRequest{Name: "series", Scopes: []string{scopeReadSeries}})
+	all := createTestKey(t, s, apiKeyRequest{Name: "all", Scopes: apiKeyScopes})
+
+	cases := []struct {
+		name, path, token string
+		status            int
+		code              string
+	}{
+		{"series key reads series", "/api/weather", series, http.StatusOK, ""},
+		{"any key reads /api/me", "/api/me", series, http.StatusOK, ""},
+		{"series key on NOAA", "/api/noaa/monthly", series, http.StatusForbidden, "insufficient_scope"},
+		{"series key on notify test", "/api/notify/test", series, http.StatusForbidden, "insufficient_scope"},
+		{"series key on CSV export", "/api/csv/range", series, http.StatusForbidden, "insufficient_scope"},
+		{"series key on alerts", "/api/alerts", series, http.StatusForbidden, "insufficient_scope"},
+		{"series key on /api/keys", "/api/keys", series, http.StatusForbidden, "insufficient_scope"},
+		{"all-scope key on NOAA", "/api/noaa/monthly", all, http.StatusOK, ""},
+		{"all-scope key on notify test", "/api/notify/test", all, http.StatusOK, ""},
+		{"all-scope key on /api/keys", "/api/keys", all, http.StatusForbidden, "insufficient_scope"},
+		{"unknown key", "/api/weather", series + "x", http.StatusUnauthorized, "invalid_token"},
+		{"malformed key", "/api/weather", "not-a-key", http.StatusUnauthorized, "invalid_token"},
+	}
+	for _, c := range cases {
+		w, code := callWithKey(h, c.path, c.token)
+		if w.Code != c.status || code != c.code {
+			t.Errorf("%s: status %d, code %q; want %d, %q", c.name, w.Code, code, c.status, c.code)
+		}
+	}
+}
+
+func TestAPIKeyRevoked(t *testing.T) {
+	s := newTestAPIKeys(t, APIKeysConfig{RatePerMinute: 600, Burst: 100})
+	h := apiKeyServer(t)
+	key, apiErr := s.Create(apiKeyRequest{Name: "revoked", Scopes: []string{scopeReadSeries}}, "test")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if _, apiErr := s.Revoke(key.ID, "test"); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if w, code := callWithKey(h, "/api/weather", key.Token); w.Code != http.StatusUnauthorized || code != "invalid_token" {
+		t.Errorf("revoked key: status %d, code %q; want 401 invalid_token", w.Code, code)
+	}
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	s := newTestAPIKeys(t, APIKeysConfig{})
+	h := apiKeyServer(t)
+	token := createTestKey(t, s, apiKeyRequest{Name: "slow", Scopes: []string{scopeReadSeries}, RatePerMinute: 1, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		if w, code := callWithKey(h, "/api/weather", token); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: status %d, code %q", i+1, w.Code, code)
+		}
+	}
+	w, code := callWithKey(h, "/api/weather", token)
+	if w.Code != http.StatusTooManyRequests || code != "rate_limited" {
+		t.Fatalf("request over the burst: status %d, code %q; want 429 rate_limited", w.Code, code)
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 60 {
+		t.Errorf("Retry-After = %q, want 1-60 seconds", w.Header().Get("Retry-After"))
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+}
+
+func TestAPIKeyQuotaRollsOverAtStationMidnight(t *testing.T) {
+	// Fourteen hours ahead of UTC, so the station's date differs from UTC's most of
+	// the day
+	withStationZone(t, "Pacific/Kiritimati")
+	s := newTestAPIKeys(t, APIKeysConfig{})
+	h := apiKeyServer(t)
+	quota := 2
+	key, apiErr := s.Create(apiKeyRequest{Name: "quota", Scopes: []string{scopeReadSeries}, RatePerMinute: 600, Burst: 100, QuotaPerDay: &quota}, "test")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+
+	for i := 0; i < quota; i++ {
+		if w, code := callWithKey(h, "/api/weather", key.Token); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the quota: status %d, code %q", i+1, w.Code, code)
+		}
+	}
+	w, code := callWithKey(h, "/api/weather", key.Token)
+	if w.Code != http.StatusTooManyRequests || code != "quota_exceeded" {
+		t.Fatalf("request over the quota: status %d, code %q; want 429 quota_exceeded", w.Code, code)
+	}
+	now := station.Now()
+	untilMidnight := station.NextDay(now).Sub(now)
+	retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || time.Duration(retry)*time.Second < untilMidnight || time.Duration(retry)*time.Second > untilMidnight+5*time.Second {
+		t.Errorf("Retry-After = %q, want the %s until station midnight", w.Header().Get("Retry-After"), untilMidnight.Round(time.Second))
+	}
+
+	s.mu.Lock()
+	k := s.keys[key.ID]
+	if today := now.Format("2006-01-02"); k.UsageDay != today {
+		t.Errorf("usage day %q, want the station date %q", k.UsageDay, today)
+	}
+	// Move the usage back to yesterday, as if station midnight had just passed
+	k.UsageDay = now.AddDate(0, 0, -1).Format("2006-01-02")
+	s.mu.Unlock()
+
+	w, code = callWithKey(h, "/api/weather", key.Token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first request of the new day: status %d, code %q", w.Code, code)
+	}
+	if got := w.Header().Get("X-Quota-Remaining"); got != strconv.Itoa(quota-1) {
+		t.Errorf("X-Quota-Remaining = %q, want %d", got, quota-1)
+	}
+}
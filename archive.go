@@ -0,0 +1,317 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Archive is read access to the WeeWX archive table. Column names always come from
+// handler code, never from the request. Each method records its latency under the
+// given query name (weatherdash_db_query_duration_seconds{query}).
+type Archive interface {
+	// Ping checks that the database is reachable
+	Ping(ctx context.Context) error
+	// MaxEpoch returns the newest record's dateTime; ok is false if the archive is empty
+	MaxEpoch(ctx context.Context, query string) (epoch int64, ok bool, err error)
+	// Latest returns cols of the newest record with from <= dateTime <= to, or
+	// sql.ErrNoRows if there is none
+	Latest(ctx context.Context, query string, cols []string, from, to int64) (int64, []sql.NullFloat64, error)
+	// Scan calls fn for each record matching s, ascending by dateTime. vals is in the
+	// order of s.Columns and reused between calls; an error from fn stops the scan.
+	Scan(ctx context.Context, query string, s ArchiveScan, fn func(epoch int64, vals []sql.NullFloat64) error) error
+	// Recent returns up to n non-NULL values of col at or before epoch, newest first
+	Recent(ctx context.Context, query string, col string, epoch int64, n int) ([]float64, error)
+	// Fingerprint returns a digest of the records in [start, end) that changes when a
+	// record is added or removed or one of cols' values changes
+	Fingerprint(ctx context.Context, query string, cols []string, start, end int64) (string, error)
+	// DaySummaries returns WeeWX's daily summaries of obstypes for the days starting in
+	// [start, end), ascending. ok is false if any archive_day_<obstype> table is missing.
+	DaySummaries(ctx context.Context, query string, obstypes []string, start, end int64) (days []DaySummary, ok bool, err error)
+	Close() error
+}
+
+// ArchiveScan selects the records of a range scan
+type ArchiveScan struct {
+	Columns    []string
+	Start, End int64 // [Start, End); use math.MaxInt64 for an open end
+	// NotNull skips records where this column is NULL
+	NotNull string
+	// Limit caps the number of records; 0 means no limit
+	Limit int
+}
+
+// DaySummary is one day of WeeWX's daily summary tables. WeeWX counts the record
+// stamped at midnight in the day that ends there, so a day covers (Start, next
+// midnight].
+type DaySummary struct {
+	Start int64 // local midnight, in WeeWX's time zone
+	Obs   map[string]ObsSummary
+}
+
+// ObsSummary is one row of an archive_day_<obstype> table, in archive units. Sum and
+// Count cover the non-NULL readings; WSum and SumTime weight them by the archive
+// interval in seconds.
+type ObsSummary struct {
+	Min, Max         sql.NullFloat64
+	MinTime, MaxTime int64
+	Sum, WSum        float64
+	SumTime          float64
+	Count            int64
+	// The wind table's Max is the highest gust. XSum and YSum are its interval
+	// weighted east and north speed components and SquareSum the sum of squared speeds.
+	XSum, YSum, SquareSum float64
+}
+
+// archive is the station's archive, opened by main
+var archive Archive
+
+// openArchive opens the archive database selected by cfg.Driver
+func openArchive(cfg DBConfig) (Archive, error) {
+	switch cfg.Driver {
+	case "mysql", "mariadb":
+		return openMySQLArchive(cfg)
+	case "sqlite":
+		return openSQLiteArchive(cfg)
+	}
+	return nil, fmt.Errorf("db.driver %q: must be mysql, mariadb or sqlite", cfg.Driver)
+}
+
+// sqlArchive implements Archive with portable SQL; the drivers differ only in how
+// the connection is opened
+type sqlArchive struct {
+	db *sql.DB
+	// tablesQuery lists the database's archive_day_* table names
+	tablesQuery string
+
+	mu         sync.Mutex
+	summaryObs map[string]bool // obstypes with a daily summary table; nil until listed
+}
+
+func (a *sqlArchive) Ping(ctx context.Context) error {
+	return a.db.PingContext(ctx)
+}
+
+func (a *sqlArchive) Close() error {
+	return a.db.Close()
+}
+
+func (a *sqlArchive) MaxEpoch(ctx context.Context, query string) (int64, bool, error) {
+	var newest sql.NullInt64
+	began := time.Now()
+	err := a.db.QueryRowContext(ctx, `SELECT MAX(dateTime) FROM archive`).Scan(&newest)
+	observeDB(query, began, err)
+	return newest.Int64, newest.Valid, err
+}
+
+func (a *sqlArchive) Latest(ctx context.Context, query string, cols []string, from, to int64) (int64, []sql.NullFloat64, error) {
+	stmt := fmt.Sprintf("SELECT dateTime, %s FROM archive WHERE dateTime >= ? AND dateTime <= ? ORDER BY dateTime DESC LIMIT 1",
+		strings.Join(cols, ", "))
+
+	var epoch int64
+	vals := make([]sql.NullFloat64, len(cols))
+	dest := make([]interface{}, len(cols)+1)
+	dest[0] = &epoch
+	for i := range vals {
+		dest[i+1] = &vals[i]
+	}
+	began := time.Now()
+	err := a.db.QueryRowContext(ctx, stmt, from, to).Scan(dest...)
+	observeDB(query, began, err)
+	if err != nil {
+		return 0, nil, err
+	}
+	return epoch, vals, nil
+}
+
+func (a *sqlArchive) Scan(ctx context.Context, query string, s ArchiveScan, fn func(int64, []sql.NullFloat64) error) error {
+	where := "dateTime >= ? AND dateTime < ?"
+	if s.NotNull != "" {
+		where += " AND " + s.NotNull + " IS NOT NULL"
+	}
+	stmt := fmt.Sprintf("SELECT dateTime, %s FROM archive WHERE %s ORDER BY dateTime ASC", strings.Join(s.Columns, ", "), where)
+	if s.Limit > 0 {
+		stmt += fmt.Sprintf(" LIMIT %d", s.Limit)
+	}
+
+	began := time.Now()
+	rows, err := a.db.QueryContext(ctx, stmt, s.Start, s.End)
+	observeDB(query, began, err)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var epoch int64
+	vals := make([]sql.NullFloat64, len(s.Columns))
+	dest := make([]interface{}, len(s.Columns)+1)
+	dest[0] = &epoch
+	for i := range vals {
+		dest[i+1] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if err := fn(epoch, vals); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (a *sqlArchive) Recent(ctx context.Context, query string, col string, epoch int64, n int) ([]float64, error) {
+	stmt := fmt.Sprintf("SELECT %s FROM archive WHERE dateTime <= ? AND %s IS NOT NULL ORDER BY dateTime DESC LIMIT ?", col, col)
+	began := time.Now()
+	rows, err := a.db.QueryContext(ctx, stmt, epoch, n)
+	observeDB(query, began, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []float64
+	for rows.Next() {
+		var v float64
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// fingerprintModulus weights each value by its record's position so that values
+// moved between records change the digest too
+const fingerprintModulus = 8191
+
+func (a *sqlArchive) Fingerprint(ctx context.Context, query string, cols []string, start, end int64) (string, error) {
+	exprs := []string{"COUNT(*)", "MAX(dateTime)"}
+	for _, col := range cols {
+		exprs = append(exprs, "COUNT("+col+")", "SUM("+col+")",
+			fmt.Sprintf("SUM(%s * (dateTime %% %d))", col, fingerprintModulus))
+	}
+	stmt := fmt.Sprintf("SELECT %s FROM archive WHERE dateTime >= ? AND dateTime < ?", strings.Join(exprs, ", "))
+
+	vals := make([]sql.NullFloat64, len(exprs))
+	dest := make([]interface{}, len(vals))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	began := time.Now()
+	err := a.db.QueryRowContext(ctx, stmt, start, end).Scan(dest...)
+	observeDB(query, began, err)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i, v := range vals {
+		if i > 0 {
+			b.WriteByte('|')
+		}
+		if v.Valid {
+			b.WriteString(strconv.FormatFloat(v.Float64, 'g', -1, 64))
+		}
+	}
+	return b.String(), nil
+}
+
+// summaryTables returns the obstypes WeeWX keeps daily summaries for. The list is
+// read once; WeeWX creates the tables when it first opens the database.
+func (a *sqlArchive) summaryTables(ctx context.Context) (map[string]bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.summaryObs != nil {
+		return a.summaryObs, nil
+	}
+	began := time.Now()
+	rows, err := a.db.QueryContext(ctx, a.tablesQuery)
+	observeDB("daily_summary_tables", began, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	obs := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		// archive_day__metadata isn't an observation
+		if t, ok := strings.CutPrefix(name, "archive_day_"); ok && t != "" && !strings.HasPrefix(t, "_") {
+			obs[t] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	a.summaryObs = obs
+	return obs, nil
+}
+
+func (a *sqlArchive) DaySummaries(ctx context.Context, query string, obstypes []string, start, end int64) ([]DaySummary, bool, error) {
+	tables, err := a.summaryTables(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, t := range obstypes {
+		if !tables[t] {
+			return nil, false, nil
+		}
+	}
+
+	var days []DaySummary
+	index := make(map[int64]int)
+	for _, t := range obstypes {
+		cols := "dateTime, min, mintime, max, maxtime, sum, count, wsum, sumtime"
+		if t == "wind" {
+			cols += ", xsum, ysum, squaresum"
+		}
+		stmt := fmt.Sprintf("SELECT %s FROM archive_day_%s WHERE dateTime >= ? AND dateTime < ? ORDER BY dateTime ASC", cols, t)
+		began := time.Now()
+		rows, err := a.db.QueryContext(ctx, stmt, start, end)
+		observeDB(query, began, err)
+		if err != nil {
+			return nil, false, err
+		}
+		for rows.Next() {
+			var day int64
+			var s ObsSummary
+			var minTime, maxTime, count sql.NullInt64
+			var sum, wsum, sumTime, xsum, ysum, squareSum sql.NullFloat64
+			dest := []interface{}{&day, &s.Min, &minTime, &s.Max, &maxTime, &sum, &count, &wsum, &sumTime}
+			if t == "wind" {
+				dest = append(dest, &xsum, &ysum, &squareSum)
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return nil, false, err
+			}
+			s.MinTime, s.MaxTime, s.Count = minTime.Int64, maxTime.Int64, count.Int64
+			s.Sum, s.WSum, s.SumTime = sum.Float64, wsum.Float64, sumTime.Float64
+			s.XSum, s.YSum, s.SquareSum = xsum.Float64, ysum.Float64, squareSum.Float64
+
+			i, ok := index[day]
+			if !ok {
+				i = len(days)
+				index[day] = i
+				days = append(days, DaySummary{Start: day, Obs: make(map[string]ObsSummary, len(obstypes))})
+			}
+			days[i].Obs[t] = s
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	slices.SortFunc(days, func(a, b DaySummary) int { return cmp.Compare(a.Start, b.Start) })
+	return days, true, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// openMySQLArchive connects to a MySQL or MariaDB WeeWX database
+func openMySQLArchive(cfg DBConfig) (*sqlArchive, error) {
+	db, err := sql.Open("mysql", mysqlDSN(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return &sqlArchive{
+		db:          db,
+		tablesQuery: `SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name LIKE 'archive_day_%'`,
+	}, nil
+}
+
+// mysqlDSN builds e.g. "user:pass@tcp(host:port)/name?params"
+func mysqlDSN(cfg DBConfig) string {
+	if cfg.Params != "" {
+		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?%s",
+			cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name, cfg.Params)
+	}
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
+		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name)
+}
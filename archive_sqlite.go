@@ -0,0 +1,39 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+
+	_ "modernc.org/sqlite"
+)
+
+// openSQLiteArchive opens a WeeWX SQLite database (weewx.sdb) read-only. WeeWX keeps
+// writing to the file, so readers wait for its locks instead of failing with
+// SQLITE_BUSY.
+func openSQLiteArchive(cfg DBConfig) (*sqlArchive, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("db.path is required for the sqlite driver")
+	}
+	params := url.Values{}
+	params.Set("mode", "ro")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "query_only(1)")
+	if cfg.Params != "" {
+		extra, err := url.ParseQuery(cfg.Params)
+		if err != nil {
+			return nil, err
+		}
+		for k, vs := range extra {
+			params[k] = append(params[k], vs...)
+		}
+	}
+	db, err := sql.Open("sqlite", "file:"+cfg.Path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return &sqlArchive{
+		db:          db,
+		tablesQuery: `SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'archive_day_%'`,
+	}, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRecord is one archive row; columns missing from vals are NULL
+type testRecord struct {
+	epoch int64
+	vals  map[string]float64
+}
+
+// newTestArchive creates a WeeWX-style SQLite database holding records, opens it
+// read-only as the global archive and returns a writable handle for edits
+func newTestArchive(t *testing.T, records []testRecord) (*sqlArchive, *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "weewx.sdb")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cols := make([]string, len(sseColumns))
+	for i, c := range sseColumns {
+		cols[i] = c + " REAL"
+	}
+	if _, err := db.Exec("CREATE TABLE archive (dateTime INTEGER NOT NULL PRIMARY KEY, usUnits INTEGER NOT NULL, `interval` INTEGER NOT NULL, " + strings.Join(cols, ", ") + ")"); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		insertTestRecord(t, db, r)
+	}
+
+	a, err := openSQLiteArchive(DBConfig{Driver: "sqlite", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	saved := archive
+	archive = a
+	t.Cleanup(func() { archive = saved })
+	return a, db
+}
+
+func insertTestRecord(t *testing.T, db *sql.DB, r testRecord) {
+	t.Helper()
+	names := []string{"dateTime", "usUnits", "`interval`"}
+	args := []interface{}{r.epoch, 1, 5}
+	for col, v := range r.vals {
+		names = append(names, col)
+		args = append(args, v)
+	}
+	stmt := fmt.Sprintf("INSERT INTO archive (%s) VALUES (?%s)", strings.Join(names, ", "), strings.Repeat(", ?", len(names)-1))
+	if _, err := db.Exec(stmt, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveFingerprint(t *testing.T) {
+	var records []testRecord
+	for i := int64(0); i < 12; i++ {
+		records = append(records, testRecord{epoch: 1718900000 + i*300, vals: map[string]float64{"outTemp": 70 + float64(i), "windSpeed": 3}})
+	}
+	a, db := newTestArchive(t, records)
+	ctx := context.Background()
+	start, end := int64(1718900000), int64(1718900000+12*300)
+
+	fingerprint := func() string {
+		t.Helper()
+		fp, err := a.Fingerprint(ctx, "test", []string{"outTemp", "dewpoint"}, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+	base := fingerprint()
+	if again := fingerprint(); again != base {
+		t.Fatalf("fingerprint not stable: %q then %q", base, again)
+	}
+
+	exec := func(stmt string, args ...interface{}) {
+		t.Helper()
+		if _, err := db.Exec(stmt, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	steps := []struct {
+		name    string
+		stmt    string
+		args    []interface{}
+		changes bool
+	}{
+		{"unrelated column edited", "UPDATE archive SET windSpeed = 9 WHERE dateTime = ?", []interface{}{start + 600}, false},
+		{"value corrected", "UPDATE archive SET outTemp = 99 WHERE dateTime = ?", []interface{}{start + 600}, true},
+		{"values swapped between records", "UPDATE archive SET outTemp = CASE dateTime WHEN ? THEN 71 ELSE 70 END WHERE dateTime IN (?, ?)", []interface{}{start, start, start + 300}, true},
+		{"NULL filled in", "UPDATE archive SET dewpoint = 50 WHERE dateTime = ?", []interface{}{start + 900}, true},
+		{"record deleted", "DELETE FROM archive WHERE dateTime = ?", []interface{}{start + 1200}, true},
+		{"record outside the range", "INSERT INTO archive (dateTime, usUnits, `interval`, outTemp) VALUES (?, 1, 5, 80)", []interface{}{end}, false},
+	}
+	for _, step := range steps {
+		exec(step.stmt, step.args...)
+		got := fingerprint()
+		if (got != base) != step.changes {
+			t.Errorf("%s: fingerprint changed=%v, want %v", step.name, got != base, step.changes)
+		}
+		base = got
+	}
+}
+
+func TestExportKeyFollowsArchiveValues(t *testing.T) {
+	withStationZone(t, "UTC")
+	start := time.Unix(1718900000, 0).UTC()
+	_, db := newTestArchive(t, []testRecord{
+		{epoch: start.Unix(), vals: map[string]float64{"outTemp": 70}},
+		{epoch: start.Unix() + 300, vals: map[string]float64{"outTemp": 71}},
+	})
+	format, _ := parseExportFormat("csv")
+	job := &exportJob{name: "test_export", format: format, cols: []*exportColumn{exportColumnByKey("outTemp")}, units: displayUnits, start: start, end: start.Add(time.Hour)}
+
+	before, err := job.key(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE archive SET outTemp = 72 WHERE dateTime = ?", start.Unix()+300); err != nil {
+		t.Fatal(err)
+	}
+	after, err := job.key(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("export key unchanged after a value was corrected")
+	}
+}
+
+// addTestDaySummaries writes WeeWX daily summaries of obstypes for records, which
+// must be 5 minutes apart. As in WeeWX, the record stamped at midnight counts towards
+// the day that ends there; the wind table summarises windSpeed and windDir.
+func addTestDaySummaries(t *testing.T, db *sql.DB, obstypes []string, records []testRecord) {
+	t.Helper()
+	const interval = 300
+	for _, obs := range obstypes {
+		cols := "dateTime INTEGER NOT NULL PRIMARY KEY, min REAL, mintime INTEGER, max REAL, maxtime INTEGER, sum REAL, count INTEGER, wsum REAL, sumtime INTEGER"
+		if obs == "wind" {
+			cols += ", max_dir REAL, xsum REAL, ysum REAL, dirsumtime INTEGER, squaresum REAL, wsquaresum REAL"
+		}
+		if _, err := db.Exec(fmt.Sprintf("CREATE TABLE archive_day_%s (%s)", obs, cols)); err != nil {
+			t.Fatal(err)
+		}
+
+		type day struct {
+			min, max, sum, xsum, ysum, squaresum float64
+			mintime, maxtime, count              int64
+		}
+		days := make(map[int64]*day)
+		for _, r := range records {
+			col := obs
+			if obs == "wind" {
+				col = "windSpeed"
+			}
+			v, ok := r.vals[col]
+			if !ok {
+				continue
+			}
+			start := station.StartOfDay(station.In(r.epoch - 1)).Unix()
+			d := days[start]
+			if d == nil {
+				d = &day{min: v, max: v, mintime: r.epoch, maxtime: r.epoch}
+				days[start] = d
+			}
+			if v < d.min {
+				d.min, d.mintime = v, r.epoch
+			}
+			if v > d.max {
+				d.max, d.maxtime = v, r.epoch
+			}
+			d.sum += v
+			d.count++
+			if obs == "wind" {
+				if dir, ok := r.vals["windDir"]; ok {
+					rad := dir * math.Pi / 180
+					d.xsum += v * math.Sin(rad) * interval
+					d.ysum += v * math.Cos(rad) * interval
+				}
+				d.squaresum += v * v
+			}
+		}
+		for start, d := range days {
+			stmt := fmt.Sprintf("INSERT INTO archive_day_%s (dateTime, min, mintime, max, maxtime, sum, count, wsum, sumtime) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", obs)
+			if _, err := db.Exec(stmt, start, d.min, d.mintime, d.max, d.maxtime, d.sum, d.count, d.sum*interval, d.count*interval); err != nil {
+				t.Fatal(err)
+			}
+			if obs == "wind" {
+				if _, err := db.Exec("UPDATE archive_day_wind SET xsum = ?, ysum = ?, squaresum = ? WHERE dateTime = ?", d.xsum, d.ysum, d.squaresum, start); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles. admin and user have full permissions; viewer is read-only; none (only valid
+// as the anonymous role) means a login is required.
+const (
+	roleAdmin  = "admin"
+	roleUser   = "user"
+	roleViewer = "viewer"
+	roleNone   = "none"
+)
+
+var validRoles = []string{roleAdmin, roleUser, roleViewer}
+
+// How a request was authenticated
+const (
+	authHubHMAC   = "hub-hmac"
+	authHubJWT    = "hub-jwt"
+	authSession   = "session"
+	authAPIKey    = "api-key"
+	authAnonymous = "anonymous"
+)
+
+const sessionCookieName = "wd_session"
+
+// Principal is the identity a request is acting as
+type Principal struct {
+	User   string `json:"user,omitempty"`
+	Role   string `json:"role"`
+	Method string `json:"method"`
+	// Set for API keys
+	KeyID  string   `json:"keyId,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+func (p *Principal) Authenticated() bool {
+	return p.Method != authAnonymous
+}
+
+type principalKey struct{}
+
+// localSession is a logged-in local user
+type localSession struct {
+	user    string
+	role    string
+	expires time.Time
+}
+
+// loginAttempts throttles password guessing per client address
+type loginAttempts struct {
+	failures int
+	since    time.Time
+}
+
+const (
+	maxLoginFailures   = 10
+	loginFailureWindow = 15 * time.Minute
+)
+
+// Authenticator resolves each request to a Principal: a verified hub identity, a
+// local session, or the anonymous role
+type Authenticator struct {
+	anonymousRole string
+	hubSecret     []byte
+	jwtSecret     []byte
+	issuer        string
+	maxSkew       time.Duration
+	sessionTTL    time.Duration
+	cookieSecure  bool
+	metricsToken  []byte
+	users         map[string]LocalUserConfig
+
+	mu       sync.Mutex
+	sessions map[string]*localSession
+	attempts map[string]*loginAttempts
+}
+
+var auth *Authenticator
+
+func NewAuthenticator(cfg AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		anonymousRole: strings.ToLower(cfg.AnonymousRole),
+		issuer:        cfg.Hub.Issuer,
+		cookieSecure:  cfg.CookieSecure,
+		users:         make(map[string]LocalUserConfig),
+		sessions:      make(map[string]*localSession),
+		attempts:      make(map[string]*loginAttempts),
+	}
+	if a.anonymousRole != roleNone && !containsString(validRoles, a.anonymousRole) {
+		return nil, fmt.Errorf("auth.anonymous_role %q (use admin, user, viewer or none)", cfg.AnonymousRole)
+	}
+	if cfg.Hub.Secret != "" {
+		a.hubSecret = []byte(cfg.Hub.Secret)
+	}
+	if cfg.Hub.JWTSecret != "" {
+		a.jwtSecret = []byte(cfg.Hub.JWTSecret)
+	}
+	if cfg.MetricsToken != "" {
+		a.metricsToken = []byte(cfg.MetricsToken)
+	}
+
+	var err error
+	if a.maxSkew, err = time.ParseDuration(cfg.Hub.MaxSkew); err != nil || a.maxSkew < 0 {
+		return nil, fmt.Errorf("auth.hub.max_skew %q: not a valid duration", cfg.Hub.MaxSkew)
+	}
+	if a.sessionTTL, err = time.ParseDuration(cfg.SessionTTL); err != nil || a.sessionTTL <= 0 {
+		return nil, fmt.Errorf("auth.session_ttl %q: not a valid duration", cfg.SessionTTL)
+	}
+
+	for i, u := range cfg.Users {
+		if u.Username == "" {
+			return nil, fmt.Errorf("auth.users[%d]: username is required", i)
+		}
+		if _, dup := a.users[u.Username]; dup {
+			return nil, fmt.Errorf("auth.users: duplicate username %q", u.Username)
+		}
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("auth.users %q: password_hash is not a bcrypt hash (generate one with: MyWeatherDash hash-password)", u.Username)
+		}
+		u.Role = strings.ToLower(u.Role)
+		if u.Role == "" {
+			u.Role = roleViewer
+		}
+		if !containsString(validRoles, u.Role) {
+			return nil, fmt.Errorf("auth.users %q: role %q (use admin, user or viewer)", u.Username, u.Role)
+		}
+		a.users[u.Username] = u
+	}
+
+	if a.anonymousRole == roleAdmin || a.anonymousRole == roleUser {
+		log.Printf("[Auth] WARNING: anonymous requests get the %q role; anyone who can reach the port has full access", a.anonymousRole)
+	}
+	if a.anonymousRole == roleNone && len(a.users) == 0 && a.hubSecret == nil && a.jwtSecret == nil {
+		log.Printf("[Auth] WARNING: anonymous_role is none but no users or hub secrets are configured; nobody can sign in")
+	}
+	log.Printf("[Auth] anonymous=%s hub-hmac=%t hub-jwt=%t local users=%d",
+		a.anonymousRole, a.hubSecret != nil, a.jwtSecret != nil, len(a.users))
+	return a, nil
+}
+
+// LoginEnabled reports whether local accounts are configured
+func (a *Authenticator) LoginEnabled() bool {
+	return len(a.users) > 0
+}
+
+// authenticate resolves r's identity. Invalid hub credentials or sessions fall back
+// to the anonymous role rather than failing the request.
+func (a *Authenticator) authenticate(r *http.Request) *Principal {
+	if tok := r.Header.Get("X-Hub-Token"); tok != "" && a.jwtSecret != nil {
+		p, err := a.verifyHubJWT(tok)
+		if err == nil {
+			return p
+		}
+		log.Printf("[Auth] rejected hub token from %s: %v", clientIP(r), err)
+	}
+	if sig := r.Header.Get("X-Hub-Signature"); sig != "" && a.hubSecret != nil {
+		p, err := a.verifyHubHMAC(r.Header)
+		if err == nil {
+			return p
+		}
+		log.Printf("[Auth] rejected hub signature from %s: %v", clientIP(r), err)
+	}
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		if p := a.session(c.Value); p != nil {
+			return p
+		}
+	}
+	return &Principal{Role: a.anonymousRole, Method: authAnonymous}
+}
+
+// -------------------- hub verification --------------------
+
+// verifyHubHMAC checks X-Hub-Signature, the hex HMAC-SHA256 of
+// "<X-Hub-User>\n<X-Hub-Role>\n<X-Hub-Timestamp>" under the shared secret
+func (a *Authenticator) verifyHubHMAC(h http.Header) (*Principal, error) {
+	user, role, ts := h.Get("X-Hub-User"), h.Get("X-Hub-Role"), h.Get("X-Hub-Timestamp")
+	epoch, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, errors.New("missing or invalid X-Hub-Timestamp")
+	}
+	if skew := time.Since(time.Unix(epoch, 0)); skew > a.maxSkew || skew < -a.maxSkew {
+		return nil, fmt.Errorf("timestamp outside the allowed skew (%s)", a.maxSkew)
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(h.Get("X-Hub-Signature"), "sha256="))
+	if err != nil {
+		return nil, errors.New("signature is not hex")
+	}
+	mac := hmac.New(sha256.New, a.hubSecret)
+	mac.Write([]byte(user + "\n" + role + "\n" + ts))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("signature mismatch")
+	}
+	role = strings.ToLower(role)
+	if !containsString(validRoles, role) {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+	return &Principal{User: user, Role: role, Method: authHubHMAC}, nil
+}
+
+// hubClaims are the JWT claims the hub sends
+type hubClaims struct {
+	Sub  string   `json:"sub"`
+	User string   `json:"user"`
+	Role string   `json:"role"`
+	Iss  string   `json:"iss"`
+	Exp  *float64 `json:"exp"`
+	Nbf  *float64 `json:"nbf"`
+}
+
+// verifyHubJWT checks an HS256 JWT from X-Hub-Token. exp is required.
+func (a *Authenticator) verifyHubJWT(tok string) (*Principal, error) {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("unsupported alg %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("signature is not base64url")
+	}
+	mac := hmac.New(sha256.New, a.jwtSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("signature mismatch")
+	}
+
+	var claims hubClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	now := float64(time.Now().Unix())
+	skew := a.maxSkew.Seconds()
+	if claims.Exp == nil {
+		return nil, errors.New("exp claim is required")
+	}
+	if now > *claims.Exp+skew {
+		return nil, errors.New("token expired")
+	}
+	if claims.Nbf != nil && now+skew < *claims.Nbf {
+		return nil, errors.New("token not valid yet")
+	}
+	if a.issuer != "" && claims.Iss != a.issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Iss)
+	}
+	role := strings.ToLower(claims.Role)
+	if !containsString(validRoles, role) {
+		return nil, fmt.Errorf("unknown role %q", claims.Role)
+	}
+	user := claims.Sub
+	if user == "" {
+		user = claims.User
+	}
+	return &Principal{User: user, Role: role, Method: authHubJWT}, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("not base64url")
+	}
+	return json.Unmarshal(b, v)
+}
+
+// -------------------- local sessions --------------------
+
+// dummyHash is compared against when the username is unknown, so a failed login
+// takes as long whether or not the account exists
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("weatherdash"), bcrypt.DefaultCost)
+
+// checkPassword verifies a local user's password
+func (a *Authenticator) checkPassword(username, password string) (LocalUserConfig, bool) {
+	u, ok := a.users[username]
+	hash := []byte(u.PasswordHash)
+	if !ok {
+		hash = dummyHash
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !ok {
+		return LocalUserConfig{}, false
+	}
+	return u, true
+}
+
+func (a *Authenticator) newSession(u LocalUserConfig) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	expires := time.Now().Add(a.sessionTTL)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for t, s := range a.sessions {
+		if now.After(s.expires) {
+			delete(a.sessions, t)
+		}
+	}
+	a.sessions[token] = &localSession{user: u.Username, role: u.Role, expires: expires}
+	return token, expires, nil
+}
+
+func (a *Authenticator) session(token string) *Principal {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[token]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(s.expires) {
+		delete(a.sessions, token)
+		return nil
+	}
+	// Accounts removed from the config lose their sessions on restart; role changes
+	// likewise apply at the next login
+	return &Principal{User: s.user, Role: s.role, Method: authSession}
+}
+
+func (a *Authenticator) endSession(token string) {
+	a.mu.Lock()
+	delete(a.sessions, token)
+	a.mu.Unlock()
+}
+
+// loginAllowed reports whether ip may attempt another login
+func (a *Authenticator) loginAllowed(ip string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	at, ok := a.attempts[ip]
+	if !ok || time.Since(at.since) > loginFailureWindow {
+		return true
+	}
+	return at.failures < maxLoginFailures
+}
+
+func (a *Authenticator) recordLogin(ip string, success bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if success {
+		delete(a.attempts, ip)
+		return
+	}
+	at, ok := a.attempts[ip]
+	if !ok || time.Since(at.since) > loginFailureWindow {
+		at = &loginAttempts{since: time.Now()}
+		a.attempts[ip] = at
+	}
+	at.failures++
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// -------------------- middleware and role checks --------------------
+
+// authPublicPaths stay reachable when the anonymous role is none. /metrics is not
+// one: it carries the live readings and API key ids, so scrapers send
+// auth.metrics_token instead.
+var authPublicPaths = []string{"/login", "/logout", "/health", "/health/live", "/health/ready", "/api/ping", "/api/me"}
+
+// Middleware attaches the request's Principal to its context. /api requests with an
+// Authorization: Bearer API key are checked against the key's scopes and limits.
+// With anonymous_role none, unauthenticated page requests are sent to /login and API
+// and /metrics requests get 401.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok && apiKeys != nil && strings.HasPrefix(r.URL.Path, "/api/") {
+			p, ok := apiKeys.authorize(w, r, token)
+			if ok {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+			}
+			return
+		}
+		p := a.authenticate(r)
+		if p.Role == roleNone && !containsString(authPublicPaths, r.URL.Path) && !strings.HasPrefix(r.URL.Path, "/static/") && !a.metricsScrape(r) {
+			if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/metrics" {
+				writeAPIError(w, &apiError{Status: http.StatusUnauthorized, Code: "unauthenticated", Message: "login required"})
+				return
+			}
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// metricsScrape reports whether r is a /metrics request with the configured scrape
+// token
+func (a *Authenticator) metricsScrape(r *http.Request) bool {
+	if r.URL.Path != "/metrics" || a.metricsToken == nil {
+		return false
+	}
+	token, ok := bearerToken(r)
+	return ok && subtle.ConstantTimeCompare([]byte(token), a.metricsToken) == 1
+}
+
+// currentPrincipal returns the identity attached by Middleware
+func currentPrincipal(r *http.Request) *Principal {
+	if p, ok := r.Context().Value(principalKey{}).(*Principal); ok {
+		return p
+	}
+	if auth != nil {
+		return auth.authenticate(r)
+	}
+	return &Principal{Role: roleViewer, Method: authAnonymous}
+}
+
+// getUserRole returns the role of the request's principal
+func getUserRole(r *http.Request) string {
+	return currentPrincipal(r).Role
+}
+
+// isAdmin checks if the user has admin or user role (full permissions)
+func isAdmin(r *http.Request) bool {
+	role := getUserRole(r)
+	return role == roleAdmin || role == roleUser
+}
+
+// requireAdmin is middleware that blocks non-admin users: 401 when nobody is
+// signed in, 403 for a signed-in user without the role. API keys reaching an admin
+// route already passed the route's scope check in Middleware.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) && currentPrincipal(r).Method != authAPIKey {
+			if !currentPrincipal(r).Authenticated() {
+				http.Error(w, "Unauthorized: login required", http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// -------------------- /login, /logout, /api/me --------------------
+
+var tmplLogin = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Sign in - {{ .Station }}</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; background: #e7edf4; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
+        form { background: #fff; border: 1px solid #d2d7e0; border-radius: 10px; padding: 28px; width: 300px; box-shadow: 0 4px 16px rgba(0,0,0,0.06); }
+        h1 { font-size: 1.2rem; margin: 0 0 18px; }
+        label { display: block; font-size: 0.85rem; margin: 12px 0 4px; color: #4b5563; }
+        input { width: 100%; box-sizing: border-box; padding: 8px 10px; border: 1px solid #d2d7e0; border-radius: 6px; font-size: 0.95rem; }
+        button { margin-top: 18px; width: 100%; padding: 9px; border: none; border-radius: 6px; background: #2563eb; color: #fff; font-size: 0.95rem; cursor: pointer; }
+        .error { color: #b91c1c; font-size: 0.85rem; margin-top: 10px; }
+    </style>
+</head>
+<body>
+    <form method="post" action="/login">
+        <h1>{{ .Station }}</h1>
+        <input type="hidden" name="next" value="{{ .Next }}">
+        <label for="username">Username</label>
+        <input id="username" name="username" autocomplete="username" value="{{ .Username }}" required autofocus>
+        <label for="password">Password</label>
+        <input id="password" name="password" type="password" autocomplete="current-password" required>
+        {{ if .Error }}<div class="error">{{ .Error }}</div>{{ end }}
+        <button type="submit">Sign in</button>
+    </form>
+</body>
+</html>`))
+
+// safeNext only allows local redirect targets
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func renderLogin(w http.ResponseWriter, status int, next, username, msg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = tmplLogin.Execute(w, map[string]string{
+		"Station":  appConfig.Location.Name,
+		"Next":     next,
+		"Username": username,
+		"Error":    msg,
+	})
+}
+
+// handleLogin serves the sign-in form (GET) and checks credentials (POST, as a form
+// or JSON {"username","password"})
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !auth.LoginEnabled() {
+		http.Error(w, "Local accounts are not configured", http.StatusNotFound)
+		return
+	}
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		renderLogin(w, http.StatusOK, safeNext(r.URL.Query().Get("next")), "", "")
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var creds struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Next     string `json:"next"`
+	}
+	if isJSON {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&creds); err != nil {
+			writeAPIError(w, &apiError{Status: http.StatusBadRequest, Code: "invalid_body", Message: "expected JSON {\"username\", \"password\"}"})
+			return
+		}
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, 4096)
+		creds.Username = r.PostFormValue("username")
+		creds.Password = r.PostFormValue("password")
+		creds.Next = r.PostFormValue("next")
+	}
+	next := safeNext(creds.Next)
+
+	ip := clientIP(r)
+	if !auth.loginAllowed(ip) {
+		log.Printf("[Auth] login throttled for %s", ip)
+		if isJSON {
+			writeAPIError(w, &apiError{Status: http.StatusTooManyRequests, Code: "too_many_attempts", Message: "too many failed logins; try again later"})
+		} else {
+			renderLogin(w, http.StatusTooManyRequests, next, creds.Username, "Too many failed attempts. Try again later.")
+		}
+		return
+	}
+
+	u, ok := auth.checkPassword(creds.Username, creds.Password)
+	auth.recordLogin(ip, ok)
+	if !ok {
+		log.Printf("[Auth] failed login for %q from %s", creds.Username, ip)
+		if isJSON {
+			writeAPIError(w, &apiError{Status: http.StatusUnauthorized, Code: "invalid_credentials", Message: "invalid username or password"})
+		} else {
+			renderLogin(w, http.StatusUnauthorized, next, creds.Username, "Invalid username or password.")
+		}
+		return
+	}
+
+	token, expires, err := auth.newSession(u)
+	if err != nil {
+		log.Printf("[Auth] creating session: %v", err)
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   auth.cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Printf("[Auth] %s signed in (%s)", u.Username, u.Role)
+
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Principal{User: u.Username, Role: u.Role, Method: authSession})
+		return
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// handleLogout ends the local session
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		auth.endSession(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   auth.cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleMe returns the caller's identity
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	p := currentPrincipal(r)
+	_ = json.NewEncoder(w).Encode(struct {
+		*Principal
+		Admin        bool `json:"admin"`
+		LoginEnabled bool `json:"loginEnabled"`
+	}{p, isAdmin(r), auth.LoginEnabled()})
+}
+
+// -------------------- hash-password --------------------
+
+// runHashPassword implements `MyWeatherDash hash-password`: it reads a password from
+// stdin and prints its bcrypt hash for auth.users[].password_hash
+func runHashPassword() {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatal("reading password: ", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		log.Fatal("empty password")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(hash))
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testHubSecret = "hub-secret"
+	testJWTSecret = "jwt-secret"
+)
+
+// newTestAuthenticator requires a login (anonymous role none), verifies hub headers
+// with a one minute skew and has one local viewer, "alice" with password "hunter2"
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAuthenticator(AuthConfig{
+		AnonymousRole: roleNone,
+		Hub:           HubAuthConfig{Secret: testHubSecret, JWTSecret: testJWTSecret, MaxSkew: "1m"},
+		Users:         []LocalUserConfig{{Username: "alice", PasswordHash: string(hash), Role: roleViewer}},
+		SessionTTL:    "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := auth
+	auth = a
+	t.Cleanup(func() { auth = saved })
+	return a
+}
+
+// hubHeaders returns headers signed as the hub would for user and role at ts
+func hubHeaders(secret, user, role string, ts time.Time) http.Header {
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(user + "\n" + role + "\n" + stamp))
+	h := http.Header{}
+	h.Set("X-Hub-User", user)
+	h.Set("X-Hub-Role", role)
+	h.Set("X-Hub-Timestamp", stamp)
+	h.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return h
+}
+
+// signJWT builds a token with the given header and claims, signed with HS256 under secret
+func signJWT(t *testing.T, secret string, header, claims map[string]interface{}) string {
+	t.Helper()
+	part := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signing := part(header) + "." + part(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signing))
+	return signing + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyHubHMAC(t *testing.T) {
+	a := newTestAuthenticator(t)
+	now := time.Now()
+
+	p, err := a.verifyHubHMAC(hubHeaders(testHubSecret, "bob", "Admin", now))
+	if err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if p.User != "bob" || p.Role != roleAdmin || p.Method != authHubHMAC {
+		t.Errorf("principal = %+v", p)
+	}
+
+	tampered := hubHeaders(testHubSecret, "bob", "viewer", now)
+	tampered.Set("X-Hub-Role", "admin")
+	replayed := hubHeaders(testHubSecret, "bob", "admin", now.Add(-5*time.Minute))
+	replayed.Set("X-Hub-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	noStamp := hubHeaders(testHubSecret, "bob", "admin", now)
+	noStamp.Del("X-Hub-Timestamp")
+	cases := map[string]http.Header{
+		"wrong secret":                    hubHeaders("other-secret", "bob", "admin", now),
+		"role changed after signing":      tampered,
+		"stale timestamp":                 hubHeaders(testHubSecret, "bob", "admin", now.Add(-2*time.Minute)),
+		"future timestamp":                hubHeaders(testHubSecret, "bob", "admin", now.Add(2*time.Minute)),
+		"replayed with a fresh timestamp": replayed,
+		"missing timestamp":               noStamp,
+		"unknown role":                    hubHeaders(testHubSecret, "bob", "root", now),
+	}
+	for name, h := range cases {
+		if p, err := a.verifyHubHMAC(h); err == nil {
+			t.Errorf("%s: accepted as %+v", name, p)
+		}
+	}
+}
+
+func TestVerifyHubJWT(t *testing.T) {
+	a := newTestAuthenticator(t)
+	hs256 := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tok := signJWT(t, testJWTSecret, hs256, map[string]interface{}{"sub": "carol", "role": "user", "exp": exp})
+	p, err := a.verifyHubJWT(tok)
+	if err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if p.User != "carol" || p.Role != roleUser || p.Method != authHubJWT {
+		t.Errorf("principal = %+v", p)
+	}
+
+	valid := map[string]interface{}{"sub": "carol", "role": "admin", "exp": exp}
+	parts := strings.Split(signJWT(t, testJWTSecret, hs256, valid), ".")
+	// The admin claims under the signature of a viewer token
+	viewer := strings.Split(signJWT(t, testJWTSecret, hs256, map[string]interface{}{"sub": "carol", "role": "viewer", "exp": exp}), ".")
+	forged := parts[0] + "." + parts[1] + "." + viewer[2]
+	unsigned := strings.Split(signJWT(t, testJWTSecret, map[string]interface{}{"alg": "none"}, valid), ".")
+	unsigned[2] = ""
+	cases := map[string]string{
+		"wrong secret":      signJWT(t, "other-secret", hs256, valid),
+		"claims swapped":    forged,
+		"alg none":          strings.Join(unsigned, "."),
+		"alg HS512":         signJWT(t, testJWTSecret, map[string]interface{}{"alg": "HS512"}, valid),
+		"alg RS256":         signJWT(t, testJWTSecret, map[string]interface{}{"alg": "RS256"}, valid),
+		"missing exp":       signJWT(t, testJWTSecret, hs256, map[string]interface{}{"sub": "carol", "role": "admin"}),
+		"expired":           signJWT(t, testJWTSecret, hs256, map[string]interface{}{"sub": "carol", "role": "admin", "exp": time.Now().Add(-2 * time.Minute).Unix()}),
+		"not valid yet":     signJWT(t, testJWTSecret, hs256, map[string]interface{}{"sub": "carol", "role": "admin", "exp": exp, "nbf": time.Now().Add(10 * time.Minute).Unix()}),
+		"unknown role":      signJWT(t, testJWTSecret, hs256, map[string]interface{}{"sub": "carol", "role": "root", "exp": exp}),
+		"malformed":         "not.a-token",
+		"signature not b64": parts[0] + "." + parts[1] + ".!!!",
+	}
+	for name, tok := range cases {
+		if p, err := a.verifyHubJWT(tok); err == nil {
+			t.Errorf("%s: accepted as %+v", name, p)
+		}
+	}
+}
+
+func TestAnonymousRoleNone(t *testing.T) {
+	a := newTestAuthenticator(t)
+	var reached bool
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }))
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		reached = false
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+	if w.Code != http.StatusUnauthorized || reached {
+		t.Errorf("anonymous API request: status %d, reached handler %t; want 401", w.Code, reached)
+	}
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code != "unauthenticated" {
+		t.Errorf("anonymous API request: body %s", w.Body)
+	}
+
+	// A forged hub signature falls back to anonymous, so it is refused too
+	req := httptest.NewRequest(http.MethodGet, "/api/weather", nil)
+	req.Header = hubHeaders("other-secret", "mallory", "admin", time.Now())
+	if w := serve(req); w.Code != http.StatusUnauthorized || reached {
+		t.Errorf("forged hub headers: status %d, reached handler %t; want 401", w.Code, reached)
+	}
+
+	if w := serve(httptest.NewRequest(http.MethodGet, "/", nil)); w.Code != http.StatusSeeOther || !strings.HasPrefix(w.Header().Get("Location"), "/login") {
+		t.Errorf("anonymous page request: status %d, Location %q; want a redirect to /login", w.Code, w.Header().Get("Location"))
+	}
+	if serve(httptest.NewRequest(http.MethodGet, "/health", nil)); !reached {
+		t.Error("/health is not public")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/weather", nil)
+	req.Header = hubHeaders(testHubSecret, "bob", "viewer", time.Now())
+	if serve(req); !reached {
+		t.Error("signed hub request refused")
+	}
+}
+
+func TestMetricsNeedLoginOrScrapeToken(t *testing.T) {
+	a := newTestAuthenticator(t)
+	a.metricsToken = []byte("scrape-token")
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	scrape := func(header http.Header) int {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header = header
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	bearer := func(token string) http.Header { return http.Header{"Authorization": {"Bearer " + token}} }
+	for name, c := range map[string]struct {
+		header http.Header
+		status int
+	}{
+		"anonymous":       {http.Header{}, http.StatusUnauthorized},
+		"wrong token":     {bearer("scrape-tokem"), http.StatusUnauthorized},
+		"scrape token":    {bearer("scrape-token"), http.StatusOK},
+		"signed hub user": {hubHeaders(testHubSecret, "bob", "viewer", time.Now()), http.StatusOK},
+	} {
+		if got := scrape(c.header); got != c.status {
+			t.Errorf("%s: status %d, want %d", name, got, c.status)
+		}
+	}
+
+	// Without a configured token, a bearer header is just anonymous
+	a.metricsToken = nil
+	if got := scrape(bearer("")); got != http.StatusUnauthorized {
+		t.Errorf("empty token with none configured: status %d, want 401", got)
+	}
+}
+
+func TestLocalLogin(t *testing.T) {
+	a := newTestAuthenticator(t)
+	login := func(user, password string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {user}, "password": {password}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handleLogin(w, req)
+		return w
+	}
+
+	for _, c := range []struct{ user, password string }{{"alice", "wrong"}, {"nobody", "hunter2"}, {"alice", ""}} {
+		if w := login(c.user, c.password); w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+			t.Errorf("login %q/%q: status %d with %d cookies; want 401 and none", c.user, c.password, w.Code, len(w.Result().Cookies()))
+		}
+	}
+
+	w := login("alice", "hunter2")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("valid login: status %d", w.Code)
+	}
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			session = c
+		}
+	}
+	if session == nil || !session.HttpOnly {
+		t.Fatalf("valid login: session cookie %+v", session)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	req.AddCookie(session)
+	if p := a.authenticate(req); p.User != "alice" || p.Role != roleViewer || p.Method != authSession {
+		t.Errorf("session principal = %+v", p)
+	}
+
+	a.endSession(session.Value)
+	if p := a.authenticate(req); p.Authenticated() {
+		t.Errorf("ended session still authenticates as %+v", p)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	a := newTestAuthenticator(t)
+	for i := 0; i < maxLoginFailures; i++ {
+		if !a.loginAllowed("192.0.2.1") {
+			t.Fatalf("refused after %d failures", i)
+		}
+		a.recordLogin("192.0.2.1", false)
+	}
+	if a.loginAllowed("192.0.2.1") {
+		t.Error("allowed after the failure limit")
+	}
+	if !a.loginAllowed("192.0.2.2") {
+		t.Error("another address is throttled")
+	}
+}
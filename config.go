@@ -3,11 +3,17 @@ package main
 import (
 	"fmt"
 	"os"
+	"path"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type DBConfig struct {
+	// Archive database: mysql (default; also MariaDB) or sqlite
+	Driver string `yaml:"driver"`
+	// WeeWX SQLite database file, e.g. /var/lib/weewx/weewx.sdb (sqlite only)
+	Path     string `yaml:"path"`
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
 	Host     string `yaml:"host"`
@@ -23,6 +29,22 @@ type ServerConfig struct {
 	SSEPollSeconds int `yaml:"sse_poll_seconds"`
 	// Client-side poll interval in seconds (how often the browser's poller calls loadAll())
 	ClientPollSeconds int `yaml:"client_poll_seconds"`
+	// Longest time window (in days) any /api series endpoint will serve
+	MaxRangeDays int `yaml:"max_range_days"`
+	// Interval between SSE heartbeat comments in seconds
+	SSEHeartbeatSeconds int `yaml:"sse_heartbeat_seconds"`
+	// Reconnect delay suggested to SSE clients via `retry:` (milliseconds)
+	SSERetryMs int `yaml:"sse_retry_ms"`
+	// Number of recent SSE events kept for Last-Event-ID replay
+	SSEReplayEvents int `yaml:"sse_replay_events"`
+	// Interval between WebSocket pings in seconds; a client that doesn't answer one
+	// before the next is dropped
+	WSPingSeconds int `yaml:"ws_ping_seconds"`
+	// Events queued per WebSocket connection before it is considered too slow
+	WSSendQueue int `yaml:"ws_send_queue"`
+	// Other origins allowed to open /api/ws, as host patterns ("*.example.com") or
+	// "scheme://host" patterns. The dashboard's own origin is always allowed.
+	WSAllowedOrigins []string `yaml:"ws_allowed_origins"`
 }
 
 type LocationConfig struct {
@@ -34,17 +56,111 @@ type LocationConfig struct {
 	Longitude float64 `yaml:"longitude"`
 	// Altitude in feet above sea level
 	Altitude float64 `yaml:"altitude"`
+	// IANA time zone of the station, e.g. "America/Denver" (defaults to America/Phoenix)
+	Timezone string `yaml:"timezone"`
 }
 
+// AlertsConfig thresholds are expressed in the default display unit system (units.system)
 type AlertsConfig struct {
-	// Extreme heat threshold (°F heat index)
+	// Extreme heat threshold (heat index)
 	ExtremeHeat float64 `yaml:"extreme_heat"`
-	// Extreme cold threshold (°F wind chill)
+	// Extreme cold threshold (wind chill)
 	ExtremeCold float64 `yaml:"extreme_cold"`
-	// Strong wind speed threshold (mph)
+	// Strong wind speed threshold
 	WindSpeed float64 `yaml:"wind_speed"`
-	// Strong wind gust threshold (mph)
+	// Strong wind gust threshold
 	WindGust float64 `yaml:"wind_gust"`
+	// Rules evaluated on every new archive record; when empty, the thresholds above
+	// are turned into equivalent default rules
+	Rules []AlertRuleConfig `yaml:"rules"`
+	// File that active alert state and history are persisted to (default data/alerts.json)
+	StateFile string `yaml:"state_file"`
+	// Maximum number of history entries kept (default 500)
+	HistoryLimit int `yaml:"history_limit"`
+}
+
+// AlertRuleConfig describes one alert rule. Values are in display units.
+type AlertRuleConfig struct {
+	// Unique, stable identifier (used in state and history)
+	ID string `yaml:"id"`
+	// Human-readable name; defaults to ID
+	Name string `yaml:"name"`
+	// Archive column (e.g. outTemp, windGust) or derived value (e.g. feelsLike)
+	Field string `yaml:"field"`
+	// "threshold" (default) compares the value; "rate" compares its change over Window
+	Type string `yaml:"type"`
+	// Comparison operator: >, >=, <, <=
+	Op string `yaml:"op"`
+	// Threshold the value (or rate) is compared against
+	Value float64 `yaml:"value"`
+	// Optional hysteresis: an active alert only clears once the value is back past
+	// this level (defaults to Value)
+	Clear *float64 `yaml:"clear"`
+	// Rate-of-change window, e.g. "3h" (required for type rate)
+	Window string `yaml:"window"`
+	// How long the condition must hold before the alert fires, e.g. "10m"
+	For string `yaml:"for"`
+	// info, warning (default) or critical
+	Severity string `yaml:"severity"`
+	// Notification channel names to route this rule to; empty means every channel
+	// whose own filters match
+	Notify []string `yaml:"notify"`
+}
+
+type NotificationsConfig struct {
+	// Delivery channels
+	Channels []NotifyChannelConfig `yaml:"channels"`
+	// Minimum time between two notifications for the same rule on a channel (default 15m)
+	RateLimit string `yaml:"rate_limit"`
+	// Quiet hours in station time, e.g. "22:00-07:00"; only alerts at or above
+	// QuietMinSeverity are delivered during them
+	QuietHours string `yaml:"quiet_hours"`
+	// Lowest severity still delivered during quiet hours (default critical)
+	QuietMinSeverity string `yaml:"quiet_min_severity"`
+	// Delivery attempts after the first failure (default 3)
+	MaxRetries int `yaml:"max_retries"`
+	// Delay before the first retry; doubles on each attempt (default 5s)
+	RetryBackoff string `yaml:"retry_backoff"`
+}
+
+type NotifyChannelConfig struct {
+	// Unique name, referenced by alert rules and the test endpoint
+	Name string `yaml:"name"`
+	// webhook, email, ntfy or gotify
+	Type string `yaml:"type"`
+	// Target URL (webhook endpoint, ntfy topic URL or Gotify server URL)
+	URL string `yaml:"url"`
+	// Webhook HMAC-SHA256 signing secret
+	Secret string `yaml:"secret"`
+	// ntfy access token or Gotify application token
+	Token string `yaml:"token"`
+	// SMTP settings for email channels
+	SMTPHost string   `yaml:"smtp_host"`
+	SMTPPort int      `yaml:"smtp_port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	TLS      string   `yaml:"tls"` // "" (STARTTLS when offered), "tls" (implicit, port 465) or "none"
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// Only deliver alerts at or above this severity (default info)
+	MinSeverity string `yaml:"min_severity"`
+	// Only deliver these rule IDs (default all)
+	Rules []string `yaml:"rules"`
+	// Events to deliver: fired, cleared (default both)
+	Events []string `yaml:"events"`
+}
+
+type UnitsConfig struct {
+	// Unit system the WeeWX archive is stored in: us (default), metric or metricwx
+	Source string `yaml:"source"`
+	// Default unit system for API responses, reports and exports: us (default), metric or metricwx
+	System string `yaml:"system"`
+	// Optional per-quantity overrides on top of System for a mixed system
+	Temperature string `yaml:"temperature"` // F, C
+	Pressure    string `yaml:"pressure"`    // inHg, hPa, mbar, kPa, mmHg
+	Wind        string `yaml:"wind"`        // mph, kmh, mps, knot
+	Rain        string `yaml:"rain"`        // in, mm, cm
+	Distance    string `yaml:"distance"`    // mi, km
 }
 
 type AppConfig struct {
@@ -52,6 +168,115 @@ type AppConfig struct {
 	Server   ServerConfig   `yaml:"server"`
 	Location LocationConfig `yaml:"location"`
 	Alerts   AlertsConfig   `yaml:"alerts"`
+	Units    UnitsConfig    `yaml:"units"`
+	// Outbound alert notifications
+	Notifications NotificationsConfig `yaml:"notifications"`
+	// Data-freshness thresholds for /health
+	Health HealthConfig `yaml:"health"`
+	// Hub header verification, local users and the anonymous role
+	Auth AuthConfig `yaml:"auth"`
+	// Data export downloads
+	Export ExportConfig `yaml:"export"`
+	// In-memory cache of series, statistics and dashboard responses
+	ResponseCache ResponseCacheConfig `yaml:"response_cache"`
+	// Climate normals that statistics and NOAA reports compare against
+	Normals NormalsConfig `yaml:"normals"`
+	// NOAA report degree day bases and day-count thresholds
+	NOAA NOAAConfig `yaml:"noaa"`
+}
+
+type ExportConfig struct {
+	// Directory finished exports are kept in so interrupted downloads can resume
+	// with Range requests (default data/exports)
+	CacheDir string `yaml:"cache_dir"`
+	// How long a finished export is kept, e.g. "1h"
+	CacheTTL string `yaml:"cache_ttl"`
+	// Most disk space finished exports may take, in MB; the oldest are deleted to
+	// make room (default 512)
+	CacheMaxMB int `yaml:"cache_max_mb"`
+}
+
+type ResponseCacheConfig struct {
+	// Most responses kept; the oldest is dropped when full (default 256)
+	MaxEntries int `yaml:"max_entries"`
+	// Longest a response is served without a new archive record, e.g. "10m"
+	MaxAge string `yaml:"max_age"`
+}
+
+type NormalsConfig struct {
+	// NCEI 1991-2020 daily normals CSV of the nearest official station
+	File string `yaml:"file"`
+	// Derive normals from the archive when there's no file
+	FromArchive bool `yaml:"from_archive"`
+	// Years of archive needed before deriving (default 5)
+	MinYears int `yaml:"min_years"`
+}
+
+// NOAAConfig overrides the NOAA report defaults. Values are in the display units.
+type NOAAConfig struct {
+	// Degree day base temperatures (default 65 °F)
+	HeatingBase *float64 `yaml:"heating_base"`
+	CoolingBase *float64 `yaml:"cooling_base"`
+	// Count days with a high at or above / at or below these (default 90 and 32 °F)
+	MaxTempAbove *float64 `yaml:"max_temp_above"`
+	MaxTempBelow *float64 `yaml:"max_temp_below"`
+	// Count days with a low at or below each of these two (default 32 and 0 °F)
+	MinTempBelow []float64 `yaml:"min_temp_below"`
+	// Count days with at least each of these three rain totals (default 0.01, 0.10 and 1.00 in)
+	RainDays []float64 `yaml:"rain_days"`
+}
+
+type AuthConfig struct {
+	// Role given to unauthenticated requests: admin, user, viewer or none (login required)
+	AnonymousRole string `yaml:"anonymous_role"`
+	// Verification of identities forwarded by the hub
+	Hub HubAuthConfig `yaml:"hub"`
+	// Local accounts for standalone mode (log in at /login)
+	Users []LocalUserConfig `yaml:"users"`
+	// Session lifetime, e.g. "24h"
+	SessionTTL string `yaml:"session_ttl"`
+	// Mark the session cookie Secure (enable when served over HTTPS)
+	CookieSecure bool `yaml:"cookie_secure"`
+	// Admin-managed API keys for scripts (Authorization: Bearer)
+	APIKeys APIKeysConfig `yaml:"api_keys"`
+	// Bearer token a scraper sends to read /metrics when anonymous_role is none
+	MetricsToken string `yaml:"metrics_token"`
+}
+
+type APIKeysConfig struct {
+	// JSON file holding the hashed keys and their usage
+	File string `yaml:"file"`
+	// Defaults for keys created without their own limits
+	RatePerMinute float64 `yaml:"rate_per_minute"`
+	Burst         int     `yaml:"burst"`
+	QuotaPerDay   int     `yaml:"quota_per_day"` // 0 = unlimited
+}
+
+type HubAuthConfig struct {
+	// Shared secret for X-Hub-Signature (HMAC-SHA256 of user, role and timestamp)
+	Secret string `yaml:"secret"`
+	// Shared secret for HS256 JWTs sent in X-Hub-Token
+	JWTSecret string `yaml:"jwt_secret"`
+	// Required JWT "iss" claim (optional)
+	Issuer string `yaml:"issuer"`
+	// Largest accepted clock difference for signed headers, e.g. "5m"
+	MaxSkew string `yaml:"max_skew"`
+}
+
+type LocalUserConfig struct {
+	Username string `yaml:"username"`
+	// bcrypt hash; generate with: MyWeatherDash hash-password
+	PasswordHash string `yaml:"password_hash"`
+	Role         string `yaml:"role"`
+}
+
+type HealthConfig struct {
+	// Expected WeeWX archive interval in seconds
+	ArchiveIntervalSeconds int `yaml:"archive_interval_seconds"`
+	// Report degraded once the newest record is this many intervals old
+	DegradedAfter int `yaml:"degraded_after"`
+	// Report unhealthy once the newest record is this many intervals old
+	UnhealthyAfter int `yaml:"unhealthy_after"`
 }
 
 var appConfig AppConfig
@@ -67,26 +292,206 @@ func loadConfig(path string) error {
 	}
 
 	// Apply sensible defaults if not set
+	if appConfig.DB.Driver == "" {
+		appConfig.DB.Driver = "mysql"
+	}
 	if appConfig.Server.SSEPollSeconds <= 0 {
 		appConfig.Server.SSEPollSeconds = 60
 	}
 	if appConfig.Server.ClientPollSeconds <= 0 {
 		appConfig.Server.ClientPollSeconds = 60
 	}
+	if appConfig.Server.MaxRangeDays <= 0 {
+		appConfig.Server.MaxRangeDays = 400
+	}
+	if appConfig.Server.SSEHeartbeatSeconds <= 0 {
+		appConfig.Server.SSEHeartbeatSeconds = 20
+	}
+	if appConfig.Server.SSERetryMs <= 0 {
+		appConfig.Server.SSERetryMs = 5000
+	}
+	if appConfig.Server.SSEReplayEvents <= 0 {
+		appConfig.Server.SSEReplayEvents = 500
+	}
+	if appConfig.Server.WSPingSeconds <= 0 {
+		appConfig.Server.WSPingSeconds = 30
+	}
+	if appConfig.Server.WSSendQueue <= 0 {
+		appConfig.Server.WSSendQueue = 64
+	}
+	if err := checkOriginPatterns(appConfig.Server.WSAllowedOrigins); err != nil {
+		return err
+	}
 	if appConfig.Server.Port == 0 {
 		appConfig.Server.Port = 8081
 	}
+	if appConfig.Alerts.StateFile == "" {
+		appConfig.Alerts.StateFile = "data/alerts.json"
+	}
+	if appConfig.Alerts.HistoryLimit <= 0 {
+		appConfig.Alerts.HistoryLimit = 500
+	}
+	if appConfig.Notifications.RateLimit == "" {
+		appConfig.Notifications.RateLimit = "15m"
+	}
+	if appConfig.Notifications.QuietMinSeverity == "" {
+		appConfig.Notifications.QuietMinSeverity = "critical"
+	}
+	if appConfig.Notifications.MaxRetries <= 0 {
+		appConfig.Notifications.MaxRetries = 3
+	}
+	if appConfig.Notifications.RetryBackoff == "" {
+		appConfig.Notifications.RetryBackoff = "5s"
+	}
+	if appConfig.Auth.AnonymousRole == "" {
+		appConfig.Auth.AnonymousRole = roleViewer
+	}
+	if appConfig.Auth.SessionTTL == "" {
+		appConfig.Auth.SessionTTL = "24h"
+	}
+	if appConfig.Auth.Hub.MaxSkew == "" {
+		appConfig.Auth.Hub.MaxSkew = "5m"
+	}
+	if appConfig.Auth.APIKeys.File == "" {
+		appConfig.Auth.APIKeys.File = "data/apikeys.json"
+	}
+	if appConfig.Auth.APIKeys.RatePerMinute <= 0 {
+		appConfig.Auth.APIKeys.RatePerMinute = 60
+	}
+	if appConfig.Auth.APIKeys.Burst <= 0 {
+		appConfig.Auth.APIKeys.Burst = 20
+	}
+	if appConfig.Export.CacheDir == "" {
+		appConfig.Export.CacheDir = "data/exports"
+	}
+	if appConfig.Export.CacheTTL == "" {
+		appConfig.Export.CacheTTL = "1h"
+	}
+	if appConfig.Export.CacheMaxMB <= 0 {
+		appConfig.Export.CacheMaxMB = 512
+	}
+	if appConfig.ResponseCache.MaxEntries <= 0 {
+		appConfig.ResponseCache.MaxEntries = 256
+	}
+	if appConfig.ResponseCache.MaxAge == "" {
+		appConfig.ResponseCache.MaxAge = "10m"
+	}
+	if appConfig.Normals.MinYears <= 0 {
+		appConfig.Normals.MinYears = 5
+	}
+	if appConfig.Health.ArchiveIntervalSeconds <= 0 {
+		appConfig.Health.ArchiveIntervalSeconds = 300
+	}
+	if appConfig.Health.DegradedAfter <= 0 {
+		appConfig.Health.DegradedAfter = 3
+	}
+	if appConfig.Health.UnhealthyAfter <= appConfig.Health.DegradedAfter {
+		appConfig.Health.UnhealthyAfter = max(12, appConfig.Health.DegradedAfter+1)
+	}
+
+	loc, err := newStationLocation(appConfig.Location)
+	if err != nil {
+		return fmt.Errorf("invalid location: %w", err)
+	}
+	station = loc
 
+	if err := applyUnitsConfig(appConfig.Units); err != nil {
+		return fmt.Errorf("invalid units: %w", err)
+	}
+	if err := applyNOAAConfig(appConfig.NOAA); err != nil {
+		return fmt.Errorf("invalid noaa: %w", err)
+	}
+
+	return nil
+}
+
+// checkOriginPatterns rejects ws_allowed_origins entries path.Match can't parse
+func checkOriginPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("server.ws_allowed_origins %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// applyUnitsConfig resolves the archive source units and the default display units
+func applyUnitsConfig(cfg UnitsConfig) error {
+	src := unitsUS
+	if cfg.Source != "" {
+		p, ok := unitPresets[strings.ToLower(cfg.Source)]
+		if !ok {
+			return fmt.Errorf("units.source %q (use us, metric or metricwx)", cfg.Source)
+		}
+		src = p
+	}
+
+	disp := unitsUS
+	if cfg.System != "" {
+		p, ok := unitPresets[strings.ToLower(cfg.System)]
+		if !ok {
+			return fmt.Errorf("units.system %q (use us, metric or metricwx)", cfg.System)
+		}
+		disp = p
+	}
+	overrides := map[string]string{
+		"temperature": cfg.Temperature,
+		"pressure":    cfg.Pressure,
+		"wind":        cfg.Wind,
+		"rain":        cfg.Rain,
+		"distance":    cfg.Distance,
+	}
+	for key, unit := range overrides {
+		if unit == "" {
+			continue
+		}
+		if err := disp.set(key, unit); err != nil {
+			return fmt.Errorf("units.%s: %w", key, err)
+		}
+	}
+
+	sourceUnits = src
+	displayUnits = disp
 	return nil
 }
 
-func buildDSN() string {
-	db := appConfig.DB
-	// e.g. "user:pass@tcp(host:port)/name?params"
-	if db.Params != "" {
-		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?%s",
-			db.User, db.Password, db.Host, db.Port, db.Name, db.Params)
+// applyNOAAConfig resolves the NOAA report thresholds, given in display units, to US
+// units. Call after applyUnitsConfig.
+func applyNOAAConfig(cfg NOAAConfig) error {
+	t := noaaThresholds
+	temps := []struct {
+		dst *float64
+		v   *float64
+	}{
+		{&t.heatingBase, cfg.HeatingBase},
+		{&t.coolingBase, cfg.CoolingBase},
+		{&t.maxAbove, cfg.MaxTempAbove},
+		{&t.maxBelow, cfg.MaxTempBelow},
+	}
+	for _, temp := range temps {
+		if temp.v != nil {
+			*temp.dst = displayUnits.ToUS(qTemp, *temp.v)
+		}
+	}
+	if cfg.MinTempBelow != nil {
+		if len(cfg.MinTempBelow) != len(t.minBelow) {
+			return fmt.Errorf("min_temp_below needs %d temperatures", len(t.minBelow))
+		}
+		for i, v := range cfg.MinTempBelow {
+			t.minBelow[i] = displayUnits.ToUS(qTemp, v)
+		}
 	}
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
-		db.User, db.Password, db.Host, db.Port, db.Name)
+	if cfg.RainDays != nil {
+		if len(cfg.RainDays) != len(t.rain) {
+			return fmt.Errorf("rain_days needs %d amounts", len(t.rain))
+		}
+		for i, v := range cfg.RainDays {
+			if v <= 0 {
+				return fmt.Errorf("rain_days: %v is not above zero", v)
+			}
+			t.rain[i] = displayUnits.ToUS(qRain, v)
+		}
+	}
+	noaaThresholds = t
+	return nil
 }
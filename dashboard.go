@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// seriesSections are the chart sections /api/dashboard returns, keyed by their
+// endpoint names
+var seriesSections = []*seriesSection{
+	weatherSection, barometerSection, feelsLikeSection, humiditySection, windSection,
+	rainSection, lightningSection, insideTempSection, insideHumiditySection,
+}
+
+// dashboardColumns is every column the dashboard sections read, so one archive scan
+// serves them all
+var dashboardColumns = func() []seriesColumn {
+	var cols []seriesColumn
+	add := func(cs []seriesColumn) {
+		for _, c := range cs {
+			if !slices.ContainsFunc(cols, func(o seriesColumn) bool { return o.Name == c.Name }) {
+				cols = append(cols, c)
+			}
+		}
+	}
+	for _, sec := range seriesSections {
+		add(sec.Cols)
+	}
+	return cols
+}()
+
+// -------------------- /api/dashboard --------------------
+
+// handleDashboard returns everything the dashboard shows for a range in one round
+// trip. The archive is read once and the chart sections are built from it
+// concurrently; statistics come from loadStatistics, as for /api/statistics, so the
+// two endpoints agree. A section that fails is null and listed in errors while the
+// rest still load.
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params, ok := parseSeriesParams(w, r)
+	if !ok {
+		return
+	}
+
+	resp := DashboardResponse{
+		Series: make(map[string]interface{}, len(seriesSections)),
+		Units:  params.Units.Labels(),
+	}
+	var mu sync.Mutex
+	fail := func(section, message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if resp.Errors == nil {
+			resp.Errors = make(map[string]string)
+		}
+		resp.Errors[section] = message
+	}
+
+	// Every task returns nil: errors are reported per section and must not cancel the
+	// others
+	var g errgroup.Group
+	g.Go(func() error {
+		raw, err := querySamples(r.Context(), dashboardColumns, params.Range, "")
+		if err != nil {
+			log.Printf("[Dashboard] archive query: %v", err)
+			for _, sec := range seriesSections {
+				fail(sec.Name, "database error")
+				mu.Lock()
+				resp.Series[sec.Name] = nil
+				mu.Unlock()
+			}
+			return nil
+		}
+
+		for _, sec := range seriesSections {
+			g.Go(func() error {
+				rows := projectSamples(raw, dashboardColumns, sec.Cols, sec.NotNull)
+				readings := sec.Build(params.result(rows, sec.Cols))
+				mu.Lock()
+				resp.Series[sec.Name] = readings
+				mu.Unlock()
+				return nil
+			})
+		}
+		return nil
+	})
+	g.Go(func() error {
+		stats, err := loadStatistics(r.Context(), params.Range, params.Units)
+		if err != nil {
+			log.Printf("[Dashboard] statistics: %v", err)
+			fail("statistics", "database error")
+			return nil
+		}
+		stats.Normals = statisticsNormals(r.Context(), params.Units)
+		mu.Lock()
+		resp.Statistics = &stats
+		mu.Unlock()
+		return nil
+	})
+	g.Go(func() error {
+		celestial, err := celestialFor(station.Today())
+		if err != nil {
+			log.Printf("[Dashboard] celestial: %v", err)
+			fail("celestial", "celestial calculation error")
+			return nil
+		}
+		mu.Lock()
+		resp.Celestial = &celestial
+		mu.Unlock()
+		return nil
+	})
+	g.Wait()
+
+	w.Header().Set("X-Units", params.Units.Key())
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "JSON error", http.StatusInternalServerError)
+	}
+}
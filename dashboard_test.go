@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDashboardStatisticsMatchStatisticsEndpoint(t *testing.T) {
+	withStationZone(t, "UTC")
+	saved := appConfig
+	t.Cleanup(func() { appConfig = saved })
+	appConfig.Server.MaxRangeDays = 31
+
+	// Three and a half days of records ending before today, so the whole days in
+	// between come from the daily summaries
+	start := station.Today().AddDate(0, 0, -5).Add(6 * time.Hour)
+	end := station.Today().AddDate(0, 0, -1)
+	var records []testRecord
+	for i, epoch := int64(0), start.Unix(); epoch < end.Unix(); i, epoch = i+1, epoch+300 {
+		vals := map[string]float64{
+			"outTemp":     55 + float64(i%97)/4,
+			"dewpoint":    40 + float64(i%61)/5,
+			"outHumidity": 30 + float64(i%41),
+			"barometer":   29.8 + float64(i%23)/100,
+			"windSpeed":   float64(i % 9),
+			"windGust":    float64(i%9) + float64(i%13)/2,
+			"windDir":     float64(i * 37 % 360),
+			"inTemp":      70 + float64(i%11)/2,
+			"inHumidity":  35 + float64(i%7),
+		}
+		if i%29 == 0 {
+			vals["rain"] = 0.01
+			vals["rainRate"] = 0.12
+		}
+		records = append(records, testRecord{epoch: epoch, vals: vals})
+	}
+	_, db := newTestArchive(t, records)
+	addTestDaySummaries(t, db, statisticsSummaries, records)
+
+	query := "?start=" + start.Format(time.RFC3339) + "&end=" + end.Format(time.RFC3339)
+	get := func(handler http.HandlerFunc, path string, v interface{}) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, path+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", path, w.Code, w.Body)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	var stats json.RawMessage
+	get(handleStatistics, "/api/statistics", &stats)
+	var dashboard struct {
+		Statistics json.RawMessage   `json:"statistics"`
+		Errors     map[string]string `json:"errors"`
+	}
+	get(handleDashboard, "/api/dashboard", &dashboard)
+
+	if len(dashboard.Errors) > 0 {
+		t.Fatalf("dashboard errors: %v", dashboard.Errors)
+	}
+	if string(dashboard.Statistics) != string(stats) {
+		t.Errorf("dashboard statistics differ from /api/statistics:\n got %s\nwant %s", dashboard.Statistics, stats)
+	}
+}
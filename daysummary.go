@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// WeeWX keeps a table per observation type (archive_day_outTemp, archive_day_wind,
+// ...) with each day's min, max, sum and count, updated with every record. Reports
+// that only need day-level aggregates read those instead of every archive row, and
+// fall back to scanning the archive when the tables aren't there.
+
+var summaryZoneWarning sync.Once
+
+// loadDaySummaries reads the daily summaries of obstypes for the station days starting
+// in [from, to), keyed by the day's start. ok is false when they can't be used: a
+// table is missing, or WeeWX's days don't start at the station's midnights because
+// its time zone differs from location.timezone. use names the caller in metrics.
+func loadDaySummaries(ctx context.Context, archive Archive, use string, obstypes []string, from, to time.Time) (map[int64]DaySummary, bool, error) {
+	days, ok, err := archive.DaySummaries(ctx, use+"_daily_summary", obstypes, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		dailySummaryReads.Inc(use, "archive")
+		return nil, false, nil
+	}
+
+	byStart := make(map[int64]DaySummary, len(days))
+	for _, d := range days {
+		if station.StartOfDay(station.In(d.Start)).Unix() != d.Start {
+			summaryZoneWarning.Do(func() {
+				log.Printf("[Summaries] WeeWX day %d doesn't start at midnight in %s; check location.timezone. Scanning the archive instead.",
+					d.Start, station.Loc)
+			})
+			dailySummaryReads.Inc(use, "archive")
+			return nil, false, nil
+		}
+		byStart[d.Start] = d
+	}
+	dailySummaryReads.Inc(use, "summary")
+	return byStart, true, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+)
+
+// derivedField is a value computed from one archive record rather than stored in it.
+// Inputs and results are in archive source units.
+type derivedField struct {
+	// Inputs lists the archive columns Compute reads
+	Inputs []string
+	// Quantity of the result, for unit conversion
+	Quantity Quantity
+	// Compute returns the value, or ok=false if required inputs are missing
+	Compute func(values map[string]float64) (float64, bool)
+}
+
+// derivedFields are the computed values alert rules (and clients) can refer to by name
+var derivedFields = map[string]derivedField{
+	"feelsLike": {
+		Inputs:   []string{"outTemp", "heatindex", "windchill"},
+		Quantity: qTemp,
+		Compute: func(v map[string]float64) (float64, bool) {
+			temp, ok := v["outTemp"]
+			if !ok {
+				return 0, false
+			}
+			return pickFeelsLikeSource(temp, valueOrNaN(v, "heatindex"), valueOrNaN(v, "windchill")).value, true
+		},
+	},
+	"dewpointSpread": {
+		Inputs:   []string{"outTemp", "dewpoint"},
+		Quantity: qTempDelta,
+		Compute: func(v map[string]float64) (float64, bool) {
+			temp, ok1 := v["outTemp"]
+			dew, ok2 := v["dewpoint"]
+			if !ok1 || !ok2 {
+				return 0, false
+			}
+			return temp - dew, true
+		},
+	},
+}
+
+// valueOrNaN returns values[key], or NaN if the column was NULL
+func valueOrNaN(values map[string]float64, key string) float64 {
+	if v, ok := values[key]; ok {
+		return v
+	}
+	return math.NaN()
+}
+
+// -------------------- latest-reading helpers --------------------
+//
+// These compute the "latest reading" fields shared by the REST handlers and the live
+// SSE updates. All inputs are in archive source units.
+
+// feelsLikeSource is the value currently shown as "feels like" and where it came from
+type feelsLikeSource struct {
+	value  float64
+	source string
+	label  string
+}
+
+// pickFeelsLikeSource takes values in archive source units; the 80/50 °F cut-offs are
+// converted to match so the choice is the same in any unit system.
+func pickFeelsLikeSource(tempF, heatIndexF, windChillF float64) feelsLikeSource {
+	heatThreshold := fromUSThreshold(qTemp, 80.0)
+	chillThreshold := fromUSThreshold(qTemp, 50.0)
+
+	activeValue := tempF
+	sourceLabel := "Air Temp"
+	sourceKey := "air"
+
+	if !math.IsNaN(heatIndexF) && heatIndexF != 0 && tempF >= heatThreshold {
+		activeValue = heatIndexF
+		sourceLabel = "Heat Index"
+		sourceKey = "heat"
+	} else if !math.IsNaN(windChillF) && windChillF != 0 && tempF <= chillThreshold {
+		activeValue = windChillF
+		sourceLabel = "Wind Chill"
+		sourceKey = "chill"
+	}
+
+	return feelsLikeSource{
+		value:  activeValue,
+		source: sourceKey,
+		label:  sourceLabel,
+	}
+}
+
+// degreesToCompass converts wind direction degrees to a 16-point compass label
+func degreesToCompass(deg float64) string {
+	if math.IsNaN(deg) {
+		return "--"
+	}
+
+	directions := []string{
+		"N", "NNE", "NE", "ENE",
+		"E", "ESE", "SE", "SSE",
+		"S", "SSW", "SW", "WSW",
+		"W", "WNW", "NW", "NNW",
+	}
+
+	index := int(math.Round(math.Mod(deg, 360.0)/22.5)) % 16
+	return directions[index]
+}
+
+// windIsStrong applies the configured wind_speed/wind_gust thresholds (display units)
+func windIsStrong(speed, gust float64) bool {
+	return speed >= displayToSource(qSpeed, appConfig.Alerts.WindSpeed) ||
+		gust >= displayToSource(qSpeed, appConfig.Alerts.WindGust)
+}
+
+// pressureLookback is how many archive rows back the pressure trend compares against
+const pressureLookback = 4
+
+// pressureOutlook classifies the barometer from the current reading and the one
+// pressureLookback rows earlier, returning level (high/normal/low), trend
+// (rapid-rise ... rapid-fall) and a short forecast.
+func pressureOutlook(current, prior float64) (level, trend, forecast string) {
+	change := current - prior
+
+	// Determine pressure level (thresholds are defined in inHg and compared in
+	// archive units so they hold whatever unit system is displayed)
+	if current > fromUSThreshold(qPressure, 30.20) {
+		level = "high"
+	} else if current < fromUSThreshold(qPressure, 29.80) {
+		level = "low"
+	} else {
+		level = "normal"
+	}
+
+	// Determine rate of change (per hour)
+	// Assuming 5 intervals in data; typical WeeWX is 5-min intervals = 12 per hour
+	// So 5 intervals = ~25 minutes. Change per hour ≈ change * (60/25) = change * 2.4
+	changePerHour := math.Abs(change) * 2.4
+	rapid := fromUSThreshold(qPressure, 0.06)
+	slow := fromUSThreshold(qPressure, 0.02)
+
+	// Categorize trend based on rate
+	switch {
+	case change != 0 && changePerHour >= rapid:
+		trend = "rapid-"
+	case change != 0 && changePerHour >= slow:
+		trend = "slow-"
+	}
+	if trend == "" {
+		trend = "steady"
+	} else if change > 0 {
+		trend += "rise"
+	} else {
+		trend += "fall"
+	}
+
+	// Generate forecast based on level + trend
+	forecasts := map[string]map[string]string{
+		"high": {
+			"steady": "Fair weather", "slow-rise": "Fair weather", "rapid-rise": "Fair, improving",
+			"slow-fall": "Cloudy later", "rapid-fall": "Warmer, cloudier",
+		},
+		"normal": {
+			"steady": "Conditions continue", "slow-rise": "Conditions continue", "rapid-rise": "Improving",
+			"slow-fall": "Minor changes", "rapid-fall": "Rain/snow likely",
+		},
+		"low": {
+			"steady": "Cooler, clearing", "slow-rise": "Cooler, clearing", "rapid-rise": "Improving quickly",
+			"slow-fall": "Rain coming", "rapid-fall": "Stormy weather",
+		},
+	}
+	return level, trend, forecasts[level][trend]
+}
+
+// recentWindow is how far back the rain/lightning "recently active" flags look
+const recentWindow = 10 * time.Minute
+
+// recentlyActive scans rows (ascending) backwards from now and reports whether any of
+// the given value columns was positive within recentWindow
+func recentlyActive(rows []sample, now time.Time, cols ...int) bool {
+	since := now.Add(-recentWindow).Unix()
+	for i := len(rows) - 1; i >= 0; i-- {
+		s := rows[i]
+		if s.Epoch < since {
+			break
+		}
+		for _, c := range cols {
+			if s.Values[c].Float64 > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// computeLiveDerived fills the derived fields of a live update for the archive record
+// at epoch. values holds that record's columns; the trend and recently-active flags
+// need a few earlier rows, which are read from the archive.
+func computeLiveDerived(epoch int64, values map[string]float64) LiveDerived {
+	var d LiveDerived
+
+	if dir, ok := values["windDir"]; ok {
+		d.Compass = degreesToCompass(dir)
+	} else {
+		d.Compass = "--"
+	}
+	d.WindStrong = windIsStrong(values["windSpeed"], values["windGust"])
+
+	if temp, ok := values["outTemp"]; ok {
+		fl := pickFeelsLikeSource(temp, valueOrNaN(values, "heatindex"), valueOrNaN(values, "windchill"))
+		d.FeelsLike = &fl.value
+		d.FeelsLikeSource = fl.source
+		d.FeelsLikeLabel = fl.label
+	}
+
+	if current, ok := values["barometer"]; ok {
+		prior, _ := archive.Recent(context.Background(), "pressure_trend", "barometer", epoch, pressureLookback+1)
+		if len(prior) > pressureLookback {
+			d.PressureLevel, d.PressureTrend, d.Forecast = pressureOutlook(current, prior[pressureLookback])
+		}
+	}
+
+	cols := []seriesColumn{{Name: "rainRate"}, {Name: "rain"}, {Name: "lightning_strike_count"}}
+	at := time.Unix(epoch, 0)
+	recent, err := querySamples(context.Background(), cols, TimeRange{Start: at.Add(-recentWindow), End: at.Add(time.Second)}, "")
+	if err != nil {
+		log.Printf("[SSE] derived fields: %v", err)
+	}
+	d.RainRecentlyActive = recentlyActive(recent, at, 0, 1)
+	d.LightningRecentlyActive = recentlyActive(recent, at, 2)
+	return d
+}
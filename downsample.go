@@ -0,0 +1,360 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aggKind selects how archive values falling in one bucket are combined
+type aggKind int
+
+const (
+	aggMean aggKind = iota
+	aggMin
+	aggMax
+	aggSum
+	aggDir // vector-averaged direction in degrees (optionally speed-weighted)
+)
+
+// Downsample describes the optional `interval=` / `points=` reduction of a series
+type Downsample struct {
+	// Interval is a fixed bucket width (e.g. 15m, 1h)
+	Interval time.Duration
+	// Points is the target maximum number of points
+	Points int
+	// Method is "bucket" (aggregate per bucket, default) or "lttb"
+	// (Largest-Triangle-Three-Buckets point selection, only with points=)
+	Method string
+}
+
+const (
+	minDownsampleInterval = time.Minute
+	maxDownsamplePoints   = 10000
+)
+
+// Active reports whether any reduction was requested
+func (d Downsample) Active() bool {
+	return d.Interval > 0 || d.Points > 0
+}
+
+// parseDownsample reads `interval`, `points` and `method` from the query string
+func parseDownsample(r *http.Request, tr TimeRange) (Downsample, *apiError) {
+	q := r.URL.Query()
+	intervalStr := strings.ToLower(strings.TrimSpace(q.Get("interval")))
+	pointsStr := strings.TrimSpace(q.Get("points"))
+	method := strings.ToLower(strings.TrimSpace(q.Get("method")))
+
+	var d Downsample
+	if intervalStr != "" && pointsStr != "" {
+		return d, badParam("interval", "invalid_downsample", "use either interval or points, not both")
+	}
+
+	if intervalStr != "" {
+		iv, ok := parseSpan(intervalStr)
+		if !ok {
+			parsed, err := time.ParseDuration(intervalStr)
+			if err != nil {
+				return d, badParam("interval", "invalid_downsample", "interval %q is not a duration (e.g. 15m, 1h, 1d)", intervalStr)
+			}
+			iv = parsed
+		}
+		if iv < minDownsampleInterval {
+			return d, badParam("interval", "invalid_downsample", "interval must be at least %s", minDownsampleInterval)
+		}
+		if iv > tr.Duration() {
+			return d, badParam("interval", "invalid_downsample", "interval %s is longer than the requested range", iv)
+		}
+		d.Interval = iv
+	}
+
+	if pointsStr != "" {
+		n, err := strconv.Atoi(pointsStr)
+		if err != nil || n < 2 || n > maxDownsamplePoints {
+			return d, badParam("points", "invalid_downsample", "points must be an integer between 2 and %d", maxDownsamplePoints)
+		}
+		d.Points = n
+	}
+
+	switch method {
+	case "", "bucket":
+		d.Method = "bucket"
+	case "lttb":
+		if d.Points == 0 {
+			return d, badParam("method", "invalid_downsample", "method=lttb requires points")
+		}
+		d.Method = "lttb"
+	default:
+		return d, badParam("method", "invalid_downsample", "unknown method %q (use bucket or lttb)", method)
+	}
+	return d, nil
+}
+
+// bucketWidth returns the bucket size in seconds for the given window
+func (d Downsample) bucketWidth(tr TimeRange) int64 {
+	if d.Interval > 0 {
+		return int64(d.Interval / time.Second)
+	}
+	span := int64(tr.Duration() / time.Second)
+	width := (span + int64(d.Points) - 1) / int64(d.Points)
+	// Round up to whole minutes so buckets line up across endpoints
+	const minute = int64(time.Minute / time.Second)
+	return ((width + minute - 1) / minute) * minute
+}
+
+// Apply reduces samples according to d. Samples must be sorted ascending by epoch.
+func (d Downsample) Apply(samples []sample, cols []seriesColumn, tr TimeRange) []sample {
+	if !d.Active() || len(samples) == 0 {
+		return samples
+	}
+	if d.Method == "lttb" {
+		return lttb(samples, d.Points)
+	}
+	width := d.bucketWidth(tr)
+	if width <= 0 {
+		return samples
+	}
+	return bucketSamples(samples, cols, width, tr.Start)
+}
+
+// bucketSamples aggregates samples into fixed-width buckets. Buckets are aligned to
+// station-local time so e.g. interval=1d buckets start at local midnight, also after
+// a DST change. Each bucket is stamped with its start time.
+func bucketSamples(samples []sample, cols []seriesColumn, width int64, anchor time.Time) []sample {
+	keyOf, startOf := bucketKeys(width, anchor)
+
+	// Locate the weighting column (wind speed) for each direction column
+	weightIdx := make([]int, len(cols))
+	for i, c := range cols {
+		weightIdx[i] = -1
+		if c.Agg != aggDir || c.WeightBy == "" {
+			continue
+		}
+		for j, o := range cols {
+			if o.Name == c.WeightBy {
+				weightIdx[i] = j
+			}
+		}
+	}
+
+	type acc struct {
+		sum, x, y float64
+		val       float64
+		n         int
+	}
+
+	out := make([]sample, 0, len(samples)/2+1)
+	accs := make([]acc, len(cols))
+	curKey := int64(math.MinInt64)
+
+	flush := func() {
+		if curKey == math.MinInt64 {
+			return
+		}
+		vals := make([]sql.NullFloat64, len(cols))
+		for i, c := range cols {
+			a := accs[i]
+			if a.n == 0 {
+				continue
+			}
+			switch c.Agg {
+			case aggMean:
+				vals[i] = sql.NullFloat64{Float64: a.sum / float64(a.n), Valid: true}
+			case aggSum:
+				vals[i] = sql.NullFloat64{Float64: a.sum, Valid: true}
+			case aggMin, aggMax:
+				vals[i] = sql.NullFloat64{Float64: a.val, Valid: true}
+			case aggDir:
+				if a.x == 0 && a.y == 0 {
+					continue
+				}
+				dir := math.Atan2(a.y, a.x) * 180.0 / math.Pi
+				if dir < 0 {
+					dir += 360
+				}
+				vals[i] = sql.NullFloat64{Float64: dir, Valid: true}
+			}
+		}
+		out = append(out, sample{Epoch: startOf(curKey), Values: vals})
+		for i := range accs {
+			accs[i] = acc{}
+		}
+	}
+
+	for _, s := range samples {
+		key := keyOf(s.Epoch)
+		if key != curKey {
+			flush()
+			curKey = key
+		}
+		for i, c := range cols {
+			v := s.Values[i]
+			if !v.Valid {
+				continue
+			}
+			a := &accs[i]
+			switch c.Agg {
+			case aggMean, aggSum:
+				a.sum += v.Float64
+			case aggMin:
+				if a.n == 0 || v.Float64 < a.val {
+					a.val = v.Float64
+				}
+			case aggMax:
+				if a.n == 0 || v.Float64 > a.val {
+					a.val = v.Float64
+				}
+			case aggDir:
+				weight := 1.0
+				if wi := weightIdx[i]; wi >= 0 {
+					if !s.Values[wi].Valid {
+						continue
+					}
+					weight = s.Values[wi].Float64
+				}
+				rad := v.Float64 * math.Pi / 180.0
+				a.x += weight * math.Cos(rad)
+				a.y += weight * math.Sin(rad)
+			}
+			a.n++
+		}
+	}
+	flush()
+	return out
+}
+
+// bucketKeys numbers the buckets of the given width and returns each bucket's start.
+// Widths of whole days count station-local calendar days from the anchor's, since
+// days are 23 or 25 hours long across DST changes. Other widths are keyed by their
+// start, a multiple of the width in local wall-clock time, so buckets after a DST
+// change still start on local boundaries.
+func bucketKeys(width int64, anchor time.Time) (keyOf func(epoch int64) int64, startOf func(key int64) int64) {
+	const day = int64(24 * time.Hour / time.Second)
+	if width%day != 0 {
+		keyOf = func(epoch int64) int64 {
+			_, offset := station.In(epoch).Zone()
+			off := int64(offset)
+			wall := floorDiv(epoch+off, width) * width
+			start := wall - off
+			if _, startOffset := station.In(start).Zone(); startOffset != offset {
+				// A DST change falls inside the bucket, so its start has the earlier
+				// offset: the first of a repeated hour, or the change itself when the
+				// start's wall-clock time was skipped
+				start = wall - int64(startOffset)
+				if _, o := station.In(start).Zone(); o != startOffset {
+					changed, _ := station.In(epoch).ZoneBounds()
+					start = changed.Unix()
+				}
+			}
+			return start
+		}
+		startOf = func(key int64) int64 { return key }
+		return keyOf, startOf
+	}
+
+	days := width / day
+	first := station.StartOfDay(anchor)
+	// civilDay numbers calendar dates independently of the zone
+	civilDay := func(t time.Time) int64 {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / day
+	}
+	base := civilDay(first)
+	keyOf = func(epoch int64) int64 {
+		return floorDiv(civilDay(station.In(epoch))-base, days)
+	}
+	startOf = func(key int64) int64 {
+		return first.AddDate(0, 0, int(key*days)).Unix()
+	}
+	return keyOf, startOf
+}
+
+// floorDiv is integer division rounding toward negative infinity
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// lttb selects up to `threshold` original rows using Largest-Triangle-Three-Buckets
+// on the first column, so the visual shape (including peaks) of that series is kept.
+// Whole rows are kept, so the other columns stay aligned with it. Rows where the
+// first column is NULL are not candidates, so gaps don't turn into dips to zero; a
+// bucket holding only gaps keeps one of them.
+func lttb(samples []sample, threshold int) []sample {
+	n := len(samples)
+	if threshold >= n || threshold < 3 {
+		return samples
+	}
+
+	valid := func(i int) bool { return samples[i].Values[0].Valid }
+	y := func(i int) float64 { return samples[i].Values[0].Float64 }
+	x := func(i int) float64 { return float64(samples[i].Epoch) }
+
+	out := make([]sample, 0, threshold)
+	out = append(out, samples[0])
+
+	every := float64(n-2) / float64(threshold-2)
+	// a is the last selected row with a value (-1 until there is one)
+	a := -1
+	if valid(0) {
+		a = 0
+	}
+	for i := 0; i < threshold-2; i++ {
+		// Average point of the next bucket
+		avgStart := int(float64(i+1)*every) + 1
+		avgEnd := int(float64(i+2)*every) + 1
+		if avgEnd > n {
+			avgEnd = n
+		}
+		var avgX, avgY float64
+		cnt := 0
+		for j := avgStart; j < avgEnd; j++ {
+			if !valid(j) {
+				continue
+			}
+			avgX += x(j)
+			avgY += y(j)
+			cnt++
+		}
+
+		rangeStart := int(float64(i)*every) + 1
+		rangeEnd := int(float64(i+1)*every) + 1
+		if cnt > 0 {
+			avgX /= float64(cnt)
+			avgY /= float64(cnt)
+		} else if a >= 0 {
+			// The next bucket is a gap: aim level with a at its middle
+			avgX, avgY = (x(avgStart)+x(avgEnd-1))/2, y(a)
+		}
+		ax, ay := avgX, avgY
+		if a >= 0 {
+			ax, ay = x(a), y(a)
+		}
+
+		// Pick the point in this bucket forming the largest triangle with a and the average
+		maxArea := -1.0
+		next := rangeStart
+		for j := rangeStart; j < rangeEnd; j++ {
+			if !valid(j) {
+				continue
+			}
+			area := math.Abs((ax-avgX)*(y(j)-ay) - (ax-x(j))*(avgY-ay))
+			if area > maxArea {
+				maxArea = area
+				next = j
+			}
+		}
+		out = append(out, samples[next])
+		if valid(next) {
+			a = next
+		}
+	}
+
+	out = append(out, samples[n-1])
+	return out
+}
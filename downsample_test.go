@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withStationZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s unavailable: %v", name, err)
+	}
+	saved := station
+	station = &StationLocation{Loc: loc}
+	t.Cleanup(func() { station = saved })
+	return loc
+}
+
+func TestBucketSamplesDailyAcrossDST(t *testing.T) {
+	loc := withStationZone(t, "America/Denver")
+	// DST starts on 2024-03-10; hourly samples from the 8th to the 12th
+	start := time.Date(2024, 3, 8, 0, 0, 0, 0, loc)
+	end := time.Date(2024, 3, 12, 0, 0, 0, 0, loc)
+	var samples []sample
+	for t := start; t.Before(end); t = t.Add(time.Hour) {
+		samples = append(samples, sample{Epoch: t.Unix(), Values: []sql.NullFloat64{{Float64: 1, Valid: true}}})
+	}
+
+	out := bucketSamples(samples, []seriesColumn{{Name: "rain", Agg: aggSum}}, 86400, start)
+	if len(out) != 4 {
+		t.Fatalf("got %d buckets, want 4", len(out))
+	}
+	wantHours := []float64{24, 24, 23, 24}
+	for i, s := range out {
+		got := time.Unix(s.Epoch, 0).In(loc)
+		if got.Hour() != 0 || got.Minute() != 0 || got.Day() != 8+i {
+			t.Errorf("bucket %d starts at %s, want local midnight of March %d", i, got, 8+i)
+		}
+		if s.Values[0].Float64 != wantHours[i] {
+			t.Errorf("bucket %d holds %v samples, want %v", i, s.Values[0].Float64, wantHours[i])
+		}
+	}
+}
+
+func TestBucketSamplesSubDay(t *testing.T) {
+	loc := withStationZone(t, "America/Denver")
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, loc)
+	var samples []sample
+	for i := 0; i < 12; i++ {
+		samples = append(samples, sample{Epoch: start.Unix() + int64(i)*1800, Values: []sql.NullFloat64{{Float64: float64(i), Valid: true}}})
+	}
+	out := bucketSamples(samples, []seriesColumn{{Name: "outTemp", Agg: aggMax}}, 3*3600, start)
+	if len(out) != 2 {
+		t.Fatalf("got %d buckets, want 2", len(out))
+	}
+	if out[0].Epoch != start.Unix() || out[1].Epoch != start.Add(3*time.Hour).Unix() {
+		t.Errorf("bucket starts %d, %d", out[0].Epoch, out[1].Epoch)
+	}
+	if out[0].Values[0].Float64 != 5 || out[1].Values[0].Float64 != 11 {
+		t.Errorf("maxima %v, %v; want 5, 11", out[0].Values[0].Float64, out[1].Values[0].Float64)
+	}
+
+	// Across DST changes, half-hourly samples for ten hours in 2h buckets
+	for _, c := range []struct {
+		name  string
+		start time.Time
+		want  []string
+	}{
+		// The repeated 01:00 hour joins the bucket that started at 00:00 MDT
+		{"fall back", time.Date(2024, 11, 2, 22, 0, 0, 0, loc), []string{"22:00 MDT", "00:00 MDT", "02:00 MST", "04:00 MST", "06:00 MST"}},
+		// 02:00 doesn't exist, so that bucket starts at 03:00
+		{"spring forward", time.Date(2024, 3, 9, 22, 0, 0, 0, loc), []string{"22:00 MST", "00:00 MST", "03:00 MDT", "04:00 MDT", "06:00 MDT", "08:00 MDT"}},
+	} {
+		samples = samples[:0]
+		for i := 0; i < 20; i++ {
+			samples = append(samples, sample{Epoch: c.start.Unix() + int64(i)*1800, Values: []sql.NullFloat64{{Float64: float64(i), Valid: true}}})
+		}
+		var got []string
+		for _, s := range bucketSamples(samples, []seriesColumn{{Name: "outTemp", Agg: aggMax}}, 2*3600, c.start) {
+			got = append(got, time.Unix(s.Epoch, 0).In(loc).Format("15:04 MST"))
+		}
+		if strings.Join(got, ", ") != strings.Join(c.want, ", ") {
+			t.Errorf("%s: bucket starts %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestLTTBSkipsNulls(t *testing.T) {
+	var samples []sample
+	for i := 0; i < 100; i++ {
+		v := sql.NullFloat64{Float64: 50 + float64(i%5), Valid: true}
+		if i >= 40 && i < 60 {
+			v = sql.NullFloat64{}
+		}
+		samples = append(samples, sample{Epoch: int64(i) * 300, Values: []sql.NullFloat64{v}})
+	}
+	out := lttb(samples, 10)
+	if len(out) != 10 {
+		t.Fatalf("got %d points, want 10", len(out))
+	}
+	// Every bucket has some readings, so no gap row may be picked as a "dip"
+	for _, s := range out {
+		if !s.Values[0].Valid {
+			t.Errorf("selected the gap row at %d", s.Epoch)
+		}
+	}
+}
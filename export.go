@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportColumn is a column the export endpoints can produce. Archive columns are read
+// as-is; derived columns are computed per row from their inputs. Only names in
+// exportColumns ever reach SQL.
+type exportColumn struct {
+	Key string
+	// Label is the CSV header; quantities get the unit appended (Temperature_F)
+	Label     string
+	Quantity  Quantity
+	Precision int
+	// Inputs are the archive columns the value needs
+	Inputs []string
+	// Compute derives a numeric value from the inputs (archive source units); nil for
+	// archive columns and text columns
+	Compute func(v map[string]float64) (float64, bool)
+	// Text derives a non-numeric value (e.g. compass point)
+	Text func(v map[string]float64) (string, bool)
+}
+
+func archiveColumn(key, label string, precision int) *exportColumn {
+	return &exportColumn{Key: key, Label: label, Quantity: archiveQuantities[key], Precision: precision, Inputs: []string{key}}
+}
+
+// exportColumns is the column registry, in default export order. dateTime is handled
+// separately and is always the first column.
+var exportColumns = []*exportColumn{
+	archiveColumn("outTemp", "Temperature", 2),
+	archiveColumn("dewpoint", "Dewpoint", 2),
+	archiveColumn("outHumidity", "Humidity_Pct", 2),
+	archiveColumn("barometer", "Barometer", 2),
+	archiveColumn("heatindex", "HeatIndex", 2),
+	archiveColumn("windchill", "WindChill", 2),
+	archiveColumn("windSpeed", "WindSpeed", 2),
+	archiveColumn("windGust", "WindGust", 2),
+	archiveColumn("windDir", "WindDirection_deg", 2),
+	archiveColumn("rainRate", "RainRate", 2),
+	archiveColumn("rain", "Rain", 2),
+	archiveColumn("lightning_strike_count", "LightningStrikes", 0),
+	archiveColumn("lightning_distance", "LightningDistance", 2),
+	archiveColumn("inTemp", "InsideTemp", 2),
+	archiveColumn("inHumidity", "InsideHumidity_Pct", 2),
+
+	// Derived columns (not part of the default set)
+	{
+		Key: "feelsLike", Label: "FeelsLike", Quantity: qTemp, Precision: 2,
+		Inputs:  derivedFields["feelsLike"].Inputs,
+		Compute: derivedFields["feelsLike"].Compute,
+	},
+	{
+		Key: "dewpointSpread", Label: "DewpointDepression", Quantity: qTempDelta, Precision: 2,
+		Inputs:  derivedFields["dewpointSpread"].Inputs,
+		Compute: derivedFields["dewpointSpread"].Compute,
+	},
+	{
+		Key: "windCompass", Label: "WindDirection_Compass",
+		Inputs: []string{"windDir"},
+		Text: func(v map[string]float64) (string, bool) {
+			dir, ok := v["windDir"]
+			if !ok {
+				return "", false
+			}
+			return degreesToCompass(dir), true
+		},
+	},
+	{
+		// Vector components follow the meteorological convention: u is positive for
+		// wind blowing towards the east, v for wind blowing towards the north
+		Key: "windU", Label: "WindU", Quantity: qSpeed, Precision: 2,
+		Inputs: []string{"windSpeed", "windDir"},
+		Compute: func(v map[string]float64) (float64, bool) {
+			u, _, ok := windComponents(v)
+			return u, ok
+		},
+	},
+	{
+		Key: "windV", Label: "WindV", Quantity: qSpeed, Precision: 2,
+		Inputs: []string{"windSpeed", "windDir"},
+		Compute: func(v map[string]float64) (float64, bool) {
+			_, vv, ok := windComponents(v)
+			return vv, ok
+		},
+	},
+}
+
+// windComponents splits speed and the direction the wind blows from into u/v.
+// Calm readings (no direction) are 0, 0.
+func windComponents(v map[string]float64) (u, vv float64, ok bool) {
+	speed, ok := v["windSpeed"]
+	if !ok {
+		return 0, 0, false
+	}
+	dir, hasDir := v["windDir"]
+	if !hasDir {
+		if speed == 0 {
+			return 0, 0, true
+		}
+		return 0, 0, false
+	}
+	rad := dir * math.Pi / 180
+	return -speed * math.Sin(rad), -speed * math.Cos(rad), true
+}
+
+func exportColumnByKey(key string) *exportColumn {
+	for _, c := range exportColumns {
+		if c.Key == key {
+			return c
+		}
+	}
+	return nil
+}
+
+// parseExportColumns resolves ?columns=. dateTime may be listed, but only first.
+func parseExportColumns(spec string) ([]*exportColumn, *apiError) {
+	if strings.TrimSpace(spec) == "" {
+		return defaultExportColumns(), nil
+	}
+	names := strings.Split(spec, ",")
+	var cols []*exportColumn
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "dateTime" {
+			if i != 0 {
+				return nil, badParam("columns", "invalid_columns", "Timestamp (dateTime) must be the first column")
+			}
+			continue
+		}
+		c := exportColumnByKey(name)
+		if c == nil {
+			return nil, badParam("columns", "invalid_columns", "unknown column %q (use %s)", name, strings.Join(exportColumnKeys(), ", "))
+		}
+		for _, prev := range cols {
+			if prev == c {
+				return nil, badParam("columns", "invalid_columns", "column %q is listed twice", name)
+			}
+		}
+		cols = append(cols, c)
+	}
+	if len(cols) == 0 {
+		return nil, badParam("columns", "invalid_columns", "at least one data column is required")
+	}
+	return cols, nil
+}
+
+// defaultExportColumns are the archive columns, exported when none are requested
+func defaultExportColumns() []*exportColumn {
+	var cols []*exportColumn
+	for _, c := range exportColumns {
+		if c.Compute == nil && c.Text == nil {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+func exportColumnKeys() []string {
+	keys := make([]string, len(exportColumns))
+	for i, c := range exportColumns {
+		keys[i] = c.Key
+	}
+	return keys
+}
+
+// header returns the column's CSV header in the given units
+func (c *exportColumn) header(units UnitSystem) string {
+	if c.Quantity != qNone {
+		return c.Label + "_" + units.Suffix(c.Quantity)
+	}
+	return c.Label
+}
+
+// number returns the column's value converted to units
+func (c *exportColumn) number(v map[string]float64, units UnitSystem) (float64, bool) {
+	var x float64
+	var ok bool
+	if c.Compute != nil {
+		x, ok = c.Compute(v)
+	} else {
+		x, ok = v[c.Key]
+	}
+	if !ok || math.IsNaN(x) {
+		return 0, false
+	}
+	if c.Quantity != qNone {
+		x = units.Convert(c.Quantity, x)
+	}
+	return x, true
+}
+
+// exportCell is one exported value; Valid is false for missing (null) values
+type exportCell struct {
+	Num   float64
+	Text  string
+	Valid bool
+}
+
+// IsText reports whether the column holds strings rather than numbers
+func (c *exportColumn) IsText() bool {
+	return c.Text != nil
+}
+
+// IsInteger reports whether the column holds whole numbers (counts)
+func (c *exportColumn) IsInteger() bool {
+	return c.Text == nil && c.Quantity == qNone && c.Precision == 0
+}
+
+// cell returns the column's value in units, rounded to its precision
+func (c *exportColumn) cell(v map[string]float64, units UnitSystem) exportCell {
+	if c.Text != nil {
+		s, ok := c.Text(v)
+		return exportCell{Text: s, Valid: ok}
+	}
+	x, ok := c.number(v, units)
+	if !ok {
+		return exportCell{}
+	}
+	scale := math.Pow(10, float64(c.Precision))
+	return exportCell{Num: math.Round(x*scale) / scale, Valid: true}
+}
+
+// exportInputs returns the archive columns cols read, without duplicates
+func exportInputs(cols []*exportColumn) []string {
+	var inputs []string
+	for _, c := range cols {
+		for _, in := range c.Inputs {
+			if !containsString(inputs, in) {
+				inputs = append(inputs, in)
+			}
+		}
+	}
+	return inputs
+}
+
+// queryExportRows streams archive rows for [start, end) to fn with the inputs of
+// cols, keyed by column name. NULL inputs are absent from the map. The query is
+// canceled with ctx.
+func queryExportRows(ctx context.Context, name string, cols []*exportColumn, start, end int64, fn func(epoch int64, v map[string]float64) error) error {
+	inputs := exportInputs(cols)
+	values := make(map[string]float64, len(inputs))
+	scan := ArchiveScan{Columns: inputs, Start: start, End: end}
+	return archive.Scan(ctx, name, scan, func(epoch int64, raw []sql.NullFloat64) error {
+		clear(values)
+		for i, in := range inputs {
+			if raw[i].Valid {
+				values[in] = raw[i].Float64
+			}
+		}
+		return fn(epoch, values)
+	})
+}
+
+// exportJob is one export: the rows in [start, end) in a format and content encoding
+type exportJob struct {
+	name     string // query name for metrics
+	filename string // download name including the extension
+	format   *exportFormat
+	encoding string // Content-Encoding; "" for none
+	cols     []*exportColumn
+	units    UnitSystem
+	start    time.Time
+	end      time.Time
+}
+
+type exportResult struct {
+	rows      int
+	truncated bool // the format's row limit was reached
+}
+
+// key identifies the job's output: its parameters plus a fingerprint of the archive
+// values it reads, so new, deleted or corrected records produce a different key
+func (job *exportJob) key(ctx context.Context) (string, error) {
+	fingerprint, err := archive.Fingerprint(ctx, job.name+"_fingerprint", exportInputs(job.cols), job.start.Unix(), job.end.Unix())
+	if err != nil {
+		return "", err
+	}
+
+	keys := make([]string, len(job.cols))
+	for i, c := range job.cols {
+		keys[i] = c.Key
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "v2|%s|%s|%s|%s|%s|%d|%d|%s", job.format.Name, job.encoding, job.units.Key(),
+		strings.Join(keys, ","), station.Loc, job.start.Unix(), job.end.Unix(), fingerprint)
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// setHeaders sets the response headers shared by streamed and cached exports
+func (job *exportJob) setHeaders(w http.ResponseWriter, key string) {
+	w.Header().Set("Content-Type", job.format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", job.filename))
+	w.Header().Set("X-Units", job.units.Key())
+	w.Header().Set("ETag", `"`+key+`"`)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if job.encoding != "" {
+		w.Header().Set("Content-Encoding", job.encoding)
+	}
+}
+
+// encode writes the job's rows to w in its format and content encoding
+func (job *exportJob) encode(ctx context.Context, w io.Writer) (exportResult, error) {
+	var res exportResult
+	enc, err := newExportEncoder(w, job.encoding)
+	if err != nil {
+		return res, err
+	}
+	out, err := job.format.New(enc, job.cols, job.units)
+	if err != nil {
+		return res, err
+	}
+
+	cells := make([]exportCell, len(job.cols))
+	err = queryExportRows(ctx, job.name, job.cols, job.start.Unix(), job.end.Unix(), func(epoch int64, v map[string]float64) error {
+		for i, c := range job.cols {
+			cells[i] = c.cell(v, job.units)
+		}
+		if err := out.WriteRow(station.In(epoch), cells); err != nil {
+			return err
+		}
+		res.rows++
+		return nil
+	})
+	if errors.Is(err, errExportRowLimit) {
+		res.truncated, err = true, nil
+	}
+	if err != nil {
+		// Writers holding temporary files release them without finishing the output
+		if d, ok := out.(interface{ Discard() }); ok {
+			d.Discard()
+		}
+		return res, err
+	}
+	if err := out.Close(); err != nil {
+		return res, err
+	}
+	return res, enc.Close()
+}
+
+// writeExport sends [start, end) in the requested ?format= with a station-local
+// timestamp column followed by the requested columns. Finished exports are served
+// from the export cache, which is what makes Range requests possible.
+func writeExport(w http.ResponseWriter, r *http.Request, name, filename string, start, end time.Time) {
+	units, apiErr := unitsFromRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	cols, apiErr := parseExportColumns(r.URL.Query().Get("columns"))
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	format, apiErr := parseExportFormat(r.URL.Query().Get("format"))
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	job := &exportJob{name: name, filename: filename + "." + format.Ext, format: format, cols: cols, units: units, start: start, end: end}
+	if !format.Compressed {
+		w.Header().Set("Vary", "Accept-Encoding")
+		job.encoding = negotiateExportEncoding(r.Header.Get("Accept-Encoding"))
+	}
+
+	ctx := r.Context()
+	key, err := job.key(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[Export] %s fingerprint error: %v", name, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// A resumed download needs the complete file, so build it first if it has
+	// expired or the original download never finished. One too large to cache is
+	// sent in full instead.
+	if r.Header.Get("Range") != "" {
+		if err := exportCache.build(ctx, key, job); err != nil && !errors.Is(err, errExportTooLarge) {
+			if ctx.Err() == nil {
+				log.Printf("[Export] %s: %v", job.filename, err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+			}
+			exportRequests.Inc(format.Name, "error")
+			return
+		}
+	}
+	if f, e := exportCache.open(key); f != nil {
+		defer f.Close()
+		job.setHeaders(w, key)
+		w.Header().Set("X-Export-Rows", strconv.Itoa(e.rows))
+		if e.truncated {
+			w.Header().Set("X-Export-Truncated", "true")
+		}
+		http.ServeContent(w, r, "", e.created, f)
+		exportRequests.Inc(format.Name, "cached")
+		return
+	}
+
+	streamExport(w, r, job, key)
+}
+
+// parseCSVDate reads a year/month/day triple of query parameters as a station-local
+// date
+func parseCSVDate(r *http.Request, yearKey, monthKey, dayKey string) (time.Time, bool) {
+	q := r.URL.Query()
+	d, err := time.Parse("2006-01-02", fmt.Sprintf("%s-%s-%s", q.Get(yearKey), q.Get(monthKey), q.Get(dayKey)))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return station.Date(d.Year(), d.Month(), d.Day()), true
+}
+
+// -------------------- /api/csv/daily, /api/csv/range --------------------
+
+func handleCSVDaily(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("year") == "" || q.Get("month") == "" || q.Get("day") == "" {
+		http.Error(w, "Missing required parameters: year, month, day", http.StatusBadRequest)
+		return
+	}
+	day, ok := parseCSVDate(r, "year", "month", "day")
+	if !ok {
+		http.Error(w, "Invalid date format", http.StatusBadRequest)
+		return
+	}
+
+	// Midnight to midnight in station local time
+	writeExport(w, r, "csv_daily", "weather_"+day.Format("2006-01-02"), day, station.NextDay(day))
+}
+
+func handleCSVRange(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	for _, key := range []string{"startYear", "startMonth", "startDay", "endYear", "endMonth", "endDay"} {
+		if q.Get(key) == "" {
+			http.Error(w, "Missing required parameters: startYear, startMonth, startDay, endYear, endMonth, endDay", http.StatusBadRequest)
+			return
+		}
+	}
+	startDate, ok := parseCSVDate(r, "startYear", "startMonth", "startDay")
+	if !ok {
+		http.Error(w, "Invalid start date format", http.StatusBadRequest)
+		return
+	}
+	endDate, ok := parseCSVDate(r, "endYear", "endMonth", "endDay")
+	if !ok {
+		http.Error(w, "Invalid end date format", http.StatusBadRequest)
+		return
+	}
+	if startDate.After(endDate) {
+		http.Error(w, "Start date must be before or equal to end date", http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("weather_%s_to_%s", startDate.Format("2006-01-02"), endDate.Format("2006-01-02"))
+	writeExport(w, r, "csv_range", filename, startDate, station.NextDay(endDate))
+}
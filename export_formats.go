@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// exportWriter encodes export rows in one file format. Timestamps are in the station
+// time zone.
+type exportWriter interface {
+	WriteRow(t time.Time, cells []exportCell) error
+	// Close finishes the file (footers, archive directory); it does not close the
+	// underlying writer
+	Close() error
+}
+
+// exportFormat is a ?format= the export endpoints accept
+type exportFormat struct {
+	Name        string
+	ContentType string
+	Ext         string
+	// Compressed formats are sent without a Content-Encoding
+	Compressed bool
+	New        func(w io.Writer, cols []*exportColumn, units UnitSystem) (exportWriter, error)
+}
+
+var exportFormats = []*exportFormat{
+	{Name: "csv", ContentType: "text/csv", Ext: "csv", New: newCSVExportWriter},
+	{Name: "ndjson", ContentType: "application/x-ndjson", Ext: "ndjson", New: newNDJSONExportWriter},
+	{Name: "xlsx", ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", Ext: "xlsx", Compressed: true, New: newXLSXExportWriter},
+	{Name: "parquet", ContentType: "application/vnd.apache.parquet", Ext: "parquet", New: newParquetExportWriter},
+}
+
+// errExportRowLimit ends an export early when the format cannot hold more rows
+var errExportRowLimit = errors.New("row limit reached")
+
+func parseExportFormat(name string) (*exportFormat, *apiError) {
+	switch name = strings.ToLower(strings.TrimSpace(name)); name {
+	case "":
+		name = "csv"
+	case "jsonl":
+		name = "ndjson"
+	}
+	names := make([]string, len(exportFormats))
+	for i, f := range exportFormats {
+		if f.Name == name {
+			return f, nil
+		}
+		names[i] = f.Name
+	}
+	return nil, badParam("format", "invalid_format", "unknown format %q (use %s)", name, strings.Join(names, ", "))
+}
+
+// -------------------- CSV --------------------
+
+type csvExportWriter struct {
+	w    *bufio.Writer
+	cols []*exportColumn
+	buf  []byte
+}
+
+func newCSVExportWriter(w io.Writer, cols []*exportColumn, units UnitSystem) (exportWriter, error) {
+	cw := &csvExportWriter{w: bufio.NewWriter(w), cols: cols}
+	b := append(cw.buf[:0], "Timestamp"...)
+	for _, c := range cols {
+		b = append(append(b, ','), c.header(units)...)
+	}
+	_, err := cw.w.Write(append(b, '\n'))
+	return cw, err
+}
+
+func (cw *csvExportWriter) WriteRow(t time.Time, cells []exportCell) error {
+	b := t.AppendFormat(cw.buf[:0], "2006-01-02 15:04:05")
+	for i, c := range cw.cols {
+		b = append(b, ',')
+		switch cell := cells[i]; {
+		case !cell.Valid:
+		case c.IsText():
+			b = append(b, cell.Text...)
+		default:
+			b = strconv.AppendFloat(b, cell.Num, 'f', c.Precision, 64)
+		}
+	}
+	b = append(b, '\n')
+	cw.buf = b
+	_, err := cw.w.Write(b)
+	return err
+}
+
+func (cw *csvExportWriter) Close() error {
+	return cw.w.Flush()
+}
+
+// -------------------- NDJSON --------------------
+
+// ndjsonExportWriter writes one JSON object per line keyed by column name, with an
+// RFC 3339 timestamp carrying the station's UTC offset and null for missing values
+type ndjsonExportWriter struct {
+	w    *bufio.Writer
+	cols []*exportColumn
+	keys [][]byte // pre-encoded `,"name":`
+	buf  []byte
+}
+
+func newNDJSONExportWriter(w io.Writer, cols []*exportColumn, units UnitSystem) (exportWriter, error) {
+	nw := &ndjsonExportWriter{w: bufio.NewWriter(w), cols: cols, keys: make([][]byte, len(cols))}
+	for i, c := range cols {
+		name, _ := json.Marshal(c.Key)
+		nw.keys[i] = append(append([]byte{','}, name...), ':')
+	}
+	return nw, nil
+}
+
+func (nw *ndjsonExportWriter) WriteRow(t time.Time, cells []exportCell) error {
+	b := append(nw.buf[:0], `{"timestamp":"`...)
+	b = t.AppendFormat(b, time.RFC3339)
+	b = append(b, '"')
+	for i, c := range nw.cols {
+		b = append(b, nw.keys[i]...)
+		switch cell := cells[i]; {
+		case !cell.Valid:
+			b = append(b, "null"...)
+		case c.IsText():
+			text, _ := json.Marshal(cell.Text)
+			b = append(b, text...)
+		default:
+			b = strconv.AppendFloat(b, cell.Num, 'f', -1, 64)
+		}
+	}
+	b = append(b, '}', '\n')
+	nw.buf = b
+	_, err := nw.w.Write(b)
+	return err
+}
+
+func (nw *ndjsonExportWriter) Close() error {
+	return nw.w.Flush()
+}
+
+// -------------------- XLSX --------------------
+//
+// One "Weather" sheet written with excelize's stream writer, which spills rows to a
+// temporary file so memory use doesn't grow with the export. Timestamps are Excel
+// date cells holding the station's wall-clock time; missing values are empty cells.
+
+// xlsxMaxRows is Excel's sheet limit, including the header row
+const xlsxMaxRows = excelize.TotalRows
+
+const xlsxSheet = "Weather"
+
+type xlsxExportWriter struct {
+	w       io.Writer
+	f       *excelize.File
+	sw      *excelize.StreamWriter
+	cols    []*exportColumn
+	rows    int
+	tsStyle int
+	values  []interface{}
+}
+
+func newXLSXExportWriter(w io.Writer, cols []*exportColumn, units UnitSystem) (exportWriter, error) {
+	f := excelize.NewFile()
+	xw, err := setupXLSXExport(f, w, cols, units)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return xw, nil
+}
+
+func setupXLSXExport(f *excelize.File, w io.Writer, cols []*exportColumn, units UnitSystem) (*xlsxExportWriter, error) {
+	if err := f.SetSheetName(f.GetSheetName(0), xlsxSheet); err != nil {
+		return nil, err
+	}
+	tsFormat := "yyyy-mm-dd hh:mm:ss"
+	tsStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &tsFormat})
+	if err != nil {
+		return nil, err
+	}
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+	sw, err := f.NewStreamWriter(xlsxSheet)
+	if err != nil {
+		return nil, err
+	}
+	if err := sw.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		return nil, err
+	}
+	if err := sw.SetColWidth(1, 1, 20); err != nil {
+		return nil, err
+	}
+
+	header := make([]interface{}, 0, len(cols)+1)
+	header = append(header, excelize.Cell{StyleID: headerStyle, Value: "Timestamp"})
+	for _, c := range cols {
+		header = append(header, excelize.Cell{StyleID: headerStyle, Value: c.header(units)})
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return nil, err
+	}
+	return &xlsxExportWriter{
+		w:       w,
+		f:       f,
+		sw:      sw,
+		cols:    cols,
+		rows:    1,
+		tsStyle: tsStyle,
+		values:  make([]interface{}, len(cols)+1),
+	}, nil
+}
+
+func (xw *xlsxExportWriter) WriteRow(t time.Time, cells []exportCell) error {
+	if xw.rows >= xlsxMaxRows {
+		return errExportRowLimit
+	}
+	xw.rows++
+	xw.values[0] = excelize.Cell{StyleID: xw.tsStyle, Value: excelSerial(t)}
+	for i, c := range xw.cols {
+		switch cell := cells[i]; {
+		case !cell.Valid:
+			xw.values[i+1] = nil
+		case c.IsText():
+			xw.values[i+1] = cell.Text
+		default:
+			xw.values[i+1] = cell.Num
+		}
+	}
+	cell, err := excelize.CoordinatesToCellName(1, xw.rows)
+	if err != nil {
+		return err
+	}
+	return xw.sw.SetRow(cell, xw.values)
+}
+
+func (xw *xlsxExportWriter) Close() error {
+	defer xw.f.Close()
+	if err := xw.sw.Flush(); err != nil {
+		return err
+	}
+	return xw.f.Write(xw.w)
+}
+
+// Discard releases the stream writer's temporary file without writing the workbook
+func (xw *xlsxExportWriter) Discard() {
+	xw.f.Close()
+}
+
+// excelSerial converts t's wall-clock time to an Excel date serial (days since
+// 1899-12-30)
+func excelSerial(t time.Time) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	return float64(wall.Unix())/86400 + 25569
+}
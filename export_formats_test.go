@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/xuri/excelize/v2"
+)
+
+// exportTestColumns covers a nullable DOUBLE, an INT64 count and a text column
+func exportTestColumns(t *testing.T) []*exportColumn {
+	t.Helper()
+	var cols []*exportColumn
+	for _, key := range []string{"outTemp", "lightning_strike_count", "windCompass"} {
+		c := exportColumnByKey(key)
+		if c == nil {
+			t.Fatalf("no export column %q", key)
+		}
+		cols = append(cols, c)
+	}
+	return cols
+}
+
+// exportTestRow is row i of a synthetic export: outTemp is missing on every third
+// row and the compass point on every fifth
+func exportTestRow(start time.Time, i int) (time.Time, []exportCell) {
+	cells := []exportCell{
+		{Num: 60 + float64(i%40)/4, Valid: i%3 != 0},
+		{Num: float64(i % 7), Valid: true},
+		{Text: []string{"N", "NE", "E", "SE", "S"}[i%5], Valid: i%5 != 4},
+	}
+	return start.Add(time.Duration(i) * 5 * time.Minute), cells
+}
+
+func writeTestExport(t *testing.T, format string, cols []*exportColumn, start time.Time, rows int) ([]byte, int) {
+	t.Helper()
+	f, apiErr := parseExportFormat(format)
+	if apiErr != nil {
+		t.Fatalf("format %s: %v", format, apiErr)
+	}
+	var buf bytes.Buffer
+	w, err := f.New(&buf, cols, displayUnits)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	written := 0
+	for i := 0; i < rows; i++ {
+		ts, cells := exportTestRow(start, i)
+		if err := w.WriteRow(ts, cells); err != nil {
+			if errors.Is(err, errExportRowLimit) {
+				break
+			}
+			t.Fatalf("WriteRow %d: %v", i, err)
+		}
+		written++
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes(), written
+}
+
+func TestParquetExportRoundTrip(t *testing.T) {
+	loc := withStationZone(t, "America/Denver")
+	cols := exportTestColumns(t)
+	start := time.Date(2024, 3, 9, 12, 0, 0, 0, loc)
+	rows := parquetRowGroupRows + 1000
+	data, _ := writeTestExport(t, "parquet", cols, start, rows)
+
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if f.NumRows() != int64(rows) {
+		t.Fatalf("file has %d rows, want %d", f.NumRows(), rows)
+	}
+	if n := len(f.RowGroups()); n != 2 {
+		t.Errorf("got %d row groups, want 2", n)
+	}
+	if tz, _ := f.Lookup("weatherdash.timezone"); tz != "America/Denver" {
+		t.Errorf("timezone metadata %q", tz)
+	}
+	if u, _ := f.Lookup("weatherdash.units"); u != displayUnits.Key() {
+		t.Errorf("units metadata %q, want %q", u, displayUnits.Key())
+	}
+
+	// Schema: column order, types and repetition as documented
+	fields := f.Schema().Fields()
+	wantNames := []string{"timestamp", "outTemp", "lightning_strike_count", "windCompass"}
+	if len(fields) != len(wantNames) {
+		t.Fatalf("got %d columns, want %d", len(fields), len(wantNames))
+	}
+	for i, field := range fields {
+		if field.Name() != wantNames[i] {
+			t.Errorf("column %d is %q, want %q", i, field.Name(), wantNames[i])
+		}
+		if field.Optional() != (i > 0) {
+			t.Errorf("column %q optional=%v", field.Name(), field.Optional())
+		}
+	}
+	if lt := fields[0].Type().LogicalType().String(); lt != "TIMESTAMP(isAdjustedToUTC=false,unit=MILLIS)" {
+		t.Errorf("timestamp logical type %s, want local TIMESTAMP(MILLIS)", lt)
+	}
+	if k := fields[1].Type().Kind(); k != parquet.Double {
+		t.Errorf("outTemp kind %v, want DOUBLE", k)
+	}
+	if k := fields[2].Type().Kind(); k != parquet.Int64 {
+		t.Errorf("lightning_strike_count kind %v, want INT64", k)
+	}
+	if lt := fields[3].Type().LogicalType().String(); lt != "STRING" {
+		t.Errorf("windCompass logical type %s, want STRING", lt)
+	}
+
+	reader := parquet.NewReader(f)
+	defer reader.Close()
+	buf := make([]parquet.Row, 1000)
+	i := 0
+	for i < rows {
+		n, err := reader.ReadRows(buf)
+		for _, row := range buf[:n] {
+			wantTime, cells := exportTestRow(start, i)
+			wall := time.Date(wantTime.Year(), wantTime.Month(), wantTime.Day(), wantTime.Hour(), wantTime.Minute(), 0, 0, time.UTC)
+			if got := row[0].Int64(); got != wall.UnixMilli() {
+				t.Fatalf("row %d timestamp %d, want %d (%s wall clock)", i, got, wall.UnixMilli(), wantTime)
+			}
+			if row[1].IsNull() != !cells[0].Valid || (cells[0].Valid && row[1].Double() != cells[0].Num) {
+				t.Fatalf("row %d outTemp %v, want %+v", i, row[1], cells[0])
+			}
+			if row[2].Int64() != int64(cells[1].Num) {
+				t.Fatalf("row %d strikes %v, want %v", i, row[2], cells[1].Num)
+			}
+			if row[3].IsNull() != !cells[2].Valid || (cells[2].Valid && row[3].String() != cells[2].Text) {
+				t.Fatalf("row %d windCompass %v, want %+v", i, row[3], cells[2])
+			}
+			i++
+		}
+		if err != nil {
+			break
+		}
+	}
+	if i != rows {
+		t.Errorf("read %d rows, want %d", i, rows)
+	}
+}
+
+func TestXLSXExportRoundTrip(t *testing.T) {
+	loc := withStationZone(t, "America/Denver")
+	cols := exportTestColumns(t)
+	start := time.Date(2024, 3, 10, 1, 30, 0, 0, loc)
+	data, _ := writeTestExport(t, "xlsx", cols, start, 50)
+
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if sheets := f.GetSheetList(); len(sheets) != 1 || sheets[0] != "Weather" {
+		t.Fatalf("sheets %v", sheets)
+	}
+	rows, err := f.GetRows("Weather", excelize.Options{RawCellValue: true})
+	if err != nil {
+		t.Fatalf("GetRows: %v", err)
+	}
+	if len(rows) != 51 {
+		t.Fatalf("got %d rows, want header + 50", len(rows))
+	}
+	wantHeader := []string{"Timestamp", cols[0].header(displayUnits), "LightningStrikes", "WindDirection_Compass"}
+	for i, h := range wantHeader {
+		if rows[0][i] != h {
+			t.Errorf("header %d is %q, want %q", i, rows[0][i], h)
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		r := i + 2
+		ts, cells := exportTestRow(start, i)
+		cell, _ := excelize.CoordinatesToCellName(1, r)
+		got, err := f.GetCellValue("Weather", cell)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Timestamps read back as the station's wall-clock time, including across
+		// the DST change at 02:00
+		if want := ts.Format("2006-01-02 15:04:05"); got != want {
+			t.Errorf("row %d timestamp %q, want %q", r, got, want)
+		}
+		for j, c := range cells {
+			cell, _ := excelize.CoordinatesToCellName(j+2, r)
+			got, _ := f.GetCellValue("Weather", cell, excelize.Options{RawCellValue: true})
+			switch {
+			case !c.Valid:
+				if got != "" {
+					t.Errorf("%s holds %q, want an empty cell", cell, got)
+				}
+			case cols[j].IsText():
+				if got != c.Text {
+					t.Errorf("%s holds %q, want %q", cell, got, c.Text)
+				}
+			default:
+				if v, err := strconv.ParseFloat(got, 64); err != nil || v != c.Num {
+					t.Errorf("%s holds %q, want %v", cell, got, c.Num)
+				}
+			}
+		}
+	}
+}
+
+func TestXLSXExportRowLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a full sheet")
+	}
+	withStationZone(t, "UTC")
+	cols := exportTestColumns(t)[:1]
+	data, written := writeTestExport(t, "xlsx", cols, time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC), xlsxMaxRows+10)
+	if written != xlsxMaxRows-1 {
+		t.Fatalf("wrote %d rows before the limit, want %d", written, xlsxMaxRows-1)
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	rows, err := f.Rows("Weather")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if err := rows.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if n != xlsxMaxRows {
+		t.Errorf("sheet has %d rows, want %d", n, xlsxMaxRows)
+	}
+}
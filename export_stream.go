@@ -0,0 +1,335 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sync/singleflight"
+)
+
+// Exports stream to the client as rows come off the query and are spooled to disk at
+// the same time. A finished file is kept under its ETag for a while, so repeated and
+// resumed (Range) downloads are served from disk instead of querying again.
+
+// -------------------- content encoding --------------------
+
+// exportEncodings are the content encodings exports can be sent with, preferred first
+var exportEncodings = []string{"zstd", "gzip"}
+
+// negotiateExportEncoding picks the encoding from an Accept-Encoding header with the
+// highest q-value, preferring exportEncodings order on ties; "" means identity
+func negotiateExportEncoding(accept string) string {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if k, v, ok := strings.Cut(params, "="); ok && strings.TrimSpace(k) == "q" {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = f
+			}
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range exportEncodings {
+		q, ok := weights[enc]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func newExportEncoder(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		// A single goroutine keeps the output identical between runs, which resumed
+		// downloads rely on
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// -------------------- streaming --------------------
+
+// exportStream passes an export through to the client, starting the response on the
+// first byte and copying everything to a spool file for the cache
+type exportStream struct {
+	w       io.Writer
+	start   func()
+	started bool
+	spool   *os.File
+	err     error // first error writing to the client
+}
+
+func (s *exportStream) Write(p []byte) (int, error) {
+	if !s.started {
+		s.started = true
+		s.start()
+	}
+	if s.spool != nil {
+		if _, err := s.spool.Write(p); err != nil {
+			log.Printf("[Export] Not caching %s: %v", s.spool.Name(), err)
+			discardSpool(s.spool)
+			s.spool = nil
+		}
+	}
+	n, err := s.w.Write(p)
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	return n, err
+}
+
+// streamExport sends the job to the client as it is generated. Errors before the
+// first byte get a 500; later ones abort the connection so the client sees a broken
+// transfer rather than a file that silently ends early. The row count follows in the
+// X-Export-Rows trailer.
+func streamExport(w http.ResponseWriter, r *http.Request, job *exportJob, key string) {
+	spool, err := exportCache.spool()
+	if err != nil {
+		log.Printf("[Export] Not caching %s: %v", job.filename, err)
+	}
+	out := &exportStream{w: w, spool: spool, start: func() {
+		job.setHeaders(w, key)
+		w.Header().Set("Trailer", "X-Export-Rows, X-Export-Truncated")
+		w.WriteHeader(http.StatusOK)
+	}}
+
+	res, err := job.encode(r.Context(), out)
+	if err != nil {
+		if out.spool != nil {
+			discardSpool(out.spool)
+		}
+		switch {
+		case out.err != nil || r.Context().Err() != nil:
+			log.Printf("[Export] %s canceled by the client after %d rows", job.filename, res.rows)
+			exportRequests.Inc(job.format.Name, "canceled")
+			return
+		case !out.started:
+			log.Printf("[Export] %s query error: %v", job.name, err)
+			exportRequests.Inc(job.format.Name, "error")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[Export] %s failed after %d rows: %v", job.filename, res.rows, err)
+		exportRequests.Inc(job.format.Name, "aborted")
+		panic(http.ErrAbortHandler)
+	}
+
+	if !out.started {
+		out.start()
+	}
+	w.Header().Set("X-Export-Rows", strconv.Itoa(res.rows))
+	if res.truncated {
+		w.Header().Set("X-Export-Truncated", "true")
+		log.Printf("[Export] %s truncated at %d rows (%s limit)", job.filename, res.rows, job.format.Name)
+	}
+	if out.spool != nil {
+		if err := exportCache.commit(key, out.spool, res); err != nil {
+			log.Printf("[Export] Not caching %s: %v", job.filename, err)
+		}
+	}
+	exportRequests.Inc(job.format.Name, "streamed")
+	log.Printf("[Export] Generated %s: %d rows, %d columns\n", job.filename, res.rows, len(job.cols)+1)
+}
+
+// -------------------- cache --------------------
+
+const exportCacheExt = ".export"
+
+// errExportTooLarge is returned by commit for an export bigger than the whole cache
+var errExportTooLarge = errors.New("export is larger than export.cache_max_mb")
+
+// exportCacheEntry is a finished export on disk
+type exportCacheEntry struct {
+	path      string
+	size      int64
+	rows      int
+	truncated bool
+	created   time.Time
+}
+
+// ExportCache keeps finished exports on disk for ttl, keyed by ETag. Together they
+// take at most maxBytes; the oldest are deleted to make room.
+type ExportCache struct {
+	dir      string
+	ttl      time.Duration
+	maxBytes int64
+	group    singleflight.Group
+	mu       sync.Mutex
+	entries  map[string]*exportCacheEntry
+	size     int64
+}
+
+var exportCache *ExportCache
+
+func NewExportCache(cfg ExportConfig) (*ExportCache, error) {
+	ttl, err := time.ParseDuration(cfg.CacheTTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("export.cache_ttl %q: must be a positive duration", cfg.CacheTTL)
+	}
+	if cfg.CacheMaxMB <= 0 {
+		return nil, fmt.Errorf("export.cache_max_mb %d: must be positive", cfg.CacheMaxMB)
+	}
+	if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
+		return nil, err
+	}
+	// The index only lives in memory, so files left by an earlier run are stale
+	for _, pattern := range []string{"*.part", "*" + exportCacheExt} {
+		stale, _ := filepath.Glob(filepath.Join(cfg.CacheDir, pattern))
+		for _, f := range stale {
+			os.Remove(f)
+		}
+	}
+	return &ExportCache{dir: cfg.CacheDir, ttl: ttl, maxBytes: int64(cfg.CacheMaxMB) << 20, entries: make(map[string]*exportCacheEntry)}, nil
+}
+
+// open returns the unexpired export cached under key, or nil
+func (c *ExportCache) open(key string) (*os.File, *exportCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	if e == nil || time.Since(e.created) > c.ttl {
+		return nil, nil
+	}
+	f, err := os.Open(e.path)
+	if err != nil {
+		c.remove(key)
+		return nil, nil
+	}
+	return f, e
+}
+
+// remove deletes the export cached under key; callers hold c.mu. Downloads already
+// reading the file finish normally.
+func (c *ExportCache) remove(key string) {
+	if e := c.entries[key]; e != nil {
+		os.Remove(e.path)
+		c.size -= e.size
+		delete(c.entries, key)
+	}
+}
+
+// spool creates a temporary file for an export in progress
+func (c *ExportCache) spool() (*os.File, error) {
+	return os.CreateTemp(c.dir, "*.part")
+}
+
+func discardSpool(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// commit moves a finished spool file into the cache under key, deleting the oldest
+// exports if the cache would grow past maxBytes
+func (c *ExportCache) commit(key string, spool *os.File, res exportResult) error {
+	info, err := spool.Stat()
+	if err == nil {
+		err = spool.Close()
+	} else {
+		spool.Close()
+	}
+	if err != nil {
+		os.Remove(spool.Name())
+		return err
+	}
+	if info.Size() > c.maxBytes {
+		os.Remove(spool.Name())
+		return fmt.Errorf("%w (%d bytes)", errExportTooLarge, info.Size())
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+	for c.size+info.Size() > c.maxBytes {
+		var oldest string
+		for k, e := range c.entries {
+			if oldest == "" || e.created.Before(c.entries[oldest].created) {
+				oldest = k
+			}
+		}
+		c.remove(oldest)
+	}
+	path := filepath.Join(c.dir, key+exportCacheExt)
+	if err := os.Rename(spool.Name(), path); err != nil {
+		os.Remove(spool.Name())
+		return err
+	}
+	c.entries[key] = &exportCacheEntry{path: path, size: info.Size(), rows: res.rows, truncated: res.truncated, created: time.Now()}
+	c.size += info.Size()
+	return nil
+}
+
+// build generates the job into the cache under key unless it is already there.
+// Concurrent builds of the same key share one query, which runs without the first
+// caller's cancellation so a client giving up doesn't fail the others.
+func (c *ExportCache) build(ctx context.Context, key string, job *exportJob) error {
+	ctx = context.WithoutCancel(ctx)
+	_, err, _ := c.group.Do(key, func() (interface{}, error) {
+		if f, _ := c.open(key); f != nil {
+			f.Close()
+			return nil, nil
+		}
+		spool, err := c.spool()
+		if err != nil {
+			return nil, err
+		}
+		res, err := job.encode(ctx, spool)
+		if err != nil {
+			discardSpool(spool)
+			return nil, err
+		}
+		log.Printf("[Export] Cached %s for resume: %d rows", job.filename, res.rows)
+		return nil, c.commit(key, spool, res)
+	})
+	return err
+}
+
+// StartPruning deletes expired exports every interval until stop is closed
+func (c *ExportCache) StartPruning(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.mu.Lock()
+				for key, e := range c.entries {
+					if time.Since(e.created) > c.ttl {
+						c.remove(key)
+					}
+				}
+				c.mu.Unlock()
+			}
+		}
+	}()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestExportCache(t *testing.T, maxMB int) *ExportCache {
+	t.Helper()
+	c, err := NewExportCache(ExportConfig{CacheDir: t.TempDir(), CacheTTL: "1h", CacheMaxMB: maxMB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// commitTestExport caches size bytes under key
+func commitTestExport(t *testing.T, c *ExportCache, key string, size int) error {
+	t.Helper()
+	spool, err := c.spool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := spool.Write(bytes.Repeat([]byte("x"), size)); err != nil {
+		t.Fatal(err)
+	}
+	return c.commit(key, spool, exportResult{rows: 1})
+}
+
+func TestExportCacheEvictsOldest(t *testing.T) {
+	c := newTestExportCache(t, 1)
+	const part = 400 << 10
+	for _, key := range []string{"a", "b", "c"} {
+		if err := commitTestExport(t, c, key, part); err != nil {
+			t.Fatalf("commit %s: %v", key, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if f, _ := c.open("a"); f != nil {
+		f.Close()
+		t.Error("oldest export kept past the size limit")
+	}
+	for _, key := range []string{"b", "c"} {
+		f, _ := c.open(key)
+		if f == nil {
+			t.Errorf("export %s evicted", key)
+			continue
+		}
+		f.Close()
+	}
+	if c.size != 2*part {
+		t.Errorf("cache size %d, want %d", c.size, 2*part)
+	}
+	files, _ := os.ReadDir(c.dir)
+	if len(files) != 2 {
+		t.Errorf("%d files in the cache directory, want 2", len(files))
+	}
+
+	// Replacing an entry doesn't count it twice
+	if err := commitTestExport(t, c, "c", part); err != nil {
+		t.Fatal(err)
+	}
+	if c.size != 2*part {
+		t.Errorf("cache size %d after replacing an entry, want %d", c.size, 2*part)
+	}
+
+	// An export bigger than the whole cache is refused without evicting anything
+	if err := commitTestExport(t, c, "huge", 2<<20); !errors.Is(err, errExportTooLarge) {
+		t.Errorf("oversized export: err = %v, want errExportTooLarge", err)
+	}
+	if f, _ := c.open("b"); f == nil {
+		t.Error("oversized export evicted other entries")
+	} else {
+		f.Close()
+	}
+	files, _ = os.ReadDir(c.dir)
+	if len(files) != 2 {
+		t.Errorf("%d files in the cache directory after an oversized export, want 2", len(files))
+	}
+}
+
+func TestExportCacheBuildOutlivesCanceledCaller(t *testing.T) {
+	withStationZone(t, "UTC")
+	start := time.Unix(1718900000, 0).UTC()
+	newTestArchive(t, []testRecord{
+		{epoch: start.Unix(), vals: map[string]float64{"outTemp": 70}},
+		{epoch: start.Unix() + 300, vals: map[string]float64{"outTemp": 71}},
+	})
+	c := newTestExportCache(t, 1)
+	format, _ := parseExportFormat("csv")
+	job := &exportJob{name: "test_export", filename: "test.csv", format: format, cols: []*exportColumn{exportColumnByKey("outTemp")}, units: displayUnits, start: start, end: start.Add(time.Hour)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.build(ctx, "key", job); err != nil {
+		t.Fatalf("build with a canceled caller: %v", err)
+	}
+	f, e := c.open("key")
+	if f == nil {
+		t.Fatal("export not cached")
+	}
+	f.Close()
+	if e.rows != 2 {
+		t.Errorf("cached %d rows, want 2", e.rows)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// noQueryArchive fails the test on any database access
+type noQueryArchive struct {
+	t *testing.T
+}
+
+func (a noQueryArchive) fail(method string) error {
+	a.t.Errorf("unexpected archive %s", method)
+	return sql.ErrConnDone
+}
+
+func (a noQueryArchive) Ping(ctx context.Context) error { return a.fail("Ping") }
+func (a noQueryArchive) MaxEpoch(ctx context.Context, query string) (int64, bool, error) {
+	return 0, false, a.fail("MaxEpoch " + query)
+}
+func (a noQueryArchive) Latest(ctx context.Context, query string, cols []string, from, to int64) (int64, []sql.NullFloat64, error) {
+	return 0, nil, a.fail("Latest " + query)
+}
+func (a noQueryArchive) Scan(ctx context.Context, query string, s ArchiveScan, fn func(int64, []sql.NullFloat64) error) error {
+	return a.fail("Scan " + query)
+}
+func (a noQueryArchive) Recent(ctx context.Context, query string, col string, epoch int64, n int) ([]float64, error) {
+	return nil, a.fail("Recent " + query)
+}
+func (a noQueryArchive) Fingerprint(ctx context.Context, query string, cols []string, start, end int64) (string, error) {
+	return "", a.fail("Fingerprint " + query)
+}
+func (a noQueryArchive) DaySummaries(ctx context.Context, query string, obstypes []string, start, end int64) ([]DaySummary, bool, error) {
+	return nil, false, a.fail("DaySummaries " + query)
+}
+func (a noQueryArchive) Close() error { return nil }
+
+// hostileColumns are ?columns= values that must never reach SQL
+var hostileColumns = []string{
+	"outTemp);DROP TABLE archive;--",
+	"outTemp,barometer); DELETE FROM archive --",
+	"outTemp,(SELECT password FROM users)",
+	"`outTemp`",
+	"outTemp AS x",
+	"outtemp",
+	"dateTime,*",
+	"outTemp,outTemp",
+	"outTemp,dateTime",
+	"dateTime",
+}
+
+func TestParseExportColumnsRejectsHostileInput(t *testing.T) {
+	for _, spec := range hostileColumns {
+		cols, apiErr := parseExportColumns(spec)
+		if apiErr == nil {
+			t.Errorf("%q: accepted as %d columns", spec, len(cols))
+			continue
+		}
+		if apiErr.Status != http.StatusBadRequest || apiErr.Code != "invalid_columns" || apiErr.Param != "columns" {
+			t.Errorf("%q: got %+v, want 400 invalid_columns", spec, apiErr)
+		}
+	}
+
+	cols, apiErr := parseExportColumns(" dateTime, outTemp ,windCompass")
+	if apiErr != nil || len(cols) != 2 || cols[0].Key != "outTemp" || cols[1].Key != "windCompass" {
+		t.Errorf("valid columns: got %v, %v", cols, apiErr)
+	}
+}
+
+func TestCSVExportRejectsHostileColumnsWithoutQuerying(t *testing.T) {
+	saved := archive
+	archive = noQueryArchive{t}
+	t.Cleanup(func() { archive = saved })
+
+	for _, spec := range hostileColumns {
+		q := url.Values{
+			"startYear": {"2024"}, "startMonth": {"06"}, "startDay": {"01"},
+			"endYear": {"2024"}, "endMonth": {"06"}, "endDay": {"02"},
+			"columns": {spec},
+		}
+		w := httptest.NewRecorder()
+		handleCSVRange(w, httptest.NewRequest(http.MethodGet, "/api/csv/range?"+q.Encode(), nil))
+
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		if w.Code != http.StatusBadRequest || json.Unmarshal(w.Body.Bytes(), &body) != nil || body.Error.Code != "invalid_columns" {
+			t.Errorf("%q: status %d, body %s; want 400 invalid_columns", spec, w.Code, w.Body)
+		}
+	}
+}
@@ -1,14 +1,15 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"sync"
@@ -17,37 +18,6 @@ import (
 	"golang.org/x/sync/singleflight"
 )
 
-// -------------------- auth helpers --------------------
-
-// getUserRole extracts the user role from hub auth headers
-func getUserRole(r *http.Request) string {
-	role := r.Header.Get("X-Hub-Role")
-	user := r.Header.Get("X-Hub-User")
-	auth := r.Header.Get("X-Hub-Authenticated")
-	log.Printf("Auth headers - User: %s, Role: %s, Authenticated: %s", user, role, auth)
-	if role == "" {
-		role = "admin" // Default to admin if no header (standalone mode)
-	}
-	return role
-}
-
-// isAdmin checks if the user has admin or user role (full permissions)
-func isAdmin(r *http.Request) bool {
-	role := getUserRole(r)
-	return role == "admin" || role == "user"
-}
-
-// requireAdmin is middleware that blocks non-admin users
-func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if !isAdmin(r) {
-			http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
-			return
-		}
-		next(w, r)
-	}
-}
-
 // -------------------- cached celestial --------------------
 type cachedCelestial struct {
 	data   CelestialData
@@ -256,16 +226,22 @@ func handleWeatherDash(w http.ResponseWriter, r *http.Request) {
 		LocationName string
 		ExtremeHeat  float64
 		ExtremeCold  float64
+		Units        map[string]string
 		IsAdmin      bool
 		UserRole     string
+		SignedIn     bool
+		LoginEnabled bool
 	}{
 		ClientPollMs: appConfig.Server.ClientPollSeconds * 1000,
 		AssetVersion: time.Now().Format("20060102T150405"),
 		LocationName: appConfig.Location.Name,
 		ExtremeHeat:  appConfig.Alerts.ExtremeHeat,
 		ExtremeCold:  appConfig.Alerts.ExtremeCold,
+		Units:        displayUnits.Labels(),
 		IsAdmin:      isAdmin(r),
 		UserRole:     getUserRole(r),
+		SignedIn:     currentPrincipal(r).Method == authSession,
+		LoginEnabled: auth.LoginEnabled(),
 	}
 
 	if err := tmplIndex.Execute(w, data); err != nil {
@@ -282,12 +258,14 @@ func handleKiosk(w http.ResponseWriter, r *http.Request) {
 		LocationName string
 		ExtremeHeat  float64
 		ExtremeCold  float64
+		Units        map[string]string
 	}{
 		ClientPollMs: appConfig.Server.ClientPollSeconds * 1000,
 		AssetVersion: time.Now().Format("20060102T150405"),
 		LocationName: appConfig.Location.Name,
 		ExtremeHeat:  appConfig.Alerts.ExtremeHeat,
 		ExtremeCold:  appConfig.Alerts.ExtremeCold,
+		Units:        displayUnits.Labels(),
 	}
 
 	if err := tmplKiosk.Execute(w, data); err != nil {
@@ -300,716 +278,305 @@ func handlePing(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(PingResponse{Message: "pong"})
 }
 
-// handleHealth returns 200 OK for health checks (systemd, monitoring, load balancers)
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	// Optional: check DB connection, add more sophisticated health checks
-	if err := db.Ping(); err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		_ = json.NewEncoder(w).Encode(map[string]string{"status": "unhealthy", "error": "database unreachable"})
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-}
-
-// Range helper: day / week / month
-func getRangeDuration(r *http.Request) time.Duration {
-	q := strings.ToLower(r.URL.Query().Get("range"))
-	switch q {
-	case "week":
-		return 7 * 24 * time.Hour
-	case "month":
-		// simple 30-day month
-		return 30 * 24 * time.Hour
-	default:
-		// "day" or anything else
-		return 24 * time.Hour
-	}
-}
-
 // -------------------- /api/barometer --------------------
 
-func handleBarometer(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	dur := getRangeDuration(r)
-	since := time.Now().Add(-dur).Unix()
-
-	rows, err := db.Query(`
-		SELECT dateTime, barometer
-		FROM archive
-		WHERE dateTime >= ?
-		ORDER BY dateTime ASC
-	`, since)
-	if err != nil {
-		log.Println("DB query error (barometer):", err)
-		http.Error(w, "DB error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var readings []BarometerReading
-	for rows.Next() {
-		var epochSec int64
-		var pressure float64
-
-		if err := rows.Scan(&epochSec, &pressure); err != nil {
-			log.Println("DB scan error (barometer):", err)
-			http.Error(w, "DB scan error", http.StatusInternalServerError)
-			return
-		}
-
-		ts := time.Unix(epochSec, 0)
-
-		readings = append(readings, BarometerReading{
-			Timestamp: ts,
-			Pressure:  pressure,
-		})
-	}
-	if err := rows.Err(); err != nil {
-		log.Println("DB rows error (barometer):", err)
-		http.Error(w, "DB rows error", http.StatusInternalServerError)
-		return
-	}
-
-	// Calculate trend, level, and forecast for the latest reading
-	if len(readings) > 4 {
-		latest := &readings[len(readings)-1]
-		current := latest.Pressure
-		prior := readings[len(readings)-5].Pressure
-		change := current - prior
-
-		// Determine pressure level
-		if current > 30.20 {
-			latest.Level = "high"
-		} else if current < 29.80 {
-			latest.Level = "low"
-		} else {
-			latest.Level = "normal"
-		}
-
-		// Determine rate of change (per hour)
-		// Assuming 5 intervals in data; typical WeeWX is 5-min intervals = 12 per hour
-		// So 5 intervals = ~25 minutes. Change per hour ≈ change * (60/25) = change * 2.4
-		changePerHour := math.Abs(change) * 2.4
-
-		// Categorize trend based on rate
-		if change > 0 {
-			if changePerHour >= 0.06 {
-				latest.Trend = "rapid-rise"
-			} else if changePerHour >= 0.02 {
-				latest.Trend = "slow-rise"
-			} else {
-				latest.Trend = "steady"
+var barometerSection = &seriesSection{
+	Name:    "barometer",
+	Cols:    seriesColumns("barometer"),
+	NotNull: true,
+	Build: func(series seriesResult) interface{} {
+		readings := make([]BarometerReading, 0, len(series.Samples))
+		for _, s := range series.Samples {
+			if !s.Values[0].Valid {
+				continue
 			}
-		} else if change < 0 {
-			if changePerHour >= 0.06 {
-				latest.Trend = "rapid-fall"
-			} else if changePerHour >= 0.02 {
-				latest.Trend = "slow-fall"
-			} else {
-				latest.Trend = "steady"
-			}
-		} else {
-			latest.Trend = "steady"
+			readings = append(readings, BarometerReading{
+				Timestamp: time.Unix(s.Epoch, 0),
+				Pressure:  s.Values[0].Float64,
+			})
 		}
 
-		// Generate forecast based on level + trend
-		switch latest.Level {
-		case "high":
-			switch latest.Trend {
-			case "steady", "slow-rise":
-				latest.Forecast = "Fair weather"
-			case "rapid-rise":
-				latest.Forecast = "Fair, improving"
-			case "slow-fall":
-				latest.Forecast = "Cloudy later"
-			case "rapid-fall":
-				latest.Forecast = "Warmer, cloudier"
-			}
-		case "normal":
-			switch latest.Trend {
-			case "steady", "slow-rise":
-				latest.Forecast = "Conditions continue"
-			case "rapid-rise":
-				latest.Forecast = "Improving"
-			case "slow-fall":
-				latest.Forecast = "Minor changes"
-			case "rapid-fall":
-				latest.Forecast = "Rain/snow likely"
-			}
-		case "low":
-			switch latest.Trend {
-			case "steady", "slow-rise":
-				latest.Forecast = "Cooler, clearing"
-			case "rapid-rise":
-				latest.Forecast = "Improving quickly"
-			case "slow-fall":
-				latest.Forecast = "Rain coming"
-			case "rapid-fall":
-				latest.Forecast = "Stormy weather"
-			}
+		// Calculate trend, level, and forecast for the latest reading. This always uses the
+		// raw archive rows so downsampling does not change the 5-interval lookback.
+		raw := series.Raw
+		if len(raw) > 4 && len(readings) > 0 {
+			latest := &readings[len(readings)-1]
+			latest.Level, latest.Trend, latest.Forecast = pressureOutlook(raw[len(raw)-1].Values[0].Float64, raw[len(raw)-5].Values[0].Float64)
 		}
-	}
+		return readings
+	},
+}
 
-	if err := json.NewEncoder(w).Encode(readings); err != nil {
-		http.Error(w, "JSON error", http.StatusInternalServerError)
-	}
+func handleBarometer(w http.ResponseWriter, r *http.Request) {
+	serveSeriesSection(w, r, barometerSection)
 }
 
 // -------------------- /api/weather --------------------
 
-func handleWeather(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	dur := getRangeDuration(r)
-	since := time.Now().Add(-dur).Unix()
-
-	rows, err := db.Query(`
-		SELECT dateTime, outTemp, dewpoint
-		FROM archive
-		WHERE dateTime >= ?
-		ORDER BY dateTime ASC
-	`, since)
-	if err != nil {
-		log.Println("DB query error (weather):", err)
-		http.Error(w, "DB error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var readings []WeatherReading
-	for rows.Next() {
-		var epochSec int64
-		var tempF, dewF float64
-
-		if err := rows.Scan(&epochSec, &tempF, &dewF); err != nil {
-			log.Println("DB scan error (weather):", err)
-			http.Error(w, "DB scan error", http.StatusInternalServerError)
-			return
-		}
-
-		ts := time.Unix(epochSec, 0)
-
-		readings = append(readings, WeatherReading{
-			Timestamp:   ts,
-			Temperature: tempF,
-			Dewpoint:    dewF,
-		})
-	}
-	if err := rows.Err(); err != nil {
-		log.Println("DB rows error (weather):", err)
-		http.Error(w, "DB rows error", http.StatusInternalServerError)
-		return
-	}
+var weatherSection = &seriesSection{
+	Name: "weather",
+	Cols: seriesColumns("outTemp", "dewpoint"),
+	Build: func(series seriesResult) interface{} {
+		readings := make([]WeatherReading, 0, len(series.Samples))
+		for _, s := range series.Samples {
+			tempF, dewF := s.Values[0], s.Values[1]
+			if !tempF.Valid || !dewF.Valid {
+				continue
+			}
+			readings = append(readings, WeatherReading{
+				Timestamp:   time.Unix(s.Epoch, 0),
+				Temperature: tempF.Float64,
+				Dewpoint:    dewF.Float64,
+			})
+		}
+		return readings
+	},
+}
 
-	if err := json.NewEncoder(w).Encode(readings); err != nil {
-		http.Error(w, "JSON error", http.StatusInternalServerError)
-	}
+func handleWeather(w http.ResponseWriter, r *http.Request) {
+	serveSeriesSection(w, r, weatherSection)
 }
 
 // -------------------- /api/feelslike --------------------
 
-func handleFeelsLike(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	dur := getRangeDuration(r)
-	since := time.Now().Add(-dur).Unix()
-
-	rows, err := db.Query(`
-		SELECT dateTime, heatindex, windchill, outTemp
-		FROM archive
-		WHERE dateTime >= ?
-		ORDER BY dateTime ASC
-	`, since)
-	if err != nil {
-		log.Println("DB query error (feelslike):", err)
-		http.Error(w, "DB error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var readings []FeelsLikeReading
-	for rows.Next() {
-		var epochSec int64
-		var heatF, chillF, tempF float64
-
-		if err := rows.Scan(&epochSec, &heatF, &chillF, &tempF); err != nil {
-			log.Println("DB scan error (feelslike):", err)
-			http.Error(w, "DB scan error", http.StatusInternalServerError)
-			return
-		}
-
-		ts := time.Unix(epochSec, 0)
-
-		reading := FeelsLikeReading{
-			Timestamp: ts,
-			HeatIndex: heatF,
-			WindChill: chillF,
-		}
-
-		// For the latest reading, compute active feels-like value
-		readings = append(readings, reading)
-	}
-	if err := rows.Err(); err != nil {
-		log.Println("DB rows error (feelslike):", err)
-		http.Error(w, "DB rows error", http.StatusInternalServerError)
-		return
-	}
-
-	// Compute active feels-like for the latest reading
-	if len(readings) > 0 {
-		latest := &readings[len(readings)-1]
-
-		// Need to get the temperature for the latest record
-		var tempF float64
-		err := db.QueryRow(`
-			SELECT outTemp FROM archive 
-			WHERE dateTime = ?
-		`, latest.Timestamp.Unix()).Scan(&tempF)
-
-		if err == nil {
-			active := pickFeelsLikeSource(tempF, latest.HeatIndex, latest.WindChill)
-			latest.ActiveValue = active.value
-			latest.ActiveSource = active.source
-			latest.ActiveLabel = active.label
+var feelsLikeSection = &seriesSection{
+	Name: "feelslike",
+	Cols: seriesColumns("heatindex", "windchill", "outTemp"),
+	Build: func(series seriesResult) interface{} {
+		readings := make([]FeelsLikeReading, 0, len(series.Samples))
+		for _, s := range series.Samples {
+			heatF, chillF := s.Values[0], s.Values[1]
+			if !heatF.Valid || !chillF.Valid {
+				continue
+			}
+			readings = append(readings, FeelsLikeReading{
+				Timestamp: time.Unix(s.Epoch, 0),
+				HeatIndex: heatF.Float64,
+				WindChill: chillF.Float64,
+			})
+		}
+
+		// Compute active feels-like for the latest reading from the newest raw row
+		if n := len(series.Raw); n > 0 && len(readings) > 0 {
+			latest := &readings[len(readings)-1]
+			last := series.Raw[n-1]
+			if last.Values[2].Valid {
+				active := pickFeelsLikeSource(last.Values[2].Float64, last.Values[0].Float64, last.Values[1].Float64)
+				latest.ActiveValue = series.Units.Convert(qTemp, active.value)
+				latest.ActiveSource = active.source
+				latest.ActiveLabel = active.label
+			}
 		}
-	}
-
-	if err := json.NewEncoder(w).Encode(readings); err != nil {
-		http.Error(w, "JSON error", http.StatusInternalServerError)
-	}
-}
-
-// Helper to pick the active feels-like source
-type feelsLikeSource struct {
-	value  float64
-	source string
-	label  string
+		return readings
+	},
 }
 
-func pickFeelsLikeSource(tempF, heatIndexF, windChillF float64) feelsLikeSource {
-	const HEAT_THRESHOLD_F = 80.0
-	const CHILL_THRESHOLD_F = 50.0
-
-	activeValue := tempF
-	sourceLabel := "Air Temp"
-	sourceKey := "air"
-
-	if !math.IsNaN(heatIndexF) && heatIndexF != 0 && tempF >= HEAT_THRESHOLD_F {
-		activeValue = heatIndexF
-		sourceLabel = "Heat Index"
-		sourceKey = "heat"
-	} else if !math.IsNaN(windChillF) && windChillF != 0 && tempF <= CHILL_THRESHOLD_F {
-		activeValue = windChillF
-		sourceLabel = "Wind Chill"
-		sourceKey = "chill"
-	}
-
-	return feelsLikeSource{
-		value:  activeValue,
-		source: sourceKey,
-		label:  sourceLabel,
-	}
+func handleFeelsLike(w http.ResponseWriter, r *http.Request) {
+	serveSeriesSection(w, r, feelsLikeSection)
 }
 
 // -------------------- /api/humidity --------------------
 
-func handleHumidity(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	dur := getRangeDuration(r)
-	since := time.Now().Add(-dur).Unix()
-
-	rows, err := db.Query(`
-   	    SELECT dateTime, outHumidity
-   	    FROM archive
-   	    WHERE dateTime >= ?
-   	    ORDER BY dateTime ASC
-   	`, since)
-	if err != nil {
-		log.Println("DB query error (humidity):", err)
-		http.Error(w, "DB error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var readings []HumidityReading
-	for rows.Next() {
-		var epochSec int64
-		var hum float64
-
-		if err := rows.Scan(&epochSec, &hum); err != nil {
-			log.Println("DB scan error (humidity):", err)
-			http.Error(w, "DB scan error", http.StatusInternalServerError)
-			return
+var humiditySection = &seriesSection{
+	Name:    "humidity",
+	Cols:    seriesColumns("outHumidity"),
+	NotNull: true,
+	Build: func(series seriesResult) interface{} {
+		readings := make([]HumidityReading, 0, len(series.Samples))
+		for _, s := range series.Samples {
+			if !s.Values[0].Valid {
+				continue
+			}
+			readings = append(readings, HumidityReading{
+				Timestamp: time.Unix(s.Epoch, 0),
+				Humidity:  s.Values[0].Float64,
+			})
 		}
+		return readings
+	},
+}
 
-		ts := time.Unix(epochSec, 0)
-
-		readings = append(readings, HumidityReading{
-			Timestamp: ts,
-			Humidity:  hum,
-		})
-	}
-
-	if err := rows.Err(); err != nil {
-		log.Println("DB rows error (humidity):", err)
-		http.Error(w, "DB rows error", http.StatusInternalServerError)
-		return
-	}
-
-	_ = json.NewEncoder(w).Encode(readings)
+func handleHumidity(w http.ResponseWriter, r *http.Request) {
+	serveSeriesSection(w, r, humiditySection)
 }
 
 // -------------------- /api/wind --------------------
 
-func handleWind(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	dur := getRangeDuration(r)
-	since := time.Now().Add(-dur).Unix()
-
-	rows, err := db.Query(`
-		SELECT dateTime, windSpeed, windGust, windDir
-		FROM archive
-		WHERE dateTime >= ?
-		ORDER BY dateTime ASC
-	`, since)
-	if err != nil {
-		log.Println("DB query error (wind):", err)
-		http.Error(w, "DB error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var readings []WindReading
-	for rows.Next() {
-		var epochSec int64
-		var speed, gust float64
-		var dir sql.NullFloat64
-
-		if err := rows.Scan(&epochSec, &speed, &gust, &dir); err != nil {
-			log.Println("DB scan error (wind):", err)
-			http.Error(w, "DB scan error", http.StatusInternalServerError)
-			return
-		}
+var windSection = &seriesSection{
+	Name: "wind",
+	// Gust first so LTTB preserves gust peaks
+	Cols: seriesColumns("windGust", "windSpeed", "windDir"),
+	Build: func(series seriesResult) interface{} {
+		readings := make([]WindReading, 0, len(series.Samples))
+		for _, s := range series.Samples {
+			gust, speed, dir := s.Values[0], s.Values[1], s.Values[2]
+			if !speed.Valid || !gust.Valid {
+				continue
+			}
 
-		ts := time.Unix(epochSec, 0)
+			var dirPtr *float64
+			if dir.Valid {
+				dirCopy := dir.Float64
+				dirPtr = &dirCopy
+			}
 
-		var dirPtr *float64
-		if dir.Valid {
-			dirCopy := dir.Float64
-			dirPtr = &dirCopy
+			readings = append(readings, WindReading{
+				Timestamp: time.Unix(s.Epoch, 0),
+				Speed:     speed.Float64,
+				Gust:      gust.Float64,
+				Direction: dirPtr,
+			})
 		}
 
-		readings = append(readings, WindReading{
-			Timestamp: ts,
-			Speed:     speed,
-			Gust:      gust,
-			Direction: dirPtr,
-		})
-	}
-	if err := rows.Err(); err != nil {
-		log.Println("DB rows error (wind):", err)
-		http.Error(w, "DB rows error", http.StatusInternalServerError)
-		return
-	}
+		// Compute compass direction and strong flag for latest reading from the newest raw row
+		if n := len(series.Raw); n > 0 && len(readings) > 0 {
+			latest := &readings[len(readings)-1]
+			last := series.Raw[n-1]
 
-	// Compute compass direction and strong flag for latest reading
-	if len(readings) > 0 {
-		latest := &readings[len(readings)-1]
+			// Compass direction
+			if last.Values[2].Valid {
+				latest.Compass = degreesToCompass(last.Values[2].Float64)
+			} else {
+				latest.Compass = "--"
+			}
 
-		// Compass direction
-		if latest.Direction != nil {
-			latest.Compass = degreesToCompass(*latest.Direction)
-		} else {
-			latest.Compass = "--"
+			// Strong wind detection using config thresholds
+			latest.Strong = windIsStrong(last.Values[1].Float64, last.Values[0].Float64)
 		}
-
-		// Strong wind detection using config thresholds
-		latest.Strong = (latest.Speed >= appConfig.Alerts.WindSpeed || latest.Gust >= appConfig.Alerts.WindGust)
-	}
-
-	if err := json.NewEncoder(w).Encode(readings); err != nil {
-		http.Error(w, "JSON error", http.StatusInternalServerError)
-	}
+		return readings
+	},
 }
 
-// Helper to convert wind direction degrees to 16-point compass
-func degreesToCompass(deg float64) string {
-	if math.IsNaN(deg) {
-		return "--"
-	}
-
-	directions := []string{
-		"N", "NNE", "NE", "ENE",
-		"E", "ESE", "SE", "SSE",
-		"S", "SSW", "SW", "WSW",
-		"W", "WNW", "NW", "NNW",
-	}
-
-	index := int(math.Round(math.Mod(deg, 360.0)/22.5)) % 16
-	return directions[index]
+func handleWind(w http.ResponseWriter, r *http.Request) {
+	serveSeriesSection(w, r, windSection)
 }
 
 // -------------------- /api/rain --------------------
 
-func handleRain(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	dur := getRangeDuration(r)
-	since := time.Now().Add(-dur).Unix()
-
-	rows, err := db.Query(`
-		SELECT dateTime, rainRate, rain
-		FROM archive
-		WHERE dateTime >= ?
-		ORDER BY dateTime ASC
-	`, since)
-	if err != nil {
-		log.Println("DB query error (rain):", err)
-		http.Error(w, "DB error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var readings []RainReading
-	for rows.Next() {
-		var epochSec int64
-		var rate, amount float64
-
-		if err := rows.Scan(&epochSec, &rate, &amount); err != nil {
-			log.Println("DB scan error (rain):", err)
-			http.Error(w, "DB scan error", http.StatusInternalServerError)
-			return
-		}
-
-		ts := time.Unix(epochSec, 0)
-
-		readings = append(readings, RainReading{
-			Timestamp: ts,
-			Rate:      rate,
-			Amount:    amount,
-		})
-	}
-	if err := rows.Err(); err != nil {
-		log.Println("DB rows error (rain):", err)
-		http.Error(w, "DB rows error", http.StatusInternalServerError)
-		return
-	}
-
-	// Compute recently-active flag for latest reading
-	if len(readings) > 0 {
-		now := time.Now()
-		tenMinutesAgo := now.Add(-10 * time.Minute)
-		recentlyActive := false
-
-		// Scan backwards through readings looking for rain in last 10 minutes
-		for i := len(readings) - 1; i >= 0; i-- {
-			if readings[i].Timestamp.Before(tenMinutesAgo) {
-				break
-			}
-			if readings[i].Rate > 0 || readings[i].Amount > 0 {
-				recentlyActive = true
-				break
+var rainSection = &seriesSection{
+	Name: "rain",
+	Cols: seriesColumns("rainRate", "rain"),
+	Build: func(series seriesResult) interface{} {
+		readings := make([]RainReading, 0, len(series.Samples))
+		for _, s := range series.Samples {
+			rate, amount := s.Values[0], s.Values[1]
+			if !rate.Valid || !amount.Valid {
+				continue
 			}
+			readings = append(readings, RainReading{
+				Timestamp: time.Unix(s.Epoch, 0),
+				Rate:      rate.Float64,
+				Amount:    amount.Float64,
+			})
 		}
 
-		readings[len(readings)-1].RecentlyActive = recentlyActive
-	}
+		// Compute recently-active flag for latest reading (any rate or amount in the last 10 minutes)
+		if len(readings) > 0 {
+			readings[len(readings)-1].RecentlyActive = recentlyActive(series.Raw, time.Now(), 0, 1)
+		}
+		return readings
+	},
+}
 
-	if err := json.NewEncoder(w).Encode(readings); err != nil {
-		http.Error(w, "JSON error", http.StatusInternalServerError)
-	}
+func handleRain(w http.ResponseWriter, r *http.Request) {
+	serveSeriesSection(w, r, rainSection)
 }
 
 // -------------------- /api/lightning --------------------
 
-func handleLightning(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	dur := getRangeDuration(r)
-	since := time.Now().Add(-dur).Unix()
-
-	rows, err := db.Query(`
-        SELECT dateTime, lightning_strike_count, lightning_distance
-        FROM archive
-        WHERE dateTime >= ? AND lightning_strike_count IS NOT NULL
-        ORDER BY dateTime ASC
-    `, since)
-	if err != nil {
-		log.Println("DB query error (lightning):", err)
-		http.Error(w, "DB error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
+var lightningSection = &seriesSection{
+	Name:    "lightning",
+	Cols:    seriesColumns("lightning_strike_count", "lightning_distance"),
+	NotNull: true,
+	Build: func(series seriesResult) interface{} {
+		readings := make([]LightningReading, 0, len(series.Samples))
+		for _, s := range series.Samples {
+			strikes, distance := s.Values[0], s.Values[1]
+			if !strikes.Valid {
+				continue
+			}
 
-	var readings []LightningReading
-	for rows.Next() {
-		var epochSec int64
-		var strikes float64
-		var distance sql.NullFloat64
+			var distPtr *float64
+			if distance.Valid {
+				distCopy := distance.Float64
+				distPtr = &distCopy
+			}
 
-		if err := rows.Scan(&epochSec, &strikes, &distance); err != nil {
-			log.Println("DB scan error (lightning):", err)
-			http.Error(w, "DB scan error", http.StatusInternalServerError)
-			return
+			readings = append(readings, LightningReading{
+				Timestamp: time.Unix(s.Epoch, 0),
+				Strikes:   strikes.Float64,
+				Distance:  distPtr,
+			})
 		}
 
-		ts := time.Unix(epochSec, 0)
-
-		var distPtr *float64
-		if distance.Valid {
-			distPtr = &distance.Float64
+		// Compute recently-active flag for latest reading (any strikes in the last 10 minutes)
+		if len(readings) > 0 {
+			readings[len(readings)-1].RecentlyActive = recentlyActive(series.Raw, time.Now(), 0)
 		}
+		return readings
+	},
+}
 
-		readings = append(readings, LightningReading{
-			Timestamp: ts,
-			Strikes:   strikes,
-			Distance:  distPtr,
-		})
-	}
-
-	if err := rows.Err(); err != nil {
-		log.Println("DB rows error (lightning):", err)
-		http.Error(w, "DB rows error", http.StatusInternalServerError)
-		return
-	}
+func handleLightning(w http.ResponseWriter, r *http.Request) {
+	serveSeriesSection(w, r, lightningSection)
+}
 
-	// Compute recently-active flag for latest reading
-	if len(readings) > 0 {
-		now := time.Now()
-		tenMinutesAgo := now.Add(-10 * time.Minute)
-		recentlyActive := false
+// -------------------- /api/insideTemp --------------------
 
-		// Scan backwards through readings looking for strikes in last 10 minutes
-		for i := len(readings) - 1; i >= 0; i-- {
-			if readings[i].Timestamp.Before(tenMinutesAgo) {
-				break
-			}
-			if readings[i].Strikes > 0 {
-				recentlyActive = true
-				break
+var insideTempSection = &seriesSection{
+	Name:    "insideTemp",
+	Cols:    seriesColumns("inTemp"),
+	NotNull: true,
+	Build: func(series seriesResult) interface{} {
+		readings := make([]InsideTemperature, 0, len(series.Samples))
+		for _, s := range series.Samples {
+			if !s.Values[0].Valid {
+				continue
 			}
+			readings = append(readings, InsideTemperature{
+				Timestamp:   time.Unix(s.Epoch, 0),
+				InsideTempF: s.Values[0].Float64,
+			})
 		}
-
-		readings[len(readings)-1].RecentlyActive = recentlyActive
-	}
-
-	if err := json.NewEncoder(w).Encode(readings); err != nil {
-		http.Error(w, "JSON error", http.StatusInternalServerError)
-	}
+		return readings
+	},
 }
 
-// -------------------- /api/insideTemp --------------------
-
 func handleInsideTemp(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	dur := getRangeDuration(r)
-	since := time.Now().Add(-dur).Unix()
-
-	rows, err := db.Query(`
-        SELECT dateTime, inTemp
-        FROM archive
-        WHERE dateTime >= ? AND inTemp IS NOT NULL
-        ORDER BY dateTime ASC
-    `, since)
-	if err != nil {
-		log.Println("DB query error (insideTemp):", err)
-		http.Error(w, "DB error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	readings := make([]InsideTemperature, 0, 1024)
-
-	for rows.Next() {
-		var epochSec int64
-		var tempF float64
-
-		if err := rows.Scan(&epochSec, &tempF); err != nil {
-			log.Println("DB scan error (insideTemp):", err)
-			http.Error(w, "DB scan error", http.StatusInternalServerError)
-			return
-		}
-
-		ts := time.Unix(epochSec, 0)
-
-		readings = append(readings, InsideTemperature{
-			Timestamp:   ts,
-			InsideTempF: tempF,
-		})
-	}
-	if err := rows.Err(); err != nil {
-		log.Println("DB rows error (insideTemp):", err)
-		http.Error(w, "DB rows error", http.StatusInternalServerError)
-		return
-	}
-
-	if err := json.NewEncoder(w).Encode(readings); err != nil {
-		http.Error(w, "JSON error", http.StatusInternalServerError)
-		return
-	}
+	serveSeriesSection(w, r, insideTempSection)
 }
 
 // -------------------- /api/insideHumidity --------------------
 
-func handleInsideHumidity(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	dur := getRangeDuration(r)
-	since := time.Now().Add(-dur).Unix()
-
-	rows, err := db.Query(`
-        SELECT dateTime, inHumidity
-        FROM archive
-        WHERE dateTime >= ? AND inHumidity IS NOT NULL
-        ORDER BY dateTime ASC
-   	`, since)
-	if err != nil {
-		log.Println("DB query error (insideHumidity):", err)
-		http.Error(w, "DB error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var readings []InsideHumidityReading
-	for rows.Next() {
-		var epochSec int64
-		var hum float64
-
-		if err := rows.Scan(&epochSec, &hum); err != nil {
-			log.Println("DB scan error (insideHumidity):", err)
-			http.Error(w, "DB scan error", http.StatusInternalServerError)
-			return
+var insideHumiditySection = &seriesSection{
+	Name:    "insideHumidity",
+	Cols:    seriesColumns("inHumidity"),
+	NotNull: true,
+	Build: func(series seriesResult) interface{} {
+		readings := make([]InsideHumidityReading, 0, len(series.Samples))
+		for _, s := range series.Samples {
+			if !s.Values[0].Valid {
+				continue
+			}
+			readings = append(readings, InsideHumidityReading{
+				Timestamp:      time.Unix(s.Epoch, 0),
+				InsideHumidity: s.Values[0].Float64,
+			})
 		}
+		return readings
+	},
+}
 
-		ts := time.Unix(epochSec, 0)
-
-		readings = append(readings, InsideHumidityReading{
-			Timestamp:      ts,
-			InsideHumidity: hum,
-		})
-	}
-
-	if err := rows.Err(); err != nil {
-		log.Println("DB rows error (insideHumidity):", err)
-		http.Error(w, "DB rows error", http.StatusInternalServerError)
-		return
-	}
-
-	_ = json.NewEncoder(w).Encode(readings)
+func handleInsideHumidity(w http.ResponseWriter, r *http.Request) {
+	serveSeriesSection(w, r, insideHumiditySection)
 }
 
 // -------------------- /api/noaa/monthly --------------------
 
 func handleNOAAMonthly(w http.ResponseWriter, r *http.Request) {
-	// Require admin role for NOAA reports
-	if !isAdmin(r) {
-		http.Error(w, "Forbidden: NOAA reports require admin access", http.StatusForbidden)
+	units, apiErr := unitsFromRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
 		return
 	}
 
@@ -1031,7 +598,7 @@ func handleNOAAMonthly(w http.ResponseWriter, r *http.Request) {
 			month = v
 		}
 	}
-	content, err := GetOrGenerateMonthly(db, NOAAMonthlyParams{Year: year, Month: month}, force)
+	content, err := GetOrGenerateMonthly(archive, NOAAMonthlyParams{Year: year, Month: month, Units: units}, force)
 	if err != nil {
 		log.Println("NOAA monthly error:", err)
 		http.Error(w, "Failed to generate summary", http.StatusInternalServerError)
@@ -1043,9 +610,9 @@ func handleNOAAMonthly(w http.ResponseWriter, r *http.Request) {
 // -------------------- /api/noaa/yearly --------------------
 
 func handleNOAAYearly(w http.ResponseWriter, r *http.Request) {
-	// Require admin role for NOAA reports
-	if !isAdmin(r) {
-		http.Error(w, "Forbidden: NOAA reports require admin access", http.StatusForbidden)
+	units, apiErr := unitsFromRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
 		return
 	}
 
@@ -1059,45 +626,158 @@ func handleNOAAYearly(w http.ResponseWriter, r *http.Request) {
 			year = v
 		}
 	}
-	content, err := GetOrGenerateYearly(db, NOAAYearlyParams{Year: year}, force)
+	content, err := GetOrGenerateYearly(archive, NOAAYearlyParams{Year: year, Units: units}, force)
 	if err != nil {
 		log.Println("NOAA yearly error:", err)
 		http.Error(w, "Failed to generate summary", http.StatusInternalServerError)
 		return
 	}
-	_, _ = w.Write([]byte(content))
+	_, _ = w.Write([]byte(content))
+}
+
+// -------------------- /api/statistics --------------------
+
+// statisticsColumns are the archive columns the statistics panel summarises
+var statisticsColumns = seriesColumns("rain", "rainRate", "lightning_strike_count", "lightning_distance",
+	"outTemp", "dewpoint", "outHumidity", "barometer",
+	"heatindex", "windchill", "windSpeed", "windGust", "windDir",
+	"inTemp", "inHumidity")
+
+func handleStatistics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tr, apiErr := parseTimeRange(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	units, apiErr := unitsFromRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	stats, err := loadStatistics(r.Context(), tr, units)
+	if err != nil {
+		log.Println("DB query error (statistics):", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	stats.Normals = statisticsNormals(r.Context(), units)
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		http.Error(w, "JSON error", http.StatusInternalServerError)
+	}
 }
 
-// -------------------- /api/statistics --------------------
+// statisticsSummaries are the daily summary tables covering statisticsColumns
+var statisticsSummaries = []string{"rain", "rainRate", "lightning_strike_count", "lightning_distance",
+	"outTemp", "dewpoint", "outHumidity", "barometer",
+	"heatindex", "windchill", "windSpeed", "windGust", "wind",
+	"inTemp", "inHumidity"}
 
-func handleStatistics(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// loadStatistics summarises tr, and today since station-local midnight whatever tr
+// is. Whole days of tr before today come from WeeWX's daily summaries when it has
+// them; the partial days at either end, and today, are scanned.
+func loadStatistics(ctx context.Context, tr TimeRange, units UnitSystem) (StatisticsData, error) {
+	add, addToday, addDay, result := newStatistics(units)
+	today := TimeRange{Start: station.Today(), End: station.Now(), Open: true}
+	if err := scanSamples(ctx, "statistics", statisticsColumns, today, "", addToday); err != nil {
+		return StatisticsData{}, err
+	}
+	scan := func(tr TimeRange) error {
+		return scanSamples(ctx, "statistics", statisticsColumns, tr, "", add)
+	}
 
-	dur := getRangeDuration(r)
-	since := time.Now().Add(-dur).Unix()
+	// Summary days run from just after one midnight to the next, so whole days are
+	// (first, last] with start <= first and last before both the range end and today
+	start, end := tr.Bounds()
+	first := station.StartOfDay(tr.Start)
+	if first.Unix() < start {
+		first = station.NextDay(first)
+	}
+	limit := min(end, station.Today().Unix())
+	last := first
+	for next := station.NextDay(last); next.Unix() < limit; next = station.NextDay(next) {
+		last = next
+	}
+	if !last.After(first) {
+		err := scan(tr)
+		return result(), err
+	}
 
-	// Get local midnight for "today" calculations
-	now := time.Now()
-	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-	midnightUnix := midnight.Unix()
-
-	// Single query to fetch all necessary data
-	rows, err := db.Query(`
-		SELECT dateTime, rain, rainRate, lightning_strike_count, lightning_distance,
-		       outTemp, dewpoint, outHumidity, barometer,
-		       heatindex, windchill, windSpeed, windGust, windDir,
-		       inTemp, inHumidity
-		FROM archive
-		WHERE dateTime >= ?
-		ORDER BY dateTime ASC
-	`, since)
+	summaries, ok, err := loadDaySummaries(ctx, archive, "statistics", statisticsSummaries, first, last)
 	if err != nil {
-		log.Println("DB query error (statistics):", err)
-		http.Error(w, "DB error", http.StatusInternalServerError)
-		return
+		return StatisticsData{}, err
+	}
+	if !ok {
+		err := scan(tr)
+		return result(), err
+	}
+
+	// The gust's direction is the wind direction of its record, which the summaries
+	// don't have; look it up for the day with the range's highest gust
+	var gustDay, gustTime int64
+	var gustMax float64
+	for day := first; day.Before(last); day = station.NextDay(day) {
+		s, found := summaries[day.Unix()]
+		if gust := s.Obs["windGust"]; found && statisticsSummaryUsable(s) && gust.Max.Float64 > gustMax {
+			gustDay, gustTime, gustMax = day.Unix(), gust.MaxTime, gust.Max.Float64
+		}
+	}
+	var gustDir sql.NullFloat64
+	if gustDay != 0 {
+		_, vals, err := archive.Latest(ctx, "statistics", []string{"windDir"}, gustTime, gustTime)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return StatisticsData{}, err
+		}
+		if err == nil {
+			gustDir = vals[0]
+		}
+	}
+
+	if err := scan(TimeRange{Start: tr.Start, End: first.Add(time.Second)}); err != nil {
+		return StatisticsData{}, err
+	}
+	for day := first; day.Before(last); day = station.NextDay(day) {
+		s, found := summaries[day.Unix()]
+		switch {
+		case !found:
+			// No records that day
+		case !statisticsSummaryUsable(s):
+			if err := scan(TimeRange{Start: day.Add(time.Second), End: station.NextDay(day).Add(time.Second)}); err != nil {
+				return StatisticsData{}, err
+			}
+		case s.Start == gustDay:
+			addDay(s, gustDir)
+		default:
+			addDay(s, sql.NullFloat64{})
+		}
 	}
-	defer rows.Close()
+	if err := scan(TimeRange{Start: last.Add(time.Second), End: tr.End, Open: tr.Open}); err != nil {
+		return StatisticsData{}, err
+	}
+	return result(), nil
+}
 
+// statisticsSummaryUsable reports whether a day's summaries give the same figures as
+// its records. Feels-like picks heat index, wind chill or temperature per record, so
+// the day must have used one of them throughout; lightning distance ignores zeros,
+// which a summary minimum can't.
+func statisticsSummaryUsable(s DaySummary) bool {
+	temp, heat, chill := s.Obs["outTemp"].Count, s.Obs["heatindex"].Count, s.Obs["windchill"].Count
+	feels := heat == temp || (heat == 0 && (chill == temp || chill == 0))
+	distance := s.Obs["lightning_distance"]
+	zeros := distance.Min.Valid && distance.Min.Float64 <= 0 && distance.Max.Float64 > 0
+	return feels && !zeros
+}
+
+// newStatistics returns add, which accumulates one archive row of statisticsColumns
+// in the range (rows in ascending time order), addToday, which does the same for a
+// row since station-local midnight, addDay, which accumulates a day of the range from
+// its daily summaries along with the direction of its highest gust, and result, which
+// summarises both
+func newStatistics(units UnitSystem) (add, addToday func(sample), addDay func(DaySummary, sql.NullFloat64), result func() StatisticsData) {
 	// Accumulators for range
 	var rainRangeTotal float64
 	var rainMidnightTotal float64
@@ -1105,20 +785,22 @@ func handleStatistics(w http.ResponseWriter, r *http.Request) {
 	var strikeMidnightTotal int
 
 	// Lightning distance (closest/minimum)
-	var lightningDistMid float64 = 999   // closest today (min value)
-	var lightningDistRange float64 = 999 // closest in range (min value)
+	lightningDistMid := math.Inf(1)   // closest today (min value)
+	lightningDistRange := math.Inf(1) // closest in range (min value)
 
-	// Temperature metrics
-	var tHiMid, fHiMid, dHiMid, hHiMid, bHiMid float64 = -999, -999, -999, -999, -999
-	var tLoMid, fLoMid, dLoMid, hLoMid, bLoMid float64 = 999, 999, 999, 999, 999
-	var tHiRange, fHiRange, dHiRange, hHiRange, bHiRange float64 = -999, -999, -999, -999, -999
-	var tLoRange, fLoRange, dLoRange, hLoRange, bLoRange float64 = 999, 999, 999, 999, 999
+	// Hi/lo metrics. These are in archive source units, where a metric barometer reads
+	// above 1000, so highs start at -Inf and lows at +Inf rather than at a sentinel.
+	unsetHi, unsetLo := math.Inf(-1), math.Inf(1)
+	tHiMid, fHiMid, dHiMid, hHiMid, bHiMid := unsetHi, unsetHi, unsetHi, unsetHi, unsetHi
+	tLoMid, fLoMid, dLoMid, hLoMid, bLoMid := unsetLo, unsetLo, unsetLo, unsetLo, unsetLo
+	tHiRange, fHiRange, dHiRange, hHiRange, bHiRange := unsetHi, unsetHi, unsetHi, unsetHi, unsetHi
+	tLoRange, fLoRange, dLoRange, hLoRange, bLoRange := unsetLo, unsetLo, unsetLo, unsetLo, unsetLo
 
 	// Inside temp/humidity
-	var inTHiMid, inHHiMid float64 = -999, -999
-	var inTLoMid, inHLoMid float64 = 999, 999
-	var inTHiRange, inHHiRange float64 = -999, -999
-	var inTLoRange, inHLoRange float64 = 999, 999
+	inTHiMid, inHHiMid := unsetHi, unsetHi
+	inTLoMid, inHLoMid := unsetLo, unsetLo
+	inTHiRange, inHHiRange := unsetHi, unsetHi
+	inTLoRange, inHLoRange := unsetLo, unsetLo
 
 	// Wind metrics
 	var windSumRange, windSumMid float64
@@ -1131,198 +813,124 @@ func handleStatistics(w http.ResponseWriter, r *http.Request) {
 	// Rain rate max
 	var rrMid, rrRange float64
 
-	for rows.Next() {
-		var epochSec int64
-		var rain, rainRate sql.NullFloat64
-		var strikes sql.NullInt64
-		var lightningDist sql.NullFloat64
-		var outTemp, dewpoint, outHumidity, barometer sql.NullFloat64
-		var heatindex, windchill sql.NullFloat64
-		var windSpeed, windGust, windDir sql.NullFloat64
-		var inTemp, inHumidity sql.NullFloat64
-
-		if err := rows.Scan(&epochSec, &rain, &rainRate, &strikes, &lightningDist,
-			&outTemp, &dewpoint, &outHumidity, &barometer,
-			&heatindex, &windchill, &windSpeed, &windGust, &windDir,
-			&inTemp, &inHumidity); err != nil {
-			log.Println("DB scan error (statistics):", err)
-			http.Error(w, "DB scan error", http.StatusInternalServerError)
-			return
+	// accumulate folds one archive row into the range figures when inRange and into
+	// today's when today
+	accumulate := func(s sample, inRange, today bool) {
+		rain, rainRate, strikes, lightningDist := s.Values[0], s.Values[1], s.Values[2], s.Values[3]
+		outTemp, dewpoint, outHumidity, barometer := s.Values[4], s.Values[5], s.Values[6], s.Values[7]
+		heatindex, windchill := s.Values[8], s.Values[9]
+		windSpeed, windGust, windDir := s.Values[10], s.Values[11], s.Values[12]
+		inTemp, inHumidity := s.Values[13], s.Values[14]
+
+		// hiLo widens the range and today's high and low with v
+		hiLo := func(v float64, hiRange, loRange, hiMid, loMid *float64) {
+			if inRange {
+				*hiRange = max(*hiRange, v)
+				*loRange = min(*loRange, v)
+			}
+			if today {
+				*hiMid = max(*hiMid, v)
+				*loMid = min(*loMid, v)
+			}
 		}
 
-		isMidnight := epochSec >= midnightUnix
-
 		// Rain accumulation
 		if rain.Valid {
-			rainRangeTotal += rain.Float64
-			if isMidnight {
+			if inRange {
+				rainRangeTotal += rain.Float64
+			}
+			if today {
 				rainMidnightTotal += rain.Float64
 			}
 		}
 
 		// Lightning strikes
 		if strikes.Valid {
-			strikeRangeTotal += int(strikes.Int64)
-			if isMidnight {
-				strikeMidnightTotal += int(strikes.Int64)
+			if inRange {
+				strikeRangeTotal += int(strikes.Float64)
+			}
+			if today {
+				strikeMidnightTotal += int(strikes.Float64)
 			}
 		}
 
 		// Lightning distance (track minimum/closest)
 		if lightningDist.Valid && lightningDist.Float64 > 0 {
-			if lightningDist.Float64 < lightningDistRange {
+			if inRange && lightningDist.Float64 < lightningDistRange {
 				lightningDistRange = lightningDist.Float64
 			}
-			if isMidnight && lightningDist.Float64 < lightningDistMid {
+			if today && lightningDist.Float64 < lightningDistMid {
 				lightningDistMid = lightningDist.Float64
 			}
 		}
 
 		// Rain rate max
 		if rainRate.Valid {
-			if rainRate.Float64 > rrRange {
+			if inRange && rainRate.Float64 > rrRange {
 				rrRange = rainRate.Float64
 			}
-			if isMidnight && rainRate.Float64 > rrMid {
+			if today && rainRate.Float64 > rrMid {
 				rrMid = rainRate.Float64
 			}
 		}
 
 		// Temperature hi/lo
 		if outTemp.Valid {
-			if outTemp.Float64 > tHiRange {
-				tHiRange = outTemp.Float64
-			}
-			if outTemp.Float64 < tLoRange {
-				tLoRange = outTemp.Float64
-			}
-			if isMidnight {
-				if outTemp.Float64 > tHiMid {
-					tHiMid = outTemp.Float64
-				}
-				if outTemp.Float64 < tLoMid {
-					tLoMid = outTemp.Float64
-				}
-			}
+			hiLo(outTemp.Float64, &tHiRange, &tLoRange, &tHiMid, &tLoMid)
 		}
 
 		// Feels-like (prefer heatindex, fallback to windchill, then outTemp)
-		var feels float64
 		if heatindex.Valid {
-			feels = heatindex.Float64
+			hiLo(heatindex.Float64, &fHiRange, &fLoRange, &fHiMid, &fLoMid)
 		} else if windchill.Valid {
-			feels = windchill.Float64
+			hiLo(windchill.Float64, &fHiRange, &fLoRange, &fHiMid, &fLoMid)
 		} else if outTemp.Valid {
-			feels = outTemp.Float64
-		} else {
-			feels = -999
-		}
-
-		if feels != -999 {
-			if feels > fHiRange {
-				fHiRange = feels
-			}
-			if feels < fLoRange {
-				fLoRange = feels
-			}
-			if isMidnight {
-				if feels > fHiMid {
-					fHiMid = feels
-				}
-				if feels < fLoMid {
-					fLoMid = feels
-				}
-			}
+			hiLo(outTemp.Float64, &fHiRange, &fLoRange, &fHiMid, &fLoMid)
 		}
 
-		// Dewpoint hi/lo
+		// Dewpoint, humidity and barometer hi/lo
 		if dewpoint.Valid {
-			if dewpoint.Float64 > dHiRange {
-				dHiRange = dewpoint.Float64
-			}
-			if dewpoint.Float64 < dLoRange {
-				dLoRange = dewpoint.Float64
-			}
-			if isMidnight {
-				if dewpoint.Float64 > dHiMid {
-					dHiMid = dewpoint.Float64
-				}
-				if dewpoint.Float64 < dLoMid {
-					dLoMid = dewpoint.Float64
-				}
-			}
+			hiLo(dewpoint.Float64, &dHiRange, &dLoRange, &dHiMid, &dLoMid)
 		}
-
-		// Humidity hi/lo
 		if outHumidity.Valid {
-			if outHumidity.Float64 > hHiRange {
-				hHiRange = outHumidity.Float64
-			}
-			if outHumidity.Float64 < hLoRange {
-				hLoRange = outHumidity.Float64
-			}
-			if isMidnight {
-				if outHumidity.Float64 > hHiMid {
-					hHiMid = outHumidity.Float64
-				}
-				if outHumidity.Float64 < hLoMid {
-					hLoMid = outHumidity.Float64
-				}
-			}
+			hiLo(outHumidity.Float64, &hHiRange, &hLoRange, &hHiMid, &hLoMid)
 		}
-
-		// Barometer hi/lo
 		if barometer.Valid {
-			if barometer.Float64 > bHiRange {
-				bHiRange = barometer.Float64
-			}
-			if barometer.Float64 < bLoRange {
-				bLoRange = barometer.Float64
-			}
-			if isMidnight {
-				if barometer.Float64 > bHiMid {
-					bHiMid = barometer.Float64
-				}
-				if barometer.Float64 < bLoMid {
-					bLoMid = barometer.Float64
-				}
-			}
+			hiLo(barometer.Float64, &bHiRange, &bLoRange, &bHiMid, &bLoMid)
 		}
 
 		// Wind statistics
 		if windSpeed.Valid {
 			speed := windSpeed.Float64
-			windSumRange += speed
-			windCountRange++
-			windSqSumRange += speed * speed
-
+			var ux, uy float64
 			if windDir.Valid {
 				rad := (windDir.Float64 * math.Pi) / 180.0
-				vecUxRange += speed * math.Cos(rad)
-				vecUyRange += speed * math.Sin(rad)
+				ux, uy = speed*math.Cos(rad), speed*math.Sin(rad)
 			}
-
-			if isMidnight {
+			if inRange {
+				windSumRange += speed
+				windCountRange++
+				windSqSumRange += speed * speed
+				vecUxRange += ux
+				vecUyRange += uy
+			}
+			if today {
 				windSumMid += speed
 				windCountMid++
 				windSqSumMid += speed * speed
-
-				if windDir.Valid {
-					rad := (windDir.Float64 * math.Pi) / 180.0
-					vecUxMid += speed * math.Cos(rad)
-					vecUyMid += speed * math.Sin(rad)
-				}
+				vecUxMid += ux
+				vecUyMid += uy
 			}
 		}
 
-		if windGust.Valid && windGust.Float64 > gustMaxRange {
+		if inRange && windGust.Valid && windGust.Float64 > gustMaxRange {
 			gustMaxRange = windGust.Float64
 			if windDir.Valid {
 				maxWindDirRange = windDir
 			}
 		}
 
-		if isMidnight && windGust.Valid && windGust.Float64 > gustMaxMid {
+		if today && windGust.Valid && windGust.Float64 > gustMaxMid {
 			gustMaxMid = windGust.Float64
 			if windDir.Valid {
 				maxWindDirMid = windDir
@@ -1331,180 +939,217 @@ func handleStatistics(w http.ResponseWriter, r *http.Request) {
 
 		// Inside temp/humidity
 		if inTemp.Valid {
-			if inTemp.Float64 > inTHiRange {
-				inTHiRange = inTemp.Float64
-			}
-			if inTemp.Float64 < inTLoRange {
-				inTLoRange = inTemp.Float64
-			}
-			if isMidnight {
-				if inTemp.Float64 > inTHiMid {
-					inTHiMid = inTemp.Float64
-				}
-				if inTemp.Float64 < inTLoMid {
-					inTLoMid = inTemp.Float64
-				}
-			}
+			hiLo(inTemp.Float64, &inTHiRange, &inTLoRange, &inTHiMid, &inTLoMid)
 		}
-
 		if inHumidity.Valid {
-			if inHumidity.Float64 > inHHiRange {
-				inHHiRange = inHumidity.Float64
+			hiLo(inHumidity.Float64, &inHHiRange, &inHLoRange, &inHHiMid, &inHLoMid)
+		}
+	}
+	add = func(s sample) { accumulate(s, true, false) }
+	addToday = func(s sample) { accumulate(s, false, true) }
+
+	addDay = func(d DaySummary, gustDir sql.NullFloat64) {
+		o := d.Obs
+		hiLo := func(s ObsSummary, hi, lo *float64) {
+			if s.Count == 0 {
+				return
 			}
-			if inHumidity.Float64 < inHLoRange {
-				inHLoRange = inHumidity.Float64
+			if s.Max.Float64 > *hi {
+				*hi = s.Max.Float64
 			}
-			if isMidnight {
-				if inHumidity.Float64 > inHHiMid {
-					inHHiMid = inHumidity.Float64
-				}
-				if inHumidity.Float64 < inHLoMid {
-					inHLoMid = inHumidity.Float64
-				}
+			if s.Min.Float64 < *lo {
+				*lo = s.Min.Float64
 			}
 		}
-	}
 
-	if err := rows.Err(); err != nil {
-		log.Println("DB rows error (statistics):", err)
-		http.Error(w, "DB rows error", http.StatusInternalServerError)
-		return
+		rainRangeTotal += o["rain"].Sum
+		strikeRangeTotal += int(o["lightning_strike_count"].Sum)
+		if dist := o["lightning_distance"]; dist.Min.Valid && dist.Min.Float64 > 0 && dist.Min.Float64 < lightningDistRange {
+			lightningDistRange = dist.Min.Float64
+		}
+		if rate := o["rainRate"]; rate.Max.Valid && rate.Max.Float64 > rrRange {
+			rrRange = rate.Max.Float64
+		}
+
+		hiLo(o["outTemp"], &tHiRange, &tLoRange)
+		feels := o["outTemp"]
+		if o["heatindex"].Count > 0 {
+			feels = o["heatindex"]
+		} else if o["windchill"].Count > 0 {
+			feels = o["windchill"]
+		}
+		hiLo(feels, &fHiRange, &fLoRange)
+		hiLo(o["dewpoint"], &dHiRange, &dLoRange)
+		hiLo(o["outHumidity"], &hHiRange, &hLoRange)
+		hiLo(o["barometer"], &bHiRange, &bLoRange)
+		hiLo(o["inTemp"], &inTHiRange, &inTLoRange)
+		hiLo(o["inHumidity"], &inHHiRange, &inHLoRange)
+
+		// WeeWX's vector sums are weighted by interval and measured from east; scale them
+		// back to per-record sums of speed·cos(dir) and speed·sin(dir)
+		speed, wind := o["windSpeed"], o["wind"]
+		windSumRange += speed.Sum
+		windCountRange += int(speed.Count)
+		windSqSumRange += wind.SquareSum
+		if wind.SumTime > 0 {
+			scale := float64(wind.Count) / wind.SumTime
+			vecUxRange += wind.YSum * scale
+			vecUyRange += wind.XSum * scale
+		}
+		if gust := o["windGust"]; gust.Max.Valid && gust.Max.Float64 > gustMaxRange {
+			gustMaxRange = gust.Max.Float64
+			if gustDir.Valid {
+				maxWindDirRange = gustDir
+			}
+		}
 	}
 
-	// Format helper functions
-	fmt0 := func(v float64) string { return fmt.Sprintf("%d", int(math.Round(v))) }
-	fmt1 := func(v float64) string { return fmt.Sprintf("%.1f", v) }
-	fmt2 := func(v float64) string { return fmt.Sprintf("%.2f", v) }
-	hiLo := func(hi, lo float64, formatter func(float64) string) string {
-		if hi == -999 || lo == 999 {
-			return "--"
+	result = func() StatisticsData {
+		// Format helper functions. All accumulators above are in archive source units;
+		// values are converted to the requested unit system only when formatted.
+		conv := units.Convert
+		fmtQ := func(q Quantity) func(float64) string {
+			prec := units.Precision(q)
+			return func(v float64) string { return strconv.FormatFloat(conv(q, v), 'f', prec, 64) }
+		}
+		fmt0 := func(v float64) string { return fmt.Sprintf("%d", int(math.Round(v))) }
+		fmt1 := func(v float64) string { return fmt.Sprintf("%.1f", v) }
+		fmtTemp := func(v float64) string { return fmt1(conv(qTemp, v)) }
+		fmtSpeed := func(v float64) string { return fmt0(conv(qSpeed, v)) }
+		hiLo := func(hi, lo float64, formatter func(float64) string) string {
+			if math.IsInf(hi, -1) || math.IsInf(lo, 1) {
+				return "--"
+			}
+			return formatter(hi) + " / " + formatter(lo)
 		}
-		return formatter(hi) + " / " + formatter(lo)
-	}
 
-	// Wind calculations
-	avgMid := "--"
-	avgRange := "--"
-	if windCountMid > 0 {
-		avgMid = fmt0(windSumMid / float64(windCountMid))
-	}
-	if windCountRange > 0 {
-		avgRange = fmt0(windSumRange / float64(windCountRange))
-	}
+		// Wind calculations
+		avgMid := "--"
+		avgRange := "--"
+		if windCountMid > 0 {
+			avgMid = fmtSpeed(windSumMid / float64(windCountMid))
+		}
+		if windCountRange > 0 {
+			avgRange = fmtSpeed(windSumRange / float64(windCountRange))
+		}
 
-	rmsMid := "--"
-	rmsRange := "--"
-	if windCountMid > 0 {
-		rmsMid = fmt0(math.Sqrt(windSqSumMid / float64(windCountMid)))
-	}
-	if windCountRange > 0 {
-		rmsRange = fmt0(math.Sqrt(windSqSumRange / float64(windCountRange)))
-	}
+		rmsMid := "--"
+		rmsRange := "--"
+		if windCountMid > 0 {
+			rmsMid = fmtSpeed(math.Sqrt(windSqSumMid / float64(windCountMid)))
+		}
+		if windCountRange > 0 {
+			rmsRange = fmtSpeed(math.Sqrt(windSqSumRange / float64(windCountRange)))
+		}
 
-	vecMagMid := "--"
-	vecMagRange := "--"
-	vecDirMid := "--"
-	vecDirRange := "--"
+		vecMagMid := "--"
+		vecMagRange := "--"
+		vecDirMid := "--"
+		vecDirRange := "--"
 
-	if windCountMid > 0 && (vecUxMid != 0 || vecUyMid != 0) {
-		mag := math.Sqrt(vecUxMid*vecUxMid+vecUyMid*vecUyMid) / float64(windCountMid)
-		vecMagMid = fmt0(mag)
-		dir := math.Atan2(vecUyMid, vecUxMid) * 180.0 / math.Pi
-		if dir < 0 {
-			dir += 360
+		if windCountMid > 0 && (vecUxMid != 0 || vecUyMid != 0) {
+			mag := math.Sqrt(vecUxMid*vecUxMid+vecUyMid*vecUyMid) / float64(windCountMid)
+			vecMagMid = fmtSpeed(mag)
+			dir := math.Atan2(vecUyMid, vecUxMid) * 180.0 / math.Pi
+			if dir < 0 {
+				dir += 360
+			}
+			vecDirMid = fmt0(dir)
 		}
-		vecDirMid = fmt0(dir)
-	}
 
-	if windCountRange > 0 && (vecUxRange != 0 || vecUyRange != 0) {
-		mag := math.Sqrt(vecUxRange*vecUxRange+vecUyRange*vecUyRange) / float64(windCountRange)
-		vecMagRange = fmt0(mag)
-		dir := math.Atan2(vecUyRange, vecUxRange) * 180.0 / math.Pi
-		if dir < 0 {
-			dir += 360
+		if windCountRange > 0 && (vecUxRange != 0 || vecUyRange != 0) {
+			mag := math.Sqrt(vecUxRange*vecUxRange+vecUyRange*vecUyRange) / float64(windCountRange)
+			vecMagRange = fmtSpeed(mag)
+			dir := math.Atan2(vecUyRange, vecUxRange) * 180.0 / math.Pi
+			if dir < 0 {
+				dir += 360
+			}
+			vecDirRange = fmt0(dir)
 		}
-		vecDirRange = fmt0(dir)
-	}
 
-	maxWindMid := "--"
-	maxWindRange := "--"
-	if gustMaxMid > 0 {
-		maxWindMid = fmt0(gustMaxMid)
-		if maxWindDirMid.Valid {
-			maxWindMid += " • " + fmt0(maxWindDirMid.Float64)
+		maxWindMid := "--"
+		maxWindRange := "--"
+		if gustMaxMid > 0 {
+			maxWindMid = fmtSpeed(gustMaxMid)
+			if maxWindDirMid.Valid {
+				maxWindMid += " • " + fmt0(maxWindDirMid.Float64)
+			}
 		}
-	}
-	if gustMaxRange > 0 {
-		maxWindRange = fmt0(gustMaxRange)
-		if maxWindDirRange.Valid {
-			maxWindRange += " • " + fmt0(maxWindDirRange.Float64)
+		if gustMaxRange > 0 {
+			maxWindRange = fmtSpeed(gustMaxRange)
+			if maxWindDirRange.Valid {
+				maxWindRange += " • " + fmt0(maxWindDirRange.Float64)
+			}
 		}
-	}
 
-	// Build response
-	stats := StatisticsData{
-		RainToday: rainMidnightTotal,
-		RainRange: rainRangeTotal,
+		fmtDist := fmtQ(qDistance)
 
-		StrikesToday: strikeMidnightTotal,
-		StrikesRange: strikeRangeTotal,
+		// Build response
+		stats := StatisticsData{
+			RainToday: conv(qRain, rainMidnightTotal),
+			RainRange: conv(qRain, rainRangeTotal),
 
-		TempToday: hiLo(tHiMid, tLoMid, fmt1),
-		TempRange: hiLo(tHiRange, tLoRange, fmt1),
+			StrikesToday: strikeMidnightTotal,
+			StrikesRange: strikeRangeTotal,
 
-		FeelsToday: hiLo(fHiMid, fLoMid, fmt1),
-		FeelsRange: hiLo(fHiRange, fLoRange, fmt1),
+			TempToday: hiLo(tHiMid, tLoMid, fmtTemp),
+			TempRange: hiLo(tHiRange, tLoRange, fmtTemp),
 
-		DewToday: hiLo(dHiMid, dLoMid, fmt1),
-		DewRange: hiLo(dHiRange, dLoRange, fmt1),
+			FeelsToday: hiLo(fHiMid, fLoMid, fmtTemp),
+			FeelsRange: hiLo(fHiRange, fLoRange, fmtTemp),
 
-		HumidityToday: hiLo(hHiMid, hLoMid, fmt0),
-		HumidityRange: hiLo(hHiRange, hLoRange, fmt0),
+			DewToday: hiLo(dHiMid, dLoMid, fmtTemp),
+			DewRange: hiLo(dHiRange, dLoRange, fmtTemp),
 
-		BarometerToday: hiLo(bHiMid, bLoMid, fmt2),
-		BarometerRange: hiLo(bHiRange, bLoRange, fmt2),
+			HumidityToday: hiLo(hHiMid, hLoMid, fmt0),
+			HumidityRange: hiLo(hHiRange, hLoRange, fmt0),
 
-		WindAvgToday: avgMid,
-		WindAvgRange: avgRange,
+			BarometerToday: hiLo(bHiMid, bLoMid, fmtQ(qPressure)),
+			BarometerRange: hiLo(bHiRange, bLoRange, fmtQ(qPressure)),
 
-		WindMaxToday: maxWindMid,
-		WindMaxRange: maxWindRange,
+			WindAvgToday: avgMid,
+			WindAvgRange: avgRange,
 
-		WindRmsToday: rmsMid,
-		WindRmsRange: rmsRange,
+			WindMaxToday: maxWindMid,
+			WindMaxRange: maxWindRange,
 
-		WindVectorToday: vecMagMid,
-		WindVectorRange: vecMagRange,
+			WindRmsToday: rmsMid,
+			WindRmsRange: rmsRange,
 
-		WindVectorDirToday: vecDirMid,
-		WindVectorDirRange: vecDirRange,
+			WindVectorToday: vecMagMid,
+			WindVectorRange: vecMagRange,
 
-		RainRateToday: fmt2(rrMid),
-		RainRateRange: fmt2(rrRange),
+			WindVectorDirToday: vecDirMid,
+			WindVectorDirRange: vecDirRange,
 
-		LightningDistToday: func() string {
-			if lightningDistMid < 999 {
-				return fmt.Sprintf("%.1f", lightningDistMid)
-			}
-			return "--"
-		}(),
-		LightningDistRange: func() string {
-			if lightningDistRange < 999 {
-				return fmt.Sprintf("%.1f", lightningDistRange)
-			}
-			return "--"
-		}(),
+			RainRateToday: fmtQ(qRainRate)(rrMid),
+			RainRateRange: fmtQ(qRainRate)(rrRange),
 
-		InsideTempToday: hiLo(inTHiMid, inTLoMid, fmt1),
-		InsideTempRange: hiLo(inTHiRange, inTLoRange, fmt1),
+			LightningDistToday: func() string {
+				if !math.IsInf(lightningDistMid, 1) {
+					return fmtDist(lightningDistMid)
+				}
+				return "--"
+			}(),
+			LightningDistRange: func() string {
+				if !math.IsInf(lightningDistRange, 1) {
+					return fmtDist(lightningDistRange)
+				}
+				return "--"
+			}(),
 
-		InsideHumToday: hiLo(inHHiMid, inHLoMid, fmt0),
-		InsideHumRange: hiLo(inHHiRange, inHLoRange, fmt0),
-	}
+			InsideTempToday: hiLo(inTHiMid, inTLoMid, fmtTemp),
+			InsideTempRange: hiLo(inTHiRange, inTLoRange, fmtTemp),
 
-	if err := json.NewEncoder(w).Encode(stats); err != nil {
-		http.Error(w, "JSON error", http.StatusInternalServerError)
+			InsideHumToday: hiLo(inHHiMid, inHLoMid, fmt0),
+			InsideHumRange: hiLo(inHHiRange, inHLoRange, fmt0),
+
+			Units: units.Labels(),
+		}
+
+		return stats
 	}
+	return add, addToday, addDay, result
 }
 
 // -------------------- /api/celestial --------------------
@@ -1512,31 +1157,16 @@ func handleStatistics(w http.ResponseWriter, r *http.Request) {
 func handleCelestial(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Parse date parameter (defaults to today in local time)
+	// Parse date parameter (defaults to today in station local time)
 	dateStr := r.URL.Query().Get("date")
 
-	// Use Rita Ranch, AZ coordinates (from config or hardcoded)
-	coords := astroglide.Coordinates{
-		Lat: 32.093174,   // Rita Ranch latitude
-		Lon: -110.777557, // Rita Ranch longitude (west is negative)
-	}
-
-	// Load MST/America/Phoenix timezone
-	loc, err := time.LoadLocation("America/Phoenix")
-	if err != nil {
-		log.Println("Failed to load America/Phoenix timezone:", err)
-		http.Error(w, "Timezone error", http.StatusInternalServerError)
-		return
-	}
-
 	var date time.Time
 	if dateStr == "" {
 		// Default to today
-		now := time.Now().In(loc)
-		date = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		date = station.Today()
 	} else {
 		// Parse YYYY-MM-DD format
-		parsed, err := time.ParseInLocation("2006-01-02", dateStr, loc)
+		parsed, err := time.ParseInLocation("2006-01-02", dateStr, station.Loc)
 		if err != nil {
 			log.Println("Invalid date parameter:", dateStr, err)
 			http.Error(w, "Invalid date format (use YYYY-MM-DD)", http.StatusBadRequest)
@@ -1545,16 +1175,31 @@ func handleCelestial(w http.ResponseWriter, r *http.Request) {
 		date = parsed
 	}
 
+	celestial, err := celestialFor(date)
+	if err != nil {
+		log.Println("Error computing celestial data:", err)
+		http.Error(w, "Celestial calculation error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(celestial); err != nil {
+		http.Error(w, "JSON error", http.StatusInternalServerError)
+	}
+}
+
+// celestialFor returns the celestial data for a station-local date from the cache,
+// computing and caching it on a miss
+func celestialFor(date time.Time) (CelestialData, error) {
 	// Cache key is date + timezone to support different locales if requested
-	cacheKey := date.Format("2006-01-02") + "|" + loc.String()
+	cacheKey := celestialCacheKey(date)
 	celestialCache.RLock()
 	if ce, ok := celestialCache.m[cacheKey]; ok && time.Now().Before(ce.expiry) {
-		// Serve cached response
-		_ = json.NewEncoder(w).Encode(ce.data)
 		celestialCache.RUnlock()
-		return
+		celestialCacheRequests.Inc("hit")
+		return ce.data, nil
 	}
 	celestialCache.RUnlock()
+	celestialCacheRequests.Inc("miss")
 
 	// Use singleflight to dedupe concurrent compute for the same cacheKey
 	result, err, _ := celestialGroup.Do(cacheKey, func() (interface{}, error) {
@@ -1566,28 +1211,17 @@ func handleCelestial(w http.ResponseWriter, r *http.Request) {
 		}
 		celestialCache.RUnlock()
 
-		return computeCelestialData(coords, date, loc)
+		return computeCelestialData(station.Coordinates(), date, station.Loc)
 	})
-
 	if err != nil {
-		log.Println("Error computing celestial data:", err)
-		http.Error(w, "Celestial calculation error", http.StatusInternalServerError)
-		return
+		return CelestialData{}, err
 	}
 
 	celestial := result.(CelestialData)
 
 	// Cache the result until the next local midnight for the requested date
-	// Compute next midnight explicitly in the request's location to avoid timezone issues.
-	nextMidnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc).Add(24 * time.Hour)
-	expiry := nextMidnight
-	celestialCache.Lock()
-	celestialCache.m[cacheKey] = &cachedCelestial{data: celestial, expiry: expiry}
-	celestialCache.Unlock()
-
-	if err := json.NewEncoder(w).Encode(celestial); err != nil {
-		http.Error(w, "JSON error", http.StatusInternalServerError)
-	}
+	storeCelestial(date, celestial)
+	return celestial, nil
 }
 
 // computeCelestialData performs the actual astroglide calculations
@@ -1781,64 +1415,64 @@ func computeCelestialData(coords astroglide.Coordinates, date time.Time, loc *ti
 	return celestial, nil
 }
 
-// refreshCelestialCacheDaily runs a background goroutine that refreshes today's and tomorrow's
-// celestial cache at 00:05 local time daily to avoid first-request latency.
-func refreshCelestialCacheDaily(stop <-chan struct{}) {
-	// Load timezone once
-	loc, err := time.LoadLocation("America/Phoenix")
-	if err != nil {
-		log.Println("[Celestial Refresh] Failed to load timezone:", err)
-		return
-	}
+// celestialCacheKey builds the cache key for a station-local date
+func celestialCacheKey(date time.Time) string {
+	return date.Format("2006-01-02") + "|" + station.Loc.String()
+}
 
-	// Rita Ranch coordinates (same as handler)
-	coords := astroglide.Coordinates{
-		Lat: 32.093174,
-		Lon: -110.777557,
-	}
+// storeCelestial caches data for date until the following station-local midnight
+func storeCelestial(date time.Time, data CelestialData) {
+	celestialCache.Lock()
+	celestialCache.m[celestialCacheKey(date)] = &cachedCelestial{data: data, expiry: station.NextDay(date)}
+	celestialCache.Unlock()
+}
+
+// refreshCelestialCacheDaily runs a background goroutine that refreshes today's and tomorrow's
+// celestial cache at 00:05 station local time daily to avoid first-request latency.
+// publish, if set, receives today's freshly computed data.
+func refreshCelestialCacheDaily(stop <-chan struct{}, publish func(CelestialData)) {
+	coords := station.Coordinates()
+	loc := station.Loc
 
 	for {
 		// Compute next 00:05 local
-		now := time.Now().In(loc)
+		now := station.Now()
 		nextRun := time.Date(now.Year(), now.Month(), now.Day(), 0, 5, 0, 0, loc)
 		if now.After(nextRun) {
 			// Already past 00:05 today, schedule for tomorrow
-			nextRun = nextRun.Add(24 * time.Hour)
+			nextRun = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 5, 0, 0, loc)
 		}
 
 		waitDuration := time.Until(nextRun)
+		markJobScheduled("celestial_refresh", nextRun)
 		log.Printf("[Celestial Refresh] Next refresh scheduled at %s (in %s)\n", nextRun.Format("2006-01-02 15:04:05 MST"), waitDuration.Round(time.Second))
 
 		select {
 		case <-time.After(waitDuration):
-			// Refresh today and tomorrow
-			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
-			tomorrow := today.Add(24 * time.Hour)
+			// Refresh today and tomorrow (relative to the time we woke up, not when we slept)
+			today := station.Today()
+			tomorrow := station.NextDay(today)
 
 			log.Println("[Celestial Refresh] Refreshing cache for today and tomorrow...")
 
-			// Refresh today
-			if data, err := computeCelestialData(coords, today, loc); err == nil {
-				cacheKey := today.Format("2006-01-02") + "|" + loc.String()
-				expiry := today.Add(24 * time.Hour)
-				celestialCache.Lock()
-				celestialCache.m[cacheKey] = &cachedCelestial{data: data, expiry: expiry}
-				celestialCache.Unlock()
-				log.Printf("[Celestial Refresh] Cached data for %s\n", today.Format("2006-01-02"))
-			} else {
-				log.Printf("[Celestial Refresh] Failed to compute today: %v\n", err)
+			var failed error
+			for _, day := range []time.Time{today, tomorrow} {
+				data, err := computeCelestialData(coords, day, loc)
+				if err != nil {
+					log.Printf("[Celestial Refresh] Failed to compute %s: %v\n", day.Format("2006-01-02"), err)
+					failed = fmt.Errorf("%s: %w", day.Format("2006-01-02"), err)
+					continue
+				}
+				storeCelestial(day, data)
+				log.Printf("[Celestial Refresh] Cached data for %s\n", day.Format("2006-01-02"))
+				if publish != nil && day.Equal(today) {
+					publish(data)
+				}
 			}
-
-			// Refresh tomorrow
-			if data, err := computeCelestialData(coords, tomorrow, loc); err == nil {
-				cacheKey := tomorrow.Format("2006-01-02") + "|" + loc.String()
-				expiry := tomorrow.Add(24 * time.Hour)
-				celestialCache.Lock()
-				celestialCache.m[cacheKey] = &cachedCelestial{data: data, expiry: expiry}
-				celestialCache.Unlock()
-				log.Printf("[Celestial Refresh] Cached data for %s\n", tomorrow.Format("2006-01-02"))
+			if failed != nil {
+				markJobFailed("celestial_refresh", failed)
 			} else {
-				log.Printf("[Celestial Refresh] Failed to compute tomorrow: %v\n", err)
+				markJobOK("celestial_refresh")
 			}
 
 		case <-stop:
@@ -1847,349 +1481,3 @@ func refreshCelestialCacheDaily(stop <-chan struct{}) {
 		}
 	}
 }
-
-// -------------------- /api/csv/daily --------------------
-
-func handleCSVDaily(w http.ResponseWriter, r *http.Request) {
-	// Get date parameters
-	yearStr := r.URL.Query().Get("year")
-	monthStr := r.URL.Query().Get("month")
-	dayStr := r.URL.Query().Get("day")
-	columnsParam := r.URL.Query().Get("columns")
-
-	if yearStr == "" || monthStr == "" || dayStr == "" {
-		http.Error(w, "Missing required parameters: year, month, day", http.StatusBadRequest)
-		return
-	}
-
-	// Parse date
-	dateStr := fmt.Sprintf("%s-%s-%s", yearStr, monthStr, dayStr)
-	targetDate, err := time.Parse("2006-01-02", dateStr)
-	if err != nil {
-		http.Error(w, "Invalid date format", http.StatusBadRequest)
-		return
-	}
-
-	// Get local timezone for midnight calculations
-	loc, err := time.LoadLocation("America/Phoenix")
-	if err != nil {
-		loc = time.Local
-	}
-
-	// Calculate start and end times (midnight to midnight in local time)
-	startOfDay := time.Date(targetDate.Year(), targetDate.Month(), targetDate.Day(), 0, 0, 0, 0, loc)
-	endOfDay := startOfDay.Add(24 * time.Hour)
-
-	startUnix := startOfDay.Unix()
-	endUnix := endOfDay.Unix()
-
-	// Parse columns parameter (default to all columns if not specified)
-	requestedColumns := []string{
-		"dateTime", "outTemp", "dewpoint", "outHumidity", "barometer",
-		"heatindex", "windchill", "windSpeed", "windGust", "windDir",
-		"rainRate", "rain", "lightning_strike_count", "lightning_distance",
-		"inTemp", "inHumidity",
-	}
-
-	if columnsParam != "" {
-		requestedColumns = strings.Split(columnsParam, ",")
-		// Validate that dateTime is always first
-		if len(requestedColumns) == 0 || requestedColumns[0] != "dateTime" {
-			http.Error(w, "Timestamp (dateTime) must be the first column", http.StatusBadRequest)
-			return
-		}
-	}
-
-	// Build SQL query dynamically
-	selectClause := strings.Join(requestedColumns, ", ")
-	query := fmt.Sprintf("SELECT %s FROM archive WHERE dateTime >= ? AND dateTime < ? ORDER BY dateTime ASC", selectClause)
-
-	rows, err := db.Query(query, startUnix, endUnix)
-	if err != nil {
-		log.Println("CSV query error:", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	// Set headers for CSV download
-	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"weather_%s.csv\"", dateStr))
-
-	// Column labels mapping
-	columnLabels := map[string]string{
-		"dateTime":               "Timestamp",
-		"outTemp":                "Temperature_F",
-		"dewpoint":               "Dewpoint_F",
-		"outHumidity":            "Humidity_Pct",
-		"barometer":              "Barometer_inHg",
-		"heatindex":              "HeatIndex_F",
-		"windchill":              "WindChill_F",
-		"windSpeed":              "WindSpeed_mph",
-		"windGust":               "WindGust_mph",
-		"windDir":                "WindDirection_deg",
-		"rainRate":               "RainRate_in_hr",
-		"rain":                   "Rain_in",
-		"lightning_strike_count": "LightningStrikes",
-		"lightning_distance":     "LightningDistance_mi",
-		"inTemp":                 "InsideTemp_F",
-		"inHumidity":             "InsideHumidity_Pct",
-	}
-
-	// Write CSV header
-	headerParts := make([]string, len(requestedColumns))
-	for i, col := range requestedColumns {
-		if label, ok := columnLabels[col]; ok {
-			headerParts[i] = label
-		} else {
-			headerParts[i] = col
-		}
-	}
-	fmt.Fprintf(w, "%s\n", strings.Join(headerParts, ","))
-
-	// Helper to format nullable float
-	formatFloat := func(nf sql.NullFloat64) string {
-		if nf.Valid {
-			return fmt.Sprintf("%.2f", nf.Float64)
-		}
-		return ""
-	}
-
-	formatInt := func(ni sql.NullInt64) string {
-		if ni.Valid {
-			return fmt.Sprintf("%d", ni.Int64)
-		}
-		return ""
-	}
-
-	// Write data rows
-	rowCount := 0
-	for rows.Next() {
-		// Prepare scan destinations based on requested columns
-		scanDest := make([]interface{}, len(requestedColumns))
-		values := make([]string, len(requestedColumns))
-
-		for i, col := range requestedColumns {
-			switch col {
-			case "dateTime":
-				var epochSec int64
-				scanDest[i] = &epochSec
-			case "lightning_strike_count":
-				var val sql.NullInt64
-				scanDest[i] = &val
-			default:
-				var val sql.NullFloat64
-				scanDest[i] = &val
-			}
-		}
-
-		if err := rows.Scan(scanDest...); err != nil {
-			log.Println("CSV scan error:", err)
-			continue
-		}
-
-		// Format values for CSV output
-		for i, col := range requestedColumns {
-			switch col {
-			case "dateTime":
-				epochSec := *scanDest[i].(*int64)
-				ts := time.Unix(epochSec, 0).In(loc)
-				values[i] = ts.Format("2006-01-02 15:04:05")
-			case "lightning_strike_count":
-				values[i] = formatInt(*scanDest[i].(*sql.NullInt64))
-			default:
-				values[i] = formatFloat(*scanDest[i].(*sql.NullFloat64))
-			}
-		}
-
-		fmt.Fprintf(w, "%s\n", strings.Join(values, ","))
-		rowCount++
-	}
-
-	if err := rows.Err(); err != nil {
-		log.Println("CSV rows error:", err)
-	}
-
-	log.Printf("[CSV] Generated daily CSV for %s: %d rows, %d columns\n", dateStr, rowCount, len(requestedColumns))
-}
-
-func handleCSVRange(w http.ResponseWriter, r *http.Request) {
-	// Get date range parameters
-	startYearStr := r.URL.Query().Get("startYear")
-	startMonthStr := r.URL.Query().Get("startMonth")
-	startDayStr := r.URL.Query().Get("startDay")
-	endYearStr := r.URL.Query().Get("endYear")
-	endMonthStr := r.URL.Query().Get("endMonth")
-	endDayStr := r.URL.Query().Get("endDay")
-	columnsParam := r.URL.Query().Get("columns")
-
-	if startYearStr == "" || startMonthStr == "" || startDayStr == "" ||
-		endYearStr == "" || endMonthStr == "" || endDayStr == "" {
-		http.Error(w, "Missing required parameters: startYear, startMonth, startDay, endYear, endMonth, endDay", http.StatusBadRequest)
-		return
-	}
-
-	// Parse start date
-	startDateStr := fmt.Sprintf("%s-%s-%s", startYearStr, startMonthStr, startDayStr)
-	startDate, err := time.Parse("2006-01-02", startDateStr)
-	if err != nil {
-		http.Error(w, "Invalid start date format", http.StatusBadRequest)
-		return
-	}
-
-	// Parse end date
-	endDateStr := fmt.Sprintf("%s-%s-%s", endYearStr, endMonthStr, endDayStr)
-	endDate, err := time.Parse("2006-01-02", endDateStr)
-	if err != nil {
-		http.Error(w, "Invalid end date format", http.StatusBadRequest)
-		return
-	}
-
-	// Validate date range
-	if startDate.After(endDate) {
-		http.Error(w, "Start date must be before or equal to end date", http.StatusBadRequest)
-		return
-	}
-
-	// Get local timezone for midnight calculations
-	loc, err := time.LoadLocation("America/Phoenix")
-	if err != nil {
-		loc = time.Local
-	}
-
-	// Calculate start and end times (midnight to midnight in local time)
-	startOfRange := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, loc)
-	endOfRange := time.Date(endDate.Year(), endDate.Month(), endDate.Day(), 23, 59, 59, 0, loc).Add(time.Second)
-
-	startUnix := startOfRange.Unix()
-	endUnix := endOfRange.Unix()
-
-	// Parse columns parameter (default to all columns if not specified)
-	requestedColumns := []string{
-		"dateTime", "outTemp", "dewpoint", "outHumidity", "barometer",
-		"heatindex", "windchill", "windSpeed", "windGust", "windDir",
-		"rainRate", "rain", "lightning_strike_count", "lightning_distance",
-		"inTemp", "inHumidity",
-	}
-
-	if columnsParam != "" {
-		requestedColumns = strings.Split(columnsParam, ",")
-		// Validate that dateTime is always first
-		if len(requestedColumns) == 0 || requestedColumns[0] != "dateTime" {
-			http.Error(w, "Timestamp (dateTime) must be the first column", http.StatusBadRequest)
-			return
-		}
-	}
-
-	// Build SQL query dynamically
-	selectClause := strings.Join(requestedColumns, ", ")
-	query := fmt.Sprintf("SELECT %s FROM archive WHERE dateTime >= ? AND dateTime < ? ORDER BY dateTime ASC", selectClause)
-
-	rows, err := db.Query(query, startUnix, endUnix)
-	if err != nil {
-		log.Println("CSV range query error:", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	// Set headers for CSV download
-	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"weather_%s_to_%s.csv\"", startDateStr, endDateStr))
-
-	// Column labels mapping
-	columnLabels := map[string]string{
-		"dateTime":               "Timestamp",
-		"outTemp":                "Temperature_F",
-		"dewpoint":               "Dewpoint_F",
-		"outHumidity":            "Humidity_Pct",
-		"barometer":              "Barometer_inHg",
-		"heatindex":              "HeatIndex_F",
-		"windchill":              "WindChill_F",
-		"windSpeed":              "WindSpeed_mph",
-		"windGust":               "WindGust_mph",
-		"windDir":                "WindDirection_deg",
-		"rainRate":               "RainRate_in_hr",
-		"rain":                   "Rain_in",
-		"lightning_strike_count": "LightningStrikes",
-		"lightning_distance":     "LightningDistance_mi",
-		"inTemp":                 "InsideTemp_F",
-		"inHumidity":             "InsideHumidity_Pct",
-	}
-
-	// Write CSV header
-	headerParts := make([]string, len(requestedColumns))
-	for i, col := range requestedColumns {
-		if label, ok := columnLabels[col]; ok {
-			headerParts[i] = label
-		} else {
-			headerParts[i] = col
-		}
-	}
-	fmt.Fprintf(w, "%s\n", strings.Join(headerParts, ","))
-
-	// Helper to format nullable float
-	formatFloat := func(nf sql.NullFloat64) string {
-		if nf.Valid {
-			return fmt.Sprintf("%.2f", nf.Float64)
-		}
-		return ""
-	}
-
-	formatInt := func(ni sql.NullInt64) string {
-		if ni.Valid {
-			return fmt.Sprintf("%d", ni.Int64)
-		}
-		return ""
-	}
-
-	// Write data rows
-	rowCount := 0
-	for rows.Next() {
-		// Prepare scan destinations based on requested columns
-		scanDest := make([]interface{}, len(requestedColumns))
-		values := make([]string, len(requestedColumns))
-
-		for i, col := range requestedColumns {
-			switch col {
-			case "dateTime":
-				var epochSec int64
-				scanDest[i] = &epochSec
-			case "lightning_strike_count":
-				var val sql.NullInt64
-				scanDest[i] = &val
-			default:
-				var val sql.NullFloat64
-				scanDest[i] = &val
-			}
-		}
-
-		if err := rows.Scan(scanDest...); err != nil {
-			log.Println("CSV scan error:", err)
-			continue
-		}
-
-		// Format values for CSV output
-		for i, col := range requestedColumns {
-			switch col {
-			case "dateTime":
-				epochSec := *scanDest[i].(*int64)
-				ts := time.Unix(epochSec, 0).In(loc)
-				values[i] = ts.Format("2006-01-02 15:04:05")
-			case "lightning_strike_count":
-				values[i] = formatInt(*scanDest[i].(*sql.NullInt64))
-			default:
-				values[i] = formatFloat(*scanDest[i].(*sql.NullFloat64))
-			}
-		}
-
-		fmt.Fprintf(w, "%s\n", strings.Join(values, ","))
-		rowCount++
-	}
-
-	if err := rows.Err(); err != nil {
-		log.Println("CSV rows error:", err)
-	}
-
-	log.Printf("[CSV] Generated range CSV from %s to %s: %d rows, %d columns\n", startDateStr, endDateStr, rowCount, len(requestedColumns))
-}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+)
+
+// withUnitsConfig applies cfg for the duration of the test
+func withUnitsConfig(t *testing.T, cfg UnitsConfig) {
+	t.Helper()
+	savedSource, savedDisplay := sourceUnits, displayUnits
+	t.Cleanup(func() { sourceUnits, displayUnits = savedSource, savedDisplay })
+	if err := applyUnitsConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatisticsMetricSource(t *testing.T) {
+	withStationZone(t, "UTC")
+	withUnitsConfig(t, UnitsConfig{Source: "metric", System: "metric"})
+
+	// One row of statisticsColumns with only barometer, outTemp and lightning distance set
+	row := func(epoch int64, barometer, outTemp, distance float64) sample {
+		vals := make([]sql.NullFloat64, len(statisticsColumns))
+		vals[3] = sql.NullFloat64{Float64: distance, Valid: true}
+		vals[4] = sql.NullFloat64{Float64: outTemp, Valid: true}
+		vals[7] = sql.NullFloat64{Float64: barometer, Valid: true}
+		return sample{Epoch: epoch, Values: vals}
+	}
+	midnight := station.Today().Unix()
+	add, addToday, _, result := newStatistics(displayUnits)
+	add(row(midnight-3600, 1021.4, 12.5, 1500))
+	for _, s := range []sample{row(midnight+300, 1013.2, 8.0, 1200), row(midnight+600, 1009.8, 9.5, 1350)} {
+		add(s)
+		addToday(s)
+	}
+	stats := result()
+
+	checks := []struct{ name, got, want string }{
+		{"BarometerToday", stats.BarometerToday, "1013.2 / 1009.8"},
+		{"BarometerRange", stats.BarometerRange, "1021.4 / 1009.8"},
+		{"TempToday", stats.TempToday, "9.5 / 8.0"},
+		{"FeelsRange", stats.FeelsRange, "12.5 / 8.0"},
+		{"LightningDistToday", stats.LightningDistToday, "1200.0"},
+		{"InsideTempRange", stats.InsideTempRange, "--"},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %q, want %q", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestStatisticsTodayIgnoresRange(t *testing.T) {
+	withStationZone(t, "UTC")
+	midnight := station.Today()
+	newTestArchive(t, []testRecord{
+		{epoch: midnight.Unix() - 5*3600, vals: map[string]float64{"outTemp": 50, "rain": 0.30, "windGust": 25}},
+		{epoch: midnight.Unix() - 4*3600, vals: map[string]float64{"outTemp": 40, "rain": 0.20}},
+		{epoch: midnight.Unix() + 1, vals: map[string]float64{"outTemp": 65, "rain": 0.10, "windGust": 12}},
+		{epoch: midnight.Unix() + 2, vals: map[string]float64{"outTemp": 60, "rain": 0.05, "windGust": 8}},
+	})
+
+	cases := []struct {
+		name      string
+		tr        TimeRange
+		tempRange string
+		rainRange float64
+	}{
+		// A window in the past leaves today's figures intact
+		{"past window", TimeRange{Start: midnight.Add(-6 * time.Hour), End: midnight.Add(-3 * time.Hour)}, "50.0 / 40.0", 0.50},
+		// A window shorter than today doesn't narrow them
+		{"recent window", TimeRange{Start: midnight.Add(2 * time.Second), Open: true}, "60.0 / 60.0", 0.05},
+	}
+	for _, c := range cases {
+		stats, err := loadStatistics(context.Background(), c.tr, displayUnits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.TempRange != c.tempRange || math.Abs(stats.RainRange-c.rainRange) > 1e-9 {
+			t.Errorf("%s: range temp %q rain %v, want %q and %v", c.name, stats.TempRange, stats.RainRange, c.tempRange, c.rainRange)
+		}
+		if stats.TempToday != "65.0 / 60.0" || math.Abs(stats.RainToday-0.15) > 1e-9 || stats.WindMaxToday != "12" {
+			t.Errorf("%s: today temp %q rain %v gust %q, want 65.0 / 60.0, 0.15 and 12", c.name, stats.TempToday, stats.RainToday, stats.WindMaxToday)
+		}
+	}
+}
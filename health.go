@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Health statuses, in increasing severity
+const (
+	healthOK        = "ok"
+	healthDegraded  = "degraded"
+	healthUnhealthy = "unhealthy"
+)
+
+var healthRank = map[string]int{healthOK: 0, healthDegraded: 1, healthUnhealthy: 2}
+
+// processStart is used for uptime and to give background jobs a grace period
+var processStart = time.Now()
+
+// healthCheckTimeout bounds the database checks so /health answers even when the
+// database hangs
+const healthCheckTimeout = 3 * time.Second
+
+// jobState is what a background job (the SSE poller, the celestial refresher)
+// last reported about itself
+type jobState struct {
+	LastSuccess time.Time
+	LastFailure time.Time
+	LastError   string
+	NextRun     time.Time
+}
+
+var jobStates = struct {
+	sync.Mutex
+	m map[string]*jobState
+}{m: make(map[string]*jobState)}
+
+func updateJob(name string, fn func(*jobState)) {
+	jobStates.Lock()
+	defer jobStates.Unlock()
+	s, ok := jobStates.m[name]
+	if !ok {
+		s = &jobState{}
+		jobStates.m[name] = s
+	}
+	fn(s)
+}
+
+// markJobOK records a successful run
+func markJobOK(name string) {
+	updateJob(name, func(s *jobState) { s.LastSuccess = time.Now() })
+}
+
+// markJobFailed records a failed run
+func markJobFailed(name string, err error) {
+	updateJob(name, func(s *jobState) {
+		s.LastFailure = time.Now()
+		s.LastError = err.Error()
+	})
+}
+
+// markJobScheduled records when a periodic job will next run
+func markJobScheduled(name string, next time.Time) {
+	updateJob(name, func(s *jobState) { s.NextRun = next })
+}
+
+func getJob(name string) (jobState, bool) {
+	jobStates.Lock()
+	defer jobStates.Unlock()
+	s, ok := jobStates.m[name]
+	if !ok {
+		return jobState{}, false
+	}
+	return *s, true
+}
+
+// -------------------- component checks --------------------
+
+func checkDatabase(ctx context.Context) HealthComponent {
+	c := HealthComponent{Name: "database", Status: healthOK}
+	start := time.Now()
+	if err := archive.Ping(ctx); err != nil {
+		c.Status = healthUnhealthy
+		c.Message = "database unreachable"
+		return c
+	}
+	c.Details = map[string]interface{}{"pingMs": time.Since(start).Milliseconds()}
+	return c
+}
+
+// checkArchive compares the newest record's age with the expected archive interval
+func checkArchive(ctx context.Context) HealthComponent {
+	c := HealthComponent{Name: "archive", Status: healthOK}
+	cfg := appConfig.Health
+	interval := time.Duration(cfg.ArchiveIntervalSeconds) * time.Second
+
+	newest, ok, err := archive.MaxEpoch(ctx, "health")
+	if err != nil {
+		c.Status = healthUnhealthy
+		c.Message = "cannot read archive table"
+		return c
+	}
+	if !ok {
+		c.Status = healthUnhealthy
+		c.Message = "archive is empty"
+		return c
+	}
+
+	age := time.Since(time.Unix(newest, 0))
+	c.Details = map[string]interface{}{
+		"newestRecord":    newest,
+		"ageSeconds":      int64(age.Seconds()),
+		"intervalSeconds": cfg.ArchiveIntervalSeconds,
+	}
+	switch {
+	case age > time.Duration(cfg.UnhealthyAfter)*interval:
+		c.Status = healthUnhealthy
+		c.Message = fmt.Sprintf("no archive record for %s; is WeeWX running?", age.Round(time.Second))
+	case age > time.Duration(cfg.DegradedAfter)*interval:
+		c.Status = healthDegraded
+		c.Message = fmt.Sprintf("newest archive record is %s old", age.Round(time.Second))
+	}
+	return c
+}
+
+// checkJob reports a background job as degraded when it has not succeeded within
+// maxAge (after a start-up grace period) or its last run failed
+func checkJob(name string, maxAge time.Duration) HealthComponent {
+	c := HealthComponent{Name: name, Status: healthOK}
+	s, ok := getJob(name)
+	details := map[string]interface{}{}
+	if ok && !s.LastSuccess.IsZero() {
+		details["lastSuccess"] = s.LastSuccess.Unix()
+	}
+	if ok && !s.LastFailure.IsZero() {
+		details["lastFailure"] = s.LastFailure.Unix()
+		details["lastError"] = s.LastError
+	}
+	if ok && !s.NextRun.IsZero() {
+		details["nextRun"] = s.NextRun.Unix()
+	}
+	if len(details) > 0 {
+		c.Details = details
+	}
+
+	switch {
+	case ok && s.LastFailure.After(s.LastSuccess):
+		c.Status = healthDegraded
+		c.Message = "last run failed: " + s.LastError
+	case !s.NextRun.IsZero() && time.Since(s.NextRun) > maxAge:
+		c.Status = healthDegraded
+		c.Message = fmt.Sprintf("overdue since %s", s.NextRun.Format(time.RFC3339))
+	case s.NextRun.IsZero() && time.Since(processStart) > maxAge &&
+		(s.LastSuccess.IsZero() || time.Since(s.LastSuccess) > maxAge):
+		c.Status = healthDegraded
+		c.Message = "no successful run recently"
+	}
+	return c
+}
+
+// noaaDirCheckTTL is how long a report directory probe is reused. /health is public,
+// so a request must not cost a disk write.
+const noaaDirCheckTTL = 5 * time.Minute
+
+var noaaDirCheck struct {
+	sync.Mutex
+	at     time.Time
+	result HealthComponent
+}
+
+// checkNOAADir verifies generated reports can be cached on disk, probing at most once
+// per noaaDirCheckTTL
+func checkNOAADir() HealthComponent {
+	noaaDirCheck.Lock()
+	defer noaaDirCheck.Unlock()
+	if noaaDirCheck.at.IsZero() || time.Since(noaaDirCheck.at) > noaaDirCheckTTL {
+		noaaDirCheck.result = probeNOAADir()
+		noaaDirCheck.at = time.Now()
+	}
+	return noaaDirCheck.result
+}
+
+// probeNOAADir creates the report directory if needed and writes a temp file to it
+func probeNOAADir() HealthComponent {
+	c := HealthComponent{Name: "noaa_dir", Status: healthOK}
+	dir := filepath.Join("static", "noaa")
+	c.Details = map[string]interface{}{"path": dir}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		c.Status = healthDegraded
+		c.Message = "cannot create report directory"
+		return c
+	}
+	f, err := os.CreateTemp(dir, ".health-*")
+	if err != nil {
+		c.Status = healthDegraded
+		c.Message = "report directory is not writable"
+		return c
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return c
+}
+
+// -------------------- handlers --------------------
+
+// handleHealth reports each component and the overall status: 200 for ok and
+// degraded, 503 for unhealthy (systemd, monitoring, load balancers)
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	pollEvery := time.Duration(appConfig.Server.SSEPollSeconds) * time.Second
+	components := []HealthComponent{checkDatabase(ctx)}
+	if components[0].Status == healthOK {
+		components = append(components, checkArchive(ctx))
+	} else {
+		components = append(components, HealthComponent{Name: "archive", Status: healthUnhealthy, Message: "not checked: database unreachable"})
+	}
+	components = append(components,
+		checkJob("sse_poller", 3*pollEvery),
+		checkJob("celestial_refresh", 10*time.Minute),
+		checkNOAADir(),
+	)
+
+	resp := HealthResponse{
+		Status:        healthOK,
+		Timestamp:     time.Now().Unix(),
+		UptimeSeconds: int64(time.Since(processStart).Seconds()),
+		Components:    components,
+	}
+	for _, c := range components {
+		if healthRank[c.Status] > healthRank[resp.Status] {
+			resp.Status = c.Status
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Status == healthUnhealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleLiveness answers as long as the process is serving requests; it checks no
+// dependencies so a database outage doesn't get the service restarted
+func handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": healthOK})
+}
+
+// handleReadiness is 200 once the database is reachable and the poller has completed
+// a run, 503 otherwise
+func handleReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	status, reason := healthOK, ""
+	if err := archive.Ping(ctx); err != nil {
+		status, reason = healthUnhealthy, "database unreachable"
+	} else if s, ok := getJob("sse_poller"); !ok || s.LastSuccess.IsZero() {
+		status, reason = healthUnhealthy, "waiting for the first poll"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	body := map[string]string{"status": status}
+	if reason != "" {
+		body["message"] = reason
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/thurmanmarka/astroglide"
+)
+
+// StationLocation is the single source of truth for the station's coordinates and
+// time zone. Every handler that needs local day boundaries, sun/moon positions or
+// local timestamps should go through the package-level `station` value.
+type StationLocation struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+	Altitude  float64 // feet above sea level
+	Loc       *time.Location
+}
+
+// station is populated by loadConfig from the location section of config.yaml
+var station = &StationLocation{Loc: time.Local}
+
+// defaultTimezone is used when location.timezone is unset. It is the zone the
+// dashboard was hard-wired to before the location section existed, so older configs
+// keep their day boundaries.
+const defaultTimezone = "America/Phoenix"
+
+// newStationLocation validates the location config and resolves its time zone
+func newStationLocation(cfg LocationConfig) (*StationLocation, error) {
+	if math.IsNaN(cfg.Latitude) || cfg.Latitude < -90 || cfg.Latitude > 90 {
+		return nil, fmt.Errorf("location.latitude %v out of range [-90, 90]", cfg.Latitude)
+	}
+	if math.IsNaN(cfg.Longitude) || cfg.Longitude < -180 || cfg.Longitude > 180 {
+		return nil, fmt.Errorf("location.longitude %v out of range [-180, 180]", cfg.Longitude)
+	}
+
+	tz := cfg.Timezone
+	if tz == "" {
+		tz = defaultTimezone
+		log.Printf("[Location] WARNING: location.timezone is not set; using %s", tz)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("location.timezone %q: %w", tz, err)
+	}
+	log.Printf("[Location] %s at %.4f, %.4f; time zone %s", cfg.Name, cfg.Latitude, cfg.Longitude, loc)
+
+	return &StationLocation{
+		Name:      cfg.Name,
+		Latitude:  cfg.Latitude,
+		Longitude: cfg.Longitude,
+		Altitude:  cfg.Altitude,
+		Loc:       loc,
+	}, nil
+}
+
+// Coordinates returns the station position in the form astroglide expects
+func (s *StationLocation) Coordinates() astroglide.Coordinates {
+	return astroglide.Coordinates{Lat: s.Latitude, Lon: s.Longitude}
+}
+
+// Now returns the current time in the station's time zone
+func (s *StationLocation) Now() time.Time {
+	return time.Now().In(s.Loc)
+}
+
+// In converts a unix epoch (as stored in the archive) to station local time
+func (s *StationLocation) In(epoch int64) time.Time {
+	return time.Unix(epoch, 0).In(s.Loc)
+}
+
+// Date returns local midnight of the given calendar date
+func (s *StationLocation) Date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, s.Loc)
+}
+
+// StartOfDay returns local midnight of the day containing t
+func (s *StationLocation) StartOfDay(t time.Time) time.Time {
+	t = t.In(s.Loc)
+	return s.Date(t.Year(), t.Month(), t.Day())
+}
+
+// Today returns local midnight of the current station day
+func (s *StationLocation) Today() time.Time {
+	return s.StartOfDay(time.Now())
+}
+
+// NextDay returns local midnight of the day after t. Unlike t.Add(24h) this stays
+// on midnight across DST transitions (23h and 25h days).
+func (s *StationLocation) NextDay(t time.Time) time.Time {
+	t = t.In(s.Loc)
+	return s.Date(t.Year(), t.Month(), t.Day()+1)
+}
+
+// DayBounds returns [start, end) for the local calendar day containing t
+func (s *StationLocation) DayBounds(t time.Time) (time.Time, time.Time) {
+	start := s.StartOfDay(t)
+	return start, s.NextDay(start)
+}
+
+// LatString formats the latitude NOAA-style, e.g. "32.09 N"
+func (s *StationLocation) LatString() string {
+	if s.Latitude < 0 {
+		return fmt.Sprintf("%.2f S", -s.Latitude)
+	}
+	return fmt.Sprintf("%.2f N", s.Latitude)
+}
+
+// LonString formats the longitude NOAA-style, e.g. "110.78 W"
+func (s *StationLocation) LonString() string {
+	if s.Longitude < 0 {
+		return fmt.Sprintf("%.2f W", -s.Longitude)
+	}
+	return fmt.Sprintf("%.2f E", s.Longitude)
+}
@@ -1,30 +1,51 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"os"
 	"time"
-
-	_ "github.com/go-sql-driver/mysql"
 )
 
 var (
 	tmplIndex *template.Template
 	tmplKiosk *template.Template
-	db        *sql.DB
 )
 
 func main() {
 	var err error
 
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		runHashPassword()
+		return
+	}
+
 	// Load config
 	if err := loadConfig("config.yaml"); err != nil {
 		log.Fatal("Error loading config:", err)
 	}
 
+	auth, err = NewAuthenticator(appConfig.Auth)
+	if err != nil {
+		log.Fatal("Error loading auth config:", err)
+	}
+	apiKeys, err = NewAPIKeyStore(appConfig.Auth.APIKeys)
+	if err != nil {
+		log.Fatal("Error loading API keys:", err)
+	}
+
+	exportCache, err = NewExportCache(appConfig.Export)
+	if err != nil {
+		log.Fatal("Error setting up export cache:", err)
+	}
+	responseCache, err = NewResponseCache(appConfig.ResponseCache)
+	if err != nil {
+		log.Fatal("Error setting up response cache:", err)
+	}
+
 	// Load HTML template
 	tmplIndex, err = template.ParseFiles("templates/index.html")
 	if err != nil {
@@ -37,20 +58,19 @@ func main() {
 		log.Fatal("Error loading kiosk template:", err)
 	}
 
-	// Build DSN from config
-	dsn := buildDSN()
-	db, err = sql.Open("mysql", dsn)
-
+	// Open the WeeWX archive (MySQL/MariaDB or SQLite)
+	archive, err = openArchive(appConfig.DB)
 	if err != nil {
 		log.Fatal("Error opening DB:", err)
 	}
-	defer db.Close()
+	defer archive.Close()
 
-	if err := db.Ping(); err != nil {
+	if err := archive.Ping(context.Background()); err != nil {
 		log.Fatal("Error pinging DB:", err)
 	}
 
-	// Routes
+	// Routes. Archive-backed JSON endpoints go through the response cache, which the
+	// SSE poller invalidates on each new record.
 	fs := http.FileServer(http.Dir("static"))
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
 
@@ -58,38 +78,99 @@ func main() {
 	http.HandleFunc("/weather", handleWeatherDash)
 	http.HandleFunc("/kiosk", handleKiosk)
 	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/health/live", handleLiveness)
+	http.HandleFunc("/health/ready", handleReadiness)
+	http.HandleFunc("/metrics", handleMetrics)
 	http.HandleFunc("/api/ping", handlePing)
-	http.HandleFunc("/api/weather", handleWeather)
-	http.HandleFunc("/api/barometer", handleBarometer)
-	http.HandleFunc("/api/feelslike", handleFeelsLike)
-	http.HandleFunc("/api/humidity", handleHumidity)
-	http.HandleFunc("/api/wind", handleWind)
-	http.HandleFunc("/api/rain", handleRain)
-	http.HandleFunc("/api/lightning", handleLightning)
-	http.HandleFunc("/api/insideTemp", handleInsideTemp)
-	http.HandleFunc("/api/insideHumidity", handleInsideHumidity)
+	http.HandleFunc("/login", handleLogin)
+	http.HandleFunc("/logout", handleLogout)
+	http.HandleFunc("/api/me", handleMe)
+	http.HandleFunc("/api/keys", requireAdmin(handleAPIKeys))
+	http.HandleFunc("/api/series", responseCache.Handler(handleSeries))
+	http.HandleFunc("/api/dashboard", responseCache.Handler(handleDashboard))
+	http.HandleFunc("/api/weather", responseCache.Handler(handleWeather))
+	http.HandleFunc("/api/barometer", responseCache.Handler(handleBarometer))
+	http.HandleFunc("/api/feelslike", responseCache.Handler(handleFeelsLike))
+	http.HandleFunc("/api/humidity", responseCache.Handler(handleHumidity))
+	http.HandleFunc("/api/wind", responseCache.Handler(handleWind))
+	http.HandleFunc("/api/rain", responseCache.Handler(handleRain))
+	http.HandleFunc("/api/lightning", responseCache.Handler(handleLightning))
+	http.HandleFunc("/api/insideTemp", responseCache.Handler(handleInsideTemp))
+	http.HandleFunc("/api/insideHumidity", responseCache.Handler(handleInsideHumidity))
 	http.HandleFunc("/api/celestial", handleCelestial)
-	http.HandleFunc("/api/noaa/monthly", handleNOAAMonthly)
-	http.HandleFunc("/api/noaa/yearly", handleNOAAYearly)
-	http.HandleFunc("/api/statistics", handleStatistics)
+	// NOAA reports (and their regeneration) require the admin or user role
+	http.HandleFunc("/api/noaa/monthly", requireAdmin(handleNOAAMonthly))
+	http.HandleFunc("/api/noaa/yearly", requireAdmin(handleNOAAYearly))
+	http.HandleFunc("/api/statistics", responseCache.Handler(handleStatistics))
+	http.HandleFunc("/api/records", responseCache.Handler(handleRecords))
+	http.HandleFunc("/api/onthisday", responseCache.Handler(handleOnThisDay))
 	http.HandleFunc("/api/csv/daily", handleCSVDaily)
 	http.HandleFunc("/api/csv/range", handleCSVRange)
+	http.HandleFunc("/api/alerts", handleAlerts)
+	http.HandleFunc("/api/alerts/history", handleAlertHistory)
+
+	// Alert rules are evaluated on every new archive record the SSE poller sees
+	alertEngine, err = NewAlertEngine(archive, appConfig.Alerts)
+	if err != nil {
+		log.Fatal("Error loading alert rules:", err)
+	}
+	notifier, err = NewNotifier(appConfig.Notifications)
+	if err != nil {
+		log.Fatal("Error loading notifications:", err)
+	}
+	if err := notifier.checkRoutes(alertEngine.rules); err != nil {
+		log.Fatal("Error loading notifications:", err)
+	}
+	alertEngine.Subscribe(notifier.Notify)
+	http.HandleFunc("/api/notify/test", requireAdmin(handleNotifyTest))
+
+	// Records are found from per-day aggregates of the whole archive, read in the
+	// background; live updates fold into them once loaded
+	recordBook = NewRecordBook(archive)
+	go func() {
+		if err := recordBook.Load(context.Background()); err != nil {
+			log.Printf("[Records] load failed (retried on the next request): %v", err)
+		}
+	}()
+	// Climate normals come from an NCEI file, or from the record book once the
+	// archive spans enough years
+	climateNormals, err = NewClimateNormals(appConfig.Normals)
+	if err != nil {
+		log.Fatal("Error loading climate normals:", err)
+	}
+
 	// Server-Sent Events stream (push updates)
-	broker := NewSSEBroker(db)
+	broker := NewSSEBroker(archive)
 	stopSSE := make(chan struct{})
+	broker.OnRecord(responseCache.Invalidate)
+	broker.OnRecord(alertEngine.Evaluate)
+	alertEngine.Subscribe(broker.PublishAlert)
 	// Poll DB every configured seconds for new rows and broadcast
 	broker.StartPolling(time.Duration(appConfig.Server.SSEPollSeconds)*time.Second, stopSSE)
 	http.Handle("/api/stream", broker)
+	http.HandleFunc("/api/ws", broker.ServeWS)
+	registerBrokerMetrics(broker)
 
 	// Start background celestial cache refresh (runs at 00:05 local daily)
 	stopCelestialRefresh := make(chan struct{})
-	go refreshCelestialCacheDaily(stopCelestialRefresh)
+	go refreshCelestialCacheDaily(stopCelestialRefresh, broker.PublishCelestial)
+
+	// Persist API key usage counters
+	stopAPIKeys := make(chan struct{})
+	apiKeys.StartFlushing(time.Minute, stopAPIKeys)
+
+	// Expire cached exports
+	stopExportCache := make(chan struct{})
+	exportCache.StartPruning(time.Minute, stopExportCache)
 
 	addr := fmt.Sprintf(":%d", appConfig.Server.Port)
 	log.Println("Server listening on", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	handler := instrumentHTTP(http.DefaultServeMux, auth.Middleware(http.DefaultServeMux))
+	if err := http.ListenAndServe(addr, handler); err != nil {
 		close(stopSSE)
 		close(stopCelestialRefresh)
+		close(stopAPIKeys)
+		close(stopExportCache)
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// -------------------- metrics registry --------------------
+//
+// Thin wrappers over prometheus/client_golang so call sites pass label values
+// directly: labelled counters and histograms updated as things happen, and gauges
+// sampled at scrape time. Everything lives in its own registry, exposed by /metrics.
+
+var metricRegistry = prometheus.NewRegistry()
+
+// counterVec is a counter family; name excludes the _total suffix
+type counterVec struct {
+	vec *prometheus.CounterVec
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name + "_total", Help: help}, labels)
+	metricRegistry.MustRegister(vec)
+	return &counterVec{vec: vec}
+}
+
+func (c *counterVec) Add(v float64, labels ...string) {
+	c.vec.WithLabelValues(labels...).Add(v)
+}
+
+func (c *counterVec) Inc(labels ...string) {
+	c.vec.WithLabelValues(labels...).Inc()
+}
+
+// histogramVec is a histogram family with fixed buckets
+type histogramVec struct {
+	vec *prometheus.HistogramVec
+}
+
+// durationBuckets suit request and query latencies (seconds)
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels)
+	metricRegistry.MustRegister(vec)
+	return &histogramVec{vec: vec}
+}
+
+func (h *histogramVec) Observe(v float64, labels ...string) {
+	h.vec.WithLabelValues(labels...).Observe(v)
+}
+
+// Since observes the time elapsed since start, in seconds
+func (h *histogramVec) Since(start time.Time, labels ...string) {
+	h.Observe(time.Since(start).Seconds(), labels...)
+}
+
+// gaugeFunc is a gauge family whose samples are collected at scrape time
+type gaugeFunc struct {
+	desc    *prometheus.Desc
+	collect func() []gaugeSample
+}
+
+type gaugeSample struct {
+	labels []string
+	value  float64
+}
+
+func newGaugeFunc(name, help string, labels []string, collect func() []gaugeSample) *gaugeFunc {
+	g := &gaugeFunc{desc: prometheus.NewDesc(name, help, labels, nil), collect: collect}
+	metricRegistry.MustRegister(g)
+	return g
+}
+
+func (g *gaugeFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+func (g *gaugeFunc) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range g.collect() {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, s.value, s.labels...)
+	}
+}
+
+// -------------------- service metrics --------------------
+
+var (
+	httpRequests = newCounterVec("weatherdash_http_requests",
+		"HTTP requests by route, method and status code.", "route", "method", "code")
+	httpDuration = newHistogramVec("weatherdash_http_request_duration_seconds",
+		"HTTP request latency by route (streaming routes excluded).", durationBuckets, "route")
+
+	dbQueryDuration = newHistogramVec("weatherdash_db_query_duration_seconds",
+		"Database query latency (until the first result) by query.", durationBuckets, "query")
+	dbQueryErrors = newCounterVec("weatherdash_db_query_errors",
+		"Failed database queries by query.", "query")
+
+	sseEvents = newCounterVec("weatherdash_sse_events",
+		"Events published to live stream clients by type.", "type")
+	sseDropped = newCounterVec("weatherdash_sse_dropped_messages",
+		"Events not delivered because a client's queue was full (the client is disconnected).", "type")
+
+	celestialCacheRequests = newCounterVec("weatherdash_celestial_cache_requests",
+		"Celestial cache lookups by result (hit or miss).", "result")
+
+	noaaCacheRequests = newCounterVec("weatherdash_noaa_cache_requests",
+		"NOAA report requests by report and result (hit or generated).", "report", "result")
+	noaaGeneration = newHistogramVec("weatherdash_noaa_generation_seconds",
+		"Time to generate a NOAA report.", []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}, "report")
+
+	dailySummaryReads = newCounterVec("weatherdash_daily_summary_reads",
+		"Day-level aggregations by use and source (summary tables or archive scan).", "use", "source")
+
+	exportRequests = newCounterVec("weatherdash_exports",
+		"Export downloads by format and result (streamed, cached, canceled, aborted, error).", "format", "result")
+
+	responseCacheRequests = newCounterVec("weatherdash_response_cache_requests",
+		"Cacheable API requests by result (hit, not_modified, miss, shared, bypass).", "result")
+
+	apiKeyRequests = newCounterVec("weatherdash_api_key_requests",
+		"Requests made with an API key by key id and result (ok, invalid, forbidden, rate_limited, quota_exceeded).", "key", "result")
+)
+
+// streamingRoutes stay open for the life of a client, so their durations would
+// swamp the latency histogram
+var streamingRoutes = map[string]bool{"/api/stream": true, "/api/ws": true}
+
+// observeDB records a query's latency and, if it failed, an error
+func observeDB(query string, start time.Time, err error) {
+	dbQueryDuration.Since(start, query)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		dbQueryErrors.Inc(query)
+	}
+}
+
+// registerBrokerMetrics exposes the live stream client count
+func registerBrokerMetrics(b *SSEBroker) {
+	newGaugeFunc("weatherdash_sse_clients", "Connected /api/stream and /api/ws clients.", nil, func() []gaugeSample {
+		return []gaugeSample{{value: float64(b.clientCount())}}
+	})
+}
+
+// latestArchive caches the newest archive row briefly so the three archive gauges
+// (and concurrent scrapers) share one query
+var latestArchive struct {
+	sync.Mutex
+	at     time.Time
+	epoch  int64
+	values map[string]float64
+	err    error
+}
+
+func loadLatestArchive() (int64, map[string]float64, error) {
+	latestArchive.Lock()
+	defer latestArchive.Unlock()
+	if time.Since(latestArchive.at) < time.Second {
+		return latestArchive.epoch, latestArchive.values, latestArchive.err
+	}
+
+	epoch, vals, err := archive.Latest(context.Background(), "metrics_latest", sseColumns, 0, math.MaxInt64)
+
+	values := make(map[string]float64, len(sseColumns))
+	if err == nil {
+		for i, col := range sseColumns {
+			if vals[i].Valid {
+				values[col] = vals[i].Float64
+			}
+		}
+	}
+	latestArchive.at, latestArchive.epoch, latestArchive.values, latestArchive.err = time.Now(), epoch, values, err
+	return epoch, values, err
+}
+
+func init() {
+	newGaugeFunc("weatherdash_db_up", "Whether the last scrape could read the archive table (1) or not (0).", nil, func() []gaugeSample {
+		_, _, err := loadLatestArchive()
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return []gaugeSample{{value: 0}}
+		}
+		return []gaugeSample{{value: 1}}
+	})
+	newGaugeFunc("weatherdash_archive_latest_timestamp_seconds", "Unix time of the newest archive record.", nil, func() []gaugeSample {
+		epoch, _, err := loadLatestArchive()
+		if err != nil {
+			return nil
+		}
+		return []gaugeSample{{value: float64(epoch)}}
+	})
+	newGaugeFunc("weatherdash_archive_latest_age_seconds", "Age of the newest archive record; alert on this for station outages.", nil, func() []gaugeSample {
+		epoch, _, err := loadLatestArchive()
+		if err != nil {
+			return nil
+		}
+		return []gaugeSample{{value: time.Since(time.Unix(epoch, 0)).Seconds()}}
+	})
+	newGaugeFunc("weatherdash_archive_value", "Newest archive reading by field, in display units.", []string{"field", "unit"}, func() []gaugeSample {
+		_, values, err := loadLatestArchive()
+		if err != nil {
+			return nil
+		}
+		var samples []gaugeSample
+		for _, col := range sseColumns {
+			v, ok := values[col]
+			if !ok {
+				continue
+			}
+			unit := ""
+			if q, ok := archiveQuantities[col]; ok {
+				v = displayUnits.Convert(q, v)
+				unit = displayUnits.Suffix(q)
+			}
+			samples = append(samples, gaugeSample{labels: []string{col, unit}, value: v})
+		}
+		return samples
+	})
+}
+
+// -------------------- /metrics --------------------
+
+// metricsHandler serves the registry in the OpenMetrics format when the scraper asks
+// for it, otherwise in the Prometheus text format
+var metricsHandler = promhttp.HandlerFor(metricRegistry, promhttp.HandlerOpts{
+	EnableOpenMetrics:                   true,
+	EnableOpenMetricsTextCreatedSamples: true,
+})
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsHandler.ServeHTTP(w, r)
+}
+
+// -------------------- HTTP instrumentation --------------------
+
+// statusRecorder captures the response status. It passes Flush and Hijack through so
+// the SSE and WebSocket handlers keep working behind it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// metricMethod keeps arbitrary client-supplied methods out of the label values
+func metricMethod(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return m
+	}
+	return "other"
+}
+
+// instrumentHTTP counts and times every request to next by the mux pattern it
+// matches, so label cardinality stays bounded by the route table
+func instrumentHTTP(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		httpRequests.Inc(route, metricMethod(r.Method), strconv.Itoa(rec.status))
+		if !streamingRoutes[route] {
+			httpDuration.Since(start, route)
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// latestStub answers the archive gauges' query; nothing else may be called
+type latestStub struct {
+	Archive
+	epoch int64
+	vals  []sql.NullFloat64
+	err   error
+}
+
+func (a latestStub) Latest(ctx context.Context, query string, cols []string, from, to int64) (int64, []sql.NullFloat64, error) {
+	return a.epoch, a.vals, a.err
+}
+
+func withMetricsArchive(t *testing.T, a Archive) {
+	t.Helper()
+	saved := archive
+	archive = a
+	latestArchive.Lock()
+	latestArchive.at = latestArchive.at.AddDate(-1, 0, 0)
+	latestArchive.Unlock()
+	t.Cleanup(func() {
+		archive = saved
+		latestArchive.Lock()
+		latestArchive.at = latestArchive.at.AddDate(-1, 0, 0)
+		latestArchive.Unlock()
+	})
+}
+
+func scrapeMetrics(t *testing.T, accept string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, req)
+	resp := rec.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	return resp
+}
+
+func findMetric(mf *dto.MetricFamily, labels map[string]string) *dto.Metric {
+	for _, m := range mf.GetMetric() {
+		match := len(m.GetLabel()) == len(labels)
+		for _, lp := range m.GetLabel() {
+			if labels[lp.GetName()] != lp.GetValue() {
+				match = false
+			}
+		}
+		if match {
+			return m
+		}
+	}
+	return nil
+}
+
+func seedMetrics(t *testing.T) {
+	t.Helper()
+	vals := make([]sql.NullFloat64, len(sseColumns))
+	for i, col := range sseColumns {
+		if col == "outTemp" {
+			vals[i] = sql.NullFloat64{Float64: 71.5, Valid: true}
+		}
+	}
+	withMetricsArchive(t, latestStub{epoch: 1718900000, vals: vals})
+	httpRequests.Inc("/test/metrics", "GET", "200")
+	dbQueryDuration.Observe(0.02, "test_metrics")
+}
+
+func TestMetricsTextFormat(t *testing.T) {
+	seedMetrics(t)
+	resp := scrapeMetrics(t, "")
+	if ct := resp.Header.Get("Content-Type"); expfmt.ResponseFormat(resp.Header).FormatType() != expfmt.TypeTextPlain {
+		t.Fatalf("content type %q, want the Prometheus text format", ct)
+	}
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	mf := families["weatherdash_http_requests_total"]
+	if mf.GetType() != dto.MetricType_COUNTER {
+		t.Fatalf("weatherdash_http_requests_total type %v, want counter", mf.GetType())
+	}
+	if m := findMetric(mf, map[string]string{"route": "/test/metrics", "method": "GET", "code": "200"}); m == nil || m.GetCounter().GetValue() < 1 {
+		t.Errorf("request counter sample missing: %v", m)
+	}
+
+	mf = families["weatherdash_db_query_duration_seconds"]
+	if mf.GetType() != dto.MetricType_HISTOGRAM {
+		t.Fatalf("weatherdash_db_query_duration_seconds type %v, want histogram", mf.GetType())
+	}
+	m := findMetric(mf, map[string]string{"query": "test_metrics"})
+	if m == nil {
+		t.Fatal("histogram sample missing")
+	}
+	h := m.GetHistogram()
+	if h.GetSampleCount() != 1 || len(h.GetBucket()) != len(durationBuckets)+1 {
+		t.Errorf("histogram count %d with %d buckets", h.GetSampleCount(), len(h.GetBucket()))
+	}
+	for _, b := range h.GetBucket() {
+		want := uint64(0)
+		if b.GetUpperBound() >= 0.02 {
+			want = 1
+		}
+		if b.GetCumulativeCount() != want {
+			t.Errorf("bucket le=%v holds %d, want %d", b.GetUpperBound(), b.GetCumulativeCount(), want)
+		}
+	}
+
+	if mf := families["weatherdash_archive_latest_timestamp_seconds"]; mf.GetType() != dto.MetricType_GAUGE || len(mf.GetMetric()) != 1 || mf.GetMetric()[0].GetGauge().GetValue() != 1718900000 {
+		t.Errorf("latest timestamp gauge: %v", mf)
+	}
+	if mf := families["weatherdash_db_up"]; len(mf.GetMetric()) != 1 || mf.GetMetric()[0].GetGauge().GetValue() != 1 {
+		t.Errorf("db_up gauge: %v", mf)
+	}
+	mf = families["weatherdash_archive_value"]
+	if m := findMetric(mf, map[string]string{"field": "outTemp", "unit": displayUnits.Suffix(archiveQuantities["outTemp"])}); m == nil {
+		t.Errorf("outTemp gauge missing: %v", mf)
+	}
+	if len(mf.GetMetric()) != 1 {
+		t.Errorf("got %d archive_value samples, want only the non-NULL field", len(mf.GetMetric()))
+	}
+}
+
+func TestMetricsProtobufFormat(t *testing.T) {
+	seedMetrics(t)
+	resp := scrapeMetrics(t, string(expfmt.NewFormat(expfmt.TypeProtoDelim)))
+	format := expfmt.ResponseFormat(resp.Header)
+	if format.FormatType() != expfmt.TypeProtoDelim {
+		t.Fatalf("format %q, want delimited protobuf", format)
+	}
+	dec := expfmt.NewDecoder(resp.Body, format)
+	found := false
+	for {
+		var mf dto.MetricFamily
+		if err := dec.Decode(&mf); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatalf("decode: %v", err)
+		}
+		if mf.GetName() == "weatherdash_http_requests_total" {
+			found = findMetric(&mf, map[string]string{"route": "/test/metrics", "method": "GET", "code": "200"}) != nil
+		}
+	}
+	if !found {
+		t.Error("request counter missing from protobuf exposition")
+	}
+}
+
+func TestMetricsOpenMetricsFormat(t *testing.T) {
+	seedMetrics(t)
+	resp := scrapeMetrics(t, "application/openmetrics-text; version=1.0.0")
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/openmetrics-text;") {
+		t.Fatalf("content type %q, want OpenMetrics", resp.Header.Get("Content-Type"))
+	}
+
+	// expfmt has no OpenMetrics parser, so check the parts the text format lacks:
+	// counter families named without _total, _created samples and the EOF marker
+	types := make(map[string]string)
+	samples := make(map[string]bool)
+	var last string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		last = line
+		if strings.HasPrefix(line, "# TYPE ") {
+			f := strings.Fields(line)
+			if len(f) != 4 {
+				t.Fatalf("malformed TYPE line %q", line)
+			}
+			types[f[2]] = f[3]
+			continue
+		}
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		name := line[:strings.IndexAny(line, "{ ")]
+		samples[name] = true
+		family := name
+		for _, suffix := range []string{"_total", "_created", "_bucket", "_count", "_sum"} {
+			if base := strings.TrimSuffix(name, suffix); base != name && types[base] != "" {
+				family = base
+			}
+		}
+		if types[family] == "" {
+			t.Errorf("sample %q precedes its TYPE line", line)
+		}
+	}
+	if last != "# EOF" {
+		t.Errorf("exposition ends with %q, want # EOF", last)
+	}
+	if types["weatherdash_http_requests"] != "counter" {
+		t.Errorf("weatherdash_http_requests TYPE %q, want counter", types["weatherdash_http_requests"])
+	}
+	if types["weatherdash_db_query_duration_seconds"] != "histogram" || types["weatherdash_db_up"] != "gauge" {
+		t.Errorf("histogram/gauge TYPE lines: %v", types)
+	}
+	for _, name := range []string{"weatherdash_http_requests_total", "weatherdash_http_requests_created", "weatherdash_db_query_duration_seconds_created", "weatherdash_db_query_duration_seconds_bucket"} {
+		if !samples[name] {
+			t.Errorf("no %s samples", name)
+		}
+	}
+}
+
+func TestMetricsArchiveDown(t *testing.T) {
+	withMetricsArchive(t, latestStub{err: errors.New("connection refused")})
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(scrapeMetrics(t, "").Body)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if mf := families["weatherdash_db_up"]; len(mf.GetMetric()) != 1 || mf.GetMetric()[0].GetGauge().GetValue() != 0 {
+		t.Errorf("db_up gauge: %v", mf)
+	}
+	if _, ok := families["weatherdash_archive_latest_timestamp_seconds"]; ok {
+		t.Error("latest timestamp exported without an archive")
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"fmt"
 	"log"
+	"maps"
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,110 +21,180 @@ import (
 type NOAAMonthlyParams struct {
 	Year  int
 	Month int // 1-12
+	Units UnitSystem
 }
 
 type NOAAYearlyParams struct {
-	Year int
+	Year  int
+	Units UnitSystem
 }
 
-// RenderMonthlyNOAA generates text content using archive table aggregates
-func RenderMonthlyNOAA(db *sql.DB, p NOAAMonthlyParams) (string, error) {
-	// Use MST7MDT timezone (Arizona local time, UTC-7)
-	loc, err := time.LoadLocation("America/Phoenix")
-	if err != nil {
-		// Fallback to system local time if timezone not available
-		loc = time.Now().Location()
+// noaaThresholdSet holds the degree day bases and day-count thresholds of the
+// reports, in US units
+type noaaThresholdSet struct {
+	heatingBase, coolingBase float64
+	maxAbove, maxBelow       float64    // days with a high >= / <= these
+	minBelow                 [2]float64 // days with a low <= these
+	rain                     [3]float64 // days with rain >= these
+}
+
+// noaaThresholds are the NOAA defaults unless set in the noaa config
+var noaaThresholds = noaaThresholdSet{
+	heatingBase: 65, coolingBase: 65,
+	maxAbove: 90, maxBelow: 32,
+	minBelow: [2]float64{32, 0},
+	rain:     [3]float64{0.01, 0.10, 1.00},
+}
+
+// degreeDays returns the heating and cooling degree days of a day whose mean
+// temperature is mean (archive units)
+func degreeDays(mean float64) (heat, cool float64) {
+	heat = max(fromUSThreshold(qTemp, noaaThresholds.heatingBase)-mean, 0)
+	cool = max(mean-fromUSThreshold(qTemp, noaaThresholds.coolingBase), 0)
+	return heat, cool
+}
+
+// noaaUnitLabels returns the temperature, rain and wind unit names used in report headers
+func noaaUnitLabels(u UnitSystem) (temp, rain, wind string) {
+	return u.unitFor(qTemp), u.unitFor(qRain), u.Label(qSpeed)
+}
+
+// noaaElevation formats the station elevation, e.g. "2500 feet" or "762 meters"
+func noaaElevation(u UnitSystem) string {
+	unit := "feet"
+	if u.unitFor(qAltitude) == "m" {
+		unit = "meters"
 	}
-	start := time.Date(p.Year, time.Month(p.Month), 1, 0, 0, 0, 0, loc)
-	end := start.AddDate(0, 1, 0)
-	startUnix := start.Unix()
-	endUnix := end.Unix()
-
-	// Daily aggregates (temp high/low, mean, rain totals, wind avg & max, dominant dir)
-	rows, err := db.Query(`
-		SELECT dateTime, outTemp, dewpoint, outHumidity, barometer,
-		       windSpeed, windGust, windDir, rain, rainRate
-		FROM archive
-		WHERE dateTime >= ? AND dateTime < ?
-		ORDER BY dateTime ASC
-	`, startUnix, endUnix)
-	if err != nil {
-		return "", err
+	return fmt.Sprintf("%.0f %s", u.FromUS(qAltitude, station.Altitude), unit)
+}
+
+// noaaDay is one station day of the values the NOAA reports summarise, in archive
+// units. As in WeeWX, the record stamped at midnight ends the previous day.
+type noaaDay struct {
+	tempCount                int64
+	tempSum                  float64
+	maxTemp, minTemp         float64
+	maxTempTime, minTempTime int64
+	rain                     float64
+	rainCount                int64
+	windSum                  float64
+	windCount                int64
+	gustMax                  float64
+	gustMaxTime              int64
+	// Speed-weighted wind vector sums over the windCount readings
+	vecEast, vecNorth float64
+}
+
+// windVector returns the day's mean speed-weighted wind vector
+func (d *noaaDay) windVector() (east, north float64, ok bool) {
+	if d.windCount == 0 || (d.vecEast == 0 && d.vecNorth == 0) {
+		return 0, 0, false
 	}
-	defer rows.Close()
-
-	// Buckets per day
-	type dayAgg struct {
-		count                    int
-		maxTemp, minTemp         float64
-		maxTempTime, minTempTime string
-		meanTempSum              float64
-		rainTotal                float64
-		windAvgSum               float64
-		windCount                int
-		windMax                  float64
-		windMaxTime              string
-		windDirSinSum            float64
-		windDirCosSum            float64
-		windDirCount             int
-		heatDegDays              float64
-		coolDegDays              float64
-	}
-	perDay := map[int]*dayAgg{}
-
-	for rows.Next() {
-		var epoch int64
-		var outTemp, dew, hum, bar, windSpeed, windGust, windDir, rain, rainRate sql.NullFloat64
-		if err := rows.Scan(&epoch, &outTemp, &dew, &hum, &bar, &windSpeed, &windGust, &windDir, &rain, &rainRate); err != nil {
-			return "", err
+	return d.vecEast / float64(d.windCount), d.vecNorth / float64(d.windCount), true
+}
+
+// loadNOAADays aggregates the station days starting in [start, end), keyed by the
+// day's start, from WeeWX's daily summaries or else the archive
+func loadNOAADays(ctx context.Context, archive Archive, use string, start, end time.Time) (map[int64]*noaaDay, error) {
+	summaries, ok, err := loadDaySummaries(ctx, archive, use, []string{"outTemp", "rain", "windSpeed", "windGust", "wind"}, start, end)
+	if err != nil {
+		return nil, err
+	}
+	days := map[int64]*noaaDay{}
+	if ok {
+		for day, s := range summaries {
+			temp, rain, speed, gust, wind := s.Obs["outTemp"], s.Obs["rain"], s.Obs["windSpeed"], s.Obs["windGust"], s.Obs["wind"]
+			d := &noaaDay{
+				tempCount: temp.Count, tempSum: temp.Sum,
+				maxTemp: temp.Max.Float64, maxTempTime: temp.MaxTime,
+				minTemp: temp.Min.Float64, minTempTime: temp.MinTime,
+				rain: rain.Sum, rainCount: rain.Count,
+				windSum: speed.Sum, windCount: speed.Count,
+			}
+			if gust.Max.Float64 > 0 {
+				d.gustMax, d.gustMaxTime = gust.Max.Float64, gust.MaxTime
+			}
+			if wind.SumTime > 0 {
+				// The vector sums are weighted by interval; scale them back to per-record sums
+				scale := float64(wind.Count) / wind.SumTime
+				d.vecEast, d.vecNorth = wind.XSum*scale, wind.YSum*scale
+			}
+			days[day] = d
 		}
-		d := time.Unix(epoch, 0).In(loc).Day()
-		ts := time.Unix(epoch, 0).In(loc)
-		timeStr := ts.Format("15:04")
-		agg := perDay[d]
-		if agg == nil {
-			agg = &dayAgg{minTemp: 9999}
-			perDay[d] = agg
+		return days, nil
+	}
+
+	scan := ArchiveScan{Columns: []string{"outTemp", "rain", "windSpeed", "windGust", "windDir"}, Start: start.Unix() + 1, End: end.Unix() + 1}
+	err = archive.Scan(ctx, use, scan, func(epoch int64, v []sql.NullFloat64) error {
+		outTemp, rain, windSpeed, windGust, windDir := v[0], v[1], v[2], v[3], v[4]
+		key := station.StartOfDay(station.In(epoch - 1)).Unix()
+		d := days[key]
+		if d == nil {
+			d = &noaaDay{}
+			days[key] = d
 		}
 		if outTemp.Valid {
-			agg.count++
-			agg.meanTempSum += outTemp.Float64
-			if outTemp.Float64 > agg.maxTemp {
-				agg.maxTemp = outTemp.Float64
-				agg.maxTempTime = timeStr
+			if d.tempCount == 0 || outTemp.Float64 > d.maxTemp {
+				d.maxTemp, d.maxTempTime = outTemp.Float64, epoch
 			}
-			if outTemp.Float64 < agg.minTemp {
-				agg.minTemp = outTemp.Float64
-				agg.minTempTime = timeStr
+			if d.tempCount == 0 || outTemp.Float64 < d.minTemp {
+				d.minTemp, d.minTempTime = outTemp.Float64, epoch
 			}
+			d.tempCount++
+			d.tempSum += outTemp.Float64
 		}
 		if rain.Valid {
-			agg.rainTotal += rain.Float64
+			d.rain += rain.Float64
+			d.rainCount++
 		}
 		if windSpeed.Valid {
-			agg.windAvgSum += windSpeed.Float64
-			agg.windCount++
-		}
-		if windGust.Valid && windGust.Float64 > agg.windMax {
-			agg.windMax = windGust.Float64
-			agg.windMaxTime = timeStr
+			d.windSum += windSpeed.Float64
+			d.windCount++
+			if windDir.Valid {
+				radians := windDir.Float64 * math.Pi / 180.0
+				d.vecEast += windSpeed.Float64 * math.Sin(radians)
+				d.vecNorth += windSpeed.Float64 * math.Cos(radians)
+			}
 		}
-		if windDir.Valid {
-			// Convert to radians and track vector components
-			radians := windDir.Float64 * math.Pi / 180.0
-			agg.windDirSinSum += math.Sin(radians)
-			agg.windDirCosSum += math.Cos(radians)
-			agg.windDirCount++
+		if windGust.Valid && windGust.Float64 > d.gustMax {
+			d.gustMax, d.gustMaxTime = windGust.Float64, epoch
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return days, nil
+}
+
+// noaaDirection formats a wind vector's direction in whole degrees
+func noaaDirection(east, north float64) int {
+	deg := math.Atan2(east, north) * 180.0 / math.Pi
+	if deg < 0 {
+		deg += 360
 	}
-	if err := rows.Err(); err != nil {
+	return int(deg + 0.5)
+}
+
+// RenderMonthlyNOAA generates text content from daily aggregates
+func RenderMonthlyNOAA(archive Archive, p NOAAMonthlyParams) (string, error) {
+	// Day boundaries follow the station's configured time zone
+	start := station.Date(p.Year, time.Month(p.Month), 1)
+	end := start.AddDate(0, 1, 0)
+
+	perDay, err := loadNOAADays(context.Background(), archive, "noaa_monthly", start, end)
+	if err != nil {
+		return "", err
+	}
+	normals, err := climateNormals.Get(context.Background())
+	if err != nil {
 		return "", err
 	}
 
 	// Check if any data exists for this month
 	hasData := false
 	for _, agg := range perDay {
-		if agg != nil && agg.count > 0 {
+		if agg.tempCount > 0 {
 			hasData = true
 			break
 		}
@@ -127,18 +203,28 @@ func RenderMonthlyNOAA(db *sql.DB, p NOAAMonthlyParams) (string, error) {
 		return "", fmt.Errorf("no data available for %s", start.Format("January 2006"))
 	}
 
-	// Compose text using provided monthly template
-	// NOTE: This is a simplified renderer; values match units used in dashboard.
+	// Compose text using provided monthly template. Aggregates stay in archive units;
+	// values are converted to p.Units as they are printed.
+	u := p.Units
+	if u.Name == "" {
+		u = displayUnits
+	}
+	tempUnit, rainUnit, windUnit := noaaUnitLabels(u)
 	monthName := start.Format("Jan 2006")
-	header := fmt.Sprintf("MONTHLY CLIMATOLOGICAL SUMMARY for %s\n\n\nNAME: %s                  \nELEV: %.0f feet    LAT: %.2f N    LONG: %.2f W\n\n\n                   TEMPERATURE (F), RAIN (in), WIND SPEED (mph)\n\n                                         HEAT   COOL         AVG\n      MEAN                               DEG    DEG          WIND                   DOM\nDAY   TEMP   HIGH   TIME    LOW   TIME   DAYS   DAYS   RAIN  SPEED   HIGH   TIME    DIR\n---------------------------------------------------------------------------------------\n",
+	// With normals, a DEP column follows MEAN: the departure of the day's (high+low)/2
+	depHead := noaaDepHeader(normals, "", "", "DEP")
+	header := fmt.Sprintf("MONTHLY CLIMATOLOGICAL SUMMARY for %s\n\n\nNAME: %s                  \nELEV: %s    LAT: %s    LONG: %s\n\n\n                   TEMPERATURE (%s), RAIN (%s), WIND SPEED (%s)\n                   HEAT BASE %s, COOL BASE %s\n\n          %s                               HEAT   COOL         AVG\n      MEAN%s                               DEG    DEG          WIND                   DOM\nDAY   TEMP%s   HIGH   TIME    LOW   TIME   DAYS   DAYS   RAIN  SPEED   HIGH   TIME    DIR\n%s---------------------------------------------------------------------------------------\n",
 		monthName,
-		appConfig.Location.Name,
-		appConfig.Location.Altitude,
-		appConfig.Location.Latitude,
-		math.Abs(appConfig.Location.Longitude))
+		station.Name,
+		noaaElevation(u),
+		station.LatString(),
+		station.LonString(),
+		tempUnit, rainUnit, windUnit,
+		noaaThresholdLabel(u, qTemp, noaaThresholds.heatingBase), noaaThresholdLabel(u, qTemp, noaaThresholds.coolingBase),
+		depHead[0], depHead[1], depHead[2], depHead[3])
 
 	lines := ""
-	daysInMonth := int(end.Add(-24 * time.Hour).Day())
+	daysInMonth := end.AddDate(0, 0, -1).Day()
 
 	// Track monthly totals for summary row
 	var monthMeanSum, monthHighSum, monthLowSum, monthRainSum, monthWindAvgSum, monthWindMaxSum float64
@@ -146,58 +232,85 @@ func RenderMonthlyNOAA(db *sql.DB, p NOAAMonthlyParams) (string, error) {
 	var monthWindDirSinSum, monthWindDirCosSum float64
 	var monthWindDirCount int
 	var monthHeatDegDays, monthCoolDegDays float64
+	// The month as a whole, for the precipitation table
+	var month noaaPeriod
+	// Departures from normal, summed over the days with both data and normals
+	var depMeanSum, depHighSum, depLowSum, depHeat, depCool, depRain float64
+	var depDays int
 
 	for d := 1; d <= daysInMonth; d++ {
-		agg := perDay[d]
-		if agg == nil || agg.count == 0 {
-			lines += fmt.Sprintf("%3d     --     --     --     --     --    --     --     --      --     --     --     --\n", d)
+		date := station.Date(p.Year, time.Month(p.Month), d)
+		agg := perDay[date.Unix()]
+		// Rain counts whether or not the day has temperatures
+		rain := "--"
+		if agg != nil && agg.rainCount > 0 {
+			month.addRain(date, agg, nil)
+			monthRainSum += agg.rain
+			if normals != nil {
+				if norm, ok := normals.Day(date.Unix()); ok {
+					depRain += agg.rain - norm.rain
+				}
+			}
+			rain = fmt.Sprintf("%4.2f", u.Convert(qRain, agg.rain))
+		}
+		if agg == nil || agg.tempCount == 0 {
+			lines += fmt.Sprintf("%3d     --%s     --     --     --     --    --     --   %4s      --     --     --     --\n",
+				d, noaaDep(normals, false, 0, 0), rain)
 			continue
 		}
-		mean := agg.meanTempSum / float64(agg.count)
+		mean := agg.tempSum / float64(agg.tempCount)
 		avgWind := 0.0
 		if agg.windCount > 0 {
-			avgWind = agg.windAvgSum / float64(agg.windCount)
+			avgWind = agg.windSum / float64(agg.windCount)
 			monthWindAvgSum += avgWind
 			monthWindDays++
 		}
 		domDir := 0
-		if agg.windDirCount > 0 {
-			// Vector average for wind direction
-			avgSin := agg.windDirSinSum / float64(agg.windDirCount)
-			avgCos := agg.windDirCosSum / float64(agg.windDirCount)
-			domDirRad := math.Atan2(avgSin, avgCos)
-			domDirDeg := domDirRad * 180.0 / math.Pi
-			if domDirDeg < 0 {
-				domDirDeg += 360
-			}
-			domDir = int(domDirDeg + 0.5)
+		if east, north, ok := agg.windVector(); ok {
+			domDir = noaaDirection(east, north)
 			// Accumulate for monthly summary
-			monthWindDirSinSum += avgSin
-			monthWindDirCosSum += avgCos
+			monthWindDirSinSum += east
+			monthWindDirCosSum += north
 			monthWindDirCount++
 		}
 
-		// Calculate degree days (base 65°F)
 		dailyAvgTemp := (agg.maxTemp + agg.minTemp) / 2.0
-		if dailyAvgTemp < 65.0 {
-			agg.heatDegDays = 65.0 - dailyAvgTemp
-		}
-		if dailyAvgTemp > 65.0 {
-			agg.coolDegDays = dailyAvgTemp - 65.0
-		}
+		heatDegDays, coolDegDays := degreeDays(dailyAvgTemp)
+		month.add(date, agg, nil)
 
 		// Accumulate for summary
 		monthMeanSum += mean
 		monthHighSum += agg.maxTemp
 		monthLowSum += agg.minTemp
-		monthRainSum += agg.rainTotal
-		monthWindMaxSum += agg.windMax
+		monthWindMaxSum += agg.gustMax
 		monthDaysWithData++
-		monthHeatDegDays += agg.heatDegDays
-		monthCoolDegDays += agg.coolDegDays
+		monthHeatDegDays += heatDegDays
+		monthCoolDegDays += coolDegDays
+
+		var norm dayNormal
+		hasNormal := false
+		if normals != nil {
+			norm, hasNormal = normals.Day(date.Unix())
+		}
+		if hasNormal {
+			depMeanSum += dailyAvgTemp - norm.mean
+			depHighSum += agg.maxTemp - norm.high
+			depLowSum += agg.minTemp - norm.low
+			depHeat += heatDegDays - norm.heatDeg
+			depCool += coolDegDays - norm.coolDeg
+			depDays++
+		}
 
-		lines += fmt.Sprintf("%3d   %4.1f  %5.1f  %5s  %5.1f  %5s  %4.0f   %4.0f   %4.2f    %4.1f   %4.1f  %5s  %5d\n",
-			d, mean, agg.maxTemp, agg.maxTempTime, agg.minTemp, agg.minTempTime, agg.heatDegDays, agg.coolDegDays, agg.rainTotal, avgWind, agg.windMax, agg.windMaxTime, domDir)
+		gustTime := ""
+		if agg.gustMaxTime != 0 {
+			gustTime = station.In(agg.gustMaxTime).Format("15:04")
+		}
+		lines += fmt.Sprintf("%3d   %4.1f%s  %5.1f  %5s  %5.1f  %5s  %4.0f   %4.0f   %4s    %4.1f   %4.1f  %5s  %5d\n",
+			d, u.Convert(qTemp, mean), noaaDep(normals, hasNormal, 1, u.Convert(qTempDelta, dailyAvgTemp-norm.mean)),
+			u.Convert(qTemp, agg.maxTemp), station.In(agg.maxTempTime).Format("15:04"),
+			u.Convert(qTemp, agg.minTemp), station.In(agg.minTempTime).Format("15:04"),
+			u.Convert(qTempDelta, heatDegDays), u.Convert(qTempDelta, coolDegDays), rain,
+			u.Convert(qSpeed, avgWind), u.Convert(qSpeed, agg.gustMax), gustTime, domDir)
 	}
 
 	// Calculate summary row means
@@ -211,383 +324,473 @@ func RenderMonthlyNOAA(db *sql.DB, p NOAAMonthlyParams) (string, error) {
 	summaryWindMax := monthWindMaxSum / float64(monthDaysWithData)
 	summaryWindDir := "--"
 	if monthWindDirCount > 0 {
-		avgSin := monthWindDirSinSum / float64(monthWindDirCount)
-		avgCos := monthWindDirCosSum / float64(monthWindDirCount)
-		domDirRad := math.Atan2(avgSin, avgCos)
-		domDirDeg := domDirRad * 180.0 / math.Pi
-		if domDirDeg < 0 {
-			domDirDeg += 360
-		}
-		summaryWindDir = fmt.Sprintf("%3d", int(domDirDeg+0.5))
+		summaryWindDir = fmt.Sprintf("%3d", noaaDirection(monthWindDirSinSum, monthWindDirCosSum))
+	}
+
+	depMean := 0.0
+	if depDays > 0 {
+		depMean = depMeanSum / float64(depDays)
+	}
+	footer := depHead[3] + "---------------------------------------------------------------------------------------\n" +
+		fmt.Sprintf("      %4.1f%s  %5.1f         %5.1f         %4.0f   %4.0f   %4.2f    %4.1f   %4.1f           %3s\n",
+			u.Convert(qTemp, summaryMean), noaaDep(normals, depDays > 0, 1, u.Convert(qTempDelta, depMean)),
+			u.Convert(qTemp, summaryHigh), u.Convert(qTemp, summaryLow),
+			u.Convert(qTempDelta, monthHeatDegDays), u.Convert(qTempDelta, monthCoolDegDays), u.Convert(qRain, monthRainSum),
+			u.Convert(qSpeed, summaryWindAvg), u.Convert(qSpeed, summaryWindMax), summaryWindDir)
+
+	// The HIGH and LOW departures are of the means of the daily highs and lows; degree
+	// days and rain are totals over the days with data
+	if normals != nil && depDays > 0 {
+		footer += fmt.Sprintf("DEP FROM NORM      %s         %s         %s   %s  %s\n",
+			noaaSigned(5, 1, u.Convert(qTempDelta, depHighSum/float64(depDays))),
+			noaaSigned(5, 1, u.Convert(qTempDelta, depLowSum/float64(depDays))),
+			noaaSigned(4, 0, u.Convert(qTempDelta, depHeat)), noaaSigned(4, 0, u.Convert(qTempDelta, depCool)),
+			noaaSigned(5, 2, u.Convert(qRain, depRain)))
 	}
 
-	footer := "---------------------------------------------------------------------------------------\n" +
-		fmt.Sprintf("      %4.1f  %5.1f         %5.1f         %4.0f   %4.0f   %4.2f    %4.1f   %4.1f           %3s\n",
-			summaryMean, summaryHigh, summaryLow, monthHeatDegDays, monthCoolDegDays, monthRainSum, summaryWindAvg, summaryWindMax, summaryWindDir)
+	// Rain day counts, as in the yearly report
+	precip := noaaPrecipitationTable(u, nil)
+	footer += fmt.Sprintf("\n\n                  PRECIPITATION (%s)\n\n", rainUnit) +
+		precip.header(" YR  MO") +
+		precip.row(fmt.Sprintf("%4d %02d", p.Year, p.Month), month.precipitationCells(u, nil, "2")...)
+
+	if normals != nil {
+		footer += "\nNORMALS: " + normals.Source + "\n"
+	}
 
 	return header + lines + footer, nil
 }
 
-// RenderYearlyNOAA generates simplified yearly summary
-func RenderYearlyNOAA(db *sql.DB, p NOAAYearlyParams) (string, error) {
-	// Use MST7MDT timezone (Arizona local time, UTC-7)
-	loc, err := time.LoadLocation("America/Phoenix")
-	if err != nil {
-		// Fallback to system local time if timezone not available
-		loc = time.Now().Location()
-	}
-	start := time.Date(p.Year, 1, 1, 0, 0, 0, 0, loc)
-	end := time.Date(p.Year+1, 1, 1, 0, 0, 0, 0, loc)
-	rows, err := db.Query(`
-		SELECT dateTime, outTemp, rain, windSpeed, windGust, windDir
-		FROM archive
-		WHERE dateTime >= ? AND dateTime < ?
-		ORDER BY dateTime ASC
-	`, start.Unix(), end.Unix())
-	if err != nil {
-		return "", err
+// noaaPeriod aggregates the days of a month or a year for the summary tables, in
+// archive units. Ties keep the earliest day.
+type noaaPeriod struct {
+	days                     int
+	maxTempSum, minTempSum   float64
+	hiTemp, lowTemp          float64
+	hiTempOn, lowTempOn      time.Time
+	heatDegDays, coolDegDays float64
+	maxAbove, maxBelow       int    // days with a high >= / <= the thresholds
+	minBelow                 [2]int // days with a low <= each threshold
+	rainObsDays              int    // days with rain readings, temperatures or not
+	rain, maxRain            float64
+	maxRainOn                time.Time
+	rainDays                 [3]int // days with rain >= each threshold
+	windAvgSum               float64
+	windDays                 int
+	gustMax                  float64
+	gustMaxOn                time.Time
+	vecEast, vecNorth        float64 // sums of the daily mean wind vectors
+	vecDays                  int
+	depMeanSum, depRain      float64 // departures of (high+low)/2 and rain from normal
+	depDays, depRainDays     int
+}
+
+// add folds in the temperatures and wind of a day with temperature data
+func (a *noaaPeriod) add(date time.Time, d *noaaDay, normals *Normals) {
+	t := noaaThresholds
+	first := a.days == 0
+	a.days++
+	a.maxTempSum += d.maxTemp
+	a.minTempSum += d.minTemp
+	if first || d.maxTemp > a.hiTemp {
+		a.hiTemp, a.hiTempOn = d.maxTemp, date
+	}
+	if first || d.minTemp < a.lowTemp {
+		a.lowTemp, a.lowTempOn = d.minTemp, date
 	}
-	defer rows.Close()
-
-	// First pass: aggregate by day to get daily highs and lows
-	type dayAgg struct {
-		maxTemp, minTemp float64
-		count            int
-		rainTotal        float64
-		windAvgSum       float64
-		windCount        int
-		windMax          float64
-		windDirSinSum    float64
-		windDirCosSum    float64
-		windDirCount     int
-	}
-	// Map key: "YYYYMMDD" (year, month, day combined)
-	perDay := map[string]*dayAgg{}
-
-	for rows.Next() {
-		var epoch int64
-		var outTemp, rain, windSpeed, windGust, windDir sql.NullFloat64
-		if err := rows.Scan(&epoch, &outTemp, &rain, &windSpeed, &windGust, &windDir); err != nil {
-			return "", err
+	mean := (d.maxTemp + d.minTemp) / 2
+	heat, cool := degreeDays(mean)
+	a.heatDegDays += heat
+	a.coolDegDays += cool
+	if d.maxTemp >= fromUSThreshold(qTemp, t.maxAbove) {
+		a.maxAbove++
+	}
+	if d.maxTemp <= fromUSThreshold(qTemp, t.maxBelow) {
+		a.maxBelow++
+	}
+	for i, v := range t.minBelow {
+		if d.minTemp <= fromUSThreshold(qTemp, v) {
+			a.minBelow[i]++
 		}
-		ts := time.Unix(epoch, 0).In(loc)
-		dayKey := fmt.Sprintf("%04d%02d%02d", ts.Year(), ts.Month(), ts.Day())
+	}
 
-		agg := perDay[dayKey]
-		if agg == nil {
-			agg = &dayAgg{minTemp: 9999}
-			perDay[dayKey] = agg
-		}
+	if d.windCount > 0 {
+		a.windAvgSum += d.windSum / float64(d.windCount)
+		a.windDays++
+	}
+	if d.gustMax > a.gustMax {
+		a.gustMax, a.gustMaxOn = d.gustMax, date
+	}
+	if east, north, ok := d.windVector(); ok {
+		a.vecEast += east
+		a.vecNorth += north
+		a.vecDays++
+	}
 
-		if outTemp.Valid {
-			agg.count++
-			if outTemp.Float64 > agg.maxTemp {
-				agg.maxTemp = outTemp.Float64
-			}
-			if outTemp.Float64 < agg.minTemp {
-				agg.minTemp = outTemp.Float64
-			}
-		}
-		if rain.Valid {
-			agg.rainTotal += rain.Float64
-		}
-		if windSpeed.Valid {
-			agg.windAvgSum += windSpeed.Float64
-			agg.windCount++
-		}
-		if windGust.Valid && windGust.Float64 > agg.windMax {
-			agg.windMax = windGust.Float64
-		}
-		if windDir.Valid {
-			// Convert to radians and track vector components
-			radians := windDir.Float64 * math.Pi / 180.0
-			agg.windDirSinSum += math.Sin(radians)
-			agg.windDirCosSum += math.Cos(radians)
-			agg.windDirCount++
+	if normals != nil {
+		if norm, ok := normals.Day(date.Unix()); ok {
+			a.depMeanSum += mean - norm.mean
+			a.depDays++
 		}
 	}
+}
 
-	// Second pass: aggregate daily values into monthly buckets
-	type monAgg struct {
-		maxTempSum    float64 // Sum of daily highs
-		minTempSum    float64 // Sum of daily lows
-		daysWithData  int     // Number of days with temp data
-		rainTotal     float64
-		windMax       float64
-		windMaxDay    int     // Day of max wind gust
-		windAvgSum    float64 // Sum of daily average wind speeds
-		windDays      int     // Days with wind data
-		windDirSinSum float64 // Vector sum for direction
-		windDirCosSum float64 // Vector sum for direction
-		windDirCount  int     // Count for direction averaging
-		hiTemp        float64 // Highest temperature in month
-		hiTempDay     int     // Day of highest temperature
-		lowTemp       float64 // Lowest temperature in month
-		lowTempDay    int     // Day of lowest temperature
-		daysMaxGE90   int     // Days with max temp >= 90°F
-		daysMaxLE32   int     // Days with max temp <= 32°F
-		daysMinLE32   int     // Days with min temp <= 32°F
-		daysMinLE0    int     // Days with min temp <= 0°F
-		maxDailyRain  float64 // Highest daily rainfall
-		maxRainDay    int     // Day of highest rainfall
-		rainDaysGE01  int     // Days with >= 0.01 inches
-		rainDaysGE10  int     // Days with >= 0.10 inches
-		rainDaysGE100 int     // Days with >= 1.00 inches
-		heatDegDays   float64 // Heating degree days (base 65°F)
-		coolDegDays   float64 // Cooling degree days (base 65°F)
-	}
-	months := make([]monAgg, 13)
-	for i := range months {
-		months[i].lowTemp = 9999 // Initialize to high value
-	}
-
-	for dayKey, dayData := range perDay {
-		if dayData.count == 0 {
-			continue
-		}
-		// Extract month and day from dayKey (format: YYYYMMDD)
-		m := int(dayKey[4]-'0')*10 + int(dayKey[5]-'0')
-		d := int(dayKey[6]-'0')*10 + int(dayKey[7]-'0')
-
-		agg := &months[m]
-		agg.maxTempSum += dayData.maxTemp
-		agg.minTempSum += dayData.minTemp
-		agg.daysWithData++
-		agg.rainTotal += dayData.rainTotal
-		if dayData.windMax > agg.windMax {
-			agg.windMax = dayData.windMax
-			agg.windMaxDay = d
-		}
-		// Track average wind speed
-		if dayData.windCount > 0 {
-			dailyAvgWind := dayData.windAvgSum / float64(dayData.windCount)
-			agg.windAvgSum += dailyAvgWind
-			agg.windDays++
-		}
-		// Track wind direction vectors
-		if dayData.windDirCount > 0 {
-			avgSin := dayData.windDirSinSum / float64(dayData.windDirCount)
-			avgCos := dayData.windDirCosSum / float64(dayData.windDirCount)
-			agg.windDirSinSum += avgSin
-			agg.windDirCosSum += avgCos
-			agg.windDirCount++
-		}
-		// Track highest temperature for the month
-		if dayData.maxTemp > agg.hiTemp {
-			agg.hiTemp = dayData.maxTemp
-			agg.hiTempDay = d
-		}
-		// Track lowest temperature for the month
-		if dayData.minTemp < agg.lowTemp {
-			agg.lowTemp = dayData.minTemp
-			agg.lowTempDay = d
-		}
-		// Track max daily rainfall
-		if dayData.rainTotal > agg.maxDailyRain {
-			agg.maxDailyRain = dayData.rainTotal
-			agg.maxRainDay = d
-		}
-		// Count rain days by threshold
-		if dayData.rainTotal >= 0.01 {
-			agg.rainDaysGE01++
-		}
-		if dayData.rainTotal >= 0.10 {
-			agg.rainDaysGE10++
-		}
-		if dayData.rainTotal >= 1.00 {
-			agg.rainDaysGE100++
-		}
-		// Count temperature threshold days
-		if dayData.maxTemp >= 90.0 {
-			agg.daysMaxGE90++
-		}
-		if dayData.maxTemp <= 32.0 {
-			agg.daysMaxLE32++
-		}
-		if dayData.minTemp <= 32.0 {
-			agg.daysMinLE32++
-		}
-		if dayData.minTemp <= 0.0 {
-			agg.daysMinLE0++
-		}
-		// Calculate degree days (base 65°F)
-		dailyAvgTemp := (dayData.maxTemp + dayData.minTemp) / 2.0
-		if dailyAvgTemp < 65.0 {
-			agg.heatDegDays += 65.0 - dailyAvgTemp
+// addRain folds in a day with rain readings, whether or not it has temperatures
+func (a *noaaPeriod) addRain(date time.Time, d *noaaDay, normals *Normals) {
+	a.rainObsDays++
+	a.rain += d.rain
+	if d.rain > a.maxRain {
+		a.maxRain, a.maxRainOn = d.rain, date
+	}
+	for i, v := range noaaThresholds.rain {
+		// Day totals are sums of readings, so allow for float error: ten 0.01s make 0.10
+		if d.rain >= fromUSThreshold(qRain, v)-1e-9 {
+			a.rainDays[i]++
 		}
-		if dailyAvgTemp > 65.0 {
-			agg.coolDegDays += dailyAvgTemp - 65.0
+	}
+	if normals != nil {
+		if norm, ok := normals.Day(date.Unix()); ok {
+			a.depRain += d.rain - norm.rain
+			a.depRainDays++
 		}
 	}
-	if err := rows.Err(); err != nil {
-		return "", err
+}
+
+// noaaDashes returns n "--" cells for a period without data
+func noaaDashes(n int) []string {
+	cells := make([]string, n)
+	for i := range cells {
+		cells[i] = "--"
 	}
+	return cells
+}
 
-	// Check if any data exists for this year
-	hasData := false
-	for m := 1; m <= 12; m++ {
-		if months[m].daysWithData > 0 {
-			hasData = true
-			break
+// noaaDate formats the date of an extreme with layout: "2" (day of month) in month
+// rows, "Jan" in the year row
+func noaaDate(t time.Time, layout string) string {
+	if t.IsZero() {
+		return "--"
+	}
+	return t.Format(layout)
+}
+
+// noaaTemperatureTable is the yearly report's temperature table; with normals, a DEP.
+// FROM NORM column follows MEAN
+func noaaTemperatureTable(u UnitSystem, normals *Normals) *noaaTable {
+	t := noaaThresholds
+	tbl := &noaaTable{label: 7}
+	tbl.add(5, "MEAN", "MAX")
+	tbl.add(5, "MEAN", "MIN")
+	tbl.add(5, "MEAN")
+	if normals != nil {
+		tbl.add(5, "DEP.", "FROM", "NORM")
+	}
+	tbl.add(5, "HEAT", "DEG", "DAYS")
+	tbl.add(5, "COOL", "DEG", "DAYS")
+	tbl.add(5, "HI")
+	tbl.add(3, "DAY")
+	tbl.add(5, "LOW")
+	tbl.add(3, "DAY")
+	tbl.add(5, "MAX", ">=", noaaThresholdLabel(u, qTemp, t.maxAbove))
+	tbl.add(5, "MAX", "<=", noaaThresholdLabel(u, qTemp, t.maxBelow))
+	for _, v := range t.minBelow {
+		tbl.add(5, "MIN", "<=", noaaThresholdLabel(u, qTemp, v))
+	}
+	return tbl
+}
+
+func (a *noaaPeriod) temperatureCells(u UnitSystem, normals *Normals, layout string) []string {
+	n := 13
+	if normals != nil {
+		n++
+	}
+	if a.days == 0 {
+		return noaaDashes(n)
+	}
+	days := float64(a.days)
+	meanMax, meanMin := a.maxTempSum/days, a.minTempSum/days
+	temp := func(v float64) string { return fmt.Sprintf("%.1f", u.Convert(qTemp, v)) }
+	cells := []string{temp(meanMax), temp(meanMin), temp((meanMax + meanMin) / 2)}
+	if normals != nil {
+		dep := "--"
+		if a.depDays > 0 {
+			dep = noaaSigned(0, 1, u.Convert(qTempDelta, a.depMeanSum/float64(a.depDays)))
+		}
+		cells = append(cells, dep)
+	}
+	return append(cells,
+		fmt.Sprintf("%.0f", u.Convert(qTempDelta, a.heatDegDays)), fmt.Sprintf("%.0f", u.Convert(qTempDelta, a.coolDegDays)),
+		temp(a.hiTemp), noaaDate(a.hiTempOn, layout), temp(a.lowTemp), noaaDate(a.lowTempOn, layout),
+		strconv.Itoa(a.maxAbove), strconv.Itoa(a.maxBelow), strconv.Itoa(a.minBelow[0]), strconv.Itoa(a.minBelow[1]))
+}
+
+// noaaPrecipitationTable is the precipitation table of both reports; with normals, a
+// DEP. FROM NORM column follows TOTAL
+func noaaPrecipitationTable(u UnitSystem, normals *Normals) *noaaTable {
+	tbl := &noaaTable{label: 7}
+	tbl.add(6, "TOTAL")
+	if normals != nil {
+		tbl.add(6, "DEP.", "FROM", "NORM")
+	}
+	tbl.add(6, "MAX", "OBS.", "DAY")
+	tbl.add(4, "DATE")
+	for _, v := range noaaThresholds.rain {
+		tbl.add(5, "DAYS", ">=", noaaThresholdLabel(u, qRain, v))
+	}
+	return tbl
+}
+
+func (a *noaaPeriod) precipitationCells(u UnitSystem, normals *Normals, layout string) []string {
+	n := 6
+	if normals != nil {
+		n++
+	}
+	if a.rainObsDays == 0 {
+		return noaaDashes(n)
+	}
+	prec := u.Precision(qRain)
+	rain := func(v float64) string { return fmt.Sprintf("%.*f", prec, u.Convert(qRain, v)) }
+	cells := []string{rain(a.rain)}
+	if normals != nil {
+		dep := "--"
+		if a.depRainDays > 0 {
+			dep = noaaSigned(0, prec, u.Convert(qRain, a.depRain))
 		}
+		cells = append(cells, dep)
 	}
-	if !hasData {
-		return "", fmt.Errorf("no data available for year %d", p.Year)
+	cells = append(cells, rain(a.maxRain), noaaDate(a.maxRainOn, layout))
+	for _, n := range a.rainDays {
+		cells = append(cells, strconv.Itoa(n))
 	}
+	return cells
+}
 
-	header := fmt.Sprintf("CLIMATOLOGICAL SUMMARY for year %d\n\n\nNAME: %s                  \nELEV: %.0f feet    LAT: %.2f N    LONG: %.2f W\n\n\n                                       TEMPERATURE (F)\n\n                              HEAT    COOL                              MAX    MAX    MIN    MIN\n          MEAN   MEAN         DEG     DEG                                >=     <=     <=     <=\n YR  MO   MAX    MIN    MEAN  DAYS    DAYS      HI  DAY     LOW  DAY     90     32     32      0\n------------------------------------------------------------------------------------------------\n",
-		p.Year,
-		appConfig.Location.Name,
-		appConfig.Location.Altitude,
-		appConfig.Location.Latitude,
-		math.Abs(appConfig.Location.Longitude))
-	lines := ""
+// noaaWindTable is the yearly report's wind table
+func noaaWindTable() *noaaTable {
+	tbl := &noaaTable{label: 7}
+	tbl.add(5, "AVG")
+	tbl.add(5, "HI")
+	tbl.add(4, "DATE")
+	tbl.add(3, "DOM", "DIR")
+	return tbl
+}
 
-	// Track yearly totals for summary row
-	var yearMaxSum, yearMinSum, yearMeanSum, yearRainSum, yearWindMaxSum float64
-	var yearMonthsWithData int
-	var yearDaysMaxGE90, yearDaysMaxLE32, yearDaysMinLE32, yearDaysMinLE0 int
-	var yearHiTemp float64
-	var yearLowTemp float64 = 9999
-	var yearMaxDailyRain float64
-	var yearRainDaysGE01, yearRainDaysGE10, yearRainDaysGE100 int
-	var yearWindAvgSum float64
-	var yearWindMonths int
-	var yearWindDirSinSum, yearWindDirCosSum float64
-	var yearWindDirCount int
-	var yearHeatDegDays, yearCoolDegDays float64
+func (a *noaaPeriod) windCells(u UnitSystem, layout string) []string {
+	if a.days == 0 {
+		return noaaDashes(4)
+	}
+	avg, dir := "--", "--"
+	if a.windDays > 0 {
+		avg = fmt.Sprintf("%.1f", u.Convert(qSpeed, a.windAvgSum/float64(a.windDays)))
+	}
+	if a.vecDays > 0 && (a.vecEast != 0 || a.vecNorth != 0) {
+		dir = strconv.Itoa(noaaDirection(a.vecEast, a.vecNorth))
+	}
+	return []string{avg, fmt.Sprintf("%.1f", u.Convert(qSpeed, a.gustMax)), noaaDate(a.gustMaxOn, layout), dir}
+}
 
-	for m := 1; m <= 12; m++ {
-		agg := months[m]
-		if agg.daysWithData == 0 {
-			lines += fmt.Sprintf("%4d %02d     --     --      --   --     --      --   --      --   --      --     --     --     --\n", p.Year, m)
-			continue
-		}
-		// MEAN MAX = average of all daily high temperatures
-		meanMax := agg.maxTempSum / float64(agg.daysWithData)
-		// MEAN MIN = average of all daily low temperatures
-		meanMin := agg.minTempSum / float64(agg.daysWithData)
-		// MEAN = average of MEAN MAX and MEAN MIN
-		mean := (meanMax + meanMin) / 2.0
+// RenderYearlyNOAA generates the yearly summary: temperature, precipitation and wind
+// tables with a row per month and a row for the year, whose dates are months
+func RenderYearlyNOAA(archive Archive, p NOAAYearlyParams) (string, error) {
+	// Day boundaries follow the station's configured time zone
+	start := station.Date(p.Year, 1, 1)
+	end := station.Date(p.Year+1, 1, 1)
+	perDay, err := loadNOAADays(context.Background(), archive, "noaa_yearly", start, end)
+	if err != nil {
+		return "", err
+	}
+	normals, err := climateNormals.Get(context.Background())
+	if err != nil {
+		return "", err
+	}
 
-		// Accumulate for summary
-		yearMaxSum += meanMax
-		yearMinSum += meanMin
-		yearMeanSum += mean
-		yearRainSum += agg.rainTotal
-		yearWindMaxSum += agg.windMax
-		yearMonthsWithData++
-		yearDaysMaxGE90 += agg.daysMaxGE90
-		yearDaysMaxLE32 += agg.daysMaxLE32
-		yearDaysMinLE32 += agg.daysMinLE32
-		yearDaysMinLE0 += agg.daysMinLE0
-		yearRainDaysGE01 += agg.rainDaysGE01
-		yearRainDaysGE10 += agg.rainDaysGE10
-		yearRainDaysGE100 += agg.rainDaysGE100
-		yearHeatDegDays += agg.heatDegDays
-		yearCoolDegDays += agg.coolDegDays
-		if agg.windDays > 0 {
-			monthlyAvgWind := agg.windAvgSum / float64(agg.windDays)
-			yearWindAvgSum += monthlyAvgWind
-			yearWindMonths++
+	// In date order, so ties go to the earliest day
+	var months [13]noaaPeriod
+	var year noaaPeriod
+	for _, day := range slices.Sorted(maps.Keys(perDay)) {
+		d := perDay[day]
+		date := station.In(day)
+		if d.rainCount > 0 {
+			months[date.Month()].addRain(date, d, normals)
+			year.addRain(date, d, normals)
 		}
-		if agg.windDirCount > 0 {
-			avgSin := agg.windDirSinSum / float64(agg.windDirCount)
-			avgCos := agg.windDirCosSum / float64(agg.windDirCount)
-			yearWindDirSinSum += avgSin
-			yearWindDirCosSum += avgCos
-			yearWindDirCount++
-		}
-		if agg.hiTemp > yearHiTemp {
-			yearHiTemp = agg.hiTemp
-		}
-		if agg.lowTemp < yearLowTemp {
-			yearLowTemp = agg.lowTemp
-		}
-		if agg.maxDailyRain > yearMaxDailyRain {
-			yearMaxDailyRain = agg.maxDailyRain
+		if d.tempCount > 0 {
+			months[date.Month()].add(date, d, normals)
+			year.add(date, d, normals)
 		}
+	}
+	if year.days == 0 {
+		return "", fmt.Errorf("no data available for year %d", p.Year)
+	}
 
-		lines += fmt.Sprintf("%4d %02d  %5.1f  %5.1f   %5.1f  %3.0f    %3.0f   %5.1f  %3d   %5.1f  %3d     %3d    %3d    %3d    %3d\n",
-			p.Year, m, meanMax, meanMin, mean, agg.heatDegDays, agg.coolDegDays, agg.hiTemp, agg.hiTempDay, agg.lowTemp, agg.lowTempDay,
-			agg.daysMaxGE90, agg.daysMaxLE32, agg.daysMinLE32, agg.daysMinLE0)
+	u := p.Units
+	if u.Name == "" {
+		u = displayUnits
 	}
+	tempUnit, rainUnit, windUnit := noaaUnitLabels(u)
+	var b strings.Builder
+	fmt.Fprintf(&b, "CLIMATOLOGICAL SUMMARY for year %d\n\n\nNAME: %s                  \nELEV: %s    LAT: %s    LONG: %s\n\n\n",
+		p.Year, station.Name, noaaElevation(u), station.LatString(), station.LonString())
 
-	// Calculate summary row means
-	summaryMax := yearMaxSum / float64(yearMonthsWithData)
-	summaryMin := yearMinSum / float64(yearMonthsWithData)
-	summaryMean := yearMeanSum / float64(yearMonthsWithData)
-	summaryWindMax := yearWindMaxSum / float64(yearMonthsWithData)
+	monthLabel := func(m int) string { return fmt.Sprintf("%4d %02d", p.Year, m) }
+	fmt.Fprintf(&b, "                          TEMPERATURE (%s), HEAT BASE %s, COOL BASE %s\n\n", tempUnit,
+		noaaThresholdLabel(u, qTemp, noaaThresholds.heatingBase), noaaThresholdLabel(u, qTemp, noaaThresholds.coolingBase))
+	temp := noaaTemperatureTable(u, normals)
+	b.WriteString(temp.header(" YR  MO"))
+	for m := 1; m <= 12; m++ {
+		b.WriteString(temp.row(monthLabel(m), months[m].temperatureCells(u, normals, "2")...))
+	}
+	b.WriteString(temp.rule())
+	b.WriteString(temp.row("", year.temperatureCells(u, normals, "Jan")...))
 
-	footer := "------------------------------------------------------------------------------------------------\n" +
-		fmt.Sprintf("         %5.1f  %5.1f   %5.1f  %3.0f   %3.0f   %5.1f        %5.1f           %2d     %2d     %2d     %2d\n\n\n                  PRECIPITATION (in)\n\n                  MAX         ---DAYS OF RAIN---\n                  OBS.               OVER\n YR  MO  TOTAL    DAY  DATE   0.01   0.10   1.00\n------------------------------------------------\n",
-			summaryMax, summaryMin, summaryMean, yearHeatDegDays, yearCoolDegDays, yearHiTemp, yearLowTemp,
-			yearDaysMaxGE90, yearDaysMaxLE32, yearDaysMinLE32, yearDaysMinLE0)
+	fmt.Fprintf(&b, "\n\n                  PRECIPITATION (%s)\n\n", rainUnit)
+	precip := noaaPrecipitationTable(u, normals)
+	b.WriteString(precip.header(" YR  MO"))
+	for m := 1; m <= 12; m++ {
+		b.WriteString(precip.row(monthLabel(m), months[m].precipitationCells(u, normals, "2")...))
+	}
+	b.WriteString(precip.rule())
+	b.WriteString(precip.row("", year.precipitationCells(u, normals, "Jan")...))
 
-	// Add monthly precipitation rows
+	fmt.Fprintf(&b, "\n\n           WIND SPEED (%s)\n\n", windUnit)
+	wind := noaaWindTable()
+	b.WriteString(wind.header(" YR  MO"))
 	for m := 1; m <= 12; m++ {
-		agg := months[m]
-		if agg.daysWithData == 0 {
-			footer += fmt.Sprintf("%4d %02d    --      --   --     --     --    --\n", p.Year, m)
-			continue
-		}
-		footer += fmt.Sprintf("%4d %02d %5.2f   %5.2f   %2d     %2d     %2d    %2d\n",
-			p.Year, m, agg.rainTotal, agg.maxDailyRain, agg.maxRainDay,
-			agg.rainDaysGE01, agg.rainDaysGE10, agg.rainDaysGE100)
+		b.WriteString(wind.row(monthLabel(m), months[m].windCells(u, "2")...))
 	}
+	b.WriteString(wind.rule())
+	b.WriteString(wind.row("", year.windCells(u, "Jan")...))
 
-	footer += "------------------------------------------------\n" +
-		fmt.Sprintf("        %5.2f   %5.2f          %2d     %2d    %2d\n\n\n           WIND SPEED (mph)\n\n                                DOM\n YR  MO    AVG     HI   DATE    DIR\n-----------------------------------\n",
-			yearRainSum, yearMaxDailyRain,
-			yearRainDaysGE01, yearRainDaysGE10, yearRainDaysGE100)
+	if normals != nil {
+		b.WriteString("\nNORMALS: " + normals.Source + "\n")
+	}
+	return b.String(), nil
+}
 
-	// Add monthly wind rows
-	for m := 1; m <= 12; m++ {
-		agg := months[m]
-		if agg.daysWithData == 0 {
-			footer += fmt.Sprintf("%4d %02d     --     --     --    --\n", p.Year, m)
-			continue
-		}
-		monthlyAvgWind := 0.0
-		if agg.windDays > 0 {
-			monthlyAvgWind = agg.windAvgSum / float64(agg.windDays)
+// noaaTable lays out a report table: a label column, then right-aligned columns two
+// spaces apart whose headings take up to three lines
+type noaaTable struct {
+	label   int // width of the label column
+	columns []noaaColumn
+}
+
+type noaaColumn struct {
+	width   int
+	heading [3]string
+}
+
+// add appends a column; its heading lines sit at the bottom of the heading
+func (t *noaaTable) add(width int, heading ...string) {
+	var h [3]string
+	copy(h[len(h)-len(heading):], heading)
+	t.columns = append(t.columns, noaaColumn{width: width, heading: h})
+}
+
+// header returns the heading lines, with label on the last, and the rule. Blank
+// heading lines are left out.
+func (t *noaaTable) header(label string) string {
+	var b strings.Builder
+	for i := range 3 {
+		cells := make([]string, len(t.columns))
+		for j, c := range t.columns {
+			cells[j] = c.heading[i]
 		}
-		domDir := "--"
-		if agg.windDirCount > 0 {
-			avgSin := agg.windDirSinSum / float64(agg.windDirCount)
-			avgCos := agg.windDirCosSum / float64(agg.windDirCount)
-			domDirRad := math.Atan2(avgSin, avgCos)
-			domDirDeg := domDirRad * 180.0 / math.Pi
-			if domDirDeg < 0 {
-				domDirDeg += 360
-			}
-			domDir = fmt.Sprintf("%3d", int(domDirDeg+0.5))
+		if i == 2 {
+			b.WriteString(t.row(label, cells...))
+		} else if line := t.row("", cells...); strings.TrimSpace(line) != "" {
+			b.WriteString(line)
 		}
-		footer += fmt.Sprintf("%4d %02d  %5.1f  %5.1f     %2d   %3s\n",
-			p.Year, m, monthlyAvgWind, agg.windMax, agg.windMaxDay, domDir)
-	}
-
-	// Calculate yearly wind summary
-	yearlyAvgWind := 0.0
-	if yearWindMonths > 0 {
-		yearlyAvgWind = yearWindAvgSum / float64(yearWindMonths)
-	}
-	yearlyDomDir := "--"
-	if yearWindDirCount > 0 {
-		avgSin := yearWindDirSinSum / float64(yearWindDirCount)
-		avgCos := yearWindDirCosSum / float64(yearWindDirCount)
-		domDirRad := math.Atan2(avgSin, avgCos)
-		domDirDeg := domDirRad * 180.0 / math.Pi
-		if domDirDeg < 0 {
-			domDirDeg += 360
+	}
+	b.WriteString(t.rule())
+	return b.String()
+}
+
+func (t *noaaTable) row(label string, cells ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-*s", t.label, label)
+	for i, c := range t.columns {
+		cell := ""
+		if i < len(cells) {
+			cell = cells[i]
 		}
-		yearlyDomDir = fmt.Sprintf("%3d", int(domDirDeg+0.5))
+		fmt.Fprintf(&b, "  %*s", c.width, cell)
 	}
+	return strings.TrimRight(b.String(), " ") + "\n"
+}
 
-	footer += "-----------------------------------\n" +
-		fmt.Sprintf("         %5.1f  %5.1f          %3s\n",
-			yearlyAvgWind, summaryWindMax, yearlyDomDir)
-	return header + lines + footer, nil
+func (t *noaaTable) rule() string {
+	n := t.label
+	for _, c := range t.columns {
+		n += 2 + c.width
+	}
+	return strings.Repeat("-", n) + "\n"
+}
+
+// noaaDepHeader returns the three heading lines and the rule segment of a departure
+// column, or blanks without normals
+func noaaDepHeader(normals *Normals, top, middle, bottom string) [4]string {
+	if normals == nil {
+		return [4]string{}
+	}
+	return [4]string{fmt.Sprintf("%7s", top), fmt.Sprintf("%7s", middle), fmt.Sprintf("%7s", bottom), "-------"}
+}
+
+// noaaDep formats a departure column cell: nothing without normals, "--" when the
+// normal is missing, else v signed with prec decimals (7 characters wide)
+func noaaDep(normals *Normals, ok bool, prec int, v float64) string {
+	switch {
+	case normals == nil:
+		return ""
+	case !ok:
+		return "     --"
+	}
+	return "  " + noaaSigned(5, prec, v)
+}
+
+// noaaSigned formats a departure with its sign, never as "-0.0"
+func noaaSigned(width, prec int, v float64) string {
+	scale := math.Pow(10, float64(prec))
+	if math.Round(v*scale) == 0 {
+		v = 0
+	}
+	return fmt.Sprintf("%+*.*f", width, prec, v)
+}
+
+// noaaThresholdLabel formats a threshold given in US units for a heading in u, e.g.
+// "90", "0.01" or "32.2"
+func noaaThresholdLabel(u UnitSystem, q Quantity, v float64) string {
+	x := u.FromUS(q, v)
+	if q == qRain {
+		return fmt.Sprintf("%.*f", u.Precision(qRain), x)
+	}
+	if math.Abs(x-math.Round(x)) < 0.05 {
+		return fmt.Sprintf("%.0f", x)
+	}
+	return fmt.Sprintf("%.1f", x)
+}
+
+// noaaFormatVersion is bumped whenever a report's layout changes, so reports cached
+// in an older layout are regenerated rather than served
+const noaaFormatVersion = 3
+
+// noaaCacheTag ends the report cache filenames with the format version and a hash of
+// the noaa thresholds and normals settings, e.g. NOAA-2025-06-v3-1a2b3c4d.txt, so a
+// new layout or a config change misses the cache
+func noaaCacheTag() string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%+v %+v", noaaThresholds, appConfig.Normals))
+	return fmt.Sprintf("-v%d-%x", noaaFormatVersion, sum[:4])
+}
+
+// noaaUnitsSuffix gives unit systems other than US their own cache file, e.g.
+// NOAA-2025-06-metric-v3-1a2b3c4d.txt
+func noaaUnitsSuffix(u UnitSystem) string {
+	if u.Name == "" {
+		u = displayUnits
+	}
+	if u == unitsUS {
+		return ""
+	}
+	return "-" + u.Key()
 }
 
 // SaveTextFile ensures directory and writes content; returns path
@@ -603,8 +806,8 @@ func SaveTextFile(relPath string, content string) (string, error) {
 }
 
 // GetOrGenerateMonthly returns file content; generates if missing or if force=true
-func GetOrGenerateMonthly(db *sql.DB, p NOAAMonthlyParams, force bool) (string, error) {
-	filename := fmt.Sprintf("noaa/NOAA-%04d-%02d.txt", p.Year, p.Month)
+func GetOrGenerateMonthly(archive Archive, p NOAAMonthlyParams, force bool) (string, error) {
+	filename := fmt.Sprintf("noaa/NOAA-%04d-%02d%s%s.txt", p.Year, p.Month, noaaUnitsSuffix(p.Units), noaaCacheTag())
 	abs := filepath.Join("static", filename)
 
 	// If force=true, delete cached file
@@ -613,12 +816,16 @@ func GetOrGenerateMonthly(db *sql.DB, p NOAAMonthlyParams, force bool) (string,
 	}
 
 	if b, err := os.ReadFile(abs); err == nil {
+		noaaCacheRequests.Inc("monthly", "hit")
 		return string(b), nil
 	}
-	content, err := RenderMonthlyNOAA(db, p)
+	noaaCacheRequests.Inc("monthly", "generated")
+	began := time.Now()
+	content, err := RenderMonthlyNOAA(archive, p)
 	if err != nil {
 		return "", err
 	}
+	noaaGeneration.Since(began, "monthly")
 	if _, err := SaveTextFile(filename, content); err != nil {
 		log.Println("Save monthly NOAA failed:", err)
 	}
@@ -626,8 +833,8 @@ func GetOrGenerateMonthly(db *sql.DB, p NOAAMonthlyParams, force bool) (string,
 }
 
 // GetOrGenerateYearly returns file content; generates if missing or if force=true
-func GetOrGenerateYearly(db *sql.DB, p NOAAYearlyParams, force bool) (string, error) {
-	filename := fmt.Sprintf("noaa/NOAA-%04d.txt", p.Year)
+func GetOrGenerateYearly(archive Archive, p NOAAYearlyParams, force bool) (string, error) {
+	filename := fmt.Sprintf("noaa/NOAA-%04d%s%s.txt", p.Year, noaaUnitsSuffix(p.Units), noaaCacheTag())
 	abs := filepath.Join("static", filename)
 
 	// If force=true, delete cached file
@@ -636,12 +843,16 @@ func GetOrGenerateYearly(db *sql.DB, p NOAAYearlyParams, force bool) (string, er
 	}
 
 	if b, err := os.ReadFile(abs); err == nil {
+		noaaCacheRequests.Inc("yearly", "hit")
 		return string(b), nil
 	}
-	content, err := RenderYearlyNOAA(db, p)
+	noaaCacheRequests.Inc("yearly", "generated")
+	began := time.Now()
+	content, err := RenderYearlyNOAA(archive, p)
 	if err != nil {
 		return "", err
 	}
+	noaaGeneration.Since(began, "yearly")
 	if _, err := SaveTextFile(filename, content); err != nil {
 		log.Println("Save yearly NOAA failed:", err)
 	}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNOAAReportsCountRainWithoutTemperature(t *testing.T) {
+	withStationZone(t, "UTC")
+	june1 := station.Date(2024, 6, 1).Unix()
+	june2 := station.Date(2024, 6, 2).Unix()
+	newTestArchive(t, []testRecord{
+		{epoch: june1 + 3600, vals: map[string]float64{"outTemp": 70, "rain": 0.04}},
+		{epoch: june1 + 7200, vals: map[string]float64{"outTemp": 80, "rain": 0.06}},
+		// The temperature sensor is down on the 2nd
+		{epoch: june2 + 3600, vals: map[string]float64{"rain": 0.15}},
+		{epoch: june2 + 7200, vals: map[string]float64{"rain": 0.10}},
+	})
+
+	monthly, err := RenderMonthlyNOAA(archive, NOAAMonthlyParams{Year: 2024, Month: 6, Units: unitsUS})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row := noaaRow(monthly, "  2 "); len(row) != 13 || row[1] != "--" || row[8] != "0.25" {
+		t.Errorf("day 2 row %q, want rain 0.25 and no temperatures", row)
+	}
+	if row := noaaRow(monthly, "      ", " 30 "); len(row) < 6 || row[5] != "0.35" {
+		t.Errorf("summary row %q, want a rain total of 0.35", row)
+	}
+	if row := noaaRow(monthly, "2024 06", "PRECIPITATION"); len(row) < 4 || row[2] != "0.35" || row[3] != "0.25" {
+		t.Errorf("precipitation row %q, want a total of 0.35 and a max of 0.25", row)
+	}
+
+	yearly, err := RenderYearlyNOAA(archive, NOAAYearlyParams{Year: 2024, Units: unitsUS})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row := noaaRow(yearly, "2024 06", "PRECIPITATION"); len(row) < 3 || row[2] != "0.35" {
+		t.Errorf("June precipitation row %q, want a total of 0.35", row)
+	}
+	if row := noaaRow(yearly, "       ", "PRECIPITATION", "2024 12"); len(row) < 1 || row[0] != "0.35" {
+		t.Errorf("year precipitation row %q, want a total of 0.35", row)
+	}
+}
+
+// noaaRow returns the fields of the first report line starting with prefix that
+// follows a line containing each of after, in order
+func noaaRow(report, prefix string, after ...string) []string {
+	lines := strings.Split(report, "\n")
+	for _, marker := range after {
+		for len(lines) > 0 && !strings.Contains(lines[0], marker) {
+			lines = lines[1:]
+		}
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, prefix) {
+			return strings.Fields(line)
+		}
+	}
+	return nil
+}
+
+func TestNOAACacheTagFollowsSettings(t *testing.T) {
+	savedThresholds, savedConfig := noaaThresholds, appConfig
+	t.Cleanup(func() { noaaThresholds, appConfig = savedThresholds, savedConfig })
+
+	tag := noaaCacheTag()
+	if !strings.HasPrefix(tag, fmt.Sprintf("-v%d-", noaaFormatVersion)) || tag != noaaCacheTag() {
+		t.Fatalf("cache tag %q, want a stable -v%d-<hash>", tag, noaaFormatVersion)
+	}
+	noaaThresholds.heatingBase = 60
+	withThresholds := noaaCacheTag()
+	if withThresholds == tag {
+		t.Error("changing a threshold kept the cache tag")
+	}
+	appConfig.Normals.File = "normals.csv"
+	if noaaCacheTag() == withThresholds {
+		t.Error("configuring normals kept the cache tag")
+	}
+}
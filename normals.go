@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Climate normals put the numbers in context: statistics and the NOAA reports show
+// how far today, the month so far and each month depart from them. They are
+// imported from an NCEI 1991-2020 daily normals file for the nearest official
+// station, or derived from the archive once it spans enough years.
+
+// dayNormal is the normal weather of one calendar day, in archive units
+type dayNormal struct {
+	high, low, mean  float64
+	rain             float64
+	heatDeg, coolDeg float64
+	ok               bool
+}
+
+// Normals holds the normals of each calendar day, indexed by normalIndex
+type Normals struct {
+	// Where they came from, e.g. "NCEI 1991-2020 normals, SEATTLE TACOMA AIRPORT, WA US (USW00024233)"
+	Source string
+	days   [366]dayNormal
+}
+
+// normalIndex numbers calendar days as in a leap year, so March 1 is always 60
+func normalIndex(month time.Month, day int) int {
+	return time.Date(2000, month, day, 0, 0, 0, 0, time.UTC).YearDay() - 1
+}
+
+// Day returns the normals of the station day starting at epoch
+func (n *Normals) Day(epoch int64) (dayNormal, bool) {
+	t := station.In(epoch)
+	d := n.days[normalIndex(t.Month(), t.Day())]
+	return d, d.ok
+}
+
+// nceiValue parses an NCEI normals value. -7777 is a trace of precipitation; -5555
+// and below flag missing or suppressed values.
+func nceiValue(s string) (float64, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	switch {
+	case err != nil:
+		return 0, false
+	case v == -7777:
+		return 0, true
+	case v <= -5555:
+		return 0, false
+	}
+	return v, true
+}
+
+// loadNCEINormals reads an NCEI 1991-2020 daily normals CSV: the per-station "access"
+// file with a row per MM-DD and DLY-TMAX-NORMAL, DLY-TMIN-NORMAL, MTD-PRCP-NORMAL, ...
+// columns. Its values are in °F and inches. Days missing a temperature or
+// precipitation normal are left out.
+func loadNCEINormals(path string) (*Normals, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	col := make(map[string]int, len(header))
+	for i, h := range header {
+		col[strings.TrimSpace(h)] = i
+	}
+	for _, name := range []string{"DATE", "DLY-TMAX-NORMAL", "DLY-TMIN-NORMAL"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("%s: no %s column (use the station's daily normals file)", path, name)
+		}
+	}
+	_, dailyRain := col["DLY-PRCP-NORMAL"]
+	if _, ok := col["MTD-PRCP-NORMAL"]; !ok && !dailyRain {
+		return nil, fmt.Errorf("%s: no MTD-PRCP-NORMAL column", path)
+	}
+	field := func(rec []string, name string) (float64, bool) {
+		i, ok := col[name]
+		if !ok || i >= len(rec) {
+			return 0, false
+		}
+		return nceiValue(rec[i])
+	}
+	text := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	n := &Normals{}
+	var monthToDate [366]float64
+	var hasMonthToDate [366]bool
+	var id, name string
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		// 2000 is a leap year, so 02-29 parses
+		date, err := time.Parse("2006-01-02", "2000-"+text(rec, "DATE"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: DATE %q is not MM-DD", path, text(rec, "DATE"))
+		}
+		i := normalIndex(date.Month(), date.Day())
+		id, name = text(rec, "STATION"), text(rec, "NAME")
+
+		if v, ok := field(rec, "MTD-PRCP-NORMAL"); ok {
+			monthToDate[i], hasMonthToDate[i] = v, true
+		}
+		high, okHigh := field(rec, "DLY-TMAX-NORMAL")
+		low, okLow := field(rec, "DLY-TMIN-NORMAL")
+		if !okHigh || !okLow {
+			continue
+		}
+		mean, ok := field(rec, "DLY-TAVG-NORMAL")
+		if !ok {
+			mean = (high + low) / 2
+		}
+		d := dayNormal{
+			high: fromUSThreshold(qTemp, high),
+			low:  fromUSThreshold(qTemp, low),
+			mean: fromUSThreshold(qTemp, mean),
+			ok:   true,
+		}
+		// The file's degree days are to base 65 °F
+		d.heatDeg, d.coolDeg = degreeDays(d.mean)
+		if v, ok := field(rec, "DLY-HTDD-NORMAL"); ok && noaaThresholds.heatingBase == 65 {
+			d.heatDeg = fromUSThreshold(qTempDelta, v)
+		}
+		if v, ok := field(rec, "DLY-CLDD-NORMAL"); ok && noaaThresholds.coolingBase == 65 {
+			d.coolDeg = fromUSThreshold(qTempDelta, v)
+		}
+		if dailyRain {
+			rain, ok := field(rec, "DLY-PRCP-NORMAL")
+			d.rain, d.ok = fromUSThreshold(qRain, rain), ok
+		}
+		n.days[i] = d
+	}
+
+	// The file's precipitation is usually month-to-date; a day's normal is the
+	// increase over the day before
+	if !dailyRain {
+		for i := range n.days {
+			date := time.Date(2000, 1, i+1, 0, 0, 0, 0, time.UTC)
+			switch {
+			case !hasMonthToDate[i]:
+				n.days[i].ok = false
+			case date.Day() == 1:
+				n.days[i].rain = fromUSThreshold(qRain, monthToDate[i])
+			case hasMonthToDate[i-1]:
+				n.days[i].rain = fromUSThreshold(qRain, max(monthToDate[i]-monthToDate[i-1], 0))
+			default:
+				n.days[i].ok = false
+			}
+		}
+	}
+	// Files without February 29 use the 28th's normals for it
+	if feb28, feb29 := normalIndex(2, 28), normalIndex(2, 29); !n.days[feb29].ok {
+		n.days[feb29] = n.days[feb28]
+	}
+
+	days := 0
+	for _, d := range n.days {
+		if d.ok {
+			days++
+		}
+	}
+	if days == 0 {
+		return nil, fmt.Errorf("%s: no days with temperature and precipitation normals", path)
+	}
+	switch {
+	case name != "" && id != "":
+		n.Source = fmt.Sprintf("NCEI 1991-2020 normals, %s (%s)", name, id)
+	case id != "":
+		n.Source = "NCEI 1991-2020 normals, " + id
+	default:
+		n.Source = "NCEI 1991-2020 normals, " + filepath.Base(path)
+	}
+	return n, nil
+}
+
+// normalsWindow is how many days either side of a calendar day are pooled when
+// deriving its normals, to smooth out single years
+const normalsWindow = 15
+
+// deriveNormals averages the complete days of the archive by calendar day. It
+// returns nil until the first day is at least minYears before today.
+func (b *RecordBook) deriveNormals(minYears int) *Normals {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	type sums struct {
+		high, low, heat, cool, rain float64
+		temps, rains                int
+	}
+	var byDay [366]sums
+	today := station.Today().Unix()
+	var first, last int64
+	for _, d := range b.days {
+		if d.start >= today {
+			break
+		}
+		if !d.temp.ok {
+			continue
+		}
+		if first == 0 {
+			first = d.start
+		}
+		last = d.start
+		t := station.In(d.start)
+		s := &byDay[normalIndex(t.Month(), t.Day())]
+		heat, cool := degreeDays((d.temp.max + d.temp.min) / 2)
+		s.high += d.temp.max
+		s.low += d.temp.min
+		s.heat += heat
+		s.cool += cool
+		s.temps++
+		if d.hasRain {
+			s.rain += d.rain
+			s.rains++
+		}
+	}
+	if first == 0 || station.In(first).AddDate(minYears, 0, 0).Unix() > today {
+		return nil
+	}
+
+	n := &Normals{Source: fmt.Sprintf("archive %d-%d", station.In(first).Year(), station.In(last).Year())}
+	for i := range n.days {
+		var pooled sums
+		for j := i - normalsWindow; j <= i+normalsWindow; j++ {
+			s := byDay[(j+len(byDay))%len(byDay)]
+			pooled.high += s.high
+			pooled.low += s.low
+			pooled.heat += s.heat
+			pooled.cool += s.cool
+			pooled.temps += s.temps
+			pooled.rain += s.rain
+			pooled.rains += s.rains
+		}
+		if pooled.temps == 0 {
+			continue
+		}
+		temps := float64(pooled.temps)
+		d := dayNormal{
+			high:    pooled.high / temps,
+			low:     pooled.low / temps,
+			heatDeg: pooled.heat / temps,
+			coolDeg: pooled.cool / temps,
+			ok:      true,
+		}
+		d.mean = (d.high + d.low) / 2
+		if pooled.rains > 0 {
+			d.rain = pooled.rain / float64(pooled.rains)
+		}
+		n.days[i] = d
+	}
+	return n
+}
+
+// ClimateNormals provides the normals in use: imported from a file at startup, or
+// derived from the record book and refreshed once a day
+type ClimateNormals struct {
+	imported    *Normals
+	fromArchive bool
+	minYears    int
+
+	mu         sync.Mutex
+	derived    *Normals
+	derivedFor int64 // station day the derived normals were computed on
+}
+
+var climateNormals *ClimateNormals
+
+func NewClimateNormals(cfg NormalsConfig) (*ClimateNormals, error) {
+	c := &ClimateNormals{fromArchive: cfg.FromArchive, minYears: cfg.MinYears}
+	if cfg.File != "" {
+		n, err := loadNCEINormals(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		c.imported = n
+		log.Printf("[Normals] using %s", n.Source)
+	}
+	return c, nil
+}
+
+// Get returns the normals in use, or nil when there are none
+func (c *ClimateNormals) Get(ctx context.Context) (*Normals, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.imported != nil {
+		return c.imported, nil
+	}
+	if !c.fromArchive || recordBook == nil {
+		return nil, nil
+	}
+	if err := recordBook.Load(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if today := station.Today().Unix(); c.derivedFor != today {
+		c.derived, c.derivedFor = recordBook.deriveNormals(c.minYears), today
+		if c.derived != nil {
+			log.Printf("[Normals] derived from the %s", c.derived.Source)
+		} else {
+			log.Printf("[Normals] the archive spans less than %d years; no normals yet", c.minYears)
+		}
+	}
+	return c.derived, nil
+}
+
+// DaysFrom returns copies of the days starting at or after start, oldest first
+func (b *RecordBook) DaysFrom(start int64) []recordDay {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []recordDay
+	for _, d := range b.days {
+		if d.start >= start {
+			out = append(out, *d)
+		}
+	}
+	return out
+}
+
+// newDeparture converts a value (archive units) and its normal to units, rounded to
+// q's precision. ok is false when there's no value yet.
+func newDeparture(q Quantity, units UnitSystem, value, normal float64, ok bool) *NormalDeparture {
+	scale := math.Pow(10, float64(units.Precision(q)))
+	round := func(v float64) float64 { return math.Round(v*scale) / scale }
+	nd := &NormalDeparture{Normal: round(units.Convert(q, normal))}
+	if ok {
+		v := round(units.Convert(q, value))
+		dep := round(v - nd.Normal)
+		nd.Value, nd.Departure = &v, &dep
+	}
+	return nd
+}
+
+// statisticsNormals compares today's high and low, and the month's rain and degree
+// days so far, with the normals; nil when there are none. Degree days count the
+// complete days before today.
+func statisticsNormals(ctx context.Context, units UnitSystem) *StatisticsNormals {
+	n, err := climateNormals.Get(ctx)
+	if err == nil && n != nil && recordBook != nil {
+		err = recordBook.Load(ctx)
+	}
+	if err != nil {
+		log.Printf("[Normals] %v", err)
+		return nil
+	}
+	if n == nil || recordBook == nil {
+		return nil
+	}
+
+	today := station.Today()
+	monthStart := station.Date(today.Year(), today.Month(), 1)
+	days := make(map[int64]recordDay)
+	for _, d := range recordBook.DaysFrom(monthStart.Unix()) {
+		days[d.start] = d
+	}
+
+	out := &StatisticsNormals{Source: n.Source}
+	var rain, normalRain, heat, normalHeat, cool, normalCool float64
+	var hasRain, hasTemp bool
+	for day := monthStart; !day.After(today); day = station.NextDay(day) {
+		norm, ok := n.Day(day.Unix())
+		if !ok {
+			continue
+		}
+		d, found := days[day.Unix()]
+		normalRain += norm.rain
+		if found && d.hasRain {
+			rain += d.rain
+			hasRain = true
+		}
+		if day.Before(today) {
+			if found && d.temp.ok {
+				h, c := degreeDays((d.temp.max + d.temp.min) / 2)
+				heat, cool = heat+h, cool+c
+				normalHeat, normalCool = normalHeat+norm.heatDeg, normalCool+norm.coolDeg
+				hasTemp = true
+			}
+			continue
+		}
+		out.HighToday = newDeparture(qTemp, units, d.temp.max, norm.high, found && d.temp.ok)
+		out.LowToday = newDeparture(qTemp, units, d.temp.min, norm.low, found && d.temp.ok)
+	}
+	out.RainMonthToDate = newDeparture(qRain, units, rain, normalRain, hasRain)
+	out.HeatDegDaysMonthToDate = newDeparture(qTempDelta, units, heat, normalHeat, hasTemp)
+	out.CoolDegDaysMonthToDate = newDeparture(qTempDelta, units, cool, normalCool, hasTemp)
+	return out
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifySender delivers one alert event over a channel
+type notifySender interface {
+	Send(ctx context.Context, ev AlertEvent) error
+}
+
+// notifyChannel is a configured channel with its routing filters and delivery queue
+type notifyChannel struct {
+	cfg    NotifyChannelConfig
+	sender notifySender
+	queue  chan AlertEvent
+}
+
+var severityRank = map[string]int{"info": 0, "warning": 1, "critical": 2}
+
+// accepts reports whether the channel's own filters let ev through
+func (c *notifyChannel) accepts(ev AlertEvent) bool {
+	if severityRank[ev.Severity] < severityRank[c.cfg.MinSeverity] {
+		return false
+	}
+	if len(c.cfg.Rules) > 0 && !containsString(c.cfg.Rules, ev.RuleID) {
+		return false
+	}
+	if len(c.cfg.Events) > 0 && !containsString(c.cfg.Events, ev.Event) {
+		return false
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// quietWindow is a daily [start, end) window in minutes since station-local midnight;
+// end < start wraps past midnight
+type quietWindow struct {
+	start, end int
+}
+
+func parseQuietHours(v string) (*quietWindow, error) {
+	if v == "" {
+		return nil, nil
+	}
+	from, to, ok := strings.Cut(v, "-")
+	if !ok {
+		return nil, fmt.Errorf("quiet_hours %q: use HH:MM-HH:MM", v)
+	}
+	parse := func(s string) (int, error) {
+		t, err := time.Parse("15:04", strings.TrimSpace(s))
+		if err != nil {
+			return 0, fmt.Errorf("quiet_hours %q: use HH:MM-HH:MM", v)
+		}
+		return t.Hour()*60 + t.Minute(), nil
+	}
+	start, err := parse(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parse(to)
+	if err != nil {
+		return nil, err
+	}
+	return &quietWindow{start: start, end: end}, nil
+}
+
+func (q *quietWindow) contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	local := t.In(station.Loc)
+	m := local.Hour()*60 + local.Minute()
+	if q.start <= q.end {
+		return m >= q.start && m < q.end
+	}
+	return m >= q.start || m < q.end
+}
+
+// Notifier routes alert events to channels, applying rate limits and quiet hours, and
+// retries failed deliveries with exponential backoff. Each channel has its own queue so
+// a slow or failing channel never delays the others.
+type Notifier struct {
+	channels    []*notifyChannel
+	rateLimit   time.Duration
+	quiet       *quietWindow
+	quietMin    string
+	maxRetries  int
+	backoff     time.Duration
+	mu          sync.Mutex
+	lastFired   map[string]time.Time // channel|rule -> last delivered "fired"
+	firedQueued map[string]bool      // channel|rule -> a "fired" was queued, so "cleared" follows
+}
+
+var notifier *Notifier
+
+// NewNotifier validates the notification config and starts one delivery goroutine per channel
+func NewNotifier(cfg NotificationsConfig) (*Notifier, error) {
+	n := &Notifier{
+		quietMin:    cfg.QuietMinSeverity,
+		maxRetries:  cfg.MaxRetries,
+		lastFired:   make(map[string]time.Time),
+		firedQueued: make(map[string]bool),
+	}
+
+	var err error
+	if n.rateLimit, err = time.ParseDuration(cfg.RateLimit); err != nil {
+		return nil, fmt.Errorf("rate_limit: %w", err)
+	}
+	if n.backoff, err = time.ParseDuration(cfg.RetryBackoff); err != nil {
+		return nil, fmt.Errorf("retry_backoff: %w", err)
+	}
+	if n.quiet, err = parseQuietHours(cfg.QuietHours); err != nil {
+		return nil, err
+	}
+	if _, ok := severityRank[n.quietMin]; !ok {
+		return nil, fmt.Errorf("quiet_min_severity %q (use info, warning or critical)", n.quietMin)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	seen := make(map[string]bool)
+	for i, cc := range cfg.Channels {
+		if cc.Name == "" {
+			return nil, fmt.Errorf("channel %d: name is required", i+1)
+		}
+		if seen[cc.Name] {
+			return nil, fmt.Errorf("channel %q: duplicate name", cc.Name)
+		}
+		seen[cc.Name] = true
+		if cc.MinSeverity == "" {
+			cc.MinSeverity = "info"
+		}
+		if _, ok := severityRank[cc.MinSeverity]; !ok {
+			return nil, fmt.Errorf("channel %q: min_severity %q", cc.Name, cc.MinSeverity)
+		}
+
+		sender, err := newNotifySender(cc, client)
+		if err != nil {
+			return nil, fmt.Errorf("channel %q: %w", cc.Name, err)
+		}
+		ch := &notifyChannel{cfg: cc, sender: sender, queue: make(chan AlertEvent, 64)}
+		n.channels = append(n.channels, ch)
+		go n.deliver(ch)
+	}
+
+	if len(n.channels) > 0 {
+		log.Printf("[Notify] %d channels configured", len(n.channels))
+	}
+	return n, nil
+}
+
+func newNotifySender(cc NotifyChannelConfig, client *http.Client) (notifySender, error) {
+	switch cc.Type {
+	case "webhook":
+		if cc.URL == "" {
+			return nil, errors.New("webhook requires url")
+		}
+		return &webhookSender{url: cc.URL, secret: cc.Secret, client: client}, nil
+	case "ntfy", "gotify":
+		if cc.URL == "" {
+			return nil, fmt.Errorf("%s requires url", cc.Type)
+		}
+		return &pushSender{flavor: cc.Type, url: strings.TrimRight(cc.URL, "/"), token: cc.Token, client: client}, nil
+	case "email":
+		if cc.SMTPHost == "" || cc.From == "" || len(cc.To) == 0 {
+			return nil, errors.New("email requires smtp_host, from and to")
+		}
+		port := cc.SMTPPort
+		if port == 0 {
+			port = 587
+			if cc.TLS == "tls" {
+				port = 465
+			}
+		}
+		switch cc.TLS {
+		case "", "tls", "none":
+		default:
+			return nil, fmt.Errorf("tls %q (use tls or none, or omit for STARTTLS)", cc.TLS)
+		}
+		return &emailSender{
+			cfg:  cc,
+			addr: net.JoinHostPort(cc.SMTPHost, strconv.Itoa(port)),
+			tls:  &tls.Config{ServerName: cc.SMTPHost},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q (use webhook, email, ntfy or gotify)", cc.Type)
+}
+
+// checkRoutes verifies that every channel named by an alert rule exists
+func (n *Notifier) checkRoutes(rules []*alertRule) error {
+	for _, r := range rules {
+		for _, name := range r.Notify {
+			if n.channel(name) == nil {
+				return fmt.Errorf("alert rule %q: unknown notification channel %q", r.ID, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Notify routes an alert event to every matching channel. It never blocks.
+func (n *Notifier) Notify(ev AlertEvent) {
+	var route []string
+	if alertEngine != nil {
+		if r := alertEngine.rule(ev.RuleID); r != nil {
+			route = r.Notify
+		}
+	}
+
+	now := time.Now()
+	quiet := n.quiet.contains(now) && severityRank[ev.Severity] < severityRank[n.quietMin]
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, ch := range n.channels {
+		if len(route) > 0 && !containsString(route, ch.cfg.Name) {
+			continue
+		}
+		if !ch.accepts(ev) {
+			continue
+		}
+		key := ch.cfg.Name + "|" + ev.RuleID
+
+		switch ev.Event {
+		case "fired":
+			if quiet {
+				log.Printf("[Notify] %s: quiet hours, suppressed %q", ch.cfg.Name, ev.Message)
+				continue
+			}
+			if last, ok := n.lastFired[key]; ok && now.Sub(last) < n.rateLimit {
+				log.Printf("[Notify] %s: rate limited, suppressed %q", ch.cfg.Name, ev.Message)
+				continue
+			}
+			n.lastFired[key] = now
+			n.firedQueued[key] = true
+		case "cleared":
+			// Only announce a clear if the matching alert was announced
+			if !n.firedQueued[key] {
+				continue
+			}
+			delete(n.firedQueued, key)
+		}
+
+		select {
+		case ch.queue <- ev:
+		default:
+			log.Printf("[Notify] %s: queue full, dropped %q", ch.cfg.Name, ev.Message)
+		}
+	}
+}
+
+// deliver sends queued events for one channel, retrying with exponential backoff
+func (n *Notifier) deliver(ch *notifyChannel) {
+	for ev := range ch.queue {
+		delay := n.backoff
+		for attempt := 0; ; attempt++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := ch.sender.Send(ctx, ev)
+			cancel()
+			if err == nil {
+				log.Printf("[Notify] %s: sent %q", ch.cfg.Name, ev.Message)
+				break
+			}
+			if attempt >= n.maxRetries {
+				log.Printf("[Notify] %s: giving up after %d attempts: %v", ch.cfg.Name, attempt+1, err)
+				break
+			}
+			log.Printf("[Notify] %s: attempt %d failed, retrying in %s: %v", ch.cfg.Name, attempt+1, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+// channel returns the named channel, or nil
+func (n *Notifier) channel(name string) *notifyChannel {
+	for _, ch := range n.channels {
+		if ch.cfg.Name == name {
+			return ch
+		}
+	}
+	return nil
+}
+
+// -------------------- senders --------------------
+
+// webhookPayload is the JSON body POSTed to webhook channels
+type webhookPayload struct {
+	Station string     `json:"station"`
+	Alert   AlertEvent `json:"alert"`
+}
+
+// webhookSender POSTs JSON, signed with HMAC-SHA256 over the raw body in the
+// X-WeatherDash-Signature header ("sha256=<hex>") when a secret is configured
+type webhookSender struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func (s *webhookSender) Send(ctx context.Context, ev AlertEvent) error {
+	body, err := json.Marshal(webhookPayload{Station: station.Name, Alert: ev})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-WeatherDash-Event", ev.Event)
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		req.Header.Set("X-WeatherDash-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return doNotifyRequest(s.client, req)
+}
+
+// pushSender posts to an ntfy topic URL or a Gotify server
+type pushSender struct {
+	flavor string // ntfy, gotify
+	url    string
+	token  string
+	client *http.Client
+}
+
+// pushPriority maps severities onto ntfy (1-5) and Gotify (0-10) priorities
+func pushPriority(flavor, severity string) int {
+	if flavor == "gotify" {
+		return map[string]int{"info": 2, "warning": 5, "critical": 8}[severity]
+	}
+	return map[string]int{"info": 3, "warning": 4, "critical": 5}[severity]
+}
+
+func (s *pushSender) Send(ctx context.Context, ev AlertEvent) error {
+	title := notifyTitle(ev)
+	var req *http.Request
+	var err error
+	if s.flavor == "gotify" {
+		body, _ := json.Marshal(map[string]interface{}{
+			"title":    title,
+			"message":  ev.Message,
+			"priority": pushPriority(s.flavor, ev.Severity),
+		})
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/message", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if s.token != "" {
+			req.Header.Set("X-Gotify-Key", s.token)
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, s.url, strings.NewReader(ev.Message))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Title", title)
+		req.Header.Set("Priority", strconv.Itoa(pushPriority(s.flavor, ev.Severity)))
+		req.Header.Set("Tags", ev.Severity+","+ev.Event)
+		if s.token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.token)
+		}
+	}
+	return doNotifyRequest(s.client, req)
+}
+
+// doNotifyRequest performs req and treats any non-2xx status as an error
+func doNotifyRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// emailSender delivers plain-text mail over SMTP
+type emailSender struct {
+	cfg  NotifyChannelConfig
+	addr string
+	tls  *tls.Config // for implicit TLS and STARTTLS
+}
+
+func (s *emailSender) Send(ctx context.Context, ev AlertEvent) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", notifyTitle(ev)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nStation: %s\r\nTime: %s\r\nSeverity: %s\r\n",
+		ev.Message, station.Name, station.In(ev.Timestamp).Format("2006-01-02 15:04 MST"), ev.Severity)
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.SMTPHost)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if s.cfg.TLS == "tls" {
+		conn = tls.Client(conn, s.tls)
+	}
+	c, err := smtp.NewClient(conn, s.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.cfg.TLS == "" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(s.tls); err != nil {
+				return err
+			}
+		}
+	}
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range s.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// notifyTitle is the subject/title line, e.g. "[WARNING] My Station: Strong Gusts"
+func notifyTitle(ev AlertEvent) string {
+	title := fmt.Sprintf("[%s] %s: %s", strings.ToUpper(ev.Severity), station.Name, ev.Name)
+	if ev.Event == "cleared" {
+		title += " (cleared)"
+	}
+	return title
+}
+
+// -------------------- /api/notify/test --------------------
+
+// notifyTestResult reports the outcome of a test send on one channel
+type notifyTestResult struct {
+	Channel string `json:"channel"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleNotifyTest sends a synthetic alert synchronously (no retries, rate limits or
+// quiet hours) to one channel (?channel=name) or all of them.
+func handleNotifyTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	channels := notifier.channels
+	if name := r.URL.Query().Get("channel"); name != "" {
+		ch := notifier.channel(name)
+		if ch == nil {
+			writeAPIError(w, &apiError{Status: http.StatusNotFound, Code: "unknown_channel", Message: fmt.Sprintf("no channel named %q", name), Param: "channel"})
+			return
+		}
+		channels = []*notifyChannel{ch}
+	}
+
+	ev := AlertEvent{
+		RuleID:    "test",
+		Name:      "Test Notification",
+		Severity:  "info",
+		Event:     "fired",
+		Timestamp: time.Now().Unix(),
+		Message:   fmt.Sprintf("Test notification from %s", station.Name),
+	}
+	results := make([]notifyTestResult, 0, len(channels))
+	for _, ch := range channels {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		err := ch.sender.Send(ctx, ev)
+		cancel()
+		res := notifyTestResult{Channel: ch.cfg.Name, OK: err == nil}
+		if err != nil {
+			res.Error = err.Error()
+			log.Printf("[Notify] test send to %s failed: %v", ch.cfg.Name, err)
+		}
+		results = append(results, res)
+	}
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		http.Error(w, "JSON error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testAlertEvent(event, severity string) AlertEvent {
+	return AlertEvent{
+		ID:        7,
+		RuleID:    "gusts",
+		Name:      "Strong Gusts",
+		Severity:  severity,
+		Field:     "windGust",
+		Event:     event,
+		Value:     41,
+		Threshold: 35,
+		Unit:      "mph",
+		Timestamp: 1718900000,
+		Message:   "Strong Gusts: wind gust 41 mph (threshold 35 mph)",
+	}
+}
+
+// recordingServer collects the requests it receives; fail makes the first n return 500
+type recordingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	fail     int
+	requests []recordedRequest
+	got      chan recordedRequest
+}
+
+type recordedRequest struct {
+	at     time.Time
+	path   string
+	header http.Header
+	body   []byte
+	status int
+}
+
+func newRecordingServer(t *testing.T, fail int) *recordingServer {
+	rs := &recordingServer{fail: fail, got: make(chan recordedRequest, 16)}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec := recordedRequest{at: time.Now(), path: r.URL.Path, header: r.Header.Clone(), body: body, status: http.StatusOK}
+		rs.mu.Lock()
+		if rs.fail > 0 {
+			rs.fail--
+			rec.status = http.StatusInternalServerError
+		}
+		rs.requests = append(rs.requests, rec)
+		rs.mu.Unlock()
+		w.WriteHeader(rec.status)
+		rs.got <- rec
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+func (rs *recordingServer) next(t *testing.T) recordedRequest {
+	t.Helper()
+	select {
+	case rec := <-rs.got:
+		return rec
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a request")
+		return recordedRequest{}
+	}
+}
+
+func (rs *recordingServer) none(t *testing.T, wait time.Duration) {
+	t.Helper()
+	select {
+	case rec := <-rs.got:
+		t.Fatalf("unexpected request: %s", rec.body)
+	case <-time.After(wait):
+	}
+}
+
+func testNotifier(t *testing.T, cfg NotificationsConfig) *Notifier {
+	t.Helper()
+	if cfg.RateLimit == "" {
+		cfg.RateLimit = "15m"
+	}
+	if cfg.RetryBackoff == "" {
+		cfg.RetryBackoff = "10ms"
+	}
+	if cfg.QuietMinSeverity == "" {
+		cfg.QuietMinSeverity = "critical"
+	}
+	n, err := NewNotifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestWebhookSignature(t *testing.T) {
+	rs := newRecordingServer(t, 0)
+	s, err := newNotifySender(NotifyChannelConfig{Type: "webhook", URL: rs.URL, Secret: "s3cret"}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := testAlertEvent("fired", "warning")
+	if err := s.Send(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	rec := rs.next(t)
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(rec.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); rec.header.Get("X-WeatherDash-Signature") != want {
+		t.Errorf("signature %q, want %q", rec.header.Get("X-WeatherDash-Signature"), want)
+	}
+	if rec.header.Get("X-WeatherDash-Event") != "fired" || rec.header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers %v", rec.header)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(rec.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Alert != ev {
+		t.Errorf("payload alert %+v, want %+v", payload.Alert, ev)
+	}
+}
+
+func TestWebhookWithoutSecretIsUnsigned(t *testing.T) {
+	rs := newRecordingServer(t, 0)
+	s, _ := newNotifySender(NotifyChannelConfig{Type: "webhook", URL: rs.URL}, http.DefaultClient)
+	if err := s.Send(context.Background(), testAlertEvent("fired", "info")); err != nil {
+		t.Fatal(err)
+	}
+	if sig := rs.next(t).header.Get("X-WeatherDash-Signature"); sig != "" {
+		t.Errorf("unexpected signature %q", sig)
+	}
+}
+
+func TestNtfyRequest(t *testing.T) {
+	rs := newRecordingServer(t, 0)
+	s, _ := newNotifySender(NotifyChannelConfig{Type: "ntfy", URL: rs.URL + "/weather", Token: "tk"}, http.DefaultClient)
+	ev := testAlertEvent("cleared", "critical")
+	if err := s.Send(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	rec := rs.next(t)
+	if rec.path != "/weather" || string(rec.body) != ev.Message {
+		t.Errorf("got %s %q", rec.path, rec.body)
+	}
+	for name, want := range map[string]string{
+		"Title":         "[CRITICAL] : Strong Gusts (cleared)",
+		"Priority":      "5",
+		"Tags":          "critical,cleared",
+		"Authorization": "Bearer tk",
+	} {
+		if got := rec.header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestGotifyRequest(t *testing.T) {
+	rs := newRecordingServer(t, 0)
+	s, _ := newNotifySender(NotifyChannelConfig{Type: "gotify", URL: rs.URL + "/", Token: "app"}, http.DefaultClient)
+	ev := testAlertEvent("fired", "warning")
+	if err := s.Send(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	rec := rs.next(t)
+	if rec.path != "/message" || rec.header.Get("X-Gotify-Key") != "app" {
+		t.Errorf("got %s with key %q", rec.path, rec.header.Get("X-Gotify-Key"))
+	}
+	var body struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.Unmarshal(rec.body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Title != "[WARNING] : Strong Gusts" || body.Message != ev.Message || body.Priority != 5 {
+		t.Errorf("body %+v", body)
+	}
+}
+
+func TestSendReportsHTTPErrors(t *testing.T) {
+	rs := newRecordingServer(t, 1)
+	s, _ := newNotifySender(NotifyChannelConfig{Type: "webhook", URL: rs.URL}, http.DefaultClient)
+	err := s.Send(context.Background(), testAlertEvent("fired", "info"))
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("got %v, want a 500 error", err)
+	}
+}
+
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	rs := newRecordingServer(t, 2)
+	n := testNotifier(t, NotificationsConfig{
+		Channels:     []NotifyChannelConfig{{Name: "hook", Type: "webhook", URL: rs.URL}},
+		MaxRetries:   3,
+		RetryBackoff: "40ms",
+	})
+	n.Notify(testAlertEvent("fired", "warning"))
+
+	a, b, c := rs.next(t), rs.next(t), rs.next(t)
+	if a.status != 500 || b.status != 500 || c.status != 200 {
+		t.Fatalf("statuses %d, %d, %d", a.status, b.status, c.status)
+	}
+	if gap := b.at.Sub(a.at); gap < 40*time.Millisecond {
+		t.Errorf("first retry after %s, want >= 40ms", gap)
+	}
+	if gap := c.at.Sub(b.at); gap < 80*time.Millisecond {
+		t.Errorf("second retry after %s, want >= 80ms (doubled)", gap)
+	}
+	rs.none(t, 100*time.Millisecond)
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	rs := newRecordingServer(t, 100)
+	n := testNotifier(t, NotificationsConfig{
+		Channels:   []NotifyChannelConfig{{Name: "hook", Type: "webhook", URL: rs.URL}},
+		MaxRetries: 1,
+	})
+	n.Notify(testAlertEvent("fired", "warning"))
+	rs.next(t)
+	rs.next(t)
+	rs.none(t, 150*time.Millisecond)
+}
+
+func TestNotifyRateLimitAndClear(t *testing.T) {
+	rs := newRecordingServer(t, 0)
+	n := testNotifier(t, NotificationsConfig{
+		Channels:  []NotifyChannelConfig{{Name: "hook", Type: "webhook", URL: rs.URL}},
+		RateLimit: "1h",
+	})
+	event := func(rec recordedRequest) string {
+		return rec.header.Get("X-WeatherDash-Event")
+	}
+
+	n.Notify(testAlertEvent("fired", "warning"))
+	n.Notify(testAlertEvent("cleared", "warning"))
+	// Within the rate limit: neither the fire nor its clear is sent
+	n.Notify(testAlertEvent("fired", "warning"))
+	n.Notify(testAlertEvent("cleared", "warning"))
+	// Another rule is limited separately
+	other := testAlertEvent("fired", "warning")
+	other.RuleID = "heat"
+	n.Notify(other)
+
+	if got := event(rs.next(t)); got != "fired" {
+		t.Fatalf("first delivery %q", got)
+	}
+	if got := event(rs.next(t)); got != "cleared" {
+		t.Fatalf("second delivery %q", got)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(rs.next(t).body, &payload); err != nil || payload.Alert.RuleID != "heat" {
+		t.Fatalf("third delivery %+v (%v)", payload.Alert, err)
+	}
+	rs.none(t, 100*time.Millisecond)
+}
+
+func TestNotifyChannelFilters(t *testing.T) {
+	rs := newRecordingServer(t, 0)
+	n := testNotifier(t, NotificationsConfig{
+		Channels: []NotifyChannelConfig{{Name: "hook", Type: "webhook", URL: rs.URL, MinSeverity: "warning", Events: []string{"fired"}}},
+	})
+	n.Notify(testAlertEvent("fired", "info"))
+	n.Notify(testAlertEvent("fired", "critical"))
+	n.Notify(testAlertEvent("cleared", "critical"))
+
+	var payload webhookPayload
+	if err := json.Unmarshal(rs.next(t).body, &payload); err != nil || payload.Alert.Severity != "critical" {
+		t.Fatalf("delivered %+v (%v)", payload.Alert, err)
+	}
+	rs.none(t, 100*time.Millisecond)
+}
+
+func TestQuietWindow(t *testing.T) {
+	withStationZone(t, "UTC")
+	at := func(hhmm string) time.Time {
+		tm, _ := time.Parse("15:04", hhmm)
+		return time.Date(2024, 6, 1, tm.Hour(), tm.Minute(), 0, 0, time.UTC)
+	}
+	overnight, err := parseQuietHours("22:00-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daytime, _ := parseQuietHours("09:30-17:00")
+	for _, c := range []struct {
+		q    *quietWindow
+		at   string
+		want bool
+	}{
+		{overnight, "21:59", false},
+		{overnight, "22:00", true},
+		{overnight, "03:00", true},
+		{overnight, "06:59", true},
+		{overnight, "07:00", false},
+		{daytime, "09:29", false},
+		{daytime, "09:30", true},
+		{daytime, "16:59", true},
+		{daytime, "17:00", false},
+		{nil, "12:00", false},
+	} {
+		if got := c.q.contains(at(c.at)); got != c.want {
+			t.Errorf("%+v contains %s = %v, want %v", c.q, c.at, got, c.want)
+		}
+	}
+	if _, err := parseQuietHours("22-07"); err == nil {
+		t.Error("expected an error for 22-07")
+	}
+}
+
+func TestNotifyQuietHours(t *testing.T) {
+	withStationZone(t, "UTC")
+	now := time.Now().UTC()
+	from, to := now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04")
+
+	rs := newRecordingServer(t, 0)
+	n := testNotifier(t, NotificationsConfig{
+		Channels:         []NotifyChannelConfig{{Name: "hook", Type: "webhook", URL: rs.URL}},
+		QuietHours:       from + "-" + to,
+		QuietMinSeverity: "critical",
+	})
+	n.Notify(testAlertEvent("fired", "warning"))
+	// The suppressed alert's clear is not announced either
+	n.Notify(testAlertEvent("cleared", "warning"))
+	crit := testAlertEvent("fired", "critical")
+	crit.RuleID = "frost"
+	n.Notify(crit)
+
+	var payload webhookPayload
+	if err := json.Unmarshal(rs.next(t).body, &payload); err != nil || payload.Alert.RuleID != "frost" {
+		t.Fatalf("delivered %+v (%v)", payload.Alert, err)
+	}
+	rs.none(t, 100*time.Millisecond)
+}
+
+func TestHandleNotifyTest(t *testing.T) {
+	ok, failing := newRecordingServer(t, 0), newRecordingServer(t, 100)
+	saved := notifier
+	notifier = testNotifier(t, NotificationsConfig{
+		Channels: []NotifyChannelConfig{
+			{Name: "ok", Type: "webhook", URL: ok.URL},
+			{Name: "failing", Type: "ntfy", URL: failing.URL},
+		},
+	})
+	t.Cleanup(func() { notifier = saved })
+
+	w := httptest.NewRecorder()
+	handleNotifyTest(w, httptest.NewRequest(http.MethodPost, "/api/notify/test", nil))
+	var results []notifyTestResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || !results[0].OK || results[1].OK || results[1].Error == "" {
+		t.Errorf("results %+v", results)
+	}
+	// A test send is not retried
+	failing.next(t)
+	failing.none(t, 100*time.Millisecond)
+
+	w = httptest.NewRecorder()
+	handleNotifyTest(w, httptest.NewRequest(http.MethodPost, "/api/notify/test?channel=nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown channel: status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handleNotifyTest(w, httptest.NewRequest(http.MethodGet, "/api/notify/test", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d", w.Code)
+	}
+}
+
+// -------------------- SMTP stand-in --------------------
+
+// smtpStub is a minimal SMTP server offering STARTTLS (or implicit TLS) and AUTH PLAIN
+type smtpStub struct {
+	ln       net.Listener
+	tls      *tls.Config
+	implicit bool
+	msgs     chan smtpMessage
+}
+
+type smtpMessage struct {
+	from   string
+	to     []string
+	data   string
+	auth   string // decoded AUTH PLAIN response
+	secure bool   // TLS was in place when the message was sent
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a pool trusting it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smtp stub"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func newSMTPStub(t *testing.T, implicit bool) (*smtpStub, *x509.CertPool) {
+	t.Helper()
+	cert, pool := testCertificate(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStub{ln: ln, tls: &tls.Config{Certificates: []tls.Certificate{cert}}, implicit: implicit, msgs: make(chan smtpMessage, 4)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, pool
+}
+
+func (s *smtpStub) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	secure := false
+	if s.implicit {
+		conn = tls.Server(conn, s.tls)
+		secure = true
+	}
+	tp := textproto.NewConn(conn)
+	var msg smtpMessage
+	tp.PrintfLine("220 stub ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if secure {
+				tp.PrintfLine("250-stub\r\n250 AUTH PLAIN")
+			} else {
+				tp.PrintfLine("250-stub\r\n250-STARTTLS\r\n250 AUTH PLAIN")
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 ready")
+			tc := tls.Server(conn, s.tls)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			conn, secure = tc, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			_, resp, _ := strings.Cut(arg, " ")
+			dec, _ := base64.StdEncoding.DecodeString(resp)
+			msg.auth = string(dec)
+			tp.PrintfLine("235 ok")
+		case "MAIL":
+			msg.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			msg.to = append(msg.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data, msg.secure = string(data), secure
+			tp.PrintfLine("250 queued")
+			s.msgs <- msg
+			msg = smtpMessage{}
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *smtpStub) next(t *testing.T) smtpMessage {
+	t.Helper()
+	select {
+	case m := <-s.msgs:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for mail")
+		return smtpMessage{}
+	}
+}
+
+func testEmail(t *testing.T, mode string) {
+	stub, pool := newSMTPStub(t, mode == "tls")
+	sender, err := newNotifySender(NotifyChannelConfig{
+		Name: "mail", Type: "email", TLS: mode,
+		SMTPHost: "127.0.0.1", SMTPPort: stub.port(),
+		Username: "wx", Password: "pw",
+		From: "station@example.com", To: []string{"a@example.com", "b@example.com"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.(*emailSender).tls.RootCAs = pool
+
+	ev := testAlertEvent("fired", "critical")
+	if err := sender.Send(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	m := stub.next(t)
+	if !m.secure {
+		t.Error("message was sent without TLS")
+	}
+	if m.auth != "\x00wx\x00pw" {
+		t.Errorf("auth %q", m.auth)
+	}
+	if m.from != "station@example.com" || strings.Join(m.to, ",") != "a@example.com,b@example.com" {
+		t.Errorf("envelope %s -> %v", m.from, m.to)
+	}
+	hdr, err := textproto.NewReader(bufio.NewReader(strings.NewReader(m.data))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Get("Subject") != "[CRITICAL] : Strong Gusts" || hdr.Get("To") != "a@example.com, b@example.com" {
+		t.Errorf("headers %v", hdr)
+	}
+	if !strings.Contains(m.data, ev.Message) {
+		t.Errorf("body does not contain the message:\n%s", m.data)
+	}
+}
+
+func TestEmailSTARTTLS(t *testing.T) {
+	testEmail(t, "")
+}
+
+func TestEmailImplicitTLS(t *testing.T) {
+	testEmail(t, "tls")
+}
+
+func TestEmailRejectsUntrustedCertificate(t *testing.T) {
+	stub, _ := newSMTPStub(t, true)
+	sender, _ := newNotifySender(NotifyChannelConfig{
+		Name: "mail", Type: "email", TLS: "tls",
+		SMTPHost: "127.0.0.1", SMTPPort: stub.port(),
+		From: "station@example.com", To: []string{"a@example.com"},
+	}, nil)
+	if err := sender.Send(context.Background(), testAlertEvent("fired", "info")); err == nil {
+		t.Error("expected a certificate error")
+	}
+}
+
+func TestEmailDefaultPorts(t *testing.T) {
+	for mode, want := range map[string]int{"": 587, "none": 587, "tls": 465} {
+		s, err := newNotifySender(NotifyChannelConfig{Type: "email", TLS: mode, SMTPHost: "mail.example.com", From: "a@b", To: []string{"c@d"}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.(*emailSender).addr; got != "mail.example.com:"+strconv.Itoa(want) {
+			t.Errorf("tls=%q: addr %s", mode, got)
+		}
+	}
+	if _, err := newNotifySender(NotifyChannelConfig{Type: "email", TLS: "ssl", SMTPHost: "h", From: "a", To: []string{"b"}}, nil); err == nil {
+		t.Error("expected an error for tls: ssl")
+	}
+}
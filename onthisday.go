@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// onThisDayMeasures are the per-year values /api/onthisday compares, with how each is
+// read from a day
+var onThisDayMeasures = []struct {
+	Name     string
+	Quantity Quantity
+	value    func(d *recordDay) (v float64, epoch int64, ok bool)
+}{
+	{"highTemp", qTemp, func(d *recordDay) (float64, int64, bool) { return d.temp.high() }},
+	{"lowTemp", qTemp, func(d *recordDay) (float64, int64, bool) { return d.temp.low() }},
+	{"rain", qRain, func(d *recordDay) (float64, int64, bool) { return d.rain, d.start, d.hasRain }},
+	{"peakGust", qSpeed, func(d *recordDay) (float64, int64, bool) { return d.gust.high() }},
+	{"strikes", qNone, func(d *recordDay) (float64, int64, bool) { return d.strikes, d.start, d.hasStrikes }},
+}
+
+// parseMonthDay reads ?date=MM-DD, defaulting to today's date at the station
+func parseMonthDay(r *http.Request) (time.Month, int, *apiError) {
+	v := strings.TrimSpace(r.URL.Query().Get("date"))
+	if v == "" {
+		now := station.Now()
+		return now.Month(), now.Day(), nil
+	}
+	// 2000 is a leap year, so 02-29 is accepted
+	t, err := time.Parse("2006-01-02", "2000-"+v)
+	if err != nil {
+		return 0, 0, badParam("date", "invalid_date", "date must be MM-DD, e.g. 07-04")
+	}
+	return t.Month(), t.Day(), nil
+}
+
+// DaysOn returns copies of the days falling on month/day, oldest first
+func (b *RecordBook) DaysOn(month time.Month, day int) []recordDay {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []recordDay
+	for _, d := range b.days {
+		if t := station.In(d.start); t.Month() == month && t.Day() == day {
+			out = append(out, *d)
+		}
+	}
+	return out
+}
+
+// -------------------- /api/onthisday --------------------
+
+// handleOnThisDay returns a calendar date's weather in every year of the archive,
+// with the average and extremes across the years whose day is complete
+func handleOnThisDay(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	month, day, apiErr := parseMonthDay(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	units, apiErr := unitsFromRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	if err := recordBook.Load(r.Context()); err != nil {
+		log.Println("DB query error (onthisday):", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	today := station.Today().Unix()
+
+	resp := OnThisDayResponse{
+		Date:    fmt.Sprintf("%02d-%02d", month, day),
+		Years:   []OnThisDayYear{},
+		Summary: make(map[string]OnThisDayStat, len(onThisDayMeasures)),
+		Units:   units.Labels(),
+	}
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, d := range recordBook.DaysOn(month, day) {
+		year := OnThisDayYear{
+			Year:    station.In(d.start).Year(),
+			Partial: d.start >= today,
+			Values:  make(map[string]*Record, len(onThisDayMeasures)),
+		}
+		for _, m := range onThisDayMeasures {
+			v, epoch, ok := m.value(&d)
+			if !ok {
+				year.Values[m.Name] = nil
+				continue
+			}
+			rec := &Record{Value: units.Convert(m.Quantity, v), Time: epoch, Date: station.In(epoch).Format("2006-01-02")}
+			if m.Name == "peakGust" {
+				dir, err := recordBook.gustDir(r.Context(), epoch)
+				if err != nil {
+					log.Println("DB query error (onthisday):", err)
+					http.Error(w, "DB error", http.StatusInternalServerError)
+					return
+				}
+				if dir.Valid {
+					rec.Dir = &dir.Float64
+				}
+			}
+			year.Values[m.Name] = rec
+			if year.Partial {
+				continue
+			}
+
+			// Ties go to the earliest year
+			stat := resp.Summary[m.Name]
+			if stat.Highest == nil || rec.Value > stat.Highest.Value {
+				stat.Highest = &OnThisDayExtreme{Value: rec.Value, Year: year.Year}
+			}
+			if stat.Lowest == nil || rec.Value < stat.Lowest.Value {
+				stat.Lowest = &OnThisDayExtreme{Value: rec.Value, Year: year.Year}
+			}
+			resp.Summary[m.Name] = stat
+			sums[m.Name] += rec.Value
+			counts[m.Name]++
+		}
+		resp.Years = append(resp.Years, year)
+	}
+	for _, m := range onThisDayMeasures {
+		stat := resp.Summary[m.Name]
+		if n := counts[m.Name]; n > 0 {
+			avg := sums[m.Name] / float64(n)
+			stat.Average = &avg
+			stat.Years = n
+		}
+		resp.Summary[m.Name] = stat
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "JSON error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Parquet exports have a flat schema: a required TIMESTAMP(MILLIS) column holding the
+// station's wall-clock time (not UTC-adjusted) followed by one optional column per
+// export column. Row groups are flushed every parquetRowGroupRows rows so memory
+// stays bounded while streaming.
+
+const parquetRowGroupRows = 65536
+
+// parquetExportWriter streams export rows as a Parquet file
+type parquetExportWriter struct {
+	w    *parquet.Writer
+	cols []*exportColumn
+	row  parquet.Row
+}
+
+func newParquetExportWriter(w io.Writer, cols []*exportColumn, units UnitSystem) (exportWriter, error) {
+	group := parquet.Group{"timestamp": parquet.TimestampAdjusted(parquet.Millisecond, false)}
+	order := []string{"timestamp"}
+	for _, c := range cols {
+		var node parquet.Node
+		switch {
+		case c.IsText():
+			node = parquet.String()
+		case c.IsInteger():
+			node = parquet.Int(64)
+		default:
+			node = parquet.Leaf(parquet.DoubleType)
+		}
+		group[c.Key] = parquet.Optional(node)
+		order = append(order, c.Key)
+	}
+	schema := parquet.NewSchema("schema", parquetOrderedGroup{Group: group, order: order})
+
+	return &parquetExportWriter{
+		w: parquet.NewWriter(w, schema,
+			parquet.MaxRowsPerRowGroup(parquetRowGroupRows),
+			parquet.KeyValueMetadata("weatherdash.timezone", station.Loc.String()),
+			parquet.KeyValueMetadata("weatherdash.units", units.Key()),
+			parquet.CreatedBy("MyWeatherDash", "", ""),
+		),
+		cols: cols,
+		row:  make(parquet.Row, len(cols)+1),
+	}, nil
+}
+
+func (pw *parquetExportWriter) WriteRow(t time.Time, cells []exportCell) error {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	pw.row[0] = parquet.Int64Value(wall.UnixMilli()).Level(0, 0, 0)
+	for i, cell := range cells {
+		v := parquet.NullValue()
+		if cell.Valid {
+			switch c := pw.cols[i]; {
+			case c.IsText():
+				v = parquet.ByteArrayValue([]byte(cell.Text))
+			case c.IsInteger():
+				v = parquet.Int64Value(int64(cell.Num))
+			default:
+				v = parquet.DoubleValue(cell.Num)
+			}
+			v = v.Level(0, 1, i+1)
+		} else {
+			v = v.Level(0, 0, i+1)
+		}
+		pw.row[i+1] = v
+	}
+	_, err := pw.w.WriteRows([]parquet.Row{pw.row})
+	return err
+}
+
+func (pw *parquetExportWriter) Close() error {
+	return pw.w.Close()
+}
+
+// parquetOrderedGroup keeps the export's column order; parquet.Group sorts its
+// fields by name
+type parquetOrderedGroup struct {
+	parquet.Group
+	order []string
+}
+
+func (g parquetOrderedGroup) Fields() []parquet.Field {
+	fields := g.Group.Fields()
+	slices.SortStableFunc(fields, func(a, b parquet.Field) int {
+		return slices.Index(g.order, a.Name()) - slices.Index(g.order, b.Name())
+	})
+	return fields
+}
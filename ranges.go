@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -------------------- structured API errors --------------------
+
+// apiError is a client-facing error serialized as {"error": {...}}
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func badParam(param, code, format string, args ...interface{}) *apiError {
+	return &apiError{
+		Status:  http.StatusBadRequest,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Param:   param,
+	}
+}
+
+// writeAPIError writes a structured JSON error response
+func writeAPIError(w http.ResponseWriter, e *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	_ = json.NewEncoder(w).Encode(map[string]*apiError{"error": e})
+}
+
+// -------------------- time ranges --------------------
+
+// TimeRange is a resolved query window over the archive, [Start, End).
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+	// Name is the named range ("day", "72h", "ytd", ...) or "custom" for explicit start/end
+	Name string
+	// Open is true when no explicit end was requested; the window then extends to the
+	// newest archive row even if the DB host's clock runs slightly ahead of ours.
+	Open bool
+}
+
+// Bounds returns the unix epoch bounds used in `dateTime >= ? AND dateTime < ?`
+func (tr TimeRange) Bounds() (int64, int64) {
+	if tr.Open {
+		return tr.Start.Unix(), math.MaxInt64
+	}
+	return tr.Start.Unix(), tr.End.Unix()
+}
+
+// Duration returns the span of the window
+func (tr TimeRange) Duration() time.Duration {
+	return tr.End.Sub(tr.Start)
+}
+
+// relativeRangeRe matches compact relative ranges such as "72h", "90m", "14d" or "2w"
+var relativeRangeRe = regexp.MustCompile(`^(\d+)(m|h|d|w)$`)
+
+// namedRangeStart resolves a named range to its start time relative to now.
+// ok is false if the name is not recognised.
+func namedRangeStart(name string, now time.Time) (time.Time, bool) {
+	switch name {
+	case "", "day":
+		return now.Add(-24 * time.Hour), true
+	case "week":
+		return now.Add(-7 * 24 * time.Hour), true
+	case "month":
+		// simple 30-day month
+		return now.Add(-30 * 24 * time.Hour), true
+	case "year":
+		return now.Add(-365 * 24 * time.Hour), true
+	case "today":
+		return station.StartOfDay(now), true
+	case "mtd":
+		local := now.In(station.Loc)
+		return station.Date(local.Year(), local.Month(), 1), true
+	case "ytd":
+		return station.Date(now.In(station.Loc).Year(), 1, 1), true
+	}
+
+	span, ok := parseSpan(name)
+	if !ok {
+		return time.Time{}, false
+	}
+	return now.Add(-span), true
+}
+
+// parseSpan parses a compact span such as "90m", "72h", "14d" or "2w"
+func parseSpan(v string) (time.Duration, bool) {
+	m := relativeRangeRe.FindStringSubmatch(v)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	unit := map[string]time.Duration{
+		"m": time.Minute,
+		"h": time.Hour,
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}[m[2]]
+	// Guard against overflow before multiplying
+	if int64(n) > int64(math.MaxInt64/unit) {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// parseTimeParam accepts ISO-8601 (RFC3339, "2006-01-02T15:04[:05]" in station time,
+// or a bare "2006-01-02" date) or a unix epoch in seconds or milliseconds.
+// dateOnly reports whether a bare date was given so callers can treat an end
+// date as inclusive.
+func parseTimeParam(v string) (t time.Time, dateOnly bool, err error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if n > 1e12 || n < -1e12 {
+			return time.UnixMilli(n), false, nil
+		}
+		return time.Unix(n, 0), false, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, false, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, v, station.Loc); err == nil {
+			return t, false, nil
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, station.Loc); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("unrecognised time %q", v)
+}
+
+// maxRangeSpan is the longest window any series endpoint will serve
+func maxRangeSpan() time.Duration {
+	return time.Duration(appConfig.Server.MaxRangeDays) * 24 * time.Hour
+}
+
+// parseTimeRange resolves the window requested by `range`, `start` and `end`.
+//
+//   - range alone: named (day, week, month, year, today, mtd, ytd) or relative (72h, 14d, 2w)
+//   - start/end: explicit bounds; a missing end means "now", a missing start means
+//     end minus the named range
+//
+// All series endpoints share this helper so they stay in lockstep.
+func parseTimeRange(r *http.Request) (TimeRange, *apiError) {
+	q := r.URL.Query()
+	name := strings.ToLower(strings.TrimSpace(q.Get("range")))
+	startStr := strings.TrimSpace(q.Get("start"))
+	endStr := strings.TrimSpace(q.Get("end"))
+	now := time.Now()
+
+	tr := TimeRange{End: now, Name: name, Open: true}
+	if tr.Name == "" {
+		tr.Name = "day"
+	}
+
+	if endStr != "" {
+		end, dateOnly, err := parseTimeParam(endStr)
+		if err != nil {
+			return TimeRange{}, badParam("end", "invalid_time", "end: %v (use ISO-8601 or unix epoch)", err)
+		}
+		if dateOnly {
+			// A bare end date includes the whole day
+			end = station.NextDay(end)
+		}
+		tr.End = end
+		tr.Open = false
+	}
+
+	if startStr != "" {
+		start, _, err := parseTimeParam(startStr)
+		if err != nil {
+			return TimeRange{}, badParam("start", "invalid_time", "start: %v (use ISO-8601 or unix epoch)", err)
+		}
+		tr.Start = start
+		tr.Name = "custom"
+	} else {
+		start, ok := namedRangeStart(name, tr.End)
+		if !ok {
+			return TimeRange{}, badParam("range", "invalid_range",
+				"unknown range %q (use day, week, month, year, today, mtd, ytd, or a span like 72h, 14d, 2w)", name)
+		}
+		tr.Start = start
+		if !tr.Open {
+			tr.Name = "custom"
+		}
+	}
+
+	if !tr.Start.Before(tr.End) {
+		return TimeRange{}, badParam("start", "invalid_range", "start must be before end")
+	}
+	if max := maxRangeSpan(); tr.Duration() > max {
+		return TimeRange{}, badParam("range", "range_too_large",
+			"requested span %s exceeds the maximum of %d days", tr.Duration().Round(time.Hour), appConfig.Server.MaxRangeDays)
+	}
+	return tr, nil
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The record book keeps one aggregate per station day for the whole archive (WeeWX's
+// daily summaries when it has them, otherwise a scan at startup) and folds each new
+// archive record into today's. Records for any period are found from the days, so
+// /api/records doesn't scan the archive, and live updates can tell when one breaks.
+
+// recordPeriods are the periods records are kept for, in response order
+var recordPeriods = []string{"allTime", "yearToDate", "monthToDate", "month"}
+
+// recordKind is one kind of record. Day-level kinds read a value from each day;
+// wettestMonth is summed from the days.
+type recordKind struct {
+	Name     string
+	Quantity Quantity
+	Low      bool // the lowest value is the record
+	value    func(d *recordDay) (v float64, epoch int64, ok bool)
+}
+
+var recordKinds = []recordKind{
+	{Name: "highTemp", Quantity: qTemp, value: func(d *recordDay) (float64, int64, bool) { return d.temp.high() }},
+	{Name: "lowTemp", Quantity: qTemp, Low: true, value: func(d *recordDay) (float64, int64, bool) { return d.temp.low() }},
+	{Name: "highGust", Quantity: qSpeed, value: func(d *recordDay) (float64, int64, bool) { return d.gust.high() }},
+	{Name: "maxRainRate", Quantity: qRainRate, value: func(d *recordDay) (float64, int64, bool) { return d.rainRate.high() }},
+	{Name: "wettestDay", Quantity: qRain, value: func(d *recordDay) (float64, int64, bool) { return d.rain, d.start, d.hasRain }},
+	{Name: "wettestMonth", Quantity: qRain},
+	{Name: "highBarometer", Quantity: qPressure, value: func(d *recordDay) (float64, int64, bool) { return d.barometer.high() }},
+	{Name: "lowBarometer", Quantity: qPressure, Low: true, value: func(d *recordDay) (float64, int64, bool) { return d.barometer.low() }},
+	{Name: "mostStrikes", Quantity: qNone, value: func(d *recordDay) (float64, int64, bool) { return d.strikes, d.start, d.hasStrikes }},
+	{Name: "largestTempSwing", Quantity: qTempDelta, value: func(d *recordDay) (float64, int64, bool) {
+		if !d.temp.ok {
+			return 0, 0, false
+		}
+		return d.temp.max - d.temp.min, d.start, true
+	}},
+}
+
+func recordKindNamed(name string) recordKind {
+	for _, k := range recordKinds {
+		if k.Name == name {
+			return k
+		}
+	}
+	return recordKind{}
+}
+
+// extreme tracks a day's lowest and highest reading and when each first occurred
+type extreme struct {
+	min, max         float64
+	minTime, maxTime int64
+	ok               bool
+}
+
+func (e *extreme) add(v float64, epoch int64) {
+	if !e.ok || v < e.min {
+		e.min, e.minTime = v, epoch
+	}
+	if !e.ok || v > e.max {
+		e.max, e.maxTime = v, epoch
+	}
+	e.ok = true
+}
+
+func (e *extreme) high() (float64, int64, bool) { return e.max, e.maxTime, e.ok }
+func (e *extreme) low() (float64, int64, bool)  { return e.min, e.minTime, e.ok }
+
+// fromSummary takes a day's extremes from its WeeWX summary
+func (e *extreme) fromSummary(s ObsSummary) {
+	if s.Count == 0 || !s.Min.Valid || !s.Max.Valid {
+		return
+	}
+	*e = extreme{min: s.Min.Float64, max: s.Max.Float64, minTime: s.MinTime, maxTime: s.MaxTime, ok: true}
+}
+
+// recordDay is one station day. As in WeeWX, the record stamped at midnight ends the
+// previous day.
+type recordDay struct {
+	start                           int64
+	temp, gust, rainRate, barometer extreme
+	rain, strikes                   float64
+	hasRain, hasStrikes             bool
+}
+
+// recordColumns are the archive columns the record book folds in
+var recordColumns = []string{"outTemp", "windGust", "windDir", "rainRate", "rain", "barometer", "lightning_strike_count"}
+
+// RecordBook holds the per-day aggregates records are found from
+type RecordBook struct {
+	archive Archive
+	// loadMu serialises Load; mu is only held briefly, so live updates don't wait
+	// for the history scan
+	loadMu sync.Mutex
+
+	mu        sync.Mutex
+	loaded    bool
+	days      []*recordDay // ascending by start
+	lastEpoch int64        // newest archive record folded in
+	// gustDirs caches the wind direction of gust readings by epoch
+	gustDirs map[int64]sql.NullFloat64
+}
+
+var recordBook *RecordBook
+
+func NewRecordBook(archive Archive) *RecordBook {
+	return &RecordBook{archive: archive, gustDirs: make(map[int64]sql.NullFloat64)}
+}
+
+// Load reads the archive's history once; later calls return immediately. The
+// history up to the newest record at the start is read without holding b.mu;
+// records arriving meanwhile are skipped by Observe and folded in from the archive
+// at the end, so none are missed or counted twice.
+func (b *RecordBook) Load(ctx context.Context) error {
+	b.loadMu.Lock()
+	defer b.loadMu.Unlock()
+	b.mu.Lock()
+	loaded := b.loaded
+	b.mu.Unlock()
+	if loaded {
+		return nil
+	}
+	began := time.Now()
+
+	newest, ok, err := b.archive.MaxEpoch(ctx, "records_max")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		b.mu.Lock()
+		b.loaded = true
+		b.mu.Unlock()
+		return nil
+	}
+
+	// Whole days before today come from the summaries; today, or everything if there
+	// are none, is scanned
+	today := station.Today()
+	scanFrom := time.Unix(0, 0)
+	summaries, ok, err := loadDaySummaries(ctx, b.archive, "records",
+		[]string{"outTemp", "windGust", "rainRate", "rain", "barometer", "lightning_strike_count"}, scanFrom, today)
+	if err != nil {
+		return err
+	}
+	var days []*recordDay
+	if ok {
+		for _, s := range summaries {
+			d := &recordDay{start: s.Start}
+			d.temp.fromSummary(s.Obs["outTemp"])
+			d.gust.fromSummary(s.Obs["windGust"])
+			d.rainRate.fromSummary(s.Obs["rainRate"])
+			d.barometer.fromSummary(s.Obs["barometer"])
+			rain, strikes := s.Obs["rain"], s.Obs["lightning_strike_count"]
+			d.rain, d.hasRain = rain.Sum, rain.Count > 0
+			d.strikes, d.hasStrikes = strikes.Sum, strikes.Count > 0
+			days = append(days, d)
+		}
+		slices.SortFunc(days, func(a, b *recordDay) int { return cmp.Compare(a.start, b.start) })
+		scanFrom = today
+	}
+
+	// Fold the history into a private book, then take it over and catch up under the
+	// lock on whatever arrived during the scan
+	history := &RecordBook{days: days, gustDirs: make(map[int64]sql.NullFloat64)}
+	scan := ArchiveScan{Columns: recordColumns, Start: scanFrom.Unix() + 1, End: newest + 1}
+	err = b.archive.Scan(ctx, "records", scan, func(epoch int64, vals []sql.NullFloat64) error {
+		history.fold(epoch, vals)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.days = history.days
+	for epoch, dir := range history.gustDirs {
+		b.gustDirs[epoch] = dir
+	}
+	b.lastEpoch = newest
+	scan = ArchiveScan{Columns: recordColumns, Start: newest + 1, End: math.MaxInt64}
+	err = b.archive.Scan(ctx, "records", scan, func(epoch int64, vals []sql.NullFloat64) error {
+		b.fold(epoch, vals)
+		return nil
+	})
+	if err != nil {
+		b.days, b.lastEpoch = nil, 0
+		return err
+	}
+	b.loaded = true
+	log.Printf("[Records] loaded %d days in %s", len(b.days), time.Since(began).Round(time.Millisecond))
+	return nil
+}
+
+// fold adds one archive record (vals in recordColumns order) to its day
+func (b *RecordBook) fold(epoch int64, vals []sql.NullFloat64) {
+	outTemp, windGust, windDir, rainRate, rain, barometer, strikes := vals[0], vals[1], vals[2], vals[3], vals[4], vals[5], vals[6]
+	start := station.StartOfDay(station.In(epoch - 1)).Unix()
+	var d *recordDay
+	if n := len(b.days); n > 0 && b.days[n-1].start == start {
+		d = b.days[n-1]
+	} else {
+		d = &recordDay{start: start}
+		b.days = append(b.days, d)
+	}
+
+	if outTemp.Valid {
+		d.temp.add(outTemp.Float64, epoch)
+	}
+	if windGust.Valid {
+		d.gust.add(windGust.Float64, epoch)
+		if d.gust.maxTime == epoch {
+			b.gustDirs[epoch] = windDir
+		}
+	}
+	if rainRate.Valid {
+		d.rainRate.add(rainRate.Float64, epoch)
+	}
+	if barometer.Valid {
+		d.barometer.add(barometer.Float64, epoch)
+	}
+	if rain.Valid {
+		d.rain += rain.Float64
+		d.hasRain = true
+	}
+	if strikes.Valid {
+		d.strikes += strikes.Float64
+		d.hasStrikes = true
+	}
+	b.lastEpoch = epoch
+}
+
+// periodDays returns the days of each period as of the station day starting at day
+func (b *RecordBook) periodDays(day time.Time, month time.Month) map[string][]*recordDay {
+	yearStart := station.Date(day.Year(), 1, 1).Unix()
+	monthStart := station.Date(day.Year(), day.Month(), 1).Unix()
+	out := map[string][]*recordDay{"allTime": b.days}
+	for _, d := range b.days {
+		if d.start >= yearStart {
+			out["yearToDate"] = append(out["yearToDate"], d)
+		}
+		if d.start >= monthStart {
+			out["monthToDate"] = append(out["monthToDate"], d)
+		}
+		if station.In(d.start).Month() == month {
+			out["month"] = append(out["month"], d)
+		}
+	}
+	return out
+}
+
+// findRecords returns the record of each kind among days (nil if there is no data).
+// Ties go to the earliest occurrence. Values are in archive units.
+func findRecords(days []*recordDay) map[string]*Record {
+	out := make(map[string]*Record, len(recordKinds))
+	for _, k := range recordKinds {
+		out[k.Name] = nil
+		if k.value == nil {
+			continue
+		}
+		for _, d := range days {
+			v, epoch, ok := k.value(d)
+			if !ok {
+				continue
+			}
+			if cur := out[k.Name]; cur == nil || (k.Low && v < cur.Value) || (!k.Low && v > cur.Value) {
+				out[k.Name] = &Record{Value: v, Time: epoch, Date: station.In(epoch).Format("2006-01-02")}
+			}
+		}
+	}
+
+	// Wettest month: days are ascending, so each month's days are contiguous
+	var month *Record
+	for _, d := range days {
+		if !d.hasRain {
+			continue
+		}
+		t := station.In(d.start)
+		start := station.Date(t.Year(), t.Month(), 1).Unix()
+		if month == nil || month.Time != start {
+			if month != nil && (out["wettestMonth"] == nil || month.Value > out["wettestMonth"].Value) {
+				out["wettestMonth"] = month
+			}
+			month = &Record{Time: start, Date: t.Format("2006-01")}
+		}
+		month.Value += d.rain
+	}
+	if month != nil && (out["wettestMonth"] == nil || month.Value > out["wettestMonth"].Value) {
+		out["wettestMonth"] = month
+	}
+	return out
+}
+
+// Records returns the records of each period as of now, with month selecting the
+// calendar month compared across years. Values are in archive units and gust
+// directions are filled in.
+func (b *RecordBook) Records(ctx context.Context, now time.Time, month time.Month) (map[string]map[string]*Record, error) {
+	b.mu.Lock()
+	periods := make(map[string]map[string]*Record, len(recordPeriods))
+	for name, days := range b.periodDays(station.StartOfDay(now), month) {
+		periods[name] = findRecords(days)
+	}
+	for _, name := range recordPeriods {
+		if periods[name] == nil {
+			periods[name] = findRecords(nil)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, records := range periods {
+		if gust := records["highGust"]; gust != nil {
+			dir, err := b.gustDir(ctx, gust.Time)
+			if err != nil {
+				return nil, err
+			}
+			if dir.Valid {
+				gust.Dir = &dir.Float64
+			}
+		}
+	}
+	return periods, nil
+}
+
+// gustDir returns the wind direction of the gust reading at epoch
+func (b *RecordBook) gustDir(ctx context.Context, epoch int64) (sql.NullFloat64, error) {
+	b.mu.Lock()
+	dir, ok := b.gustDirs[epoch]
+	b.mu.Unlock()
+	if ok {
+		return dir, nil
+	}
+	_, vals, err := b.archive.Latest(ctx, "records_gust_dir", []string{"windDir"}, epoch, epoch)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return sql.NullFloat64{}, err
+	}
+	if err == nil {
+		dir = vals[0]
+	}
+	b.mu.Lock()
+	b.gustDirs[epoch] = dir
+	b.mu.Unlock()
+	return dir, nil
+}
+
+// Observe folds a new archive record in and returns the records it broke. A record
+// only counts as broken if the period already had one held by another reading, day
+// or month; today extending its own rain total isn't reported again.
+func (b *RecordBook) Observe(epoch int64, values map[string]float64) []BrokenRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.loaded || epoch <= b.lastEpoch {
+		return nil
+	}
+
+	vals := make([]sql.NullFloat64, len(recordColumns))
+	for i, col := range recordColumns {
+		if v, ok := values[col]; ok {
+			vals[i] = sql.NullFloat64{Float64: v, Valid: true}
+		}
+	}
+	day := station.StartOfDay(station.In(epoch - 1))
+	before := make(map[string]map[string]*Record, len(recordPeriods))
+	for name, days := range b.periodDays(day, day.Month()) {
+		before[name] = findRecords(days)
+	}
+	b.fold(epoch, vals)
+
+	var broken []BrokenRecord
+	after := b.periodDays(day, day.Month())
+	for _, period := range recordPeriods {
+		records := findRecords(after[period])
+		for _, k := range recordKinds {
+			// Records only change hands when beaten outright
+			prev, cur := before[period][k.Name], records[k.Name]
+			if prev == nil || cur == nil || cur.Time == prev.Time {
+				continue
+			}
+			if dir, ok := b.gustDirs[prev.Time]; ok && dir.Valid && k.Name == "highGust" {
+				prev.Dir = &dir.Float64
+			}
+			broken = append(broken, BrokenRecord{Period: period, Record: k.Name, Value: cur.Value, Time: epoch, Previous: prev})
+		}
+	}
+	return broken
+}
+
+// converted returns r with its value in units
+func (r *Record) converted(q Quantity, units UnitSystem) *Record {
+	if r == nil {
+		return nil
+	}
+	out := *r
+	out.Value = units.Convert(q, r.Value)
+	return &out
+}
+
+// -------------------- /api/records --------------------
+
+// handleRecords returns the all-time, year-to-date, month-to-date and calendar-month
+// records with when each was set
+func handleRecords(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	units, apiErr := unitsFromRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	now := station.Now()
+	month := now.Month()
+	if v := r.URL.Query().Get("month"); v != "" {
+		m, err := strconv.Atoi(v)
+		if err != nil || m < 1 || m > 12 {
+			writeAPIError(w, badParam("month", "invalid_month", "month must be 1-12"))
+			return
+		}
+		month = time.Month(m)
+	}
+
+	if err := recordBook.Load(r.Context()); err != nil {
+		log.Println("DB query error (records):", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	periods, err := recordBook.Records(r.Context(), now, month)
+	if err != nil {
+		log.Println("DB query error (records):", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	convert := func(records map[string]*Record) map[string]*Record {
+		out := make(map[string]*Record, len(recordKinds))
+		for _, k := range recordKinds {
+			out[k.Name] = records[k.Name].converted(k.Quantity, units)
+		}
+		return out
+	}
+	resp := RecordsResponse{
+		AllTime:       convert(periods["allTime"]),
+		YearToDate:    convert(periods["yearToDate"]),
+		MonthToDate:   convert(periods["monthToDate"]),
+		Month:         convert(periods["month"]),
+		CalendarMonth: int(month),
+		Units:         units.Labels(),
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "JSON error", http.StatusInternalServerError)
+	}
+}
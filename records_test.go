@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingArchive holds the first history scan of the record book until release is
+// closed
+type blockingArchive struct {
+	Archive
+	once     sync.Once
+	scanning chan struct{}
+	release  chan struct{}
+}
+
+func (a *blockingArchive) Scan(ctx context.Context, query string, s ArchiveScan, fn func(int64, []sql.NullFloat64) error) error {
+	if query == "records" {
+		a.once.Do(func() {
+			close(a.scanning)
+			<-a.release
+		})
+	}
+	return a.Archive.Scan(ctx, query, s, fn)
+}
+
+func TestRecordBookLoadDoesNotBlockObserve(t *testing.T) {
+	withStationZone(t, "UTC")
+	start := station.Today().AddDate(0, 0, -3).Unix()
+	var records []testRecord
+	for i := int64(0); i < 24; i++ {
+		records = append(records, testRecord{epoch: start + i*3600, vals: map[string]float64{"outTemp": 60 + float64(i%12)}})
+	}
+	a, db := newTestArchive(t, records)
+	blocking := &blockingArchive{Archive: a, scanning: make(chan struct{}), release: make(chan struct{})}
+	book := NewRecordBook(blocking)
+
+	loaded := make(chan error)
+	go func() { loaded <- book.Load(context.Background()) }()
+	<-blocking.scanning
+
+	// A record arriving mid-load: the poller reads it from the archive, then offers it
+	// to the book, which must not wait for the history scan
+	late := testRecord{epoch: start + 30*3600, vals: map[string]float64{"outTemp": 95}}
+	insertTestRecord(t, db, late)
+	observed := make(chan []BrokenRecord)
+	go func() { observed <- book.Observe(late.epoch, late.vals) }()
+	select {
+	case broken := <-observed:
+		if broken != nil {
+			t.Errorf("Observe before the load finished reported %v", broken)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Observe blocked on the history scan")
+	}
+
+	close(blocking.release)
+	if err := <-loaded; err != nil {
+		t.Fatal(err)
+	}
+
+	// The late record was folded in by the load, once
+	periods, err := book.Records(context.Background(), station.Now(), station.Now().Month())
+	if err != nil {
+		t.Fatal(err)
+	}
+	high := periods["allTime"]["highTemp"]
+	if high == nil || high.Value != 95 || high.Time != late.epoch {
+		t.Errorf("all-time high = %+v, want 95 at %d", high, late.epoch)
+	}
+	if book.lastEpoch != late.epoch {
+		t.Errorf("lastEpoch = %d, want %d", book.lastEpoch, late.epoch)
+	}
+	if broken := book.Observe(late.epoch, late.vals); broken != nil {
+		t.Errorf("re-observing a loaded record reported %v", broken)
+	}
+}
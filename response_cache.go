@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Archive data only changes when WeeWX writes a record, so the read-only JSON
+// endpoints are cached in memory per path and query string. The SSE poller drops
+// every entry when it sees a new MAX(dateTime); max_age bounds how long rolling
+// windows and "today" figures can lag while the station is silent. Responses carry
+// an ETag and Last-Modified so polling clients revalidate with a 304.
+
+// cachedResponse is a complete 200 response
+type cachedResponse struct {
+	header   http.Header
+	body     []byte
+	etag     string
+	modified time.Time // time of the newest archive record
+	created  time.Time
+}
+
+// ResponseCache holds cached responses built from the archive as of epoch
+type ResponseCache struct {
+	maxEntries int
+	maxAge     time.Duration
+	group      singleflight.Group
+
+	mu      sync.Mutex
+	epoch   int64 // newest archive record; 0 until the poller has seen one
+	entries map[string]*cachedResponse
+}
+
+var responseCache *ResponseCache
+
+func NewResponseCache(cfg ResponseCacheConfig) (*ResponseCache, error) {
+	maxAge, err := time.ParseDuration(cfg.MaxAge)
+	if err != nil || maxAge <= 0 {
+		return nil, fmt.Errorf("response_cache.max_age %q: must be a positive duration", cfg.MaxAge)
+	}
+	return &ResponseCache{
+		maxEntries: cfg.MaxEntries,
+		maxAge:     maxAge,
+		entries:    make(map[string]*cachedResponse),
+	}, nil
+}
+
+// Invalidate drops every entry once the archive has a record newer than the ones
+// they were built from. It is registered with SSEBroker.OnRecord.
+func (c *ResponseCache) Invalidate(epoch int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch <= c.epoch {
+		return
+	}
+	c.epoch = epoch
+	clear(c.entries)
+}
+
+// lookup returns the fresh entry for key and the archive epoch it must match
+func (c *ResponseCache) lookup(key string) (*cachedResponse, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	if e != nil && time.Since(e.created) > c.maxAge {
+		delete(c.entries, key)
+		e = nil
+	}
+	return e, c.epoch
+}
+
+// store keeps e unless the archive moved on while it was being built
+func (c *ResponseCache) store(key string, epoch int64, e *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch != c.epoch {
+		return
+	}
+	if len(c.entries) >= c.maxEntries {
+		var oldest string
+		for k, o := range c.entries {
+			if oldest == "" || o.created.Before(c.entries[oldest].created) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = e
+}
+
+// responseRecorder buffers a handler's response so it can be cached
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header { return rec.header }
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+// build runs next for r and records its response. entry is nil unless the handler
+// succeeded.
+func (c *ResponseCache) build(next http.HandlerFunc, r *http.Request, epoch int64) (rec *responseRecorder, entry *cachedResponse) {
+	rec = &responseRecorder{header: make(http.Header)}
+	next(rec, r)
+	if rec.status != http.StatusOK {
+		return rec, nil
+	}
+	sum := sha256.Sum256(rec.body.Bytes())
+	return rec, &cachedResponse{
+		header:   rec.header,
+		body:     rec.body.Bytes(),
+		etag:     `"` + hex.EncodeToString(sum[:12]) + `"`,
+		modified: time.Unix(epoch, 0).UTC(),
+		created:  time.Now(),
+	}
+}
+
+type cacheBuild struct {
+	rec   *responseRecorder
+	entry *cachedResponse
+}
+
+// Handler serves GET requests for next from the cache. Concurrent misses for the
+// same key share one run of next, detached from the first caller's cancellation so
+// the others still get a response.
+func (c *ResponseCache) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next(w, r)
+			return
+		}
+		key := r.URL.Path + "?" + r.URL.Query().Encode()
+
+		e, epoch := c.lookup(key)
+		if e != nil {
+			responseCacheRequests.Inc("hit")
+			c.serve(w, r, e)
+			return
+		}
+		if epoch == 0 {
+			// The poller hasn't read the archive yet, so nothing would invalidate the entry
+			responseCacheRequests.Inc("bypass")
+			next(w, r)
+			return
+		}
+
+		v, _, shared := c.group.Do(fmt.Sprintf("%d %s", epoch, key), func() (interface{}, error) {
+			rec, entry := c.build(next, r.WithContext(context.WithoutCancel(r.Context())), epoch)
+			if entry != nil {
+				c.store(key, epoch, entry)
+			}
+			return cacheBuild{rec, entry}, nil
+		})
+		b := v.(cacheBuild)
+		if b.entry == nil {
+			// Errors aren't cached; pass the recorded response through
+			for k, vs := range b.rec.header {
+				w.Header()[k] = vs
+			}
+			w.WriteHeader(b.rec.status)
+			w.Write(b.rec.body.Bytes())
+			return
+		}
+		if shared {
+			responseCacheRequests.Inc("shared")
+		} else {
+			responseCacheRequests.Inc("miss")
+		}
+		c.serve(w, r, b.entry)
+	}
+}
+
+// serve writes e, or 304 Not Modified when the client already has it
+func (c *ResponseCache) serve(w http.ResponseWriter, r *http.Request, e *cachedResponse) {
+	h := w.Header()
+	h.Set("ETag", e.etag)
+	h.Set("Last-Modified", e.modified.Format(http.TimeFormat))
+	// Browsers must revalidate every time, since the data changes with each record
+	h.Set("Cache-Control", "private, no-cache")
+
+	if notModified(r, e) {
+		responseCacheRequests.Inc("not_modified")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	for k, vs := range e.header {
+		h[k] = vs
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(e.body)
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is none
+func notModified(r *http.Request, e *cachedResponse) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == e.etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !e.modified.After(t)
+	}
+	return false
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// seriesColumn is one archive column requested by a series handler, along with how
+// it should be combined when the series is downsampled.
+type seriesColumn struct {
+	Name string
+	Agg  aggKind
+	// WeightBy names another column in the same query used to weight an aggDir
+	// column (e.g. wind direction weighted by wind speed)
+	WeightBy string
+}
+
+// seriesFields are the archive columns /api/series serves, with how each is combined
+// when downsampled. The per-quantity endpoints take their columns from here too.
+var seriesFields = []seriesColumn{
+	{Name: "outTemp", Agg: aggMean},
+	{Name: "dewpoint", Agg: aggMean},
+	{Name: "outHumidity", Agg: aggMean},
+	{Name: "barometer", Agg: aggMean},
+	{Name: "pressure", Agg: aggMean},
+	{Name: "altimeter", Agg: aggMean},
+	// Heat index keeps its peaks and wind chill its lows
+	{Name: "heatindex", Agg: aggMax},
+	{Name: "windchill", Agg: aggMin},
+	{Name: "windSpeed", Agg: aggMean},
+	{Name: "windGust", Agg: aggMax},
+	// Direction is a speed-weighted vector average
+	{Name: "windDir", Agg: aggDir, WeightBy: "windSpeed"},
+	// Rate keeps its peak and amounts are summed
+	{Name: "rainRate", Agg: aggMax},
+	{Name: "rain", Agg: aggSum},
+	// Strikes are summed; distance keeps the closest strike
+	{Name: "lightning_strike_count", Agg: aggSum},
+	{Name: "lightning_distance", Agg: aggMin},
+	{Name: "inTemp", Agg: aggMean},
+	{Name: "inHumidity", Agg: aggMean},
+}
+
+func seriesField(name string) (seriesColumn, bool) {
+	for _, c := range seriesFields {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return seriesColumn{}, false
+}
+
+// seriesColumns returns the named seriesFields in order. Names come from handler
+// code, so an unknown one is a programming error.
+func seriesColumns(names ...string) []seriesColumn {
+	cols := make([]seriesColumn, len(names))
+	for i, name := range names {
+		c, ok := seriesField(name)
+		if !ok {
+			panic("unknown series field " + name)
+		}
+		cols[i] = c
+	}
+	return cols
+}
+
+// parseSeriesFields reads a comma-separated ?fields= list
+func parseSeriesFields(spec string) ([]seriesColumn, *apiError) {
+	var cols []seriesColumn
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		c, ok := seriesField(name)
+		if !ok {
+			return nil, badParam("fields", "invalid_fields", "unknown field %q", name)
+		}
+		for _, o := range cols {
+			if o.Name == name {
+				return nil, badParam("fields", "invalid_fields", "field %q requested twice", name)
+			}
+		}
+		cols = append(cols, c)
+	}
+	if len(cols) == 0 {
+		return nil, badParam("fields", "invalid_fields", "fields is required, e.g. fields=outTemp,dewpoint")
+	}
+	return cols, nil
+}
+
+// sample is one archive row, or one downsampled bucket, of a series query.
+// Values are in the same order as the requested columns.
+type sample struct {
+	Epoch  int64
+	Values []sql.NullFloat64
+}
+
+// seriesResult carries both the raw rows (used for "latest reading" derived fields
+// such as pressure trend) and the possibly downsampled rows that are returned.
+// Raw stays in archive source units; Samples are converted to Units.
+type seriesResult struct {
+	Raw     []sample
+	Samples []sample
+	Units   UnitSystem
+}
+
+// scanSamples calls fn for each archive row of the given columns in the window,
+// ascending by dateTime, skipping rows where the notNull column (if any) is NULL. The
+// sample's Values are reused between calls.
+func scanSamples(ctx context.Context, query string, cols []seriesColumn, tr TimeRange, notNull string, fn func(sample)) error {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = c.Name
+	}
+	start, end := tr.Bounds()
+	scan := ArchiveScan{Columns: names, Start: start, End: end, NotNull: notNull}
+	return archive.Scan(ctx, query, scan, func(epoch int64, vals []sql.NullFloat64) error {
+		fn(sample{Epoch: epoch, Values: vals})
+		return nil
+	})
+}
+
+// querySamples reads the given columns for the window, ascending by dateTime
+func querySamples(ctx context.Context, cols []seriesColumn, tr TimeRange, notNull string) ([]sample, error) {
+	var samples []sample
+	err := scanSamples(ctx, "series", cols, tr, notNull, func(s sample) {
+		samples = append(samples, sample{Epoch: s.Epoch, Values: slices.Clone(s.Values)})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// projectSamples picks cols out of samples that were read with all, dropping rows
+// whose first column is NULL when notNull is set
+func projectSamples(samples []sample, all, cols []seriesColumn, notNull bool) []sample {
+	idx := make([]int, len(cols))
+	for i, c := range cols {
+		idx[i] = slices.IndexFunc(all, func(o seriesColumn) bool { return o.Name == c.Name })
+	}
+	n := len(cols)
+	values := make([]sql.NullFloat64, len(samples)*n)
+	out := make([]sample, 0, len(samples))
+	for _, s := range samples {
+		if notNull && !s.Values[idx[0]].Valid {
+			continue
+		}
+		vals := values[len(out)*n : (len(out)+1)*n : (len(out)+1)*n]
+		for i, j := range idx {
+			vals[i] = s.Values[j]
+		}
+		out = append(out, sample{Epoch: s.Epoch, Values: vals})
+	}
+	return out
+}
+
+// seriesParams are the range, downsampling and unit parameters of a series request
+type seriesParams struct {
+	Range      TimeRange
+	Downsample Downsample
+	Units      UnitSystem
+}
+
+// parseSeriesParams reads the series parameters, writing an error response on
+// failure. ok is false if the handler should return immediately.
+func parseSeriesParams(w http.ResponseWriter, r *http.Request) (seriesParams, bool) {
+	tr, apiErr := parseTimeRange(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return seriesParams{}, false
+	}
+	ds, apiErr := parseDownsample(r, tr)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return seriesParams{}, false
+	}
+	units, apiErr := unitsFromRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return seriesParams{}, false
+	}
+	return seriesParams{Range: tr, Downsample: ds, Units: units}, true
+}
+
+// result downsamples raw rows of cols and converts them to the requested units
+func (p seriesParams) result(raw []sample, cols []seriesColumn) seriesResult {
+	return seriesResult{
+		Raw:     raw,
+		Samples: convertSamples(p.Downsample.Apply(raw, cols, p.Range), cols, p.Units),
+		Units:   p.Units,
+	}
+}
+
+// loadSeries parses range and downsampling parameters, runs the query and writes an
+// error response on failure. ok is false if the handler should return immediately.
+func loadSeries(w http.ResponseWriter, r *http.Request, name string, cols []seriesColumn, notNull string) (seriesResult, bool) {
+	params, ok := parseSeriesParams(w, r)
+	if !ok {
+		return seriesResult{}, false
+	}
+
+	raw, err := querySamples(r.Context(), cols, params.Range, notNull)
+	if err != nil {
+		log.Printf("DB query error (%s): %v", name, err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return seriesResult{}, false
+	}
+
+	w.Header().Set("X-Units", params.Units.Key())
+	return params.result(raw, cols), true
+}
+
+// seriesSection is one per-quantity series endpoint (weather, wind, ...): its
+// columns and how its readings are built. /api/dashboard builds every section from
+// one shared query.
+type seriesSection struct {
+	Name string
+	Cols []seriesColumn
+	// NotNull skips rows whose first column is NULL
+	NotNull bool
+	Build   func(seriesResult) interface{}
+}
+
+// serveSeriesSection answers the section's own endpoint
+func serveSeriesSection(w http.ResponseWriter, r *http.Request, sec *seriesSection) {
+	w.Header().Set("Content-Type", "application/json")
+
+	notNull := ""
+	if sec.NotNull {
+		notNull = sec.Cols[0].Name
+	}
+	series, ok := loadSeries(w, r, sec.Name, sec.Cols, notNull)
+	if !ok {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(sec.Build(series)); err != nil {
+		http.Error(w, "JSON error", http.StatusInternalServerError)
+	}
+}
+
+// seriesValues marshals as a JSON array with null for missing readings
+type seriesValues []sql.NullFloat64
+
+func (v seriesValues) MarshalJSON() ([]byte, error) {
+	b := make([]byte, 0, 2+len(v)*8)
+	b = append(b, '[')
+	for i, x := range v {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		if x.Valid {
+			b = strconv.AppendFloat(b, x.Float64, 'f', -1, 64)
+		} else {
+			b = append(b, "null"...)
+		}
+	}
+	return append(b, ']'), nil
+}
+
+// -------------------- /api/series --------------------
+
+// handleSeries returns any set of archive fields from a single query in columnar
+// form: one timestamp array shared by a value array per field
+func handleSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cols, apiErr := parseSeriesFields(r.URL.Query().Get("fields"))
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	// Query weighting columns (wind speed for direction) even when they weren't asked
+	// for, so downsampled directions match /api/wind
+	requested := len(cols)
+	for _, c := range cols[:requested] {
+		if c.WeightBy == "" {
+			continue
+		}
+		if !slices.ContainsFunc(cols, func(o seriesColumn) bool { return o.Name == c.WeightBy }) {
+			weight, _ := seriesField(c.WeightBy)
+			cols = append(cols, weight)
+		}
+	}
+
+	series, ok := loadSeries(w, r, "series", cols, "")
+	if !ok {
+		return
+	}
+
+	resp := SeriesResponse{
+		Timestamps: make([]int64, len(series.Samples)),
+		Fields:     make([]string, requested),
+		Values:     make(map[string]seriesValues, requested),
+		Units:      make(map[string]string),
+	}
+	for i, s := range series.Samples {
+		resp.Timestamps[i] = s.Epoch
+	}
+	for j, c := range cols[:requested] {
+		values := make(seriesValues, len(series.Samples))
+		for i, s := range series.Samples {
+			values[i] = s.Values[j]
+		}
+		resp.Fields[j] = c.Name
+		resp.Values[c.Name] = values
+		if q, ok := archiveQuantities[c.Name]; ok {
+			resp.Units[c.Name] = series.Units.Label(q)
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "JSON error", http.StatusInternalServerError)
+	}
+}
@@ -1,154 +1,410 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-// SSEBroker polls the database for new readings and broadcasts them to connected SSE clients.
+// SSE event types clients can subscribe to with ?events=update,alert,...
+const (
+	sseUpdate    = "update"    // a new archive record
+	sseAlert     = "alert"     // an alert fired or cleared
+	sseStatus    = "status"    // poller/database health changes
+	sseCelestial = "celestial" // today's sun/moon data after the daily refresh
+)
+
+var sseEventTypes = []string{sseUpdate, sseAlert, sseStatus, sseCelestial}
+
+// sseID orders events. Updates use the archive epoch as their id ("1718900000");
+// other events raised after that record use "<epoch>.<seq>".
+type sseID struct {
+	Epoch int64
+	Seq   int
+}
+
+func (id sseID) String() string {
+	if id.Seq == 0 {
+		return strconv.FormatInt(id.Epoch, 10)
+	}
+	return fmt.Sprintf("%d.%d", id.Epoch, id.Seq)
+}
+
+func (id sseID) after(o sseID) bool {
+	return id.Epoch > o.Epoch || (id.Epoch == o.Epoch && id.Seq > o.Seq)
+}
+
+func parseSSEID(v string) (sseID, bool) {
+	epochStr, seqStr, hasSeq := strings.Cut(strings.TrimSpace(v), ".")
+	epoch, err := strconv.ParseInt(epochStr, 10, 64)
+	if err != nil {
+		return sseID{}, false
+	}
+	id := sseID{Epoch: epoch}
+	if hasSeq {
+		if id.Seq, err = strconv.Atoi(seqStr); err != nil {
+			return sseID{}, false
+		}
+	}
+	return id, true
+}
+
+// sseEvent is one buffered event. Payloads that contain measurements are rendered per
+// unit system on first use and cached.
+type sseEvent struct {
+	ID     sseID
+	Type   string
+	render func(units UnitSystem) ([]byte, error)
+
+	mu       sync.Mutex
+	rendered map[string][]byte
+}
+
+func (ev *sseEvent) data(units UnitSystem) ([]byte, error) {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	if b, ok := ev.rendered[units.Key()]; ok {
+		return b, nil
+	}
+	b, err := ev.render(units)
+	if err != nil {
+		return nil, err
+	}
+	if ev.rendered == nil {
+		ev.rendered = make(map[string][]byte)
+	}
+	ev.rendered[units.Key()] = b
+	return b, nil
+}
+
+// sseClient is one connected stream
+type sseClient struct {
+	ch     chan *sseEvent
+	units  UnitSystem
+	events map[string]bool // subscribed event types
+	lagged chan struct{}   // closed when the client fell behind and must reconnect
+}
+
+// SSEBroker polls the database for new readings and broadcasts them to connected SSE
+// clients. Recent events are kept in a ring buffer so clients reconnecting with
+// Last-Event-ID receive what they missed.
 type SSEBroker struct {
-	db        *sql.DB
+	archive   Archive
 	mu        sync.Mutex
-	clients   map[chan string]struct{}
+	clients   map[*sseClient]bool
 	lastEpoch int64
+	// onRecord hooks run (on the poller goroutine) for every new archive record
+	onRecord []func(epoch int64)
+
+	ring      []*sseEvent // oldest first, at most replay events
+	lastID    sseID
+	status    string // last published status state
+	replay    int
+	retryMs   int
+	heartbeat time.Duration
 }
 
-func NewSSEBroker(db *sql.DB) *SSEBroker {
+func NewSSEBroker(archive Archive) *SSEBroker {
 	return &SSEBroker{
-		db:      db,
-		clients: make(map[chan string]struct{}),
+		archive:   archive,
+		clients:   make(map[*sseClient]bool),
+		replay:    appConfig.Server.SSEReplayEvents,
+		retryMs:   appConfig.Server.SSERetryMs,
+		heartbeat: time.Duration(appConfig.Server.SSEHeartbeatSeconds) * time.Second,
 	}
 }
 
-func (b *SSEBroker) AddClient(ch chan string) {
+// OnRecord registers fn to be called with the epoch of each new archive record
+func (b *SSEBroker) OnRecord(fn func(epoch int64)) {
+	b.onRecord = append(b.onRecord, fn)
+}
+
+// addClient registers c and returns the buffered events newer than since, under the
+// same lock so nothing published in between is lost or duplicated
+func (b *SSEBroker) addClient(c *sseClient, since *sseID) []*sseEvent {
 	b.mu.Lock()
-	b.clients[ch] = struct{}{}
-	b.mu.Unlock()
-	log.Printf("[SSE] client connected; total=%d", b.clientCount())
+	defer b.mu.Unlock()
+	b.clients[c] = true
+	log.Printf("[SSE] client connected; total=%d", len(b.clients))
+
+	if since == nil {
+		return nil
+	}
+	var missed []*sseEvent
+	for _, ev := range b.ring {
+		if ev.ID.after(*since) && c.events[ev.Type] {
+			missed = append(missed, ev)
+		}
+	}
+	if len(b.ring) > 0 && b.ring[0].ID.after(*since) {
+		log.Printf("[SSE] client resumed from %s, older than the replay buffer (%s)", since, b.ring[0].ID)
+	}
+	return missed
 }
 
-func (b *SSEBroker) RemoveClient(ch chan string) {
+func (b *SSEBroker) RemoveClient(c *sseClient) {
 	b.mu.Lock()
-	delete(b.clients, ch)
+	delete(b.clients, c)
+	n := len(b.clients)
 	b.mu.Unlock()
-	log.Printf("[SSE] client disconnected; total=%d", b.clientCount())
+	log.Printf("[SSE] client disconnected; total=%d", n)
 }
 
-func (b *SSEBroker) clientCount() int {
+// setClientEvents replaces the event types c is subscribed to
+func (b *SSEBroker) setClientEvents(c *sseClient, events map[string]bool) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	return len(b.clients)
+	c.events = events
 }
 
-func (b *SSEBroker) broadcast(msg string) {
+// latest returns the most recent buffered event of type typ, or nil
+func (b *SSEBroker) latest(typ string) *sseEvent {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	n := len(b.clients)
-	for ch := range b.clients {
-		select {
-		case ch <- msg:
-		default:
-			// If client is slow, drop this update for them
+	for i := len(b.ring) - 1; i >= 0; i-- {
+		if b.ring[i].Type == typ {
+			return b.ring[i]
 		}
 	}
-	log.Printf("[SSE] broadcast sent to %d clients", n)
+	return nil
 }
 
-// pollOnce checks the archive for the newest timestamp and, if it's newer than the
-// last seen, reads the latest row and broadcasts a JSON payload.
-func (b *SSEBroker) pollOnce() error {
-	var maxEpoch sql.NullInt64
-	err := b.db.QueryRow(`SELECT MAX(dateTime) FROM archive`).Scan(&maxEpoch)
-	if err != nil {
-		log.Printf("[SSE] pollOnce: error reading MAX(dateTime): %v", err)
-		return err
-	}
-	if !maxEpoch.Valid {
-		log.Printf("[SSE] pollOnce: no rows in archive (MAX invalid)")
-		return nil
-	}
-	if maxEpoch.Int64 == b.lastEpoch {
-		log.Printf("[SSE] pollOnce: no change (max=%d, last=%d)", maxEpoch.Int64, b.lastEpoch)
-		return nil
-	}
+func (b *SSEBroker) clientCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
 
-	log.Printf("[SSE] pollOnce: change detected (max=%d, last=%d) — loading latest row", maxEpoch.Int64, b.lastEpoch)
+// publish assigns the next id, buffers the event and fans it out. epoch is the archive
+// epoch of update events; other types are numbered after the latest update.
+// A client whose queue is full is disconnected rather than silently skipped; it
+// reconnects with Last-Event-ID and catches up from the ring buffer.
+func (b *SSEBroker) publish(typ string, epoch int64, render func(UnitSystem) ([]byte, error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	// New data available; read the latest full record
-	row := b.db.QueryRow(`
-        SELECT dateTime, outTemp, dewpoint, barometer, outHumidity, windSpeed, windGust, windDir, rainRate, rain, lightning_strike_count, inTemp, inHumidity
-        FROM archive
-        WHERE dateTime IS NOT NULL
-        ORDER BY dateTime DESC
-        LIMIT 1
-    `)
+	id := sseID{Epoch: epoch}
+	if typ != sseUpdate || !id.after(b.lastID) {
+		id = sseID{Epoch: b.lastID.Epoch, Seq: b.lastID.Seq + 1}
+	}
+	b.lastID = id
 
-	var epoch int64
-	var outTemp, dewpoint, baro, outHum, windSpeed, windGust, windDir, rainRate, rainAmt, lightning sql.NullFloat64
-	var inTemp, inHum sql.NullFloat64
+	ev := &sseEvent{ID: id, Type: typ, render: render}
+	b.ring = append(b.ring, ev)
+	if over := len(b.ring) - b.replay; over > 0 {
+		b.ring = append([]*sseEvent(nil), b.ring[over:]...)
+	}
 
-	if err := row.Scan(&epoch, &outTemp, &dewpoint, &baro, &outHum, &windSpeed, &windGust, &windDir, &rainRate, &rainAmt, &lightning, &inTemp, &inHum); err != nil {
-		log.Printf("[SSE] pollOnce: error scanning latest row: %v", err)
-		return err
+	sseEvents.Inc(typ)
+	sent := 0
+	for c := range b.clients {
+		if !c.events[typ] {
+			continue
+		}
+		select {
+		case c.ch <- ev:
+			sent++
+		default:
+			log.Printf("[SSE] client too slow; disconnecting so it resumes from %s", id)
+			sseDropped.Inc(typ)
+			close(c.lagged)
+			delete(b.clients, c)
+		}
 	}
+	log.Printf("[SSE] %s %s sent to %d clients", typ, id, sent)
+}
 
+// sseColumns are the archive columns included in every live update
+var sseColumns = []string{
+	"outTemp", "dewpoint", "barometer", "outHumidity", "windSpeed", "windGust", "windDir",
+	"rainRate", "rain", "lightning_strike_count", "lightning_distance", "inTemp", "inHumidity",
+	"heatindex", "windchill",
+}
+
+// renderRecord builds the JSON payload for one archive record in the given units.
+// values, derived and broken are in archive source units; NULL columns are simply
+// absent.
+func renderRecord(epoch int64, values map[string]float64, derived LiveDerived, broken []BrokenRecord, units UnitSystem) ([]byte, error) {
 	payload := map[string]interface{}{
 		"timestamp": epoch,
+		"units":     units.Labels(),
 	}
-
-	if outTemp.Valid {
-		payload["outTemp"] = outTemp.Float64
+	for k, v := range values {
+		if q, ok := archiveQuantities[k]; ok {
+			v = units.Convert(q, v)
+		}
+		payload[k] = v
 	}
-	if dewpoint.Valid {
-		payload["dewpoint"] = dewpoint.Float64
+	if derived.FeelsLike != nil {
+		fl := units.Convert(qTemp, *derived.FeelsLike)
+		derived.FeelsLike = &fl
 	}
-	if baro.Valid {
-		payload["barometer"] = baro.Float64
+	payload["derived"] = derived
+	if len(broken) > 0 {
+		records := make([]BrokenRecord, len(broken))
+		for i, br := range broken {
+			q := recordKindNamed(br.Record).Quantity
+			br.Value = units.Convert(q, br.Value)
+			br.Previous = br.Previous.converted(q, units)
+			records[i] = br
+		}
+		payload["records"] = records
 	}
-	if outHum.Valid {
-		payload["outHumidity"] = outHum.Float64
+	return json.Marshal(payload)
+}
+
+// broadcastRecord publishes an archive record as an update event
+func (b *SSEBroker) broadcastRecord(epoch int64, values map[string]float64) {
+	derived := computeLiveDerived(epoch, values)
+	var broken []BrokenRecord
+	if recordBook != nil {
+		broken = recordBook.Observe(epoch, values)
 	}
-	if windSpeed.Valid {
-		payload["windSpeed"] = windSpeed.Float64
+	b.publish(sseUpdate, epoch, func(units UnitSystem) ([]byte, error) {
+		return renderRecord(epoch, values, derived, broken, units)
+	})
+}
+
+// PublishAlert publishes a fired/cleared alert. ev is in display units and is
+// re-expressed in each client's units.
+func (b *SSEBroker) PublishAlert(ev AlertEvent) {
+	q := qNone
+	if alertEngine != nil {
+		q = alertEngine.quantityOf(ev.RuleID)
 	}
-	if windGust.Valid {
-		payload["windGust"] = windGust.Float64
+	b.publish(sseAlert, 0, func(units UnitSystem) ([]byte, error) {
+		out := ev
+		out.Value = units.FromUS(q, displayUnits.ToUS(q, ev.Value))
+		out.Threshold = units.FromUS(q, displayUnits.ToUS(q, ev.Threshold))
+		out.Unit = units.Label(q)
+		return json.Marshal(out)
+	})
+}
+
+// PublishCelestial publishes freshly computed sun/moon data
+func (b *SSEBroker) PublishCelestial(data CelestialData) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("[SSE] celestial render error: %v", err)
+		return
 	}
-	if windDir.Valid {
-		payload["windDir"] = windDir.Float64
+	b.publish(sseCelestial, 0, func(UnitSystem) ([]byte, error) {
+		return payload, nil
+	})
+}
+
+// setStatus publishes a status event when the poller's state changes
+// (ok, db_error or no_data)
+func (b *SSEBroker) setStatus(state, message string) {
+	b.mu.Lock()
+	changed := b.status != state
+	b.status = state
+	b.mu.Unlock()
+	if !changed {
+		return
 	}
-	if rainRate.Valid {
-		payload["rainRate"] = rainRate.Float64
+	payload, err := json.Marshal(b.statusPayload(state, message))
+	if err != nil {
+		return
 	}
-	if rainAmt.Valid {
-		payload["rain"] = rainAmt.Float64
+	b.publish(sseStatus, 0, func(UnitSystem) ([]byte, error) {
+		return payload, nil
+	})
+}
+
+func (b *SSEBroker) statusPayload(state, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"state":     state,
+		"message":   message,
+		"lastEpoch": b.lastEpochSeen(),
+		"timestamp": time.Now().Unix(),
 	}
-	if lightning.Valid {
-		payload["lightning_strike_count"] = lightning.Float64
+}
+
+func (b *SSEBroker) lastEpochSeen() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastID.Epoch
+}
+
+// pollOnce publishes every archive record newer than the last one seen. The first
+// poll only publishes the newest record.
+func (b *SSEBroker) pollOnce() error {
+	ctx := context.Background()
+	maxEpoch, ok, err := b.archive.MaxEpoch(ctx, "sse_max")
+	if err != nil {
+		log.Printf("[SSE] pollOnce: error reading MAX(dateTime): %v", err)
+		b.setStatus("db_error", "database unavailable")
+		markJobFailed("sse_poller", err)
+		return err
 	}
-	if inTemp.Valid {
-		payload["inTemp"] = inTemp.Float64
+	if !ok {
+		log.Printf("[SSE] pollOnce: no rows in archive (MAX invalid)")
+		b.setStatus("no_data", "archive is empty")
+		markJobOK("sse_poller")
+		return nil
 	}
-	if inHum.Valid {
-		payload["inHumidity"] = inHum.Float64
+	if maxEpoch == b.lastEpoch {
+		log.Printf("[SSE] pollOnce: no change (max=%d, last=%d)", maxEpoch, b.lastEpoch)
+		b.setStatus("ok", "")
+		markJobOK("sse_poller")
+		return nil
 	}
 
-	b.lastEpoch = epoch
+	log.Printf("[SSE] pollOnce: change detected (max=%d, last=%d) — loading new rows", maxEpoch, b.lastEpoch)
 
-	bts, err := json.Marshal(payload)
+	type record struct {
+		epoch  int64
+		values map[string]float64
+	}
+	var records []record
+	add := func(epoch int64, vals []sql.NullFloat64) error {
+		values := make(map[string]float64, len(sseColumns))
+		for i, col := range sseColumns {
+			if vals[i].Valid {
+				values[col] = vals[i].Float64
+			}
+		}
+		records = append(records, record{epoch, values})
+		return nil
+	}
+	if b.lastEpoch == 0 {
+		var epoch int64
+		var vals []sql.NullFloat64
+		epoch, vals, err = b.archive.Latest(ctx, "sse_poll", sseColumns, 0, maxEpoch)
+		if err == nil {
+			err = add(epoch, vals)
+		}
+	} else {
+		// Catch up on every record since the last poll (bounded, in case of a long outage)
+		scan := ArchiveScan{Columns: sseColumns, Start: b.lastEpoch + 1, End: math.MaxInt64, Limit: 100}
+		err = b.archive.Scan(ctx, "sse_poll", scan, add)
+	}
 	if err != nil {
+		log.Printf("[SSE] pollOnce: error loading new rows: %v", err)
+		b.setStatus("db_error", "database unavailable")
+		markJobFailed("sse_poller", err)
 		return err
 	}
+	// Only a poll that delivered its rows counts as healthy
+	b.setStatus("ok", "")
+	markJobOK("sse_poller")
 
-	// Broadcast as a single SSE update event
-	msg := string(bts)
-	b.broadcast(msg)
-	log.Printf("[SSE] broadcast: new update epoch=%d payloadSize=%dB", epoch, len(bts))
+	for _, rec := range records {
+		b.lastEpoch = rec.epoch
+		b.broadcastRecord(rec.epoch, rec.values)
+		for _, fn := range b.onRecord {
+			fn(rec.epoch)
+		}
+	}
 	return nil
 }
 
@@ -180,6 +436,26 @@ func (b *SSEBroker) StartPolling(interval time.Duration, stopCh <-chan struct{})
 	}()
 }
 
+// parseSSEEvents reads ?events=update,alert (default: all types)
+func parseSSEEvents(r *http.Request) (map[string]bool, *apiError) {
+	set := make(map[string]bool)
+	spec := strings.TrimSpace(r.URL.Query().Get("events"))
+	if spec == "" {
+		for _, t := range sseEventTypes {
+			set[t] = true
+		}
+		return set, nil
+	}
+	for _, t := range strings.Split(spec, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if !containsString(sseEventTypes, t) {
+			return nil, badParam("events", "invalid_events", "unknown event type %q (use %s)", t, strings.Join(sseEventTypes, ", "))
+		}
+		set[t] = true
+	}
+	return set, nil
+}
+
 // HTTP handler for /api/stream
 func (b *SSEBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	flusher, ok := w.(http.Flusher)
@@ -188,19 +464,76 @@ func (b *SSEBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	units, apiErr := unitsFromRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	events, apiErr := parseSSEEvents(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	// Browsers send Last-Event-ID on reconnect; ?lastEventId= allows a manual resume
+	var since *sseID
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = r.URL.Query().Get("lastEventId")
+	}
+	if resume != "" {
+		id, ok := parseSSEID(resume)
+		if !ok {
+			writeAPIError(w, badParam("lastEventId", "invalid_event_id", "unrecognised event id %q", resume))
+			return
+		}
+		since = &id
+	}
+
 	// Set SSE headers
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
+	// Disable proxy buffering (nginx) so events are delivered immediately
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	client := &sseClient{
+		ch:     make(chan *sseEvent, 16),
+		units:  units,
+		events: events,
+		lagged: make(chan struct{}),
+	}
+	missed := b.addClient(client, since)
+	defer b.RemoveClient(client)
 
-	msgCh := make(chan string, 4)
-	b.AddClient(msgCh)
-	defer b.RemoveClient(msgCh)
+	// Establish the stream and tell the browser how soon to reconnect
+	fmt.Fprintf(w, ": connected\nretry: %d\n\n", b.retryMs)
 
-	// Send a short comment to establish the stream
-	fmt.Fprintf(w, ": connected\n\n")
+	write := func(ev *sseEvent) bool {
+		data, err := ev.data(client.units)
+		if err != nil {
+			log.Printf("[SSE] render error for %s %s: %v", ev.Type, ev.ID, err)
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data); err != nil {
+			return false
+		}
+		return true
+	}
+
+	for _, ev := range missed {
+		if !write(ev) {
+			return
+		}
+	}
+	if len(missed) > 0 {
+		log.Printf("[SSE] replayed %d events since %s", len(missed), since)
+	}
 	flusher.Flush()
 
+	heartbeat := time.NewTicker(b.heartbeat)
+	defer heartbeat.Stop()
+
 	// Listen until client disconnects
 	notify := r.Context().Done()
 	for {
@@ -208,12 +541,19 @@ func (b *SSEBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case <-notify:
 			log.Printf("[SSE] client disconnected (http context done)")
 			return
-		case msg := <-msgCh:
-			// SSE event named 'update'
-			fmt.Fprintf(w, "event: update\n")
-			fmt.Fprintf(w, "data: %s\n\n", msg)
+		case <-client.lagged:
+			return
+		case <-heartbeat.C:
+			// Comment lines keep proxies and NAT from closing an idle stream
+			if _, err := fmt.Fprintf(w, ": heartbeat %d\n\n", time.Now().Unix()); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev := <-client.ch:
+			if !write(ev) {
+				return
+			}
 			flusher.Flush()
-			// Note: per-client send logged at broadcast; here we can trace per-delivery if needed.
 		}
 	}
 }
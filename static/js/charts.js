@@ -13,16 +13,9 @@ function formatTime24FromISO(timeStr) {
 }
 // --- Statistics Panel: Rain & Lightning Totals ---
 (function(){
-    async function fetchJSON(url) {
-        const res = await fetch(url);
-        if (!res.ok) throw new Error(`HTTP ${res.status} for ${url}`);
-        return res.json();
-    }
-
     async function computeStatistics(range) {
         try {
-            const q = `?range=${encodeURIComponent(range)}`;
-            const stats = await fetchJSON(`/api/statistics${q}`);
+            const stats = await dashboardSection('statistics', '/api/statistics', range);
 
             // Update all Today/Range values directly from backend response
             const el = (id) => document.getElementById(id);
@@ -148,22 +141,23 @@ function formatTime24FromISO(timeStr) {
             }
 
             // Dynamically set units column labels
+            const u = Object.assign({}, UNITS, stats.units || {});
             const units = {
-                'stats-rain-unit': 'in',
+                'stats-rain-unit': u.rain,
                 'stats-strike-unit': '',
-                'stats-temp-unit': '°F',
-                'stats-feels-unit': '°F',
-                'stats-dew-unit': '°F',
+                'stats-temp-unit': u.temperature,
+                'stats-feels-unit': u.temperature,
+                'stats-dew-unit': u.temperature,
                 'stats-humidity-unit': '%',
-                'stats-barometer-unit': 'inHg',
-                'stats-wind-max-unit': 'mph',
-                'stats-wind-avg-unit': 'mph',
-                'stats-wind-rms-unit': 'mph',
-                'stats-wind-vector-unit': 'mph',
+                'stats-barometer-unit': u.pressure,
+                'stats-wind-max-unit': u.wind,
+                'stats-wind-avg-unit': u.wind,
+                'stats-wind-rms-unit': u.wind,
+                'stats-wind-vector-unit': u.wind,
                 'stats-wind-vector-dir-unit': '°',
-                'stats-rain-rate-unit': 'in/h',
-                'stats-lightning-distance-unit': 'mi',
-                'stats-inside-temp-unit': '°F',
+                'stats-rain-rate-unit': u.rainRate,
+                'stats-lightning-distance-unit': u.distance,
+                'stats-inside-temp-unit': u.temperature,
                 'stats-inside-hum-unit': '%'
             };
             for (const [id, text] of Object.entries(units)) {
@@ -229,10 +223,7 @@ async function loadWeather() {
     statusEl.textContent = 'Loading data (' + currentRange + ')...';
 
     try {
-        const res = await fetch('/api/weather?range=' + encodeURIComponent(currentRange));
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-
-        let data = await res.json();
+        let data = await dashboardSection('weather', '/api/weather', currentRange);
         if (data === null) data = [];
         if (!Array.isArray(data) || data.length === 0) {
             statusEl.textContent = 'No weather data for selected range.';
@@ -275,7 +266,7 @@ function renderWeatherChart(labels, temps, dews, times) {
             labels,
             datasets: [
                 {
-                    label: 'Temperature (°F)',
+                    label: `Temperature (${UNITS.temperature})`,
                     data: temps,
                     borderWidth: 2.5,
                     borderColor: 'rgba(220, 38, 38, 1)',
@@ -283,7 +274,7 @@ function renderWeatherChart(labels, temps, dews, times) {
                     yAxisID: 'y'
                 },
                 {
-                    label: 'Dew Point (°F)',
+                    label: `Dew Point (${UNITS.temperature})`,
                     data: dews,
                     borderWidth: 2,
                     borderColor: 'rgba(34, 211, 238, 0.95)',
@@ -309,7 +300,7 @@ function renderWeatherChart(labels, temps, dews, times) {
                 y: {
                     type: 'linear',
                     position: 'left',
-                    title: { display: true, text: 'Temperature / Dew Point (°F)' },
+                    title: { display: true, text: `Temperature / Dew Point (${UNITS.temperature})` },
                     ticks: { maxTicksLimit: 7 },
                     grid: { color: 'rgba(148, 163, 184, 0.25)' }
                 },
@@ -331,10 +322,7 @@ async function loadBarometer() {
     updateCurrentConditions();
 
     try {
-        const res = await fetch('/api/barometer?range=' + encodeURIComponent(currentRange));
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-
-        const data = await res.json();
+        const data = await dashboardSection('barometer', '/api/barometer', currentRange);
         if (!Array.isArray(data) || data.length === 0 || !hasMasterTimes()) {
             console.warn('No barometer data or no master timeline.');
             latestBarometer = null;
@@ -372,7 +360,7 @@ function renderBarometerChart(labels, pressures, times) {
             labels,
             datasets: [
                 {
-                    label: 'Barometer (inHg)',
+                    label: `Barometer (${UNITS.pressure})`,
                     data: pressures,
                     borderWidth: 2.2,
                     borderColor: 'rgba(16, 185, 129, 0.95)',
@@ -394,7 +382,7 @@ function renderBarometerChart(labels, pressures, times) {
             },
             scales: {
                 y: {
-                    title: { display: true, text: 'Barometric Pressure (inHg)' },
+                    title: { display: true, text: `Barometric Pressure (${UNITS.pressure})` },
                     ticks: { maxTicksLimit: 6 },
                     grid: { color: 'rgba(148, 163, 184, 0.25)' }
                 },
@@ -416,10 +404,7 @@ async function loadFeelsLike() {
     updateCurrentConditions();
 
     try {
-        const res = await fetch('/api/feelslike?range=' + encodeURIComponent(currentRange));
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-
-        const data = await res.json();
+        const data = await dashboardSection('feelslike', '/api/feelslike', currentRange);
         if (!Array.isArray(data) || data.length === 0 || !hasMasterTimes()) {
             console.warn('No feels-like data or no master timeline.');
             latestFeelsLike = null;
@@ -458,7 +443,7 @@ function renderFeelsLikeChart(labels, heatVals, chillVals, activeSource, times)
             labels,
             datasets: [
                 {
-                    label: 'Heat Index (°F)',
+                    label: `Heat Index (${UNITS.temperature})`,
                     data: heatVals,
                     yAxisID: 'y',
                     borderWidth: heatActive ? 3 : 1.5,
@@ -468,7 +453,7 @@ function renderFeelsLikeChart(labels, heatVals, chillVals, activeSource, times)
                     pointHitRadius: 6
                 },
                 {
-                    label: 'Wind Chill (°F)',
+                    label: `Wind Chill (${UNITS.temperature})`,
                     data: chillVals,
                     yAxisID: 'y',
                     borderWidth: chillActive ? 3 : 1.5,
@@ -495,7 +480,7 @@ function renderFeelsLikeChart(labels, heatVals, chillVals, activeSource, times)
                 y: {
                     type: 'linear',
                     position: 'left',
-                    title: { display: true, text: 'Feels Like (°F)' },
+                    title: { display: true, text: `Feels Like (${UNITS.temperature})` },
                     ticks: { maxTicksLimit: 7 },
                     grid: { color: 'rgba(148, 163, 184, 0.25)' }
                 },
@@ -516,10 +501,7 @@ async function loadHumidity() {
     updateCurrentConditions();
 
     try {
-        const res = await fetch('/api/humidity?range=' + encodeURIComponent(currentRange));
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-
-        const data = await res.json();
+        const data = await dashboardSection('humidity', '/api/humidity', currentRange);
         if (!Array.isArray(data) || data.length === 0 || !hasMasterTimes()) {
             console.warn('No humidity data or no master timeline.');
             latestHumidity = null;
@@ -600,10 +582,7 @@ async function loadWind() {
     updateCurrentConditions();
 
     try {
-        const res = await fetch('/api/wind?range=' + encodeURIComponent(currentRange));
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-
-        const data = await res.json();
+        const data = await dashboardSection('wind', '/api/wind', currentRange);
         if (!Array.isArray(data) || data.length === 0 || !hasMasterTimes()) {
             console.warn('No wind data or no master timeline.');
             latestWind = null;
@@ -646,7 +625,7 @@ async function loadWind() {
 
         // Backend now computes strong flag
         windStrong = latestWind.strong;
-        console.log('[Wind] Speed:', latestWind.speed.toFixed(1), 'mph | Gust:', (latestWind.gust || 0).toFixed(1), 'mph | Strong:', windStrong);
+        console.log('[Wind] Speed:', latestWind.speed.toFixed(1), UNITS.wind, '| Gust:', (latestWind.gust || 0).toFixed(1), UNITS.wind, '| Strong:', windStrong);
 
         // Update CC panel with new latestWind + windStrong
         updateCurrentConditions();
@@ -673,7 +652,7 @@ function renderWindChart(labels, speeds, gusts, times) {
             labels,
             datasets: [
                 {
-                    label: 'Wind Speed (mph)',
+                    label: `Wind Speed (${UNITS.wind})`,
                     data: speeds,
                     yAxisID: 'y',
                     borderWidth: 1,
@@ -682,7 +661,7 @@ function renderWindChart(labels, speeds, gusts, times) {
                     pointRadius: 0
                 },
                 {
-                    label: 'Wind Gust (mph)',
+                    label: `Wind Gust (${UNITS.wind})`,
                     data: gusts,
                     yAxisID: 'y',
                     borderWidth: 1,
@@ -708,7 +687,7 @@ function renderWindChart(labels, speeds, gusts, times) {
                 y: {
                     type: 'linear',
                     position: 'left',
-                    title: { display: true, text: 'Wind (mph)' },
+                    title: { display: true, text: `Wind (${UNITS.wind})` },
                     ticks: { maxTicksLimit: 7 },
                     grid: { color: 'rgba(148, 163, 184, 0.25)' }
                 },
@@ -769,7 +748,7 @@ function renderWindVectorChart(speeds, dirs, times) {
                 y: {
                     min: -displayMax,
                     max:  displayMax,
-                    title: { display: true, text: 'Wind Vector (mph)' },
+                    title: { display: true, text: `Wind Vector (${UNITS.wind})` },
                     grid: { color: 'rgba(148, 163, 184, 0.25)' },
                     ticks: {
                         stepSize: 5,
@@ -786,7 +765,7 @@ function renderWindVectorChart(speeds, dirs, times) {
                             const i = ctx.dataIndex;
                             const vec = avgVectors[i];
                             if (!vec) return '';
-                            return `Speed: ${vec.speed.toFixed(1)} mph, Dir: ${vec.direction.toFixed(0)}°`;
+                            return `Speed: ${vec.speed.toFixed(1)} ${UNITS.wind}, Dir: ${vec.direction.toFixed(0)}°`;
                         }
                     }
                 },
@@ -809,10 +788,7 @@ function renderWindVectorChart(speeds, dirs, times) {
 // ---------------------------------------------------------------------
 async function loadWindDirection() {
     try {
-        const res = await fetch('/api/wind?range=' + encodeURIComponent(currentRange));
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-
-        const data = await res.json();
+        const data = await dashboardSection('wind', '/api/wind', currentRange);
         if (!Array.isArray(data) || data.length === 0 || !hasMasterTimes()) {
             console.warn('No wind direction data or no master timeline.');
             return;
@@ -891,7 +867,7 @@ function renderWindDirectionChart(labels, dirs, speeds, times) {
                         label(ctx) {
                             const d = ctx.raw.y;
                             const s = ctx.raw.s;
-                            return `Dir: ${d}°, Speed: ${s} mph`;
+                            return `Dir: ${d}°, Speed: ${s} ${UNITS.wind}`;
                         }
                     }
                 },
@@ -909,10 +885,7 @@ function renderWindDirectionChart(labels, dirs, speeds, times) {
 // ---------------------------------------------------------------------
 async function loadRain() {
     try {
-        const res = await fetch('/api/rain?range=' + encodeURIComponent(currentRange));
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-
-        const data = await res.json();
+        const data = await dashboardSection('rain', '/api/rain', currentRange);
 
         // Compute Rain Today and current rate from data
         if (Array.isArray(data) && data.length > 0) {
@@ -951,7 +924,7 @@ async function loadRain() {
 
             rainToday = totalToday;
             latestRainRate = (typeof lastRate === 'number') ? lastRate : null;
-            console.log('[Rain] Today total:', totalToday.toFixed(2), 'in | Recent rate:', (latestRainRate || 0).toFixed(3), 'in/hr | Recently active:', rainRecentlyActive);
+            console.log('[Rain] Today total:', totalToday.toFixed(2), UNITS.rain, '| Recent rate:', (latestRainRate || 0).toFixed(3), UNITS.rainRate, '| Recently active:', rainRecentlyActive);
         } else {
             rainToday = null;
             latestRainRate = null;
@@ -1056,11 +1029,11 @@ function renderRainRateChart(labels, rates, times) {
                             return 'rgba(148, 163, 184, 0.25)'; // light grey for 0 rate
                         }
 
-                        // Adjust thresholds to your units (these assume in/hr)
-                        if (v < 0.02)  return 'rgba(148, 163, 184, 0.6)'; // very light
-                        if (v < 0.1)   return 'rgba(96, 165, 250, 0.8)';  // light
-                        if (v < 0.3)   return 'rgba(37, 99, 235, 0.9)';   // moderate
-                        if (v < 0.7)   return 'rgba(234, 179, 8, 0.9)';   // heavy
+                        // Thresholds are in in/hr, scaled to the display rain unit
+                        if (v < 0.02 * RAIN_PER_INCH)  return 'rgba(148, 163, 184, 0.6)'; // very light
+                        if (v < 0.1 * RAIN_PER_INCH)   return 'rgba(96, 165, 250, 0.8)';  // light
+                        if (v < 0.3 * RAIN_PER_INCH)   return 'rgba(37, 99, 235, 0.9)';   // moderate
+                        if (v < 0.7 * RAIN_PER_INCH)   return 'rgba(234, 179, 8, 0.9)';   // heavy
                         return 'rgba(220, 38, 38, 0.95)';                 // very heavy
                     }
                 }
@@ -1098,10 +1071,7 @@ function renderRainRateChart(labels, rates, times) {
 // ---------------------------------------------------------------------
 async function loadLightning() {
     try {
-        const res = await fetch('/api/lightning?range=' + encodeURIComponent(currentRange));
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-
-        const data = await res.json();
+        const data = await dashboardSection('lightning', '/api/lightning', currentRange);
 
         // Compute lightningToday from data
         if (Array.isArray(data) && data.length > 0) {
@@ -1140,7 +1110,7 @@ async function loadLightning() {
             }
 
             lightningToday = totalToday;
-            console.log('[Lightning] Today total:', totalToday.toFixed(0), 'strikes | Recently active (10 min):', lightningRecentlyActive, '| Last distance:', lightningDistance ? lightningDistance.toFixed(1) + ' mi' : 'N/A');
+            console.log('[Lightning] Today total:', totalToday.toFixed(0), 'strikes | Recently active (10 min):', lightningRecentlyActive, '| Last distance:', lightningDistance ? lightningDistance.toFixed(1) + ' ' + UNITS.distance : 'N/A');
         } else {
             lightningToday = null;
             lightningDistance = null;
@@ -1311,10 +1281,7 @@ async function loadInsideTemp() {
     updateCurrentConditions();
 
     try {
-        const res = await fetch('/api/insideTemp?range=' + encodeURIComponent(currentRange));
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-
-        const data = await res.json();
+        const data = await dashboardSection('insideTemp', '/api/insideTemp', currentRange);
         if (!Array.isArray(data) || data.length === 0 || !hasMasterTimes()) {
             console.warn('No inside temperature data or no master timeline.');
             latestInsideTemp = null;
@@ -1346,7 +1313,7 @@ function renderInsideTempChart(labels, temps, times) {
             labels,
             datasets: [
                 {
-                    label: 'Inside Temperature (°F)',
+                    label: `Inside Temperature (${UNITS.temperature})`,
                     data: temps,
                     borderWidth: 2.2,
                     borderColor: 'rgba(248, 113, 113, 1)',
@@ -1371,7 +1338,7 @@ function renderInsideTempChart(labels, temps, times) {
                 y: {
                     type: 'linear',
                     position: 'left',
-                    title: { display: true, text: 'Inside Temperature (°F)' },
+                    title: { display: true, text: `Inside Temperature (${UNITS.temperature})` },
                     ticks: { maxTicksLimit: 7 },
                     grid: { color: 'rgba(148, 163, 184, 0.25)' }
                 },
@@ -1392,10 +1359,7 @@ async function loadInsideHumidity() {
     updateCurrentConditions();
 
     try {
-        const res = await fetch('/api/insideHumidity?range=' + encodeURIComponent(currentRange));
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-
-        const data = await res.json();
+        const data = await dashboardSection('insideHumidity', '/api/insideHumidity', currentRange);
         if (!Array.isArray(data) || data.length === 0 || !hasMasterTimes()) {
             console.warn('No inside humidity data or no master timeline.');
             latestInsideHumidity = null;
@@ -1468,12 +1432,52 @@ function renderInsideHumidityChart(labels, inHums, times) {
     });
 }
 
+// ---------------------------------------------------------------------
+// Dashboard bootstrap: every section comes from one /api/dashboard request
+// ---------------------------------------------------------------------
+let dashboardRequest = null;   // { range, promise, settled }
+
+// Start (or join) the /api/dashboard request for a range. An in-flight request is
+// shared; a settled one is only reused when fresh is false.
+function fetchDashboard(range, fresh) {
+    const req = dashboardRequest;
+    if (req && req.range === range && (!req.settled || !fresh)) return req.promise;
+
+    const next = { range, settled: false };
+    next.promise = fetch('/api/dashboard?range=' + encodeURIComponent(range))
+        .then(res => {
+            if (!res.ok) throw new Error('HTTP ' + res.status);
+            return res.json();
+        })
+        .finally(() => { next.settled = true; });
+    dashboardRequest = next;
+    return next.promise;
+}
+
+// One section of the dashboard payload. If the dashboard request itself fails the
+// section's own endpoint is used instead; a section the server reported as failed
+// throws with its error.
+async function dashboardSection(name, url, range) {
+    let data;
+    try {
+        data = await fetchDashboard(range, false);
+    } catch (err) {
+        console.warn('[Dashboard] request failed, loading ' + name + ' on its own:', err);
+        const res = await fetch(url + '?range=' + encodeURIComponent(range));
+        if (!res.ok) throw new Error('HTTP ' + res.status);
+        return res.json();
+    }
+    if (data.errors && data.errors[name]) throw new Error(name + ': ' + data.errors[name]);
+    return name in data.series ? data.series[name] : data[name];
+}
+
 // ---------------------------------------------------------------------
 // Load everything (weather first to establish master timeline)
 // ---------------------------------------------------------------------
 // Flag to prevent overlapping loadAll() runs when polling
 let isLoadAllRunning = false;
 function loadAll() {
+    fetchDashboard(currentRange, true).catch(() => {});
     return loadWeather()
         .then(() => {
             if (!hasMasterTimes()) return;
@@ -1539,6 +1543,8 @@ document.addEventListener('DOMContentLoaded', () => {
                         lastSSEEpoch = ts;
                         console.log('[SSE] update received, new timestamp:', ts);
                     }
+                    // Refresh the current-conditions tiles straight from the payload
+                    applyLiveUpdate(payload);
                 } catch (e) {
                     console.warn('[SSE] failed to parse update payload, triggering refresh', e);
                 }
@@ -1557,6 +1563,24 @@ document.addEventListener('DOMContentLoaded', () => {
                     isLoadAllRunning = false;
                 }
             });
+
+            // Alerts and server status arrive as their own event types
+            es.addEventListener('alert', (ev) => {
+                try {
+                    const alert = JSON.parse(ev.data || '{}');
+                    console.log('[SSE] alert', alert.event, '-', alert.message);
+                } catch (e) {
+                    console.warn('[SSE] failed to parse alert payload', e);
+                }
+            });
+            es.addEventListener('status', (ev) => {
+                try {
+                    const status = JSON.parse(ev.data || '{}');
+                    console.log('[SSE] server status:', status.state, status.message || '');
+                } catch (e) {
+                    console.warn('[SSE] failed to parse status payload', e);
+                }
+            });
         } catch (err) {
             console.warn('[SSE] failed to create EventSource:', err);
         }
@@ -64,8 +64,22 @@ let lightningRecentlyActive = false;
 let windStrong = false;
 
 // Alert thresholds (from server config)
-const FEELS_EXTREME_HEAT = window.APP_CONFIG?.extremeHeat || 95; // °F heat index threshold
-const FEELS_EXTREME_COLD = window.APP_CONFIG?.extremeCold || 32; // °F wind chill threshold
+const FEELS_EXTREME_HEAT = window.APP_CONFIG?.extremeHeat || 95; // heat index threshold (display units)
+const FEELS_EXTREME_COLD = window.APP_CONFIG?.extremeCold || 32; // wind chill threshold (display units)
+
+// Display unit labels (from server config, units section)
+const UNITS = Object.assign({
+    temperature: '°F',
+    pressure: 'inHg',
+    wind: 'mph',
+    rain: 'in',
+    rainRate: 'in/h',
+    distance: 'mi',
+    altitude: 'ft'
+}, window.APP_CONFIG?.units || {});
+
+// Scale factor from inches to the display rain unit (for fixed in/hr thresholds)
+const RAIN_PER_INCH = { in: 1, mm: 25.4, cm: 2.54 }[UNITS.rain] || 1;
 
 // Day / Week / Month selector
 let currentRange = 'day';
@@ -75,10 +89,7 @@ let currentRange = 'day';
 // ---------------------------------------------------------------------
 async function loadCelestial() {
     try {
-        const res = await fetch('/api/celestial');
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-        
-        const data = await res.json();
+        const data = await dashboardSection('celestial', '/api/celestial', currentRange);
         celestialData = data;
         
         console.log('[Celestial] Loaded data:', data);
@@ -488,6 +499,64 @@ function setRange(range) {
     loadAll();
 }
 
+// ---------------------------------------------------------------------
+// Live updates (SSE)
+// ---------------------------------------------------------------------
+// Apply an SSE 'update' payload to the current-conditions state. The server
+// includes the derived fields (compass, pressure trend, feels-like, ...) so the
+// tiles refresh immediately, before the charts are re-fetched.
+function applyLiveUpdate(payload) {
+    if (!payload || typeof payload !== 'object') return;
+    const d = payload.derived || {};
+    const num = (v) => (typeof v === 'number' ? v : null);
+
+    if (num(payload.outTemp) !== null) {
+        latestWeather = Object.assign({}, latestWeather, {
+            temperature: payload.outTemp,
+            dewpoint: num(payload.dewpoint)
+        });
+    }
+    if (num(payload.barometer) !== null) {
+        latestBarometer = Object.assign({}, latestBarometer, { pressure: payload.barometer });
+        if (d.pressureTrend) {
+            barometerLevel = d.pressureLevel;
+            barometerTrend = d.pressureTrend;
+            barometerForecast = d.forecast || 'conditions unchanged';
+        }
+    }
+    if (num(d.feelsLike) !== null) {
+        latestFeelsLike = {
+            activeValue: d.feelsLike,
+            activeSource: d.feelsLikeSource,
+            activeLabel: d.feelsLikeLabel
+        };
+    }
+    if (num(payload.outHumidity) !== null) {
+        latestHumidity = { humidity: payload.outHumidity };
+    }
+    if (num(payload.windSpeed) !== null) {
+        latestWind = {
+            speed: payload.windSpeed,
+            gust: num(payload.windGust),
+            direction: num(payload.windDir),
+            compass: d.compass || '--',
+            strong: !!d.windStrong
+        };
+        windStrong = latestWind.strong;
+    }
+    latestRainRate = num(payload.rainRate) ?? 0;
+    rainRecentlyActive = !!d.rainRecentlyActive;
+    lightningRecentlyActive = !!d.lightningRecentlyActive;
+    if (num(payload.inTemp) !== null) {
+        latestInsideTemp = { inside_temp_f: payload.inTemp };
+    }
+    if (num(payload.inHumidity) !== null) {
+        latestInsideHumidity = { inside_humidity: payload.inHumidity };
+    }
+
+    updateCurrentConditions();
+}
+
 // ---------------------------------------------------------------------
 // Current Conditions sidebar
 // ---------------------------------------------------------------------
@@ -515,7 +584,7 @@ function updateCurrentConditions() {
 
     // Barometer
     if (latestBarometer && typeof latestBarometer.pressure === 'number') {
-        baroEl.textContent = latestBarometer.pressure.toFixed(3) + ' inHg';
+        baroEl.textContent = latestBarometer.pressure.toFixed(UNITS.pressure === 'inHg' ? 3 : 1) + ' ' + UNITS.pressure;
         // Update barometer icon trend class and forecast
         const baroIconEl = document.getElementById('cc-barometer-icon');
         const baroForecastEl = document.getElementById('cc-barometer-forecast');
@@ -543,12 +612,12 @@ function updateCurrentConditions() {
     // Outside temperature / dew point
     if (latestWeather) {
         if (typeof latestWeather.temperature === 'number') {
-            outTempEl.textContent = latestWeather.temperature.toFixed(1) + ' °F';
+            outTempEl.textContent = latestWeather.temperature.toFixed(1) + ' ' + UNITS.temperature;
         } else {
             outTempEl.textContent = '--';
         }
         if (typeof latestWeather.dewpoint === 'number') {
-            outDewEl.textContent  = latestWeather.dewpoint.toFixed(1) + ' °F';
+            outDewEl.textContent  = latestWeather.dewpoint.toFixed(1) + ' ' + UNITS.temperature;
         } else {
             outDewEl.textContent  = '--';
         }
@@ -567,7 +636,7 @@ function updateCurrentConditions() {
         const sourceLabel = latestFeelsLike.activeLabel || 'Air Temp';
         const sourceKey = latestFeelsLike.activeSource || 'air';
         
-        feelsLikeEl.textContent = activeValue.toFixed(1) + ' °F (' + sourceLabel + ')';
+        feelsLikeEl.textContent = activeValue.toFixed(1) + ' ' + UNITS.temperature + ' (' + sourceLabel + ')';
 
         // Swap icon based on active source
         if (feelsIconEl) {
@@ -622,7 +691,7 @@ function updateCurrentConditions() {
         }
 
     } else if (latestWeather && typeof latestWeather.temperature === 'number') {
-        feelsLikeEl.textContent = latestWeather.temperature.toFixed(1) + ' °F (Air Temp)';
+        feelsLikeEl.textContent = latestWeather.temperature.toFixed(1) + ' ' + UNITS.temperature + ' (Air Temp)';
         if (feelsRowEl) feelsRowEl.classList.remove('cc-alert', 'cc-alert-heat', 'cc-alert-cold');
         if (feelsIconEl) feelsIconEl.classList.remove('cc-pulse', 'cc-pulse-heat', 'cc-pulse-cold');
     } else {
@@ -640,15 +709,15 @@ function updateCurrentConditions() {
     if (latestWind && latestWind.speed != null) {
         const spd = latestWind.speed.toFixed(1);
         if (latestWind.speed === 0 || latestWind.direction == null) {
-            windEl.textContent = `${spd} mph  -- (--)°`;
+            windEl.textContent = `${spd} ${UNITS.wind}  -- (--)°`;
         } else {
             const deg = latestWind.direction.toFixed(0);
             // Backend now provides compass direction
             const dir = latestWind.compass || '--';
-            windEl.textContent = `${spd} mph  ${dir} (${deg}°)`;
+            windEl.textContent = `${spd} ${UNITS.wind}  ${dir} (${deg}°)`;
         }
     } else {
-        windEl.textContent = `-- mph -- (--)°`;
+        windEl.textContent = `-- ${UNITS.wind} -- (--)°`;
     }
 
     // Wind alert styling
@@ -674,7 +743,7 @@ if (windRowEl) {
 
     // Inside temperature
     if (latestInsideTemp && typeof latestInsideTemp.inside_temp_f === 'number') {
-        inTempEl.textContent = latestInsideTemp.inside_temp_f.toFixed(1) + ' °F';
+        inTempEl.textContent = latestInsideTemp.inside_temp_f.toFixed(1) + ' ' + UNITS.temperature;
     } else {
         inTempEl.textContent = '--';
     }
@@ -689,7 +758,7 @@ if (windRowEl) {
     // Rain Today + Rain Rate
     if (rainTodayEl) {
         if (typeof rainToday === 'number') {
-            rainTodayEl.textContent = rainToday.toFixed(2) + ' in';
+            rainTodayEl.textContent = rainToday.toFixed(2) + ' ' + UNITS.rain;
         } else {
             rainTodayEl.textContent = '--';
         }
@@ -697,9 +766,9 @@ if (windRowEl) {
 
     if (rainRateEl) {
         if (typeof latestRainRate === 'number') {
-            rainRateEl.textContent = latestRainRate.toFixed(2) + ' in/hr';
+            rainRateEl.textContent = latestRainRate.toFixed(2) + ' ' + UNITS.rainRate;
         } else {
-            rainRateEl.textContent = '0.00 in/hr';
+            rainRateEl.textContent = '0.00 ' + UNITS.rainRate;
         }
     }
 
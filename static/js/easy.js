@@ -30,7 +30,12 @@
         { value: 'lightning_strike_count', label: 'Lightning Strikes' },
         { value: 'lightning_distance', label: 'Lightning Distance' },
         { value: 'inTemp', label: 'Inside Temperature' },
-        { value: 'inHumidity', label: 'Inside Humidity' }
+        { value: 'inHumidity', label: 'Inside Humidity' },
+        { value: 'feelsLike', label: 'Feels Like' },
+        { value: 'dewpointSpread', label: 'Dewpoint Depression' },
+        { value: 'windCompass', label: 'Wind Direction (Compass)' },
+        { value: 'windU', label: 'Wind U (East) Component' },
+        { value: 'windV', label: 'Wind V (North) Component' }
     ];
 
     // Track column dropdowns
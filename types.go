@@ -6,6 +6,60 @@ type PingResponse struct {
 	Message string `json:"message"`
 }
 
+// HealthResponse is the /health body; Status is the worst component status
+type HealthResponse struct {
+	Status        string            `json:"status"` // ok, degraded, unhealthy
+	Timestamp     int64             `json:"timestamp"`
+	UptimeSeconds int64             `json:"uptimeSeconds"`
+	Components    []HealthComponent `json:"components"`
+}
+
+type HealthComponent struct {
+	Name    string                 `json:"name"`
+	Status  string                 `json:"status"`
+	Message string                 `json:"message,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// APIKey is an API key as listed by /api/keys; the token itself is only returned
+// when the key is created
+type APIKey struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	RatePerMinute float64  `json:"ratePerMinute"`
+	Burst         int      `json:"burst"`
+	QuotaPerDay   int      `json:"quotaPerDay"` // 0 = unlimited
+	UsedToday     int      `json:"usedToday"`
+	CreatedAt     int64    `json:"createdAt"`
+	CreatedBy     string   `json:"createdBy,omitempty"`
+	ExpiresAt     int64    `json:"expiresAt,omitempty"`
+	LastUsedAt    int64    `json:"lastUsedAt,omitempty"`
+	RevokedAt     int64    `json:"revokedAt,omitempty"`
+	Token         string   `json:"token,omitempty"`
+}
+
+// SeriesResponse is the columnar /api/series payload. Values[field][i] is the reading
+// at Timestamps[i] (Unix seconds), null when missing.
+type SeriesResponse struct {
+	Timestamps []int64                 `json:"timestamps"`
+	Fields     []string                `json:"fields"`
+	Values     map[string]seriesValues `json:"values"`
+	Units      map[string]string       `json:"units"` // unit label per field that has one
+}
+
+// DashboardResponse is everything the dashboard loads for a range (/api/dashboard).
+// Series holds each chart section under its endpoint name (weather, wind, ...) in the
+// same shape that endpoint returns. Sections that failed are null and have a message
+// in Errors.
+type DashboardResponse struct {
+	Series     map[string]interface{} `json:"series"`
+	Statistics *StatisticsData        `json:"statistics"`
+	Celestial  *CelestialData         `json:"celestial"`
+	Units      map[string]string      `json:"units"`
+	Errors     map[string]string      `json:"errors,omitempty"`
+}
+
 type BarometerReading struct {
 	Timestamp time.Time `json:"timestamp"`
 	Pressure  float64   `json:"pressure"` // inHg or mbar
@@ -138,6 +192,68 @@ type MoonPhase struct {
 	Percentage int     `json:"percentage"` // Illumination percentage (0-100)
 }
 
+// Record is a period's record and when it was set
+type Record struct {
+	Value float64 `json:"value"`
+	// Time is the reading's epoch, or the start of the day or month for totals
+	// (wettestDay, wettestMonth, mostStrikes, largestTempSwing)
+	Time int64  `json:"time"`
+	Date string `json:"date"` // station-local "2006-01-02", or "2006-01" for wettestMonth
+	// Dir is the wind direction of the highest gust
+	Dir *float64 `json:"dir,omitempty"`
+}
+
+// RecordsResponse maps each record kind to its record (null without data) per period
+type RecordsResponse struct {
+	AllTime     map[string]*Record `json:"allTime"`
+	YearToDate  map[string]*Record `json:"yearToDate"`
+	MonthToDate map[string]*Record `json:"monthToDate"`
+	// Month compares CalendarMonth (1-12) across every year
+	Month         map[string]*Record `json:"month"`
+	CalendarMonth int                `json:"calendarMonth"`
+	Units         map[string]string  `json:"units"`
+}
+
+// BrokenRecord marks a record set by a live update
+type BrokenRecord struct {
+	Period   string  `json:"period"` // allTime, yearToDate, monthToDate, month
+	Record   string  `json:"record"`
+	Value    float64 `json:"value"`
+	Time     int64   `json:"time"`
+	Previous *Record `json:"previous"`
+}
+
+// OnThisDayYear is one year's weather on the requested date. Values maps highTemp,
+// lowTemp, rain, peakGust and strikes to the day's value (null without data); rain
+// and strikes are day totals timed at the day's start.
+type OnThisDayYear struct {
+	Year    int                `json:"year"`
+	Partial bool               `json:"partial,omitempty"` // today, still in progress
+	Values  map[string]*Record `json:"values"`
+}
+
+// OnThisDayExtreme is the highest or lowest value across years
+type OnThisDayExtreme struct {
+	Value float64 `json:"value"`
+	Year  int     `json:"year"`
+}
+
+// OnThisDayStat summarises one value across the complete years
+type OnThisDayStat struct {
+	Average *float64          `json:"average"`
+	Highest *OnThisDayExtreme `json:"highest"`
+	Lowest  *OnThisDayExtreme `json:"lowest"`
+	Years   int               `json:"years"` // years with data
+}
+
+// OnThisDayResponse compares a calendar date across the years of the archive
+type OnThisDayResponse struct {
+	Date    string                   `json:"date"` // MM-DD
+	Years   []OnThisDayYear          `json:"years"`
+	Summary map[string]OnThisDayStat `json:"summary"`
+	Units   map[string]string        `json:"units"`
+}
+
 // StatisticsData holds aggregated metrics for today and the selected range
 type StatisticsData struct {
 	// Rain
@@ -203,4 +319,77 @@ type StatisticsData struct {
 	// Inside Humidity (hi/lo format)
 	InsideHumToday string `json:"insideHumToday"`
 	InsideHumRange string `json:"insideHumRange"`
+
+	// Departures from the climate normals; omitted when none are configured
+	Normals *StatisticsNormals `json:"normals,omitempty"`
+
+	// Display labels of the unit system used above, e.g. {"temperature": "°C"}
+	Units map[string]string `json:"units"`
+}
+
+// StatisticsNormals compares today and the month so far with the climate normals.
+// A field is null when its normal is missing for the day.
+type StatisticsNormals struct {
+	Source                 string           `json:"source"`
+	HighToday              *NormalDeparture `json:"highToday"`
+	LowToday               *NormalDeparture `json:"lowToday"`
+	RainMonthToDate        *NormalDeparture `json:"rainMonthToDate"`
+	HeatDegDaysMonthToDate *NormalDeparture `json:"heatDegDaysMonthToDate"`
+	CoolDegDaysMonthToDate *NormalDeparture `json:"coolDegDaysMonthToDate"`
+}
+
+// NormalDeparture is a value next to its normal. Value and Departure are null until
+// there is data.
+type NormalDeparture struct {
+	Value     *float64 `json:"value"`
+	Normal    float64  `json:"normal"`
+	Departure *float64 `json:"departure"`
+}
+
+// ActiveAlert is a pending or active alert. Values are in archive units internally
+// and converted to the requested units by the API.
+type ActiveAlert struct {
+	RuleID    string  `json:"ruleId"`
+	Name      string  `json:"name"`
+	Severity  string  `json:"severity"` // info, warning, critical
+	Field     string  `json:"field"`
+	Status    string  `json:"status"` // pending (waiting for "for" duration), active
+	Value     float64 `json:"value"`  // latest value (or change, for rate rules)
+	Peak      float64 `json:"peak"`   // most extreme value since the alert started
+	Threshold float64 `json:"threshold"`
+	Unit      string  `json:"unit,omitempty"`
+	Since     int64   `json:"since"`   // epoch the alert became pending/active
+	Updated   int64   `json:"updated"` // epoch of the last evaluated record
+	Message   string  `json:"message,omitempty"`
+}
+
+// AlertEvent is one entry in the alert history
+type AlertEvent struct {
+	ID        int64   `json:"id"`
+	RuleID    string  `json:"ruleId"`
+	Name      string  `json:"name"`
+	Severity  string  `json:"severity"`
+	Field     string  `json:"field"`
+	Event     string  `json:"event"` // fired, cleared
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Unit      string  `json:"unit,omitempty"`
+	Timestamp int64   `json:"timestamp"`
+	Duration  int64   `json:"durationSeconds,omitempty"` // how long the alert was active (cleared only)
+	Message   string  `json:"message"`
+}
+
+// LiveDerived carries the "latest reading" fields of an SSE update, so live tiles don't
+// need to re-fetch the series endpoints
+type LiveDerived struct {
+	Compass                 string   `json:"compass"`
+	WindStrong              bool     `json:"windStrong"`
+	PressureLevel           string   `json:"pressureLevel,omitempty"` // high, normal, low
+	PressureTrend           string   `json:"pressureTrend,omitempty"` // rapid-rise ... rapid-fall
+	Forecast                string   `json:"forecast,omitempty"`
+	FeelsLike               *float64 `json:"feelsLike,omitempty"`
+	FeelsLikeSource         string   `json:"feelsLikeSource,omitempty"` // heat, chill, air
+	FeelsLikeLabel          string   `json:"feelsLikeLabel,omitempty"`
+	RainRecentlyActive      bool     `json:"rainRecentlyActive"`
+	LightningRecentlyActive bool     `json:"lightningRecentlyActive"`
 }
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Quantity identifies the physical kind of a value so it can be converted between
+// unit systems.
+type Quantity int
+
+const (
+	qNone      Quantity = iota
+	qTemp               // air temperature
+	qTempDelta          // temperature difference (degree days, daily swing)
+	qPressure           // barometric pressure
+	qSpeed              // wind speed
+	qRain               // rain amount
+	qRainRate           // rain rate
+	qDistance           // lightning distance
+	qAltitude           // station elevation
+)
+
+// unitKeys names each quantity in config, the `units=` query override and the
+// "units" label maps returned to clients.
+var unitKeys = map[Quantity]string{
+	qTemp:     "temperature",
+	qPressure: "pressure",
+	qSpeed:    "wind",
+	qRain:     "rain",
+	qRainRate: "rainRate",
+	qDistance: "distance",
+	qAltitude: "altitude",
+}
+
+// archiveQuantities maps WeeWX archive columns to their quantity
+var archiveQuantities = map[string]Quantity{
+	"outTemp":            qTemp,
+	"dewpoint":           qTemp,
+	"heatindex":          qTemp,
+	"windchill":          qTemp,
+	"inTemp":             qTemp,
+	"barometer":          qPressure,
+	"pressure":           qPressure,
+	"altimeter":          qPressure,
+	"windSpeed":          qSpeed,
+	"windGust":           qSpeed,
+	"rain":               qRain,
+	"rainRate":           qRainRate,
+	"lightning_distance": qDistance,
+}
+
+// UnitSystem selects the unit used for each quantity. The zero value is not valid;
+// use one of the presets or parseUnitSystem.
+type UnitSystem struct {
+	Name        string // "us", "metric", "metricwx" or "custom"
+	Temperature string // F, C
+	Pressure    string // inHg, hPa, mbar, kPa, mmHg
+	Wind        string // mph, kmh, mps, knot
+	Rain        string // in, mm, cm
+	Distance    string // mi, km
+}
+
+var (
+	unitsUS       = UnitSystem{Name: "us", Temperature: "F", Pressure: "inHg", Wind: "mph", Rain: "in", Distance: "mi"}
+	unitsMetric   = UnitSystem{Name: "metric", Temperature: "C", Pressure: "hPa", Wind: "kmh", Rain: "cm", Distance: "km"}
+	unitsMetricWX = UnitSystem{Name: "metricwx", Temperature: "C", Pressure: "hPa", Wind: "mps", Rain: "mm", Distance: "km"}
+)
+
+// unitPresets are the WeeWX unit system names accepted in config and `units=`
+var unitPresets = map[string]UnitSystem{
+	"us":       unitsUS,
+	"metric":   unitsMetric,
+	"metricwx": unitsMetricWX,
+}
+
+// allowedUnits lists the valid unit names for each overridable quantity
+var allowedUnits = map[string][]string{
+	"temperature": {"F", "C"},
+	"pressure":    {"inHg", "hPa", "mbar", "kPa", "mmHg"},
+	"wind":        {"mph", "kmh", "mps", "knot"},
+	"rain":        {"in", "mm", "cm"},
+	"distance":    {"mi", "km"},
+}
+
+// sourceUnits is the unit system the WeeWX archive is stored in, and displayUnits
+// the default for responses. Both are set by loadConfig.
+var (
+	sourceUnits  = unitsUS
+	displayUnits = unitsUS
+)
+
+// set overrides one quantity. Unit names are matched case-insensitively.
+func (u *UnitSystem) set(key, unit string) error {
+	allowed, ok := allowedUnits[key]
+	if !ok {
+		return fmt.Errorf("unknown unit quantity %q", key)
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, unit) {
+			switch key {
+			case "temperature":
+				u.Temperature = a
+			case "pressure":
+				u.Pressure = a
+			case "wind":
+				u.Wind = a
+			case "rain":
+				u.Rain = a
+			case "distance":
+				u.Distance = a
+			}
+			u.Name = "custom"
+			for name, p := range unitPresets {
+				if p.Temperature == u.Temperature && p.Pressure == u.Pressure && p.Wind == u.Wind &&
+					p.Rain == u.Rain && p.Distance == u.Distance {
+					u.Name = name
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown %s unit %q (use one of %s)", key, unit, strings.Join(allowed, ", "))
+}
+
+// parseUnitSystem parses "<system>[,<quantity>:<unit>...]", e.g. "metric" or
+// "metric,wind:mph". A spec that starts with an override applies it to base.
+func parseUnitSystem(spec string, base UnitSystem) (UnitSystem, error) {
+	u := base
+	for i, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if key, unit, ok := strings.Cut(part, ":"); ok {
+			if err := u.set(strings.TrimSpace(key), strings.TrimSpace(unit)); err != nil {
+				return base, err
+			}
+			continue
+		}
+		preset, ok := unitPresets[strings.ToLower(part)]
+		if !ok || i > 0 {
+			return base, fmt.Errorf("unknown unit system %q (use us, metric or metricwx)", part)
+		}
+		u = preset
+	}
+	return u, nil
+}
+
+// unitsFromRequest returns the unit system requested via `units=`, or the configured default
+func unitsFromRequest(r *http.Request) (UnitSystem, *apiError) {
+	spec := strings.TrimSpace(r.URL.Query().Get("units"))
+	if spec == "" {
+		return displayUnits, nil
+	}
+	u, err := parseUnitSystem(spec, displayUnits)
+	if err != nil {
+		return displayUnits, badParam("units", "invalid_units", "%v", err)
+	}
+	return u, nil
+}
+
+// Key is a compact identifier for caching, e.g. "us" or "C-hPa-mph-mm-km"
+func (u UnitSystem) Key() string {
+	if u.Name != "custom" && u.Name != "" {
+		return u.Name
+	}
+	return strings.Join([]string{u.Temperature, u.Pressure, u.Wind, u.Rain, u.Distance}, "-")
+}
+
+// unitFor returns the unit name this system uses for q
+func (u UnitSystem) unitFor(q Quantity) string {
+	switch q {
+	case qTemp, qTempDelta:
+		return u.Temperature
+	case qPressure:
+		return u.Pressure
+	case qSpeed:
+		return u.Wind
+	case qRain, qRainRate:
+		return u.Rain
+	case qDistance:
+		return u.Distance
+	case qAltitude:
+		if u.Distance == "mi" {
+			return "ft"
+		}
+		return "m"
+	}
+	return ""
+}
+
+// FromUS converts a value expressed in US units into this system
+func (u UnitSystem) FromUS(q Quantity, v float64) float64 {
+	switch q {
+	case qTemp:
+		if u.Temperature == "C" {
+			return (v - 32) * 5 / 9
+		}
+	case qTempDelta:
+		if u.Temperature == "C" {
+			return v * 5 / 9
+		}
+	case qPressure:
+		switch u.Pressure {
+		case "hPa", "mbar":
+			return v * 33.8638866667
+		case "kPa":
+			return v * 3.38638866667
+		case "mmHg":
+			return v * 25.4
+		}
+	case qSpeed:
+		switch u.Wind {
+		case "kmh":
+			return v * 1.609344
+		case "mps":
+			return v * 0.44704
+		case "knot":
+			return v * 0.868976242
+		}
+	case qRain, qRainRate:
+		switch u.Rain {
+		case "mm":
+			return v * 25.4
+		case "cm":
+			return v * 2.54
+		}
+	case qDistance:
+		if u.Distance == "km" {
+			return v * 1.609344
+		}
+	case qAltitude:
+		if u.unitFor(qAltitude) == "m" {
+			return v * 0.3048
+		}
+	}
+	return v
+}
+
+// ToUS converts a value expressed in this system back into US units
+func (u UnitSystem) ToUS(q Quantity, v float64) float64 {
+	switch q {
+	case qTemp:
+		if u.Temperature == "C" {
+			return v*9/5 + 32
+		}
+		return v
+	case qTempDelta:
+		if u.Temperature == "C" {
+			return v * 9 / 5
+		}
+		return v
+	}
+	// Every other conversion is a pure scale factor
+	if f := u.FromUS(q, 1); f != 0 {
+		return v / f
+	}
+	return v
+}
+
+// Convert converts an archive value (stored in sourceUnits) into this system
+func (u UnitSystem) Convert(q Quantity, v float64) float64 {
+	if u == sourceUnits {
+		return v
+	}
+	return u.FromUS(q, sourceUnits.ToUS(q, v))
+}
+
+// fromUSThreshold expresses a US-unit threshold in the archive's source units, so
+// fixed cut-offs such as 30.20 inHg or 80 °F stay correct whatever WeeWX stores.
+func fromUSThreshold(q Quantity, v float64) float64 {
+	return sourceUnits.FromUS(q, v)
+}
+
+// Label returns the display label for q, e.g. "°C", "hPa", "km/h"
+func (u UnitSystem) Label(q Quantity) string {
+	unit := u.unitFor(q)
+	switch unit {
+	case "F":
+		return "°F"
+	case "C":
+		return "°C"
+	case "kmh":
+		return "km/h"
+	case "mps":
+		return "m/s"
+	case "knot":
+		return "kn"
+	}
+	if q == qRainRate {
+		return unit + "/h"
+	}
+	return unit
+}
+
+// Suffix returns a label-safe unit token for CSV headers, e.g. "F", "hPa", "in_hr"
+func (u UnitSystem) Suffix(q Quantity) string {
+	unit := u.unitFor(q)
+	if q == qRainRate {
+		return unit + "_hr"
+	}
+	return unit
+}
+
+// Precision returns a sensible number of decimals for q in this system
+func (u UnitSystem) Precision(q Quantity) int {
+	switch q {
+	case qTemp, qTempDelta, qDistance:
+		return 1
+	case qSpeed, qAltitude:
+		return 0
+	case qPressure:
+		switch u.Pressure {
+		case "inHg", "kPa":
+			return 2
+		}
+		return 1
+	case qRain, qRainRate:
+		if u.Rain == "mm" {
+			return 1
+		}
+		return 2
+	}
+	return 2
+}
+
+// Labels returns the display label of every quantity, keyed by unitKeys
+func (u UnitSystem) Labels() map[string]string {
+	labels := make(map[string]string, len(unitKeys))
+	for q, key := range unitKeys {
+		labels[key] = u.Label(q)
+	}
+	return labels
+}
+
+// displayToSource converts a config value written in the default display units
+// (e.g. an alert threshold) into archive source units for comparisons.
+func displayToSource(q Quantity, v float64) float64 {
+	return sourceUnits.FromUS(q, displayUnits.ToUS(q, v))
+}
+
+// convertSamples returns a converted copy of samples; the input is left untouched so
+// raw rows can still be compared against source-unit thresholds.
+func convertSamples(samples []sample, cols []seriesColumn, u UnitSystem) []sample {
+	if u == sourceUnits {
+		return samples
+	}
+	out := make([]sample, len(samples))
+	for i, s := range samples {
+		vals := make([]sql.NullFloat64, len(s.Values))
+		copy(vals, s.Values)
+		for j, c := range cols {
+			if q, ok := archiveQuantities[c.Name]; ok && vals[j].Valid {
+				vals[j].Float64 = u.Convert(q, vals[j].Float64)
+			}
+		}
+		out[i] = sample{Epoch: s.Epoch, Values: vals}
+	}
+	return out
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/coder/websocket"
+)
+
+// /api/ws carries the same events as /api/stream over a WebSocket, for clients behind
+// proxies that buffer SSE and for scripts that want to control their subscription.
+//
+// Server -> client messages:
+//
+//	{"type":"update","id":"1718900000","data":{...}}   (also alert, status, celestial)
+//	{"type":"snapshot","id":"1718900000","data":{...}} reply to a snapshot request
+//	{"type":"subscribed","events":[...],"fields":[...]}
+//	{"type":"error","error":"...","message":"..."}
+//
+// Client -> server messages:
+//
+//	{"type":"subscribe","events":["alert"],"fields":["outTemp"]}
+//	{"type":"unsubscribe","events":["status"],"fields":["derived"]}
+//	{"type":"snapshot"}
+//
+// Update payloads hold the archive fields, "derived" (live derived values) and, when
+// a reading sets a new record, "records"; fields picks among these.
+
+const (
+	wsMaxMessageBytes = 64 << 10
+	wsWriteTimeout    = 10 * time.Second
+)
+
+// wsMessage is a server -> client message
+type wsMessage struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Events  []string        `json:"events,omitempty"`
+	Fields  []string        `json:"fields,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+// wsRequest is a client -> server message
+type wsRequest struct {
+	Type   string   `json:"type"`
+	Events []string `json:"events"`
+	Fields []string `json:"fields"`
+}
+
+// wsFields are the update payload keys a client can subscribe to. timestamp and
+// units are always sent.
+func wsFields() []string {
+	return append(append([]string(nil), sseColumns...), "derived", "records")
+}
+
+// wsSession is one WebSocket connection's subscription state
+type wsSession struct {
+	broker *SSEBroker
+	conn   *websocket.Conn
+	ctx    context.Context
+	client *sseClient
+
+	mu     sync.Mutex
+	events map[string]bool
+	fields map[string]bool // nil = every field
+	lastID sseID
+}
+
+// ServeWS handles /api/ws. It accepts the same ?units=, ?events= and ?lastEventId=
+// parameters as /api/stream, plus ?fields= to limit update payloads.
+func (b *SSEBroker) ServeWS(w http.ResponseWriter, r *http.Request) {
+	units, apiErr := unitsFromRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	events, apiErr := parseSSEEvents(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	var fields map[string]bool
+	if spec := strings.TrimSpace(r.URL.Query().Get("fields")); spec != "" {
+		fields = make(map[string]bool)
+		if err := addWSFields(fields, strings.Split(spec, ",")); err != nil {
+			writeAPIError(w, badParam("fields", "invalid_fields", "%v", err))
+			return
+		}
+	}
+	var since *sseID
+	if resume := r.URL.Query().Get("lastEventId"); resume != "" {
+		id, ok := parseSSEID(resume)
+		if !ok {
+			writeAPIError(w, badParam("lastEventId", "invalid_event_id", "unrecognised event id %q", resume))
+			return
+		}
+		since = &id
+	}
+
+	// Browsers send cookies with cross-origin WebSocket requests, so only the
+	// dashboard's own origin and the configured ones may connect
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: appConfig.Server.WSAllowedOrigins})
+	if err != nil {
+		log.Printf("[WS] upgrade from %s failed: %v", r.RemoteAddr, err)
+		return
+	}
+	conn.SetReadLimit(wsMaxMessageBytes)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &wsSession{
+		broker: b,
+		conn:   conn,
+		ctx:    ctx,
+		client: &sseClient{
+			ch:     make(chan *sseEvent, appConfig.Server.WSSendQueue),
+			units:  units,
+			events: copyEventSet(events),
+			lagged: make(chan struct{}),
+		},
+		events: events,
+		fields: fields,
+	}
+	if since != nil {
+		s.lastID = *since
+	}
+	missed := b.addClient(s.client, since)
+	defer b.RemoveClient(s.client)
+	log.Printf("[WS] client connected from %s", r.RemoteAddr)
+
+	s.run(missed)
+}
+
+// run delivers events until the connection closes. Reading happens on a second
+// goroutine and pings on a third; a peer that doesn't answer a ping before the next
+// one is due is dropped.
+func (s *wsSession) run(missed []*sseEvent) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			typ, msg, err := s.conn.Read(s.ctx)
+			if err != nil {
+				if websocket.CloseStatus(err) == -1 && !errors.Is(err, net.ErrClosed) && s.ctx.Err() == nil {
+					log.Printf("[WS] read: %v", err)
+				}
+				return
+			}
+			if typ != websocket.MessageText {
+				s.conn.Close(websocket.StatusUnsupportedData, "text messages only")
+				return
+			}
+			if !utf8.Valid(msg) {
+				s.conn.Close(websocket.StatusInvalidFramePayloadData, "invalid UTF-8")
+				return
+			}
+			if err := s.handle(msg); err != nil {
+				return
+			}
+		}
+	}()
+	go s.keepalive(time.Duration(appConfig.Server.WSPingSeconds) * time.Second)
+	defer s.conn.Close(websocket.StatusNormalClosure, "")
+
+	if err := s.sendSubscribed(); err != nil {
+		return
+	}
+	for _, ev := range missed {
+		if err := s.send(ev.Type, ev); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-done:
+			log.Printf("[WS] client disconnected")
+			return
+		case <-s.client.lagged:
+			s.mu.Lock()
+			last := s.lastID
+			s.mu.Unlock()
+			log.Printf("[WS] client too slow; closing")
+			s.conn.Close(websocket.StatusTryAgainLater, fmt.Sprintf("too slow; reconnect with lastEventId=%s", last))
+			return
+		case ev := <-s.client.ch:
+			if err := s.send(ev.Type, ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// keepalive pings the peer every interval until the session ends, dropping it when a
+// pong doesn't arrive in time
+func (s *wsSession) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(s.ctx, interval)
+			err := s.conn.Ping(ctx)
+			cancel()
+			if err != nil {
+				if s.ctx.Err() == nil {
+					log.Printf("[WS] no pong; dropping client: %v", err)
+					s.conn.CloseNow()
+				}
+				return
+			}
+		}
+	}
+}
+
+// handle processes one client request. Bad requests get an error message; only a
+// failed write ends the session.
+func (s *wsSession) handle(msg []byte) error {
+	var req wsRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return s.sendError("invalid_message", "message must be a JSON object")
+	}
+
+	switch req.Type {
+	case "subscribe", "unsubscribe":
+		add := req.Type == "subscribe"
+		s.mu.Lock()
+		events := copyEventSet(s.events)
+		var fields map[string]bool
+		if s.fields != nil || len(req.Fields) > 0 {
+			fields = make(map[string]bool)
+			if s.fields == nil {
+				// Subscribing to fields narrows "everything" to them; unsubscribing
+				// removes them from everything
+				for _, f := range wsFields() {
+					fields[f] = !add
+				}
+			} else {
+				for f := range s.fields {
+					fields[f] = true
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		for _, t := range req.Events {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if !containsString(sseEventTypes, t) {
+				return s.sendError("invalid_events", fmt.Sprintf("unknown event type %q (use %s)", t, strings.Join(sseEventTypes, ", ")))
+			}
+			if add {
+				events[t] = true
+			} else {
+				delete(events, t)
+			}
+		}
+		if len(req.Fields) > 0 {
+			changed := make(map[string]bool)
+			if err := addWSFields(changed, req.Fields); err != nil {
+				return s.sendError("invalid_fields", err.Error())
+			}
+			for f := range changed {
+				fields[f] = add
+			}
+		}
+		if fields != nil {
+			for f, on := range fields {
+				if !on {
+					delete(fields, f)
+				}
+			}
+			if len(fields) == len(wsFields()) {
+				fields = nil
+			}
+		}
+
+		s.mu.Lock()
+		s.events, s.fields = events, fields
+		s.mu.Unlock()
+		s.broker.setClientEvents(s.client, copyEventSet(events))
+		return s.sendSubscribed()
+
+	case "snapshot":
+		ev := s.broker.latest(sseUpdate)
+		if ev == nil {
+			return s.sendError("no_data", "no reading has been received yet")
+		}
+		return s.send("snapshot", ev)
+
+	default:
+		return s.sendError("invalid_message", fmt.Sprintf("unknown message type %q (use subscribe, unsubscribe, snapshot)", req.Type))
+	}
+}
+
+// send writes ev as a message of type typ, applying the field filter to updates
+func (s *wsSession) send(typ string, ev *sseEvent) error {
+	data, err := ev.data(s.client.units)
+	if err != nil {
+		log.Printf("[WS] render error for %s %s: %v", ev.Type, ev.ID, err)
+		return nil
+	}
+	s.mu.Lock()
+	fields := s.fields
+	if typ != "snapshot" && ev.ID.after(s.lastID) {
+		s.lastID = ev.ID
+	}
+	s.mu.Unlock()
+
+	if ev.Type == sseUpdate && fields != nil {
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(data, &payload); err == nil {
+			for k := range payload {
+				if k != "timestamp" && k != "units" && !fields[k] {
+					delete(payload, k)
+				}
+			}
+			if filtered, err := json.Marshal(payload); err == nil {
+				data = filtered
+			}
+		}
+	}
+	return s.write(wsMessage{Type: typ, ID: ev.ID.String(), Data: data})
+}
+
+func (s *wsSession) sendSubscribed() error {
+	s.mu.Lock()
+	msg := wsMessage{Type: "subscribed", Events: sortedKeys(s.events), Fields: sortedKeys(s.fields)}
+	s.mu.Unlock()
+	if msg.Fields == nil {
+		msg.Fields = wsFields()
+	}
+	if msg.Events == nil {
+		msg.Events = []string{}
+	}
+	return s.write(msg)
+}
+
+func (s *wsSession) sendError(code, message string) error {
+	return s.write(wsMessage{Type: "error", Error: code, Message: message})
+}
+
+func (s *wsSession) write(msg wsMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, wsWriteTimeout)
+	defer cancel()
+	return s.conn.Write(ctx, websocket.MessageText, b)
+}
+
+// addWSFields validates names against wsFields and adds them to set
+func addWSFields(set map[string]bool, names []string) error {
+	valid := wsFields()
+	for _, f := range names {
+		f = strings.TrimSpace(f)
+		if !containsString(valid, f) {
+			return fmt.Errorf("unknown field %q (use %s)", f, strings.Join(valid, ", "))
+		}
+		set[f] = true
+	}
+	return nil
+}
+
+func copyEventSet(in map[string]bool) map[string]bool {
+	out := make(map[string]bool, len(in))
+	for k, v := range in {
+		if v {
+			out[k] = true
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]bool) []string {
+	if m == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
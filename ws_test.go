@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// wsTestServer serves a fresh broker's /api/ws
+func wsTestServer(t *testing.T, server ServerConfig) (*SSEBroker, *httptest.Server) {
+	t.Helper()
+	saved := appConfig
+	t.Cleanup(func() { appConfig = saved })
+	if server.WSPingSeconds == 0 {
+		server.WSPingSeconds = 30
+	}
+	if server.WSSendQueue == 0 {
+		server.WSSendQueue = 16
+	}
+	server.SSEReplayEvents = 100
+	appConfig.Server = server
+
+	b := NewSSEBroker(nil)
+	srv := httptest.NewServer(http.HandlerFunc(b.ServeWS))
+	t.Cleanup(srv.Close)
+	return b, srv
+}
+
+func wsDial(t *testing.T, srv *httptest.Server, query, origin string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h := http.Header{}
+	if origin != "" {
+		h.Set("Origin", origin)
+	}
+	c, resp, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/api/ws"+query, &websocket.DialOptions{HTTPHeader: h})
+	if c != nil {
+		t.Cleanup(func() { c.CloseNow() })
+	}
+	return c, resp, err
+}
+
+func wsRead(t *testing.T, c *websocket.Conn) wsMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	typ, data, err := c.Read(ctx)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if typ != websocket.MessageText {
+		t.Fatalf("got a %v message", typ)
+	}
+	var msg wsMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+	return msg
+}
+
+func wsSend(t *testing.T, c *websocket.Conn, msg string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Write(ctx, websocket.MessageText, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wsCloseStatus reads until the server closes the connection and returns its status
+func wsCloseStatus(t *testing.T, c *websocket.Conn) (websocket.StatusCode, string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		_, _, err := c.Read(ctx)
+		if err == nil {
+			continue
+		}
+		var ce websocket.CloseError
+		if !errors.As(err, &ce) {
+			t.Fatalf("connection ended without a close frame: %v", err)
+		}
+		return ce.Code, ce.Reason
+	}
+}
+
+func TestWSOriginCheck(t *testing.T) {
+	_, srv := wsTestServer(t, ServerConfig{WSAllowedOrigins: []string{"*.example.com"}})
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	for _, c := range []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://" + host, true},
+		{"https://dash.example.com", true},
+		{"https://example.com.evil.net", false},
+		{"https://sibling.example.org", false},
+		{"null", false},
+	} {
+		conn, resp, err := wsDial(t, srv, "", c.origin)
+		if c.ok {
+			if err != nil {
+				t.Errorf("origin %q: %v", c.origin, err)
+				continue
+			}
+			if msg := wsRead(t, conn); msg.Type != "subscribed" {
+				t.Errorf("origin %q: first message %q", c.origin, msg.Type)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("origin %q was accepted", c.origin)
+			continue
+		}
+		if resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("origin %q: got %v, want 403", c.origin, err)
+		}
+	}
+}
+
+func TestWSRejectsBadParameters(t *testing.T) {
+	_, srv := wsTestServer(t, ServerConfig{})
+	for _, q := range []string{"?fields=nope", "?events=weather", "?lastEventId=x", "?units=imperial"} {
+		_, resp, err := wsDial(t, srv, q, "")
+		if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got %v, want 400", q, err)
+		}
+	}
+}
+
+func TestWSSubscriptionAndEvents(t *testing.T) {
+	b, srv := wsTestServer(t, ServerConfig{})
+	c, _, err := wsDial(t, srv, "?events=update,celestial", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := wsRead(t, c)
+	if msg.Type != "subscribed" || strings.Join(msg.Events, ",") != "celestial,update" || len(msg.Fields) != len(wsFields()) {
+		t.Fatalf("subscribed %+v", msg)
+	}
+
+	// Nothing received yet, so there's no snapshot
+	wsSend(t, c, `{"type":"snapshot"}`)
+	if msg := wsRead(t, c); msg.Type != "error" || msg.Error != "no_data" {
+		t.Errorf("snapshot before data: %+v", msg)
+	}
+
+	wsSend(t, c, `{"type":"subscribe","fields":["outTemp"]}`)
+	if msg := wsRead(t, c); msg.Type != "subscribed" || strings.Join(msg.Fields, ",") != "outTemp" {
+		t.Fatalf("subscribed %+v", msg)
+	}
+	wsSend(t, c, `{"type":"unsubscribe","events":["celestial"]}`)
+	if msg := wsRead(t, c); strings.Join(msg.Events, ",") != "update" {
+		t.Fatalf("subscribed %+v", msg)
+	}
+
+	b.publish(sseCelestial, 0, func(UnitSystem) ([]byte, error) { return []byte(`{}`), nil })
+	b.publish(sseUpdate, 1718900000, func(UnitSystem) ([]byte, error) {
+		return []byte(`{"timestamp":1718900000,"units":{},"outTemp":71.5,"windSpeed":4}`), nil
+	})
+	msg = wsRead(t, c)
+	if msg.Type != "update" || msg.ID != "1718900000" {
+		t.Fatalf("got %+v, want the update (celestial is unsubscribed)", msg)
+	}
+	var data map[string]any
+	json.Unmarshal(msg.Data, &data)
+	if _, ok := data["windSpeed"]; ok || data["outTemp"] != 71.5 || data["timestamp"] == nil || data["units"] == nil {
+		t.Errorf("filtered update %s", msg.Data)
+	}
+
+	wsSend(t, c, `{"type":"snapshot"}`)
+	if msg := wsRead(t, c); msg.Type != "snapshot" || msg.ID != "1718900000" {
+		t.Errorf("snapshot %+v", msg)
+	}
+
+	// Bad requests are answered without closing the session
+	for _, req := range []string{`not json`, `{"type":"dance"}`, `{"type":"subscribe","events":["weather"]}`, `{"type":"subscribe","fields":["nope"]}`} {
+		wsSend(t, c, req)
+		if msg := wsRead(t, c); msg.Type != "error" {
+			t.Errorf("%s: got %+v", req, msg)
+		}
+	}
+	wsSend(t, c, `{"type":"snapshot"}`)
+	if msg := wsRead(t, c); msg.Type != "snapshot" {
+		t.Errorf("session ended after bad requests: %+v", msg)
+	}
+}
+
+func TestWSFieldFilterKeepsBrokenRecords(t *testing.T) {
+	b, srv := wsTestServer(t, ServerConfig{})
+	c, _, err := wsDial(t, srv, "?fields=outTemp,records", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := wsRead(t, c); strings.Join(msg.Fields, ",") != "outTemp,records" {
+		t.Fatalf("subscribed %+v", msg)
+	}
+
+	values := map[string]float64{"outTemp": 101.5, "windSpeed": 4}
+	broken := []BrokenRecord{{Period: "allTime", Record: "highTemp", Value: 101.5, Time: 1718900000}}
+	b.publish(sseUpdate, 1718900000, func(units UnitSystem) ([]byte, error) {
+		return renderRecord(1718900000, values, LiveDerived{}, broken, units)
+	})
+	msg := wsRead(t, c)
+	var data struct {
+		OutTemp   *float64       `json:"outTemp"`
+		WindSpeed *float64       `json:"windSpeed"`
+		Derived   any            `json:"derived"`
+		Records   []BrokenRecord `json:"records"`
+	}
+	if err := json.Unmarshal(msg.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.OutTemp == nil || data.WindSpeed != nil || data.Derived != nil {
+		t.Errorf("filtered update %s", msg.Data)
+	}
+	if len(data.Records) != 1 || data.Records[0].Record != "highTemp" {
+		t.Errorf("update %s lost its broken record", msg.Data)
+	}
+}
+
+func TestWSReplaysFromLastEventID(t *testing.T) {
+	b, srv := wsTestServer(t, ServerConfig{})
+	for _, epoch := range []int64{1718900000, 1718900300, 1718900600} {
+		b.publish(sseUpdate, epoch, func(UnitSystem) ([]byte, error) { return []byte(`{}`), nil })
+	}
+	c, _, err := wsDial(t, srv, "?lastEventId=1718900000", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsRead(t, c)
+	if a, b := wsRead(t, c), wsRead(t, c); a.ID != "1718900300" || b.ID != "1718900600" {
+		t.Errorf("replayed %s, %s", a.ID, b.ID)
+	}
+}
+
+func TestWSFragmentedMessage(t *testing.T) {
+	_, srv := wsTestServer(t, ServerConfig{})
+	c, _, err := wsDial(t, srv, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsRead(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	w, err := c.Writer(ctx, websocket.MessageText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{`{"type":"subsc`, `ribe","events":`, `["alert"]}`} {
+		if _, err := w.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if msg := wsRead(t, c); msg.Type != "subscribed" || !strings.Contains(strings.Join(msg.Events, ","), "alert") {
+		t.Errorf("got %+v", msg)
+	}
+}
+
+func TestWSClosesOnInvalidInput(t *testing.T) {
+	_, srv := wsTestServer(t, ServerConfig{})
+	ctx := context.Background()
+	for _, c := range []struct {
+		name string
+		typ  websocket.MessageType
+		data []byte
+		want websocket.StatusCode
+	}{
+		{"binary", websocket.MessageBinary, []byte(`{"type":"snapshot"}`), websocket.StatusUnsupportedData},
+		{"invalid UTF-8", websocket.MessageText, []byte("{\"type\":\"\xff\xfe\"}"), websocket.StatusInvalidFramePayloadData},
+		{"too big", websocket.MessageText, []byte(`"` + strings.Repeat("x", wsMaxMessageBytes) + `"`), websocket.StatusMessageTooBig},
+	} {
+		conn, _, err := wsDial(t, srv, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		wsRead(t, conn)
+		if err := conn.Write(ctx, c.typ, c.data); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if code, _ := wsCloseStatus(t, conn); code != c.want {
+			t.Errorf("%s: closed with %v, want %v", c.name, code, c.want)
+		}
+	}
+}
+
+func TestWSClientClose(t *testing.T) {
+	b, srv := wsTestServer(t, ServerConfig{})
+	c, _, err := wsDial(t, srv, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsRead(t, c)
+	if b.clientCount() != 1 {
+		t.Fatalf("%d clients", b.clientCount())
+	}
+	if err := c.Close(websocket.StatusNormalClosure, "bye"); err != nil {
+		t.Errorf("close handshake: %v", err)
+	}
+	waitFor(t, func() bool { return b.clientCount() == 0 })
+}
+
+func TestWSKeepalive(t *testing.T) {
+	b, srv := wsTestServer(t, ServerConfig{WSPingSeconds: 1})
+	live, _, err := wsDial(t, srv, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsRead(t, live)
+	// A reading client answers pings automatically
+	go func() {
+		for {
+			if _, _, err := live.Read(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	silent, _, err := wsDial(t, srv, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = silent // never reads, so never answers a ping
+	waitFor(t, func() bool { return b.clientCount() == 2 })
+
+	time.Sleep(2500 * time.Millisecond)
+	if n := b.clientCount(); n != 1 {
+		t.Errorf("%d clients after the silent one missed a ping, want 1", n)
+	}
+}
+
+func TestWSLaggedClientIsClosed(t *testing.T) {
+	b, srv := wsTestServer(t, ServerConfig{WSSendQueue: 1})
+	c, _, err := wsDial(t, srv, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsRead(t, c)
+	waitFor(t, func() bool { return b.clientCount() == 1 })
+
+	// Hold the session in its first send while more events than its queue holds arrive
+	entered, release := make(chan struct{}), make(chan struct{})
+	b.publish(sseUpdate, 1718900000, func(UnitSystem) ([]byte, error) {
+		close(entered)
+		<-release
+		return []byte(`{}`), nil
+	})
+	<-entered
+	for _, epoch := range []int64{1718900300, 1718900600} {
+		b.publish(sseUpdate, epoch, func(UnitSystem) ([]byte, error) { return []byte(`{}`), nil })
+	}
+	close(release)
+
+	// The reason names the last event written, which may include the one still queued
+	code, reason := wsCloseStatus(t, c)
+	last := strings.TrimPrefix(reason, "too slow; reconnect with lastEventId=")
+	if code != websocket.StatusTryAgainLater || (last != "1718900000" && last != "1718900300") {
+		t.Errorf("closed with %v %q", code, reason)
+	}
+	waitFor(t, func() bool { return b.clientCount() == 0 })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}